@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"logging"
+	"net/http"
 	"net/url"
 	base "webcrawler/base"
 	mdw "webcrawler/middleware"
@@ -57,6 +58,9 @@ func (analyzer *myAnalyzer) Analyze(
 	var reqUrl *url.URL = httpResp.Request.URL
 	logger.Infof("Parse the response (reqUrl=%s)... \n", reqUrl)
 	respDepth := resp.Depth()
+	respMeta := resp.Meta()
+	// 使響應解析函數可以透過 base.MetaFromHttpReq 取得中繼資料。
+	httpResp.Request = base.HttpReqWithMeta(httpResp.Request, respMeta)
 
 	// 解析HTTP響應。
 	dataList = make([]base.Data, 0)
@@ -70,7 +74,7 @@ func (analyzer *myAnalyzer) Analyze(
 		pDataList, pErrorList := respParser(httpResp, respDepth)
 		if pDataList != nil {
 			for _, pData := range pDataList {
-				dataList = appendDataList(dataList, pData, respDepth)
+				dataList = appendDataList(dataList, pData, respDepth, respMeta, reqUrl)
 			}
 		}
 		if pErrorList != nil {
@@ -83,19 +87,44 @@ func (analyzer *myAnalyzer) Analyze(
 }
 
 // 加入請求值或項目值到清單。
-func appendDataList(dataList []base.Data, data base.Data, respDepth uint32) []base.Data {
+// 響應的中繼資料會被傳遞給請求和項目，父網頁的URL和引用頁會被自動記錄在請求中。
+func appendDataList(
+	dataList []base.Data,
+	data base.Data,
+	respDepth uint32,
+	respMeta base.Meta,
+	reqUrl *url.URL) []base.Data {
 	if data == nil {
 		return dataList
 	}
-	req, ok := data.(*base.Request)
-	if !ok {
-		return append(dataList, data)
-	}
-	newDepth := respDepth + 1
-	if req.Depth() != newDepth {
-		req = base.NewRequest(req.HttpReq(), newDepth)
+	switch d := data.(type) {
+	case *base.Request:
+		httpReq := d.HttpReq()
+		if httpReq == nil {
+			return append(dataList, d)
+		}
+		parentUrl := reqUrl.String()
+		meta := respMeta.Merge(d.Meta()).
+			With(base.META_KEY_PARENT_URL, parentUrl)
+		if httpReq.Header == nil {
+			httpReq.Header = make(http.Header)
+		}
+		if referer := httpReq.Header.Get("Referer"); referer == "" {
+			httpReq.Header.Set("Referer", parentUrl)
+			meta = meta.With(base.META_KEY_REFERER, parentUrl)
+		} else {
+			meta = meta.With(base.META_KEY_REFERER, referer)
+		}
+		return append(dataList, base.NewRequestWithMeta(httpReq, respDepth+1, meta))
+	case *base.Item:
+		if d != nil && *d != nil {
+			if _, ok := (*d)[base.ITEM_META_KEY]; !ok {
+				(*d)[base.ITEM_META_KEY] =
+					respMeta.With(base.META_KEY_PAGE_URL, reqUrl.String())
+			}
+		}
 	}
-	return append(dataList, req)
+	return append(dataList, data)
 }
 
 // 加入錯誤值到清單。
@@ -0,0 +1,66 @@
+package analyzer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	base "webcrawler/base"
+)
+
+func TestAnalyzeMeta(t *testing.T) {
+	parentReq, _ := http.NewRequest("GET", "http://example.com/list", nil)
+	httpResp := &http.Response{
+		StatusCode: 200,
+		Request:    parentReq,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+	meta := base.NewMeta(map[string]interface{}{"category": "books"})
+	resp := base.NewResponseWithMeta(httpResp, 1, meta)
+	var seenCategory string
+	parser := func(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+		seenCategory = base.MetaFromHttpReq(httpResp.Request).GetString("category")
+		childReq, _ := http.NewRequest("GET", "http://example.com/item/1", nil)
+		childMeta := base.NewMeta(map[string]interface{}{"template": "item"})
+		item := base.Item(map[string]interface{}{"title": "Go"})
+		return []base.Data{
+			base.NewRequestWithMeta(childReq, respDepth, childMeta),
+			&item,
+		}, nil
+	}
+	dataList, errs := NewAnalyzer().Analyze([]ParseResponse{parser}, *resp)
+	if len(errs) > 0 {
+		t.Fatalf("ERROR: Analyze failing: %v\n", errs)
+	}
+	if seenCategory != "books" {
+		t.Errorf("ERROR: The parser got category %q, but should be \"books\"!\n", seenCategory)
+	}
+	if len(dataList) != 2 {
+		t.Fatalf("ERROR: The data number is %d, but should be 2!\n", len(dataList))
+	}
+	req := dataList[0].(*base.Request)
+	if req.Depth() != 2 {
+		t.Errorf("ERROR: The child depth is %d, but should be 2!\n", req.Depth())
+	}
+	expected := map[string]string{
+		"category":               "books",
+		"template":               "item",
+		base.META_KEY_PARENT_URL: "http://example.com/list",
+		base.META_KEY_REFERER:    "http://example.com/list",
+	}
+	for k, v := range expected {
+		if actual := req.Meta().GetString(k); actual != v {
+			t.Errorf("ERROR: The child meta %q is %q, but should be %q!\n", k, actual, v)
+		}
+	}
+	if referer := req.HttpReq().Header.Get("Referer"); referer != "http://example.com/list" {
+		t.Errorf("ERROR: The child referer is %q!\n", referer)
+	}
+	item := dataList[1].(*base.Item)
+	if category := item.Meta().GetString("category"); category != "books" {
+		t.Errorf("ERROR: The item meta category is %q, but should be \"books\"!\n", category)
+	}
+	if pageUrl := item.Meta().GetString(base.META_KEY_PAGE_URL); pageUrl != "http://example.com/list" {
+		t.Errorf("ERROR: The item meta page url is %q!\n", pageUrl)
+	}
+}
@@ -0,0 +1,339 @@
+package analyzer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"net/http"
+	"net/url"
+	"strings"
+	base "webcrawler/base"
+)
+
+// 連結來源的型態。多個來源可以透過位元或運算組合。
+type LinkSource uint16
+
+// 連結來源常數。
+const (
+	LINK_SOURCE_A      LinkSource = 1 << iota // <a href>。
+	LINK_SOURCE_NEXT                          // <link rel=next>。
+	LINK_SOURCE_AREA                          // <area href>。
+	LINK_SOURCE_IFRAME                        // <iframe src>。
+	LINK_SOURCE_FORM                          // <form method=get>。
+	LINK_SOURCE_ALL    = LINK_SOURCE_A | LINK_SOURCE_NEXT | LINK_SOURCE_AREA |
+		LINK_SOURCE_IFRAME | LINK_SOURCE_FORM
+)
+
+// 表示連結來源與其名稱之間的映射關系的字典。
+var linkSourceNameMap = map[LinkSource]string{
+	LINK_SOURCE_A:      "a",
+	LINK_SOURCE_NEXT:   "link[rel=next]",
+	LINK_SOURCE_AREA:   "area",
+	LINK_SOURCE_IFRAME: "iframe",
+	LINK_SOURCE_FORM:   "form[method=get]",
+}
+
+func (ls LinkSource) String() string {
+	names := make([]string, 0)
+	for _, source := range []LinkSource{LINK_SOURCE_A, LINK_SOURCE_NEXT,
+		LINK_SOURCE_AREA, LINK_SOURCE_IFRAME, LINK_SOURCE_FORM} {
+		if ls&source != 0 {
+			names = append(names, linkSourceNameMap[source])
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// 連結提取器參數容器的描述範本。
+var linkExtractorArgsTemplate string = "{ sources: %s, maxFormsPerPage: %d }"
+
+// 連結提取器參數的容器。
+type LinkExtractorArgs struct {
+	sources         LinkSource // 需要跟隨的連結來源。
+	maxFormsPerPage uint32     // 每個網頁最多會被提交的表單的數量。0表示不限制。
+	description     string     // 描述。
+}
+
+// 建立連結提取器參數的容器。
+func NewLinkExtractorArgs(
+	sources LinkSource,
+	maxFormsPerPage uint32) LinkExtractorArgs {
+	return LinkExtractorArgs{
+		sources:         sources,
+		maxFormsPerPage: maxFormsPerPage,
+	}
+}
+
+func (args *LinkExtractorArgs) Check() error {
+	if args.sources == 0 {
+		return errors.New("The link sources can not be empty!\n")
+	}
+	if args.sources&^LINK_SOURCE_ALL != 0 {
+		return errors.New(fmt.Sprintf("Unknown link sources %d!\n", args.sources&^LINK_SOURCE_ALL))
+	}
+	return nil
+}
+
+func (args *LinkExtractorArgs) String() string {
+	if args.description == "" {
+		args.description =
+			fmt.Sprintf(linkExtractorArgsTemplate,
+				args.sources,
+				args.maxFormsPerPage)
+	}
+	return args.description
+}
+
+// 獲得需要跟隨的連結來源。
+func (args *LinkExtractorArgs) Sources() LinkSource {
+	return args.sources
+}
+
+// 獲得每個網頁最多會被提交的表單的數量。
+func (args *LinkExtractorArgs) MaxFormsPerPage() uint32 {
+	return args.maxFormsPerPage
+}
+
+// 建立連結提取函數。其結果可以直接作為分析器的響應解析函數使用。
+// 該函數會讀取響應的內容，並在解析之後以可重新讀取的形式放回，以便其他解析函數使用。
+func NewLinkExtractor(args LinkExtractorArgs) (ParseResponse, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	extractor := &linkExtractor{args: args}
+	return extractor.parse, nil
+}
+
+// 連結提取器。
+type linkExtractor struct {
+	args LinkExtractorArgs // 參數的容器。
+}
+
+// 表單中的字段。
+type formField struct {
+	name  string // 名稱。
+	value string // 預設值。
+}
+
+// 需要被提交的表單。
+type pendingForm struct {
+	action string      // 提交位址。
+	fields []formField // 字段清單。
+}
+
+func (extractor *linkExtractor) parse(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		err := errors.New(
+			fmt.Sprintf("Unsupported status code %d. (url=%s)",
+				httpResp.StatusCode, httpResp.Request.URL))
+		return nil, []error{err}
+	}
+	if httpResp.Body == nil {
+		return nil, []error{errors.New("The http response body is invalid!")}
+	}
+	content, err := base.BufferBody(httpResp)
+	if err != nil {
+		return nil, []error{err}
+	}
+	links, errs := extractor.extract(httpResp.Request.URL, content)
+	dataList := make([]base.Data, 0, len(links))
+	for _, link := range links {
+		httpReq, err := http.NewRequest("GET", link, nil)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		dataList = append(dataList, base.NewRequest(httpReq, respDepth))
+	}
+	return dataList, errs
+}
+
+// 從網頁內容中提取連結。結果中的連結已經是去重過的絕對位址。
+// 網頁會先被解析為文件樹，因此注解、腳本以及CDATA中的標簽不會被當作連結，
+// 而<base>無論出現在何處都會作用於所有連結。
+func (extractor *linkExtractor) extract(pageUrl *url.URL, content []byte) ([]string, []error) {
+	links := make([]string, 0)
+	errs := make([]error, 0)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(content))
+	if err != nil {
+		return links, append(errs, err)
+	}
+	sources := extractor.args.Sources()
+	maxForms := extractor.args.MaxFormsPerPage()
+	baseUrl := pageUrl
+	// 只有第一個<base>會生效。
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := url.Parse(strings.TrimSpace(href)); err == nil {
+			baseUrl = pageUrl.ResolveReference(u)
+		}
+	}
+	linkSet := make(map[string]bool)
+	addLink := func(ref string) {
+		link, err := resolveLink(baseUrl, ref)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if link == "" || linkSet[link] {
+			return
+		}
+		linkSet[link] = true
+		links = append(links, link)
+	}
+	var formCount uint32
+	doc.Find("a, area, link, iframe, form").Each(func(index int, sel *goquery.Selection) {
+		switch goquery.NodeName(sel) {
+		case "a":
+			if sources&LINK_SOURCE_A != 0 {
+				addLink(sel.AttrOr("href", ""))
+			}
+		case "area":
+			if sources&LINK_SOURCE_AREA != 0 {
+				addLink(sel.AttrOr("href", ""))
+			}
+		case "link":
+			if sources&LINK_SOURCE_NEXT != 0 && hasRel(sel.AttrOr("rel", ""), "next") {
+				addLink(sel.AttrOr("href", ""))
+			}
+		case "iframe":
+			if sources&LINK_SOURCE_IFRAME != 0 {
+				addLink(sel.AttrOr("src", ""))
+			}
+		case "form":
+			if sources&LINK_SOURCE_FORM == 0 {
+				return
+			}
+			method := strings.ToLower(strings.TrimSpace(sel.AttrOr("method", "")))
+			if method != "" && method != "get" {
+				return
+			}
+			if maxForms > 0 && formCount >= maxForms {
+				return
+			}
+			formCount++
+			addLink(buildFormLink(newPendingForm(sel, baseUrl)))
+		}
+	})
+	return links, errs
+}
+
+// 根據表單元素及其字段的預設值建立需要被提交的表單。
+func newPendingForm(sel *goquery.Selection, baseUrl *url.URL) *pendingForm {
+	action := sel.AttrOr("action", "")
+	if strings.TrimSpace(action) == "" {
+		action = baseUrl.String()
+	}
+	form := &pendingForm{action: action}
+	sel.Find("input, textarea, select").Each(func(index int, field *goquery.Selection) {
+		name := field.AttrOr("name", "")
+		if name == "" {
+			return
+		}
+		switch goquery.NodeName(field) {
+		case "input":
+			if f, ok := inputField(field); ok {
+				form.fields = append(form.fields, f)
+			}
+		case "textarea":
+			form.fields = append(form.fields, formField{name, field.Text()})
+		case "select":
+			options := field.Find("option")
+			if options.Length() == 0 {
+				return
+			}
+			option := options.Filter("[selected]").First()
+			if option.Length() == 0 {
+				option = options.First()
+			}
+			value, ok := option.Attr("value")
+			if !ok {
+				value = strings.TrimSpace(option.Text())
+			}
+			form.fields = append(form.fields, formField{name, value})
+		}
+	})
+	return form
+}
+
+// 判斷rel屬性中是否包含指定的連結關系。
+func hasRel(rel string, target string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if r == target {
+			return true
+		}
+	}
+	return false
+}
+
+// 根據input元素產生表單字段。
+// 只有會被瀏覽器在預設狀態下提交的字段才會被產生。
+func inputField(sel *goquery.Selection) (formField, bool) {
+	name := sel.AttrOr("name", "")
+	if name == "" {
+		return formField{}, false
+	}
+	if _, disabled := sel.Attr("disabled"); disabled {
+		return formField{}, false
+	}
+	inputType := strings.ToLower(strings.TrimSpace(sel.AttrOr("type", "")))
+	switch inputType {
+	case "submit", "button", "reset", "image", "file":
+		return formField{}, false
+	case "checkbox", "radio":
+		if _, checked := sel.Attr("checked"); !checked {
+			return formField{}, false
+		}
+		value, ok := sel.Attr("value")
+		if !ok {
+			value = "on"
+		}
+		return formField{name, value}, true
+	}
+	return formField{name, sel.AttrOr("value", "")}, true
+}
+
+// 根據表單及其字段的預設值產生提交位址。
+func buildFormLink(form *pendingForm) string {
+	action := strings.TrimSpace(form.action)
+	if index := strings.Index(action, "#"); index >= 0 {
+		action = action[:index]
+	}
+	if index := strings.Index(action, "?"); index >= 0 {
+		action = action[:index]
+	}
+	values := url.Values{}
+	for _, field := range form.fields {
+		values.Add(field.name, field.value)
+	}
+	if len(values) == 0 {
+		return action
+	}
+	return action + "?" + values.Encode()
+}
+
+// 把連結解析為絕對位址。
+// 空連結、頁內錨點以及非HTTP(S)協定的連結會被忽略，此時結果為空字串。
+func resolveLink(baseUrl *url.URL, ref string) (string, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return "", nil
+	}
+	lowerRef := strings.ToLower(ref)
+	if strings.HasPrefix(lowerRef, "javascript:") ||
+		strings.HasPrefix(lowerRef, "mailto:") ||
+		strings.HasPrefix(lowerRef, "data:") {
+		return "", nil
+	}
+	refUrl, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	absUrl := baseUrl.ResolveReference(refUrl)
+	scheme := strings.ToLower(absUrl.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", nil
+	}
+	absUrl.Fragment = ""
+	return absUrl.String(), nil
+}
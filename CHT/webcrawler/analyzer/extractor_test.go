@@ -0,0 +1,110 @@
+package analyzer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	base "webcrawler/base"
+)
+
+var testPage = `<html><head>
+<base href="http://example.com/list/">
+<link rel="stylesheet" href="/style.css">
+<link rel="next" href="?page=2">
+</head><body>
+<!-- <a href="/commented">x</a> -->
+<script>document.write('<a href="/scripted">x</a>');</script>
+<a href="item/1">one</a>
+<a href="item/1#top">one again</a>
+<a href="javascript:void(0)">js</a>
+<a href="mailto:a@example.com">mail</a>
+<map><area href="/area" alt=""></map>
+<iframe src='/frame'></iframe>
+<form action="/search?ignored=1" method="GET">
+  <input type="hidden" name="cat" value="books">
+  <input type="text" name="q" value="go &amp; web">
+  <input type="checkbox" name="new" checked>
+  <input type="checkbox" name="used">
+  <input type="submit" name="go" value="Go">
+  <select name="sort"><option value="price">Price</option><option value="date" selected>Date</option></select>
+  <textarea name="note">hi</textarea>
+</form>
+<form action="/login" method="post"><input name="user" value="x"></form>
+</body></html>`
+
+func extractFromTestPage(t *testing.T, sources LinkSource) []string {
+	parser, err := NewLinkExtractor(NewLinkExtractorArgs(sources, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Link extractor initialization failing: %s\n", err)
+	}
+	httpReq, _ := http.NewRequest("GET", "http://example.com/list/index.html", nil)
+	httpResp := &http.Response{
+		StatusCode: 200,
+		Request:    httpReq,
+		Body:       ioutil.NopCloser(strings.NewReader(testPage)),
+	}
+	dataList, errs := parser(httpResp, 0)
+	if len(errs) > 0 {
+		t.Fatalf("ERROR: Link extraction failing: %v\n", errs)
+	}
+	links := make([]string, 0)
+	for _, data := range dataList {
+		links = append(links, data.(*base.Request).HttpReq().URL.String())
+	}
+	rest, _ := ioutil.ReadAll(httpResp.Body)
+	if string(rest) != testPage {
+		t.Errorf("ERROR: The response body is not replayable after extraction!\n")
+	}
+	return links
+}
+
+func TestLinkExtractor(t *testing.T) {
+	expected := []string{
+		"http://example.com/list/?page=2",
+		"http://example.com/list/item/1",
+		"http://example.com/area",
+		"http://example.com/frame",
+		"http://example.com/search?cat=books&new=on&note=hi&q=go+%26+web&sort=date",
+	}
+	links := extractFromTestPage(t, LINK_SOURCE_ALL)
+	if strings.Join(links, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("ERROR: The extracted links are\n%s\nbut should be\n%s\n",
+			strings.Join(links, "\n"), strings.Join(expected, "\n"))
+	}
+	links = extractFromTestPage(t, LINK_SOURCE_A)
+	if len(links) != 1 || links[0] != expected[1] {
+		t.Errorf("ERROR: The extracted links with only <a> are %v!\n", links)
+	}
+	args := NewLinkExtractorArgs(0, 0)
+	if _, err := NewLinkExtractor(args); err == nil {
+		t.Errorf("ERROR: The link extractor should not be initialized without sources!\n")
+	}
+}
+
+func TestLinkExtractorMarkup(t *testing.T) {
+	extractor := &linkExtractor{args: NewLinkExtractorArgs(LINK_SOURCE_ALL, 0)}
+	page := `<html><head><title>a <a href="/title"></title></head><body>
+<a
+  href="first">split</a>
+<!-- <a href="/commented"> --->
+<script>var s = "<!-- <a href='/scripted'>";</script>
+<style>a[href="/styled"] {}</style>
+<svg><![CDATA[<a href="/cdata">]]></svg>
+<textarea><a href="/textarea"></textarea>
+<base href="http://example.com/other/">
+<a href=second>second</a>
+<base href="http://example.com/ignored/">
+</body></html>`
+	pageUrl, _ := url.Parse("http://example.com/list/index.html")
+	links, errs := extractor.extract(pageUrl, []byte(page))
+	expected := []string{
+		"http://example.com/other/first",
+		"http://example.com/other/second",
+	}
+	if len(errs) > 0 || strings.Join(links, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("ERROR: The extracted links are\n%s\nbut should be\n%s\n(errs=%v)\n",
+			strings.Join(links, "\n"), strings.Join(expected, "\n"), errs)
+	}
+}
@@ -6,4 +6,5 @@ import (
 )
 
 // 被用於解析HTTP響應的函數型態。
+// 產生該響應的請求的中繼資料可以透過 base.MetaFromHttpReq(httpResp.Request) 取得。
 type ParseResponse func(httpResp *http.Response, respDepth uint32) ([]base.Data, []error)
@@ -3,7 +3,7 @@ package analyzer
 import (
 	"errors"
 	"fmt"
-	"reflect"
+	"time"
 	mdw "webcrawler/middleware"
 )
 
@@ -12,30 +12,47 @@ type GenAnalyzer func() Analyzer
 
 // 分析器池的接口型態。
 type AnalyzerPool interface {
-	Take() (Analyzer, error)        // 從池中取出一個分析器。
+	Take() (Analyzer, error) // 從池中取出一個分析器。
+	// 從池中取出一個分析器。至多等待參數timeout所代表的時間。
+	TakeTimeout(timeout time.Duration) (Analyzer, error)
 	Return(analyzer Analyzer) error // 把一個分析器歸還給池。
 	Total() uint32                  // 獲得池的總容量。
 	Used() uint32                   // 獲得正在被使用的分析器的數量。
+	Resize(total uint32) error      // 調整池的總容量。
+	// 設定分析器的健康檢查函數。未通過檢查的分析器會被新產生的分析器替換。
+	SetHealthCheck(check func(analyzer Analyzer) bool)
+	Stats() mdw.PoolStats // 獲得池的使用統計。
 }
 
 func NewAnalyzerPool(
 	total uint32,
 	gen GenAnalyzer) (AnalyzerPool, error) {
-	etype := reflect.TypeOf(gen())
+	if gen == nil {
+		return nil, errors.New("The analyzer generator can not be nil!\n")
+	}
 	genEntity := func() mdw.Entity {
 		return gen()
 	}
-	pool, err := mdw.NewPool(total, etype, genEntity)
+	pool, err := mdw.NewPool(total, genEntity)
 	if err != nil {
 		return nil, err
 	}
-	dlpool := &myAnalyzerPool{pool: pool, etype: etype}
+	dlpool := &myAnalyzerPool{pool: pool}
 	return dlpool, nil
 }
 
 type myAnalyzerPool struct {
-	pool  mdw.Pool     // 實體池。
-	etype reflect.Type // 池內實體的型態。
+	pool mdw.Pool // 實體池。
+}
+
+// 把實體轉換為分析器。
+func toAnalyzer(entity mdw.Entity) Analyzer {
+	analyzer, ok := entity.(Analyzer)
+	if !ok {
+		errMsg := fmt.Sprintf("The type of entity %T is NOT an analyzer!\n", entity)
+		panic(errors.New(errMsg))
+	}
+	return analyzer
 }
 
 func (spdpool *myAnalyzerPool) Take() (Analyzer, error) {
@@ -43,12 +60,15 @@ func (spdpool *myAnalyzerPool) Take() (Analyzer, error) {
 	if err != nil {
 		return nil, err
 	}
-	analyzer, ok := entity.(Analyzer)
-	if !ok {
-		errMsg := fmt.Sprintf("The type of entity is NOT %s!\n", spdpool.etype)
-		panic(errors.New(errMsg))
+	return toAnalyzer(entity), nil
+}
+
+func (spdpool *myAnalyzerPool) TakeTimeout(timeout time.Duration) (Analyzer, error) {
+	entity, err := spdpool.pool.TakeTimeout(timeout)
+	if err != nil {
+		return nil, err
 	}
-	return analyzer, nil
+	return toAnalyzer(entity), nil
 }
 
 func (spdpool *myAnalyzerPool) Return(analyzer Analyzer) error {
@@ -58,6 +78,25 @@ func (spdpool *myAnalyzerPool) Return(analyzer Analyzer) error {
 func (spdpool *myAnalyzerPool) Total() uint32 {
 	return spdpool.pool.Total()
 }
+
 func (spdpool *myAnalyzerPool) Used() uint32 {
 	return spdpool.pool.Used()
 }
+
+func (spdpool *myAnalyzerPool) Resize(total uint32) error {
+	return spdpool.pool.Resize(total)
+}
+
+func (spdpool *myAnalyzerPool) SetHealthCheck(check func(analyzer Analyzer) bool) {
+	if check == nil {
+		spdpool.pool.SetHealthCheck(nil)
+		return
+	}
+	spdpool.pool.SetHealthCheck(func(entity mdw.Entity) bool {
+		return check(toAnalyzer(entity))
+	})
+}
+
+func (spdpool *myAnalyzerPool) Stats() mdw.PoolStats {
+	return spdpool.pool.Stats()
+}
@@ -91,22 +91,33 @@ func (args *ChannelArgs) ErrorChanLen() uint {
 
 // 池基本參數容器的描述範本。
 var poolBaseArgsTemplate string = "{ pageDownloaderPoolSize: %d," +
-	" analyzerPoolSize: %d }"
+	" analyzerPoolSize: %d, itemPipelineConcurrency: %d }"
 
 // 池基本參數的容器。
 type PoolBaseArgs struct {
-	pageDownloaderPoolSize uint32 // 網頁下載器池的尺寸。
-	analyzerPoolSize       uint32 // 分析器池的尺寸。
-	description            string // 描述。
+	pageDownloaderPoolSize  uint32 // 網頁下載器池的尺寸。
+	analyzerPoolSize        uint32 // 分析器池的尺寸。
+	itemPipelineConcurrency uint32 // 項目處理管線的並行處理數量。
+	description             string // 描述。
 }
 
-// 建立池基本參數的容器。
+// 建立池基本參數的容器。項目處理管線的並行處理數量會與分析器池的尺寸相同。
 func NewPoolBaseArgs(
 	pageDownloaderPoolSize uint32,
 	analyzerPoolSize uint32) PoolBaseArgs {
+	return NewPoolBaseArgsWithPipeline(
+		pageDownloaderPoolSize, analyzerPoolSize, analyzerPoolSize)
+}
+
+// 建立池基本參數的容器，並指定項目處理管線的並行處理數量。
+func NewPoolBaseArgsWithPipeline(
+	pageDownloaderPoolSize uint32,
+	analyzerPoolSize uint32,
+	itemPipelineConcurrency uint32) PoolBaseArgs {
 	return PoolBaseArgs{
-		pageDownloaderPoolSize: pageDownloaderPoolSize,
-		analyzerPoolSize:       analyzerPoolSize,
+		pageDownloaderPoolSize:  pageDownloaderPoolSize,
+		analyzerPoolSize:        analyzerPoolSize,
+		itemPipelineConcurrency: itemPipelineConcurrency,
 	}
 }
 
@@ -117,6 +128,9 @@ func (args *PoolBaseArgs) Check() error {
 	if args.analyzerPoolSize == 0 {
 		return errors.New("The analyzer pool size can not be 0!\n")
 	}
+	if args.itemPipelineConcurrency == 0 {
+		return errors.New("The item pipeline concurrency can not be 0!\n")
+	}
 	return nil
 }
 
@@ -125,7 +139,8 @@ func (args *PoolBaseArgs) String() string {
 		args.description =
 			fmt.Sprintf(poolBaseArgsTemplate,
 				args.pageDownloaderPoolSize,
-				args.analyzerPoolSize)
+				args.analyzerPoolSize,
+				args.itemPipelineConcurrency)
 	}
 	return args.description
 }
@@ -139,3 +154,8 @@ func (args *PoolBaseArgs) PageDownloaderPoolSize() uint32 {
 func (args *PoolBaseArgs) AnalyzerPoolSize() uint32 {
 	return args.analyzerPoolSize
 }
+
+// 獲得項目處理管線的並行處理數量。
+func (args *PoolBaseArgs) ItemPipelineConcurrency() uint32 {
+	return args.itemPipelineConcurrency
+}
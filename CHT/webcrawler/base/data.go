@@ -1,7 +1,12 @@
 package base
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
 	"net/http"
+	"sync"
 )
 
 // 資料的接口。
@@ -13,11 +18,42 @@ type Data interface {
 type Request struct {
 	httpReq *http.Request // HTTP請求的指標值。
 	depth   uint32        // 請求的深度。
+	meta    Meta          // 中繼資料。
+	key     *requestKey   // 請求的鍵的快取。它被請求的所有複本共享。
+}
+
+// 請求的鍵的快取。
+type requestKey struct {
+	once  sync.Once // 確保鍵只被計算一次。
+	value string    // 鍵。
 }
 
 // 建立新的請求。
 func NewRequest(httpReq *http.Request, depth uint32) *Request {
-	return &Request{httpReq: httpReq, depth: depth}
+	return &Request{httpReq: httpReq, depth: depth, key: &requestKey{}}
+}
+
+// 建立帶有中繼資料的新的請求。
+func NewRequestWithMeta(httpReq *http.Request, depth uint32, meta Meta) *Request {
+	return &Request{httpReq: httpReq, depth: depth, meta: meta, key: &requestKey{}}
+}
+
+// 建立帶有請求體的新的請求，例如POST請求。
+// 請求體可以被重新讀取，因此該請求可以被安全地重試。
+func NewRequestWithBody(
+	method string,
+	urlStr string,
+	contentType string,
+	body []byte,
+	depth uint32) (*Request, error) {
+	httpReq, err := http.NewRequest(method, urlStr, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	return NewRequest(httpReq, depth), nil
 }
 
 // 取得HTTP請求。
@@ -30,6 +66,54 @@ func (req *Request) Depth() uint32 {
 	return req.depth
 }
 
+// 取得中繼資料。
+func (req *Request) Meta() Meta {
+	return req.meta
+}
+
+// 獲得請求的鍵，它被用來判斷請求是否重復。
+// GET請求的鍵即為其URL，其他請求的鍵還包含了方法和請求體的雜湊值。
+// 由建構函數建立的請求的鍵只會被計算一次，因此在取得鍵之後不應再修改HTTP請求的URL、方法和請求體。
+func (req *Request) Key() string {
+	if req.key == nil {
+		return req.computeKey()
+	}
+	req.key.once.Do(func() {
+		req.key.value = req.computeKey()
+	})
+	return req.key.value
+}
+
+// 計算請求的鍵。
+func (req *Request) computeKey() string {
+	httpReq := req.httpReq
+	if httpReq == nil || httpReq.URL == nil {
+		return ""
+	}
+	urlStr := httpReq.URL.String()
+	if httpReq.Method == "" || httpReq.Method == "GET" {
+		return urlStr
+	}
+	var body []byte
+	if httpReq.GetBody != nil {
+		if rc, err := httpReq.GetBody(); err == nil {
+			body, _ = ioutil.ReadAll(rc)
+			rc.Close()
+		}
+	}
+	sum := sha1.Sum(body)
+	return httpReq.Method + " " + urlStr + " " + hex.EncodeToString(sum[:])
+}
+
+// 判斷請求是否可以被安全地重新傳送，即：請求體不存在或可以被重新讀取。
+func (req *Request) Replayable() bool {
+	httpReq := req.httpReq
+	if httpReq == nil {
+		return false
+	}
+	return httpReq.Body == nil || httpReq.Body == http.NoBody || httpReq.GetBody != nil
+}
+
 // 資料是否有效。
 func (req *Request) Valid() bool {
 	return req.httpReq != nil && req.httpReq.URL != nil
@@ -37,8 +121,10 @@ func (req *Request) Valid() bool {
 
 // 響應。
 type Response struct {
-	httpResp *http.Response
-	depth    uint32
+	httpResp  *http.Response
+	depth     uint32
+	duplicate bool // 內容是否與已下載過的網頁重復。
+	meta      Meta // 中繼資料。來自於對應的請求。
 }
 
 // 建立新的響應。
@@ -46,6 +132,11 @@ func NewResponse(httpResp *http.Response, depth uint32) *Response {
 	return &Response{httpResp: httpResp, depth: depth}
 }
 
+// 建立帶有中繼資料的新的響應。
+func NewResponseWithMeta(httpResp *http.Response, depth uint32, meta Meta) *Response {
+	return &Response{httpResp: httpResp, depth: depth, meta: meta}
+}
+
 // 取得HTTP響應。
 func (resp *Response) HttpResp() *http.Response {
 	return resp.httpResp
@@ -56,11 +147,58 @@ func (resp *Response) Depth() uint32 {
 	return resp.depth
 }
 
+// 取得中繼資料。
+func (resp *Response) Meta() Meta {
+	return resp.meta
+}
+
+// 判斷內容是否與已下載過的網頁重復。
+func (resp *Response) Duplicate() bool {
+	return resp.duplicate
+}
+
+// 設定內容是否與已下載過的網頁重復。
+func (resp *Response) SetDuplicate(duplicate bool) {
+	resp.duplicate = duplicate
+}
+
 // 資料是否有效。
 func (resp *Response) Valid() bool {
 	return resp.httpResp != nil && resp.httpResp.Body != nil
 }
 
+// 讀取HTTP響應的內容，隨後以可重新讀取的形式放回。
+// 已被本函數讀取過的內容不會被再次讀取和複製，因此多個元件可以依次呼叫本函數而只付出一次的代價。
+// 讀取失敗時，已讀取的部分會被放回。
+func BufferBody(httpResp *http.Response) ([]byte, error) {
+	if body, ok := httpResp.Body.(*bufferedBody); ok {
+		httpResp.Body = newBufferedBody(body.content)
+		return body.content, nil
+	}
+	var content []byte
+	var err error
+	if httpResp.Body != nil {
+		content, err = ioutil.ReadAll(httpResp.Body)
+		httpResp.Body.Close()
+	}
+	httpResp.Body = newBufferedBody(content)
+	return content, err
+}
+
+// 已被緩衝的HTTP響應的內容。
+type bufferedBody struct {
+	*bytes.Reader
+	content []byte // 全部的內容。
+}
+
+func newBufferedBody(content []byte) *bufferedBody {
+	return &bufferedBody{Reader: bytes.NewReader(content), content: content}
+}
+
+func (body *bufferedBody) Close() error {
+	return nil
+}
+
 // 項目。
 type Item map[string]interface{}
 
@@ -68,3 +206,15 @@ type Item map[string]interface{}
 func (item Item) Valid() bool {
 	return item != nil
 }
+
+// 取得項目所攜帶的中繼資料。它通常來自於產生該項目的網頁所對應的請求。
+func (item Item) Meta() Meta {
+	meta, _ := item[ITEM_META_KEY].(Meta)
+	return meta
+}
+
+// 取得項目的種類。未指定種類的項目的種類為空字串。
+func (item Item) Kind() string {
+	kind, _ := item[ITEM_KIND_KEY].(string)
+	return kind
+}
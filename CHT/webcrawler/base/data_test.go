@@ -0,0 +1,69 @@
+package base
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBufferBody(t *testing.T) {
+	httpResp := &http.Response{Body: ioutil.NopCloser(strings.NewReader("<html>a</html>"))}
+	content, err := BufferBody(httpResp)
+	if err != nil {
+		t.Fatalf("ERROR: Buffer body failing: %s\n", err)
+	}
+	if string(content) != "<html>a</html>" {
+		t.Errorf("ERROR: The buffered content %q is wrong!\n", content)
+	}
+	// 讀取放回的響應體之後，再次緩衝的結果應是同一份內容，而響應體仍可被重新讀取。
+	if body, _ := ioutil.ReadAll(httpResp.Body); string(body) != "<html>a</html>" {
+		t.Errorf("ERROR: The replaced body %q is wrong!\n", body)
+	}
+	again, err := BufferBody(httpResp)
+	if err != nil {
+		t.Fatalf("ERROR: Buffer body again failing: %s\n", err)
+	}
+	if &again[0] != &content[0] {
+		t.Errorf("ERROR: The buffered content should not be copied again!\n")
+	}
+	if body, _ := ioutil.ReadAll(httpResp.Body); string(body) != "<html>a</html>" {
+		t.Errorf("ERROR: The body %q after buffering again is wrong!\n", body)
+	}
+
+	httpResp = &http.Response{}
+	content, err = BufferBody(httpResp)
+	if err != nil || len(content) != 0 || httpResp.Body == nil {
+		t.Errorf("ERROR: Buffering an absent body should result in an empty body!\n")
+	}
+}
+
+func TestRequestKey(t *testing.T) {
+	req, err := NewRequestWithBody("POST", "http://example.com/search", "", []byte("q=go"), 0)
+	if err != nil {
+		t.Fatalf("ERROR: Request initialization failing: %s\n", err)
+	}
+	var reads int
+	getBody := req.HttpReq().GetBody
+	req.HttpReq().GetBody = func() (io.ReadCloser, error) {
+		reads++
+		return getBody()
+	}
+	key := req.Key()
+	// 請求的複本共享鍵的快取，因此請求體只會被讀取一次。
+	copied := *req
+	if copied.Key() != key || req.Key() != key || reads != 1 {
+		t.Errorf("ERROR: The key %q is computed %d times!\n", key, reads)
+	}
+	other, _ := NewRequestWithBody("POST", "http://example.com/search", "", []byte("q=rust"), 0)
+	if other.Key() == key {
+		t.Errorf("ERROR: The requests with different bodies have the same key!\n")
+	}
+	// 不經由建構函數建立的請求不會快取鍵。
+	httpReq, _ := http.NewRequest("PUT", "http://example.com/", bytes.NewReader([]byte("a")))
+	if (&Request{httpReq: httpReq}).Key() != (&Request{httpReq: httpReq}).Key() {
+		t.Errorf("ERROR: The key of the request without cache is not stable!\n")
+	}
+}
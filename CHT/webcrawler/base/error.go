@@ -2,7 +2,10 @@ package base
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // 錯誤型態。
@@ -13,24 +16,62 @@ const (
 	DOWNLOADER_ERROR     ErrorType = "Downloader Error"
 	ANALYZER_ERROR       ErrorType = "Analyzer Error"
 	ITEM_PROCESSOR_ERROR ErrorType = "Item Processor Error"
+	SCHEDULER_ERROR      ErrorType = "Scheduler Error"
+	FILTER_ERROR         ErrorType = "Filter Error"
+	ROBOTS_ERROR         ErrorType = "Robots Error"
 )
 
 // 爬蟲錯誤的接口。
 type CrawlerError interface {
-	Type() ErrorType // 獲得錯誤型態。
-	Error() string   // 獲得錯誤提示訊息。
+	Type() ErrorType      // 獲得錯誤型態。
+	Error() string        // 獲得錯誤提示訊息。
+	Url() string          // 獲得相關請求的URL。未知時為空字串。
+	Depth() uint32        // 獲得相關請求的深度。
+	ComponentId() string  // 獲得產生錯誤的元件案例代號。未知時為空字串。
+	StatusCode() int      // 獲得相關響應的HTTP狀態碼。未知時為0。
+	Cause() error         // 獲得原始錯誤。可能為nil。
+	Unwrap() error        // 獲得原始錯誤。它使 errors.Is 和 errors.As 可以檢查原始錯誤。
+	Timestamp() time.Time // 獲得錯誤產生的時間。
+	Retryable() bool      // 判斷產生錯誤的動作是否值得重試。
+}
+
+// 爬蟲錯誤的詳細訊息。未知的字段保持零值即可。
+type ErrorDetail struct {
+	Url         string // 相關請求的URL。
+	Depth       uint32 // 相關請求的深度。
+	ComponentId string // 產生錯誤的元件案例代號。
+	StatusCode  int    // 相關響應的HTTP狀態碼。
+	Retryable   bool   // 產生錯誤的動作是否值得重試。
 }
 
 // 爬蟲錯誤的實現。
 type myCrawlerError struct {
-	errType    ErrorType // 錯誤型態。
-	errMsg     string    // 錯誤提示訊息。
-	fullErrMsg string    // 完整的錯誤提示訊息。
+	errType    ErrorType   // 錯誤型態。
+	errMsg     string      // 錯誤提示訊息。
+	cause      error       // 原始錯誤。
+	detail     ErrorDetail // 詳細訊息。
+	timestamp  time.Time   // 錯誤產生的時間。
+	fullErrMsg string      // 完整的錯誤提示訊息。
 }
 
 // 建立一個新的爬蟲錯誤。
 func NewCrawlerError(errType ErrorType, errMsg string) CrawlerError {
-	return &myCrawlerError{errType: errType, errMsg: errMsg}
+	return NewCrawlerErrorWithDetail(errType, errors.New(errMsg), ErrorDetail{})
+}
+
+// 建立一個包裝了原始錯誤並帶有詳細訊息的爬蟲錯誤。
+func NewCrawlerErrorWithDetail(errType ErrorType, cause error, detail ErrorDetail) CrawlerError {
+	ce := &myCrawlerError{
+		errType:   errType,
+		cause:     cause,
+		detail:    detail,
+		timestamp: time.Now(),
+	}
+	if cause != nil {
+		ce.errMsg = strings.TrimRight(cause.Error(), "\n")
+	}
+	ce.genFullErrMsg()
+	return ce
 }
 
 // 獲得錯誤型態。
@@ -40,13 +81,43 @@ func (ce *myCrawlerError) Type() ErrorType {
 
 // 獲得錯誤提示訊息。
 func (ce *myCrawlerError) Error() string {
-	if ce.fullErrMsg == "" {
-		ce.genFullErrMsg()
-	}
 	return ce.fullErrMsg
 }
 
+func (ce *myCrawlerError) Url() string {
+	return ce.detail.Url
+}
+
+func (ce *myCrawlerError) Depth() uint32 {
+	return ce.detail.Depth
+}
+
+func (ce *myCrawlerError) ComponentId() string {
+	return ce.detail.ComponentId
+}
+
+func (ce *myCrawlerError) StatusCode() int {
+	return ce.detail.StatusCode
+}
+
+func (ce *myCrawlerError) Cause() error {
+	return ce.cause
+}
+
+func (ce *myCrawlerError) Unwrap() error {
+	return ce.cause
+}
+
+func (ce *myCrawlerError) Timestamp() time.Time {
+	return ce.timestamp
+}
+
+func (ce *myCrawlerError) Retryable() bool {
+	return ce.detail.Retryable
+}
+
 // 產生錯誤提示訊息，並給對應的字段給予值。
+// 詳細訊息中的已知字段會以括號的形式附加在錯誤提示訊息之後。
 func (ce *myCrawlerError) genFullErrMsg() {
 	var buffer bytes.Buffer
 	buffer.WriteString("Crawler Error: ")
@@ -55,6 +126,27 @@ func (ce *myCrawlerError) genFullErrMsg() {
 		buffer.WriteString(": ")
 	}
 	buffer.WriteString(ce.errMsg)
+	detail := ce.detail
+	if detail.Url != "" || detail.ComponentId != "" || detail.StatusCode != 0 {
+		buffer.WriteString(" (")
+		sep := ""
+		if detail.Url != "" {
+			buffer.WriteString(fmt.Sprintf("url=%s, depth=%d", detail.Url, detail.Depth))
+			sep = ", "
+		}
+		if detail.ComponentId != "" {
+			buffer.WriteString(fmt.Sprintf("%scomponent=%s", sep, detail.ComponentId))
+			sep = ", "
+		}
+		if detail.StatusCode != 0 {
+			buffer.WriteString(fmt.Sprintf("%sstatus=%d", sep, detail.StatusCode))
+			sep = ", "
+		}
+		if detail.Retryable {
+			buffer.WriteString(sep + "retryable")
+		}
+		buffer.WriteString(")")
+	}
 	ce.fullErrMsg = fmt.Sprintf("%s\n", buffer.String())
 	return
 }
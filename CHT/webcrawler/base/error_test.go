@@ -0,0 +1,49 @@
+package base
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCrawlerError(t *testing.T) {
+	ce := NewCrawlerError(ANALYZER_ERROR, "Bad response!\n")
+	if ce.Error() != "Crawler Error: Analyzer Error: Bad response!\n" {
+		t.Errorf("ERROR: Unexpected error message %q!\n", ce.Error())
+	}
+	if ce.Url() != "" || ce.StatusCode() != 0 || ce.Retryable() {
+		t.Errorf("ERROR: Unexpected detail of plain crawler error!\n")
+	}
+
+	cause := &os.PathError{Op: "open", Path: "x", Err: os.ErrNotExist}
+	detail := ErrorDetail{
+		Url:         "http://example.com/a",
+		Depth:       2,
+		ComponentId: "downloader-3",
+		StatusCode:  503,
+		Retryable:   true,
+	}
+	ce = NewCrawlerErrorWithDetail(DOWNLOADER_ERROR, cause, detail)
+	if !errors.Is(ce, os.ErrNotExist) {
+		t.Errorf("ERROR: The cause can not be found by errors.Is!\n")
+	}
+	var pathErr *os.PathError
+	if !errors.As(ce, &pathErr) || pathErr != cause {
+		t.Errorf("ERROR: The cause can not be found by errors.As!\n")
+	}
+	var found CrawlerError
+	wrapped := errors.New("outer")
+	if errors.As(wrapped, &found) {
+		t.Errorf("ERROR: A plain error is regarded as crawler error!\n")
+	}
+	for _, part := range []string{"url=http://example.com/a", "depth=2",
+		"component=downloader-3", "status=503", "retryable"} {
+		if !strings.Contains(ce.Error(), part) {
+			t.Errorf("ERROR: The error message %q does not contain %q!\n", ce.Error(), part)
+		}
+	}
+	if ce.Timestamp().IsZero() {
+		t.Errorf("ERROR: The timestamp is not set!\n")
+	}
+}
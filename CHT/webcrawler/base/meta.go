@@ -0,0 +1,128 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// 中繼資料的保留鍵。
+const (
+	META_KEY_PARENT_URL = "parent_url" // 父網頁的URL。
+	META_KEY_REFERER    = "referer"    // 引用頁的URL。
+	META_KEY_PAGE_URL   = "page_url"   // 產生項目的網頁的URL。只存在於項目的中繼資料中。
+)
+
+// 項目中存放中繼資料的保留鍵。
+// 項目處理器和階段收到的項目中都帶有它，但項目結構的驗證會忽略它。
+const ITEM_META_KEY = "_meta"
+
+// 項目中存放項目種類的保留鍵。它的值應為字串。
+const ITEM_KIND_KEY = "_kind"
+
+// 項目中存放去重鍵的保留鍵。它由項目處理管線中的去重階段寫入。
+const ITEM_DEDUP_KEY = "_dedup_key"
+
+// 中繼資料。它是不可變的，所有的修改動作都會傳回一個新的值。
+type Meta struct {
+	m map[string]interface{} // 鍵值對的容器。
+}
+
+// 建立中繼資料。參數pairs中的鍵值對會被複製。
+func NewMeta(pairs map[string]interface{}) Meta {
+	m := make(map[string]interface{}, len(pairs))
+	for k, v := range pairs {
+		m[k] = v
+	}
+	return Meta{m: m}
+}
+
+// 取得與鍵對應的值。
+func (meta Meta) Get(key string) (interface{}, bool) {
+	v, ok := meta.m[key]
+	return v, ok
+}
+
+// 取得與鍵對應的字串值。若鍵不存在或值不是字串，則傳回空字串。
+func (meta Meta) GetString(key string) string {
+	v, _ := meta.m[key].(string)
+	return v
+}
+
+// 傳回加入了指定鍵值對的新的中繼資料。
+func (meta Meta) With(key string, value interface{}) Meta {
+	m := make(map[string]interface{}, len(meta.m)+1)
+	for k, v := range meta.m {
+		m[k] = v
+	}
+	m[key] = value
+	return Meta{m: m}
+}
+
+// 傳回合並了另一份中繼資料的新的中繼資料。鍵相同時以另一份中的值為準。
+func (meta Meta) Merge(other Meta) Meta {
+	if len(other.m) == 0 {
+		return meta
+	}
+	if len(meta.m) == 0 {
+		return other
+	}
+	m := make(map[string]interface{}, len(meta.m)+len(other.m))
+	for k, v := range meta.m {
+		m[k] = v
+	}
+	for k, v := range other.m {
+		m[k] = v
+	}
+	return Meta{m: m}
+}
+
+// 獲得鍵值對的數量。
+func (meta Meta) Len() int {
+	return len(meta.m)
+}
+
+// 獲得已排序的鍵的清單。
+func (meta Meta) Keys() []string {
+	keys := make([]string, 0, len(meta.m))
+	for k := range meta.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 獲得中繼資料的副本。
+func (meta Meta) ToMap() map[string]interface{} {
+	m := make(map[string]interface{}, len(meta.m))
+	for k, v := range meta.m {
+		m[k] = v
+	}
+	return m
+}
+
+func (meta Meta) String() string {
+	return fmt.Sprintf("%v", meta.m)
+}
+
+// 中繼資料在上下文中的鍵的型態。
+type metaContextKey struct{}
+
+// 傳回帶有中繼資料的HTTP請求的副本。
+func HttpReqWithMeta(httpReq *http.Request, meta Meta) *http.Request {
+	ctx := context.WithValue(httpReq.Context(), metaContextKey{}, meta)
+	return httpReq.WithContext(ctx)
+}
+
+// 取得HTTP請求所攜帶的中繼資料。
+// 響應解析函數可以以此取得產生該響應的請求的中繼資料，例如：
+//
+//	meta := base.MetaFromHttpReq(httpResp.Request)
+func MetaFromHttpReq(httpReq *http.Request) Meta {
+	if httpReq == nil {
+		return Meta{}
+	}
+	meta, _ := httpReq.Context().Value(metaContextKey{}).(Meta)
+	return meta
+}
@@ -8,13 +8,20 @@ import (
 	"logging"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 	"webcrawler/analyzer"
 	base "webcrawler/base"
+	"webcrawler/downloader"
+	"webcrawler/fingerprint"
+	"webcrawler/graph"
 	pipeline "webcrawler/itempipeline"
+	mdw "webcrawler/middleware"
 	sched "webcrawler/scheduler"
 	"webcrawler/tool"
+	"webcrawler/tool/cookie"
+	"webcrawler/tool/session"
 )
 
 // 日志記錄器。
@@ -90,6 +97,7 @@ func parseForATag(httpResp *http.Response, respDepth uint32) ([]base.Data, []err
 		text := strings.TrimSpace(sel.Text())
 		if text != "" {
 			imap := make(map[string]interface{})
+			imap[base.ITEM_KIND_KEY] = "anchor"
 			imap["parent_url"] = reqUrl
 			imap["a.text"] = text
 			imap["a.index"] = index
@@ -116,9 +124,30 @@ func getItemProcessors() []pipeline.ProcessItem {
 	return itemProcessors
 }
 
-// 產生HTTP用戶端。
+// 在所有HTTP用戶端之間共享的cookie容器。
+var cookieJar http.CookieJar = cookie.NewCookiejar()
+
+// 產生HTTP用戶端。設定了會話時，分派器會改用會話的 HttpClient 方法。
 func genHttpClient() *http.Client {
-	return &http.Client{}
+	return &http.Client{Jar: cookieJar}
+}
+
+// 網頁下載器的參數：輪流使用的User-Agent，以及最多兩次的重試。
+var downloaderArgs = downloader.NewDownloaderArgs(
+	downloader.NewHeaderPolicy(
+		http.Header{"Accept-Language": {"zh-TW,zh;q=0.8"}},
+		[]string{
+			"Mozilla/5.0 (compatible; webcrawler/1.0)",
+			"Mozilla/5.0 (X11; Linux x86_64; webcrawler/1.0)",
+		},
+		nil),
+	2,
+	500*time.Millisecond)
+
+// 產生網頁下載器。網頁下載器的參數在開啟分派器之前已被檢查過，因此這裡不會出錯。
+func genPageDownloader(httpClient *http.Client) downloader.PageDownloader {
+	pageDownloader, _ := downloader.NewPageDownloaderWithArgs(httpClient, downloaderArgs)
+	return pageDownloader
 }
 
 func record(level byte, content string) {
@@ -138,18 +167,70 @@ func record(level byte, content string) {
 func main() {
 	// 建立分派器
 	scheduler := sched.NewScheduler()
+	// 設定內容去重器
+	deduper, err := fingerprint.NewContentDeduper(3)
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	scheduler.SetContentDeduper(deduper, true)
+	// 設定網頁下載器的產生函數
+	if err := downloaderArgs.Check(); err != nil {
+		logger.Errorln(err)
+		return
+	}
+	scheduler.SetPageDownloaderGenerator(genPageDownloader)
+	// 設定爬取圖
+	crawlGraph := graph.NewCrawlGraph()
+	scheduler.SetCrawlGraph(crawlGraph)
+	// 設定會話：若果環境變數 WEBCRAWLER_COOKIE_FILE 指定了Netscape格式的cookie檔案，那麼以其中的cookie登入
+	if cookieFile := os.Getenv("WEBCRAWLER_COOKIE_FILE"); cookieFile != "" {
+		crawlSession, err := session.NewSession(
+			session.NewCookieFileAuthenticator(cookieFile), nil, cookieJar, nil)
+		if err != nil {
+			logger.Errorln(err)
+			return
+		}
+		scheduler.SetSession(crawlSession)
+	}
+	// 設定陷阱檢測器
+	trapDetector, err := mdw.NewTrapDetector(mdw.NewTrapDetectorArgs(3, 2048, 20, 200))
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	scheduler.SetTrapDetector(trapDetector)
+	// 設定爬取預算
+	scheduler.SetBudgetArgs(sched.NewBudgetArgs(500, 50<<20, 5*time.Minute, 200))
+
+	// 設定項目結構
+	anchorSchema, err := pipeline.NewItemSchema("anchor",
+		pipeline.NewField("a.text", pipeline.FIELD_TYPE_STRING, true, pipeline.NotEmpty()),
+		pipeline.NewField("a.index", pipeline.FIELD_TYPE_INT, true))
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
+	schemas := pipeline.NewSchemaRegistry()
+	schemas.Register(anchorSchema)
+	scheduler.SetItemSchemas(schemas, pipeline.NewJSONDeadLetterSink(os.Stderr))
 
 	// 準備監控參數
 	intervalNs := 10 * time.Millisecond
-	maxIdleCount := uint(1000)
+	// 準備警報規則
+	errorRateRule, err := tool.NewErrorRateRule(0.5, 10)
+	if err != nil {
+		logger.Errorln(err)
+		return
+	}
 	// 開始監控
 	checkCountChan := tool.Monitoring(
 		scheduler,
 		intervalNs,
-		maxIdleCount,
 		true,
-		false,
-		record)
+		tool.NewRecordReporter(record, time.Second, false),
+		errorRateRule,
+		tool.NewEmptyFrontierRule(5*time.Second))
 
 	// 準備啟動參數
 	channelArgs := base.NewChannelArgs(10, 10, 10, 10)
@@ -176,4 +257,6 @@ func main() {
 
 	// 等待監控結束
 	<-checkCountChan
+	// 輸出爬取報告
+	logger.Infof("Crawl report:\n%s", crawlGraph.Report())
 }
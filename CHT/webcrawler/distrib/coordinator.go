@@ -0,0 +1,472 @@
+package distrib
+
+import (
+	"errors"
+	"fmt"
+	"logging"
+	"net"
+	"net/http"
+	"net/rpc"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	base "webcrawler/base"
+	mdw "webcrawler/middleware"
+	sched "webcrawler/scheduler"
+)
+
+// 日志記錄器。
+var logger logging.Logger = base.NewLogger()
+
+// 協調者參數容器的描述範本。
+var coordinatorArgsTemplate string = "{ crawlDepth: %d, minWorkers: %d, workerTimeout: %s }"
+
+// 協調者參數的容器。
+type CoordinatorArgs struct {
+	crawlDepth    uint32        // 爬取的最大深度。
+	minWorkers    uint32        // 開始分配請求之前至少需要加入的工作者的數量。
+	workerTimeout time.Duration // 工作者的逾時時間。超過該時間未與協調者聯系的工作者會被移除。
+	description   string        // 描述。
+}
+
+// 建立協調者參數的容器。
+// 在工作者數量達到參數minWorkers之前，協調者不會分配任何請求，
+// 以避免主機在工作者陸續加入的過程中被移交給其他工作者。
+func NewCoordinatorArgs(
+	crawlDepth uint32,
+	minWorkers uint32,
+	workerTimeout time.Duration) CoordinatorArgs {
+	return CoordinatorArgs{
+		crawlDepth:    crawlDepth,
+		minWorkers:    minWorkers,
+		workerTimeout: workerTimeout,
+	}
+}
+
+func (args *CoordinatorArgs) Check() error {
+	if args.workerTimeout <= 0 {
+		return errors.New("The worker timeout must be positive!\n")
+	}
+	return nil
+}
+
+func (args *CoordinatorArgs) String() string {
+	if args.description == "" {
+		args.description =
+			fmt.Sprintf(coordinatorArgsTemplate,
+				args.crawlDepth,
+				args.minWorkers,
+				args.workerTimeout)
+	}
+	return args.description
+}
+
+// 獲得爬取的最大深度。
+func (args *CoordinatorArgs) CrawlDepth() uint32 {
+	return args.crawlDepth
+}
+
+// 獲得開始分配請求之前至少需要加入的工作者的數量。
+func (args *CoordinatorArgs) MinWorkers() uint32 {
+	return args.minWorkers
+}
+
+// 獲得工作者的逾時時間。
+func (args *CoordinatorArgs) WorkerTimeout() time.Duration {
+	return args.workerTimeout
+}
+
+// 協調者的接口型態。
+// 協調者持有待爬取的請求以及已請求的URL的字典，並依據主機名把請求分配給各個工作者。
+// 同一主機的請求總是被分配給同一個工作者，因此針對主機的禮貌策略可以在工作者內部實現。
+type Coordinator interface {
+	// 開始在指定的網路位址上提供服務，並以參數seeds作為爬取流程的起始點。
+	// 種子請求的主域名決定了允許爬取的範圍。
+	Start(addr string, seeds []*http.Request) error
+	// 獲得實際監聽的網路位址。
+	Addr() string
+	// 獲得一個會在爬取流程結束或者服務被停止時被關閉的通道。
+	Done() <-chan struct{}
+	// 停止服務。
+	Stop() error
+	// 獲得已處理的請求、已提取的項目以及錯誤的計數值。
+	// 作為結果值的切片總會有三個元素值。
+	Count() []uint64
+	// 取得摘要訊息。
+	Summary() string
+}
+
+// 建立協調者。
+// 參數seenSet代表被用來判斷請求是否重復的已見集合，為nil時會使用基於記憶體的精確的已見集合。
+func NewCoordinator(args CoordinatorArgs, seenSet mdw.SeenSet) (Coordinator, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	if seenSet == nil {
+		seenSet = mdw.NewMemorySeenSet()
+	}
+	return &myCoordinator{
+		args:           args,
+		ring:           NewHashRing(),
+		queues:         make(map[string][]WireRequest),
+		inflight:       make(map[string]map[uint64]WireRequest),
+		lastContact:    make(map[string]time.Time),
+		seenSet:        seenSet,
+		primaryDomains: make(map[string]bool),
+		done:           make(chan struct{}),
+	}, nil
+}
+
+// 協調者的實現型態。
+type myCoordinator struct {
+	args           CoordinatorArgs                   // 參數的容器。
+	listener       net.Listener                      // 網路監聽器。
+	ring           HashRing                          // 工作者的一致性雜湊環。
+	queues         map[string][]WireRequest          // 各工作者的待處理請求的佇列。
+	orphans        []WireRequest                     // 尚無工作者可以處理的請求。
+	inflight       map[string]map[uint64]WireRequest // 各工作者正在處理的請求。
+	lastContact    map[string]time.Time              // 各工作者最後一次與協調者聯系的時間。
+	seenSet        mdw.SeenSet                       // 已請求的URL的已見集合。
+	primaryDomains map[string]bool                   // 允許爬取的主域名的集合。
+	nextId         uint64                            // 下一個請求的ID。
+	processed      uint64                            // 已處理的請求的數量。
+	items          uint64                            // 已提取的項目的數量。
+	errors         uint64                            // 錯誤的數量。
+	started        bool                              // 是否已開始。
+	quorum         bool                              // 工作者的數量是否曾達到下限。
+	done           chan struct{}                     // 爬取流程結束的知會通道。
+	mutex          sync.Mutex                        // 互斥鎖。
+}
+
+func (coord *myCoordinator) Start(addr string, seeds []*http.Request) error {
+	if len(seeds) == 0 {
+		return errors.New("The seed request list is empty!")
+	}
+	coord.mutex.Lock()
+	if coord.started {
+		coord.mutex.Unlock()
+		return errors.New("The coordinator has been started!")
+	}
+	for _, seed := range seeds {
+		if seed == nil || seed.URL == nil {
+			coord.mutex.Unlock()
+			return errors.New("The seed request is invalid!")
+		}
+		pd, err := sched.GetPrimaryDomain(seed.Host)
+		if err != nil {
+			coord.mutex.Unlock()
+			return err
+		}
+		coord.primaryDomains[pd] = true
+	}
+	coord.mutex.Unlock()
+
+	// 只有在監聽器和RPC服務都就緒之後，協調者才會被標記為已開始。
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName(coordinatorServiceName, &coordinatorService{coord: coord}); err != nil {
+		listener.Close()
+		return err
+	}
+	coord.mutex.Lock()
+	if coord.started {
+		coord.mutex.Unlock()
+		listener.Close()
+		return errors.New("The coordinator has been started!")
+	}
+	for _, seed := range seeds {
+		coord.admit(base.NewRequest(seed, 0))
+	}
+	coord.started = true
+	coord.listener = listener
+	// 所有的種子請求都可能被拒絕，此時爬取流程立即結束。
+	coord.checkDone()
+	coord.mutex.Unlock()
+	go server.Accept(listener)
+	logger.Infof("The coordinator is serving at %s.\n", listener.Addr())
+	return nil
+}
+
+func (coord *myCoordinator) Addr() string {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+	if coord.listener == nil {
+		return ""
+	}
+	return coord.listener.Addr().String()
+}
+
+func (coord *myCoordinator) Done() <-chan struct{} {
+	return coord.done
+}
+
+func (coord *myCoordinator) Stop() error {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+	if coord.listener == nil {
+		return errors.New("The coordinator has not been started!")
+	}
+	// 停止服務也會關閉知會通道，以免等待爬取流程結束的呼叫方被永遠阻塞。
+	select {
+	case <-coord.done:
+	default:
+		close(coord.done)
+	}
+	return coord.listener.Close()
+}
+
+func (coord *myCoordinator) Count() []uint64 {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+	return []uint64{coord.processed, coord.items, coord.errors}
+}
+
+var coordinatorSummaryTemplate = "args: %s, workers: %v, queued: %d, inflight: %d," +
+	" urls: %d, processed: %d, items: %d, errors: %d"
+
+func (coord *myCoordinator) Summary() string {
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+	queued := len(coord.orphans)
+	for _, queue := range coord.queues {
+		queued += len(queue)
+	}
+	inflight := 0
+	for _, reqs := range coord.inflight {
+		inflight += len(reqs)
+	}
+	return fmt.Sprintf(coordinatorSummaryTemplate,
+		coord.args.String(), coord.ring.Nodes(), queued, inflight,
+		coord.seenSet.Len(), coord.processed, coord.items, coord.errors)
+}
+
+// 判斷請求是否可以被接受，若可以則將其放入對應工作者的佇列。呼叫方應持有互斥鎖。
+func (coord *myCoordinator) admit(req *base.Request) bool {
+	httpReq := req.HttpReq()
+	if httpReq == nil || httpReq.URL == nil {
+		return false
+	}
+	if strings.ToLower(httpReq.URL.Scheme) != "http" {
+		return false
+	}
+	if req.Depth() > coord.args.CrawlDepth() {
+		return false
+	}
+	if pd, _ := sched.GetPrimaryDomain(httpReq.Host); !coord.primaryDomains[pd] {
+		return false
+	}
+	wreq, err := toWireRequest(req)
+	if err != nil {
+		logger.Warnf("Ignore the request! It can not be transmitted: %s\n", err)
+		return false
+	}
+	if !coord.seenSet.Add(req.Key()) {
+		return false
+	}
+	coord.nextId++
+	wreq.Id = coord.nextId
+	coord.enqueue(wreq)
+	return true
+}
+
+// 把請求放入負責其主機的工作者的佇列。呼叫方應持有互斥鎖。
+func (coord *myCoordinator) enqueue(wreq WireRequest) {
+	worker := coord.ring.Get(hostOf(wreq.Url))
+	if worker == "" {
+		coord.orphans = append(coord.orphans, wreq)
+		return
+	}
+	coord.queues[worker] = append(coord.queues[worker], wreq)
+}
+
+// 在工作者變動之後重新分配所有尚未被拉取的請求。呼叫方應持有互斥鎖。
+func (coord *myCoordinator) rebalance(extra []WireRequest) {
+	pending := append(extra, coord.orphans...)
+	coord.orphans = nil
+	for worker, queue := range coord.queues {
+		pending = append(pending, queue...)
+		coord.queues[worker] = nil
+	}
+	for _, wreq := range pending {
+		coord.enqueue(wreq)
+	}
+}
+
+// 加入工作者。呼叫方應持有互斥鎖。
+func (coord *myCoordinator) addWorker(workerId string) {
+	coord.lastContact[workerId] = time.Now()
+	if _, ok := coord.inflight[workerId]; ok {
+		return
+	}
+	logger.Infof("The worker '%s' joined.\n", workerId)
+	coord.inflight[workerId] = make(map[uint64]WireRequest)
+	coord.queues[workerId] = nil
+	coord.ring.Add(workerId)
+	coord.rebalance(nil)
+}
+
+// 移除工作者，並把其正在處理的請求重新分配給其他工作者。呼叫方應持有互斥鎖。
+func (coord *myCoordinator) removeWorker(workerId string) {
+	inflight, ok := coord.inflight[workerId]
+	if !ok {
+		return
+	}
+	logger.Infof("The worker '%s' left.\n", workerId)
+	coord.ring.Remove(workerId)
+	delete(coord.inflight, workerId)
+	delete(coord.lastContact, workerId)
+	extra := coord.queues[workerId]
+	delete(coord.queues, workerId)
+	for _, wreq := range inflight {
+		extra = append(extra, wreq)
+	}
+	coord.rebalance(extra)
+}
+
+// 移除逾時的工作者。呼叫方應持有互斥鎖。
+func (coord *myCoordinator) expireWorkers() {
+	for workerId, t := range coord.lastContact {
+		if time.Since(t) > coord.args.WorkerTimeout() {
+			coord.removeWorker(workerId)
+		}
+	}
+}
+
+// 檢查爬取流程是否已經結束，若是則關閉知會通道。呼叫方應持有互斥鎖。
+func (coord *myCoordinator) checkDone() {
+	if !coord.started || len(coord.orphans) > 0 {
+		return
+	}
+	for _, queue := range coord.queues {
+		if len(queue) > 0 {
+			return
+		}
+	}
+	for _, reqs := range coord.inflight {
+		if len(reqs) > 0 {
+			return
+		}
+	}
+	select {
+	case <-coord.done:
+	default:
+		logger.Infoln("The distributed crawling is done.")
+		close(coord.done)
+	}
+}
+
+// 判斷爬取流程是否已經結束。
+func (coord *myCoordinator) isDone() bool {
+	select {
+	case <-coord.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// 獲得URL中的主機部分。
+func hostOf(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// 協調者的RPC服務。
+type coordinatorService struct {
+	coord *myCoordinator // 所屬的協調者。
+}
+
+// 註冊工作者。
+func (service *coordinatorService) Register(args RegisterArgs, reply *RegisterReply) error {
+	if args.WorkerId == "" {
+		return errors.New("The worker id is empty!")
+	}
+	coord := service.coord
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+	coord.addWorker(args.WorkerId)
+	return nil
+}
+
+// 註銷工作者。
+func (service *coordinatorService) Unregister(args RegisterArgs, reply *RegisterReply) error {
+	coord := service.coord
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+	coord.removeWorker(args.WorkerId)
+	coord.checkDone()
+	return nil
+}
+
+// 拉取分配給工作者的請求。
+func (service *coordinatorService) Pull(args PullArgs, reply *PullReply) error {
+	coord := service.coord
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+	if coord.isDone() {
+		reply.Done = true
+		return nil
+	}
+	coord.expireWorkers()
+	inflight, ok := coord.inflight[args.WorkerId]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unknown worker '%s'!", args.WorkerId))
+	}
+	coord.lastContact[args.WorkerId] = time.Now()
+	if !coord.quorum {
+		if uint32(len(coord.inflight)) < coord.args.MinWorkers() {
+			return nil
+		}
+		coord.quorum = true
+	}
+	queue := coord.queues[args.WorkerId]
+	n := int(args.Max)
+	if n <= 0 || n > len(queue) {
+		n = len(queue)
+	}
+	reply.Requests = append([]WireRequest(nil), queue[:n]...)
+	coord.queues[args.WorkerId] = queue[n:]
+	for _, wreq := range reply.Requests {
+		inflight[wreq.Id] = wreq
+	}
+	return nil
+}
+
+// 報告請求的處理結果。
+func (service *coordinatorService) Report(args ReportArgs, reply *ReportReply) error {
+	coord := service.coord
+	coord.mutex.Lock()
+	defer coord.mutex.Unlock()
+	inflight, ok := coord.inflight[args.WorkerId]
+	if !ok {
+		return errors.New(fmt.Sprintf("Unknown worker '%s'!", args.WorkerId))
+	}
+	coord.lastContact[args.WorkerId] = time.Now()
+	for _, result := range args.Results {
+		if _, ok := inflight[result.Id]; !ok {
+			// 請求已被重新分配給其他工作者。
+			continue
+		}
+		delete(inflight, result.Id)
+		coord.processed++
+		coord.items += uint64(result.ItemCount)
+		coord.errors += uint64(len(result.Errors))
+		for _, child := range result.Children {
+			req, err := child.toRequest()
+			if err != nil {
+				coord.errors++
+				continue
+			}
+			coord.admit(req)
+		}
+	}
+	coord.checkDone()
+	return nil
+}
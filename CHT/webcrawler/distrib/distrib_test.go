@@ -0,0 +1,270 @@
+package distrib
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	anlz "webcrawler/analyzer"
+	base "webcrawler/base"
+	ipl "webcrawler/itempipeline"
+)
+
+func TestHashRing(t *testing.T) {
+	ring := NewHashRing()
+	if node := ring.Get("a.com"); node != "" {
+		t.Errorf("ERROR: The empty ring returned node %q!\n", node)
+	}
+	nodes := []string{"w1", "w2", "w3"}
+	for _, node := range nodes {
+		ring.Add(node)
+	}
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("host%d.example.com", i)
+		owners[key] = ring.Get(key)
+		counts[owners[key]]++
+	}
+	for _, node := range nodes {
+		if counts[node] == 0 {
+			t.Errorf("ERROR: The node %q owns no key!\n", node)
+		}
+	}
+	ring.Remove("w2")
+	for key, owner := range owners {
+		newOwner := ring.Get(key)
+		if owner != "w2" && newOwner != owner {
+			t.Errorf("ERROR: The key %q moved from %q to %q!\n", key, owner, newOwner)
+		}
+		if newOwner == "w2" {
+			t.Errorf("ERROR: The key %q is still owned by removed node!\n", key)
+		}
+	}
+}
+
+// 測試用的網站。它記錄每個網頁被請求的次數。
+type testSite struct {
+	server *httptest.Server
+	others []*testSite
+	pages  int
+	hits   map[string]int
+	mutex  sync.Mutex
+}
+
+func (site *testSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	site.mutex.Lock()
+	site.hits[r.URL.Path]++
+	site.mutex.Unlock()
+	var page int
+	if r.URL.Path != "/" {
+		fmt.Sscanf(r.URL.Path, "/p%d", &page)
+	}
+	fmt.Fprint(w, "<html><body>")
+	for i := 1; i <= 2; i++ {
+		next := (page+i)%site.pages + 1
+		fmt.Fprintf(w, `<a href="/p%d">p%d</a>`, next, next)
+	}
+	for _, other := range site.others {
+		fmt.Fprintf(w, `<a href="%s/p%d">other</a>`, other.server.URL, page%other.pages+1)
+	}
+	fmt.Fprint(w, "</body></html>")
+}
+
+func TestDistributedCrawl(t *testing.T) {
+	sites := make([]*testSite, 3)
+	for i := range sites {
+		sites[i] = &testSite{pages: 5, hits: make(map[string]int)}
+		sites[i].server = httptest.NewServer(sites[i])
+		defer sites[i].server.Close()
+	}
+	for i, site := range sites {
+		site.others = []*testSite{sites[(i+1)%len(sites)]}
+	}
+
+	coord, err := NewCoordinator(NewCoordinatorArgs(10, 3, 5*time.Second), nil)
+	if err != nil {
+		t.Fatalf("ERROR: Coordinator initialization failing: %s\n", err)
+	}
+	seeds := make([]*http.Request, 0)
+	for _, site := range sites {
+		seed, _ := http.NewRequest("GET", site.server.URL+"/", nil)
+		seeds = append(seeds, seed)
+	}
+	if err := coord.Start("127.0.0.1:0", seeds); err != nil {
+		t.Fatalf("ERROR: Coordinator startup failing: %s\n", err)
+	}
+	defer coord.Stop()
+
+	extractor, err := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Link extractor initialization failing: %s\n", err)
+	}
+	processItem := func(item base.Item) (base.Item, error) { return item, nil }
+	workers := make([]Worker, 3)
+	for i := range workers {
+		args := NewWorkerArgs(base.NewPoolBaseArgs(2, 2), 0, 5*time.Millisecond, 0)
+		worker, err := NewWorker(fmt.Sprintf("worker-%d", i), coord.Addr(), args,
+			func() *http.Client { return &http.Client{} },
+			[]anlz.ParseResponse{extractor},
+			[]ipl.ProcessItem{processItem})
+		if err != nil {
+			t.Fatalf("ERROR: Worker initialization failing: %s\n", err)
+		}
+		if err := worker.Start(); err != nil {
+			t.Fatalf("ERROR: Worker startup failing: %s\n", err)
+		}
+		workers[i] = worker
+	}
+
+	select {
+	case <-coord.Done():
+	case <-time.After(20 * time.Second):
+		t.Fatalf("ERROR: The distributed crawling is not done in time! (%s)\n", coord.Summary())
+	}
+	for _, worker := range workers {
+		select {
+		case <-worker.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("ERROR: The worker %s is not stopped in time!\n", worker.Id())
+		}
+	}
+
+	// 每個網頁都應被請求且只被請求一次。
+	total := 0
+	for _, site := range sites {
+		total += site.pages + 1
+		for path, hits := range site.hits {
+			if hits != 1 {
+				t.Errorf("ERROR: The page %s%s is requested %d times!\n", site.server.URL, path, hits)
+			}
+		}
+		if len(site.hits) != site.pages+1 {
+			t.Errorf("ERROR: Only %d pages of %s are requested!\n", len(site.hits), site.server.URL)
+		}
+	}
+	if processed := coord.Count()[0]; processed != uint64(total) {
+		t.Errorf("ERROR: The processed number is %d, but should be %d!\n", processed, total)
+	}
+	// 同一主機的請求只應被同一個工作者處理。
+	hostOwners := make(map[string]string)
+	for _, worker := range workers {
+		for _, host := range worker.Hosts() {
+			if owner, ok := hostOwners[host]; ok {
+				t.Errorf("ERROR: The host %s is crawled by both %s and %s!\n", host, owner, worker.Id())
+			}
+			hostOwners[host] = worker.Id()
+		}
+	}
+	for _, site := range sites {
+		host := strings.TrimPrefix(site.server.URL, "http://")
+		if _, ok := hostOwners[host]; !ok {
+			t.Errorf("ERROR: The host %s is crawled by no worker!\n", host)
+		}
+	}
+}
+
+func TestCoordinatorLifecycle(t *testing.T) {
+	coord, err := NewCoordinator(NewCoordinatorArgs(10, 1, 5*time.Second), nil)
+	if err != nil {
+		t.Fatalf("ERROR: Coordinator initialization failing: %s\n", err)
+	}
+	// 監聽失敗時協調者不會被標記為已開始。
+	seed, _ := http.NewRequest("GET", "https://example.com/", nil)
+	if err := coord.Start("127.0.0.1:-1", []*http.Request{seed}); err == nil {
+		t.Fatalf("ERROR: The coordinator should not start on an invalid address!\n")
+	}
+	// 所有的種子請求都被拒絕時，爬取流程立即結束。
+	if err := coord.Start("127.0.0.1:0", []*http.Request{seed}); err != nil {
+		t.Fatalf("ERROR: Coordinator startup failing: %s\n", err)
+	}
+	defer coord.Stop()
+	select {
+	case <-coord.Done():
+	case <-time.After(time.Second):
+		t.Errorf("ERROR: The crawling without admitted seeds is not done!\n")
+	}
+
+	coord, err = NewCoordinator(NewCoordinatorArgs(10, 1, 5*time.Second), nil)
+	if err != nil {
+		t.Fatalf("ERROR: Coordinator initialization failing: %s\n", err)
+	}
+	seed, _ = http.NewRequest("GET", "http://example.com/", nil)
+	if err := coord.Start("127.0.0.1:0", []*http.Request{seed}); err != nil {
+		t.Fatalf("ERROR: Coordinator startup failing: %s\n", err)
+	}
+	if err := coord.Stop(); err != nil {
+		t.Fatalf("ERROR: Coordinator stopping failing: %s\n", err)
+	}
+	select {
+	case <-coord.Done():
+	case <-time.After(time.Second):
+		t.Errorf("ERROR: The done channel is not closed after stopping!\n")
+	}
+}
+
+func TestWorkerHostPoliteness(t *testing.T) {
+	var mutex sync.Mutex
+	var active, maxActive int
+	var starts []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		starts = append(starts, time.Now())
+		mutex.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		if r.URL.Path == "/" {
+			for i := 1; i <= 8; i++ {
+				fmt.Fprintf(w, `<a href="/p%d">p%d</a>`, i, i)
+			}
+		}
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+	}))
+	defer server.Close()
+	coord, err := NewCoordinator(NewCoordinatorArgs(10, 1, 5*time.Second), nil)
+	if err != nil {
+		t.Fatalf("ERROR: Coordinator initialization failing: %s\n", err)
+	}
+	seed, _ := http.NewRequest("GET", server.URL+"/", nil)
+	if err := coord.Start("127.0.0.1:0", []*http.Request{seed}); err != nil {
+		t.Fatalf("ERROR: Coordinator startup failing: %s\n", err)
+	}
+	defer coord.Stop()
+	extractor, _ := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	hostDelay := 20 * time.Millisecond
+	args := NewWorkerArgs(base.NewPoolBaseArgs(4, 4), 8, 5*time.Millisecond, hostDelay)
+	worker, err := NewWorker("worker-0", coord.Addr(), args,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{})
+	if err != nil {
+		t.Fatalf("ERROR: Worker initialization failing: %s\n", err)
+	}
+	if err := worker.Start(); err != nil {
+		t.Fatalf("ERROR: Worker startup failing: %s\n", err)
+	}
+	select {
+	case <-coord.Done():
+	case <-time.After(20 * time.Second):
+		t.Fatalf("ERROR: The distributed crawling is not done in time! (%s)\n", coord.Summary())
+	}
+	<-worker.Done()
+	mutex.Lock()
+	defer mutex.Unlock()
+	// 即使一批請求都指向同一主機，它們也會被依次處理。
+	if len(starts) != 9 || maxActive != 1 {
+		t.Fatalf("ERROR: %d requests with max concurrency %d, but should be %d with %d!\n",
+			len(starts), maxActive, 9, 1)
+	}
+	if elapsed := starts[8].Sub(starts[1]); elapsed < 7*hostDelay-5*time.Millisecond {
+		t.Errorf("ERROR: The requests to the same host are not delayed! (elapsed=%s)\n", elapsed)
+	}
+}
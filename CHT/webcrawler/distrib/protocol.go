@@ -0,0 +1,117 @@
+package distrib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	base "webcrawler/base"
+)
+
+// 協調者的RPC服務名稱。
+const coordinatorServiceName = "Coordinator"
+
+// 在網路上傳輸的請求。
+// 中繼資料中的值會以字串形式傳輸。
+type WireRequest struct {
+	Id     uint64            // 由協調者分配的ID。
+	Method string            // HTTP方法。
+	Url    string            // URL。
+	Header http.Header       // 請求標頭。
+	Body   []byte            // 請求體。
+	Depth  uint32            // 深度。
+	Meta   map[string]string // 中繼資料。
+}
+
+// 把請求轉換為可在網路上傳輸的形式。
+func toWireRequest(req *base.Request) (WireRequest, error) {
+	httpReq := req.HttpReq()
+	wreq := WireRequest{
+		Method: httpReq.Method,
+		Url:    httpReq.URL.String(),
+		Header: httpReq.Header,
+		Depth:  req.Depth(),
+		Meta:   make(map[string]string),
+	}
+	if httpReq.GetBody != nil {
+		rc, err := httpReq.GetBody()
+		if err != nil {
+			return WireRequest{}, err
+		}
+		wreq.Body, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return WireRequest{}, err
+		}
+	}
+	meta := req.Meta()
+	for _, k := range meta.Keys() {
+		v, _ := meta.Get(k)
+		wreq.Meta[k] = fmt.Sprint(v)
+	}
+	return wreq, nil
+}
+
+// 把在網路上傳輸的請求還原為請求。
+func (wreq WireRequest) toRequest() (*base.Request, error) {
+	method := wreq.Method
+	if method == "" {
+		method = "GET"
+	}
+	var httpReq *http.Request
+	var err error
+	if wreq.Body != nil {
+		httpReq, err = http.NewRequest(method, wreq.Url, bytes.NewReader(wreq.Body))
+	} else {
+		httpReq, err = http.NewRequest(method, wreq.Url, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range wreq.Header {
+		httpReq.Header[k] = append([]string(nil), vs...)
+	}
+	pairs := make(map[string]interface{}, len(wreq.Meta))
+	for k, v := range wreq.Meta {
+		pairs[k] = v
+	}
+	return base.NewRequestWithMeta(httpReq, wreq.Depth, base.NewMeta(pairs)), nil
+}
+
+// 對請求的處理結果。
+type WireResult struct {
+	Id         uint64        // 請求的ID。
+	StatusCode int           // HTTP響應的狀態碼。下載失敗時為0。
+	Children   []WireRequest // 從響應中提取出的請求。
+	ItemCount  uint32        // 從響應中提取出的項目的數量。
+	Errors     []string      // 處理過程中出現的錯誤。
+}
+
+// 註冊動作的參數。
+type RegisterArgs struct {
+	WorkerId string // 工作者的ID。
+}
+
+// 註冊動作的結果。
+type RegisterReply struct{}
+
+// 拉取動作的參數。
+type PullArgs struct {
+	WorkerId string // 工作者的ID。
+	Max      uint32 // 最多拉取的請求的數量。
+}
+
+// 拉取動作的結果。
+type PullReply struct {
+	Requests []WireRequest // 分配給工作者的請求。
+	Done     bool          // 爬取流程是否已經結束。
+}
+
+// 報告動作的參數。
+type ReportArgs struct {
+	WorkerId string       // 工作者的ID。
+	Results  []WireResult // 處理結果。
+}
+
+// 報告動作的結果。
+type ReportReply struct{}
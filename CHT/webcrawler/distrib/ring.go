@@ -0,0 +1,100 @@
+package distrib
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// 每個節點在雜湊環上的虛擬節點的數量。
+const virtualNodeNumber = 64
+
+// 一致性雜湊環的接口型態。
+type HashRing interface {
+	// 加入節點。
+	Add(node string)
+	// 移除節點。
+	Remove(node string)
+	// 獲得負責指定鍵的節點。若環上沒有任何節點，則傳回空字串。
+	Get(key string) string
+	// 獲得所有節點。
+	Nodes() []string
+}
+
+// 建立一致性雜湊環。
+func NewHashRing() HashRing {
+	return &myHashRing{
+		owners: make(map[uint32]string),
+		nodes:  make(map[string]bool),
+	}
+}
+
+// 一致性雜湊環的實現型態。
+type myHashRing struct {
+	points []uint32          // 已排序的虛擬節點的雜湊值。
+	owners map[uint32]string // 虛擬節點與節點之間的映射。
+	nodes  map[string]bool   // 節點的集合。
+	mutex  sync.RWMutex      // 讀寫鎖。
+}
+
+func (ring *myHashRing) Add(node string) {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	if ring.nodes[node] {
+		return
+	}
+	ring.nodes[node] = true
+	for i := 0; i < virtualNodeNumber; i++ {
+		point := crc32.ChecksumIEEE([]byte(node + "#" + strconv.Itoa(i)))
+		if _, ok := ring.owners[point]; ok {
+			continue
+		}
+		ring.owners[point] = node
+		ring.points = append(ring.points, point)
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+}
+
+func (ring *myHashRing) Remove(node string) {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	if !ring.nodes[node] {
+		return
+	}
+	delete(ring.nodes, node)
+	points := make([]uint32, 0, len(ring.points))
+	for _, point := range ring.points {
+		if ring.owners[point] == node {
+			delete(ring.owners, point)
+			continue
+		}
+		points = append(points, point)
+	}
+	ring.points = points
+}
+
+func (ring *myHashRing) Get(key string) string {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	if len(ring.points) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	index := sort.Search(len(ring.points), func(i int) bool { return ring.points[i] >= hash })
+	if index == len(ring.points) {
+		index = 0
+	}
+	return ring.owners[ring.points[index]]
+}
+
+func (ring *myHashRing) Nodes() []string {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	nodes := make([]string, 0, len(ring.nodes))
+	for node := range ring.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
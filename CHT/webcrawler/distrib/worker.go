@@ -0,0 +1,432 @@
+package distrib
+
+import (
+	"errors"
+	"fmt"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"time"
+	anlz "webcrawler/analyzer"
+	base "webcrawler/base"
+	dl "webcrawler/downloader"
+	ipl "webcrawler/itempipeline"
+	sched "webcrawler/scheduler"
+)
+
+// 工作者參數容器的描述範本。
+var workerArgsTemplate string = "{ poolBaseArgs: %s, batchSize: %d, pollInterval: %s, hostDelay: %s }"
+
+// 工作者參數的容器。
+type WorkerArgs struct {
+	poolBaseArgs base.PoolBaseArgs // 池基本參數的容器。
+	batchSize    uint32            // 每次最多拉取的請求的數量。0表示與網頁下載器池的尺寸相同。
+	pollInterval time.Duration     // 沒有請求可處理時的拉取間隔時間。
+	hostDelay    time.Duration     // 對同一主機的相鄰兩次請求之間的最小間隔時間。
+	description  string            // 描述。
+}
+
+// 建立工作者參數的容器。
+// 同一批請求中指向同一主機的請求會被依次處理，參數hostDelay代表其中相鄰兩次請求之間的最小間隔時間。
+func NewWorkerArgs(
+	poolBaseArgs base.PoolBaseArgs,
+	batchSize uint32,
+	pollInterval time.Duration,
+	hostDelay time.Duration) WorkerArgs {
+	return WorkerArgs{
+		poolBaseArgs: poolBaseArgs,
+		batchSize:    batchSize,
+		pollInterval: pollInterval,
+		hostDelay:    hostDelay,
+	}
+}
+
+func (args *WorkerArgs) Check() error {
+	if err := args.poolBaseArgs.Check(); err != nil {
+		return err
+	}
+	if args.pollInterval <= 0 {
+		return errors.New("The poll interval must be positive!\n")
+	}
+	if args.hostDelay < 0 {
+		return errors.New("The host delay can not be negative!\n")
+	}
+	return nil
+}
+
+func (args *WorkerArgs) String() string {
+	if args.description == "" {
+		args.description =
+			fmt.Sprintf(workerArgsTemplate,
+				args.poolBaseArgs.String(),
+				args.BatchSize(),
+				args.pollInterval,
+				args.hostDelay)
+	}
+	return args.description
+}
+
+// 獲得池基本參數的容器。
+func (args *WorkerArgs) PoolBaseArgs() base.PoolBaseArgs {
+	return args.poolBaseArgs
+}
+
+// 獲得每次最多拉取的請求的數量。
+func (args *WorkerArgs) BatchSize() uint32 {
+	if args.batchSize == 0 {
+		return args.poolBaseArgs.PageDownloaderPoolSize()
+	}
+	return args.batchSize
+}
+
+// 獲得沒有請求可處理時的拉取間隔時間。
+func (args *WorkerArgs) PollInterval() time.Duration {
+	return args.pollInterval
+}
+
+// 獲得對同一主機的相鄰兩次請求之間的最小間隔時間。
+func (args *WorkerArgs) HostDelay() time.Duration {
+	return args.hostDelay
+}
+
+// 工作者的接口型態。
+// 工作者從協調者處拉取請求，利用網頁下載器池和分析器池處理它們，
+// 把提取出的項目交給項目處理管線，並把提取出的請求報告給協調者。
+type Worker interface {
+	// 獲得ID。
+	Id() string
+	// 連接協調者並開始工作。
+	Start() error
+	// 停止工作並從協調者處註銷。
+	Stop()
+	// 獲得一個會在工作者停止時被關閉的通道。
+	Done() <-chan struct{}
+	// 獲得已處理的請求的數量。
+	Processed() uint64
+	// 獲得已處理過的主機名的集合。
+	Hosts() []string
+	// 取得摘要訊息。
+	Summary() string
+}
+
+// 建立工作者。
+// 參數coordAddr代表協調者的網路位址。
+// 其他參數的含義與分派器的Start方法的同名參數相同。
+func NewWorker(
+	id string,
+	coordAddr string,
+	args WorkerArgs,
+	httpClientGenerator sched.GenHttpClient,
+	respParsers []anlz.ParseResponse,
+	itemProcessors []ipl.ProcessItem) (Worker, error) {
+	if id == "" {
+		return nil, errors.New("The worker id is empty!")
+	}
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	if httpClientGenerator == nil {
+		return nil, errors.New("The HTTP client generator is invalid!")
+	}
+	if respParsers == nil {
+		return nil, errors.New("The response parser list is invalid!")
+	}
+	if itemProcessors == nil {
+		return nil, errors.New("The item processor list is invalid!")
+	}
+	poolBaseArgs := args.PoolBaseArgs()
+	dlpool, err := dl.NewPageDownloaderPool(
+		poolBaseArgs.PageDownloaderPoolSize(),
+		func() dl.PageDownloader {
+			return dl.NewPageDownloader(httpClientGenerator())
+		})
+	if err != nil {
+		return nil, err
+	}
+	analyzerPool, err := anlz.NewAnalyzerPool(
+		poolBaseArgs.AnalyzerPoolSize(),
+		func() anlz.Analyzer {
+			return anlz.NewAnalyzer()
+		})
+	if err != nil {
+		return nil, err
+	}
+	return &myWorker{
+		id:           id,
+		coordAddr:    coordAddr,
+		args:         args,
+		dlpool:       dlpool,
+		analyzerPool: analyzerPool,
+		respParsers:  respParsers,
+		itemPipeline: ipl.NewItemPipeline(itemProcessors),
+		hosts:        make(map[string]time.Time),
+		stopCh:       make(chan struct{}),
+		done:         make(chan struct{}),
+	}, nil
+}
+
+// 工作者的實現型態。
+type myWorker struct {
+	id           string                // ID。
+	coordAddr    string                // 協調者的網路位址。
+	args         WorkerArgs            // 參數的容器。
+	client       *rpc.Client           // RPC用戶端。
+	dlpool       dl.PageDownloaderPool // 網頁下載器池。
+	analyzerPool anlz.AnalyzerPool     // 分析器池。
+	respParsers  []anlz.ParseResponse  // 響應解析函數的序列。
+	itemPipeline ipl.ItemPipeline      // 項目處理管線。
+	processed    uint64                // 已處理的請求的數量。
+	hosts        map[string]time.Time  // 已處理過的主機名與最近一次對其請求的時間的對應。
+	hostsMutex   sync.Mutex            // 針對主機名字典的互斥鎖。
+	stopOnce     sync.Once             // 確保停止動作只執行一次。
+	stopCh       chan struct{}         // 停止訊號的通道。
+	done         chan struct{}         // 工作者停止的知會通道。
+}
+
+func (w *myWorker) Id() string {
+	return w.id
+}
+
+func (w *myWorker) Start() error {
+	client, err := rpc.Dial("tcp", w.coordAddr)
+	if err != nil {
+		return err
+	}
+	w.client = client
+	if err := w.register(); err != nil {
+		client.Close()
+		return err
+	}
+	go w.loop()
+	return nil
+}
+
+func (w *myWorker) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+	})
+}
+
+func (w *myWorker) Done() <-chan struct{} {
+	return w.done
+}
+
+func (w *myWorker) Processed() uint64 {
+	return atomic.LoadUint64(&w.processed)
+}
+
+func (w *myWorker) Hosts() []string {
+	w.hostsMutex.Lock()
+	defer w.hostsMutex.Unlock()
+	hosts := make([]string, 0, len(w.hosts))
+	for host := range w.hosts {
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+var workerSummaryTemplate = "id: %s, args: %s, processed: %d, hosts: %v," +
+	" downloaderPool: %d/%d, analyzerPool: %d/%d, itemPipeline: { %s }"
+
+func (w *myWorker) Summary() string {
+	return fmt.Sprintf(workerSummaryTemplate,
+		w.id, w.args.String(), w.Processed(), w.Hosts(),
+		w.dlpool.Used(), w.dlpool.Total(),
+		w.analyzerPool.Used(), w.analyzerPool.Total(),
+		w.itemPipeline.Summary())
+}
+
+// 向協調者註冊。
+func (w *myWorker) register() error {
+	return w.client.Call(coordinatorServiceName+".Register",
+		RegisterArgs{WorkerId: w.id}, &RegisterReply{})
+}
+
+// 判斷是否已收到停止訊號。
+func (w *myWorker) stopped() bool {
+	select {
+	case <-w.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// 工作循環。
+func (w *myWorker) loop() {
+	defer func() {
+		w.client.Call(coordinatorServiceName+".Unregister",
+			RegisterArgs{WorkerId: w.id}, &RegisterReply{})
+		w.client.Close()
+		close(w.done)
+	}()
+	for !w.stopped() {
+		var reply PullReply
+		err := w.client.Call(coordinatorServiceName+".Pull",
+			PullArgs{WorkerId: w.id, Max: w.args.BatchSize()}, &reply)
+		if err == rpc.ErrShutdown {
+			logger.Errorf("The connection to coordinator is shut down (worker=%s).\n", w.id)
+			return
+		}
+		if err != nil {
+			// 可能已因逾時而被協調者移除，重新註冊。
+			logger.Warnf("Pull requests failing (worker=%s): %s\n", w.id, err)
+			if err := w.register(); err != nil {
+				logger.Errorf("Re-register failing (worker=%s): %s\n", w.id, err)
+				return
+			}
+			continue
+		}
+		if reply.Done {
+			return
+		}
+		if len(reply.Requests) == 0 {
+			select {
+			case <-w.stopCh:
+			case <-time.After(w.args.PollInterval()):
+			}
+			continue
+		}
+		results := w.processBatch(reply.Requests)
+		if !w.report(results) {
+			return
+		}
+	}
+}
+
+// 向協調者報告處理結果。失敗時會在輪詢間隔之後重新註冊並重試，直到成功為止，
+// 否則這批請求會一直被協調者視為正在處理。
+// 結果值為false時表示與協調者的連接已被關閉，或者已收到停止訊號。
+// 此時工作者會被註銷，協調者會把這批請求重新分配給其他工作者。
+func (w *myWorker) report(results []WireResult) bool {
+	for {
+		err := w.client.Call(coordinatorServiceName+".Report",
+			ReportArgs{WorkerId: w.id, Results: results}, &ReportReply{})
+		if err == nil {
+			return true
+		}
+		if err == rpc.ErrShutdown {
+			logger.Errorf("The connection to coordinator is shut down (worker=%s).\n", w.id)
+			return false
+		}
+		logger.Warnf("Report results failing (worker=%s): %s\n", w.id, err)
+		select {
+		case <-w.stopCh:
+			return false
+		case <-time.After(w.args.PollInterval()):
+		}
+		// 可能已因逾時而被協調者移除，重新註冊。此時結果中的請求已被重新分配，它們會被協調者略過。
+		if err := w.register(); err != nil {
+			logger.Errorf("Re-register failing (worker=%s): %s\n", w.id, err)
+		}
+	}
+}
+
+// 處理一批請求。請求按主機分組，不同主機的請求並行地被處理，
+// 同一主機的請求則按順序依次被處理，並且相鄰兩次請求之間至少間隔參數中的主機間隔時間。
+func (w *myWorker) processBatch(wreqs []WireRequest) []WireResult {
+	results := make([]WireResult, len(wreqs))
+	hosts := make([]string, 0)
+	groups := make(map[string][]int)
+	for i, wreq := range wreqs {
+		host := hostOf(wreq.Url)
+		if _, ok := groups[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		groups[host] = append(groups[host], i)
+	}
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host string, indexes []int) {
+			defer wg.Done()
+			for _, i := range indexes {
+				w.waitForHost(host)
+				results[i] = w.process(wreqs[i])
+				atomic.AddUint64(&w.processed, 1)
+			}
+		}(host, groups[host])
+	}
+	wg.Wait()
+	return results
+}
+
+// 等待到可以再次請求主機的時間，並記錄該時間。收到停止訊號時會立即傳回。
+func (w *myWorker) waitForHost(host string) {
+	w.hostsMutex.Lock()
+	now := time.Now()
+	next := now
+	if last, ok := w.hosts[host]; ok && last.Add(w.args.HostDelay()).After(now) {
+		next = last.Add(w.args.HostDelay())
+	}
+	w.hosts[host] = next
+	w.hostsMutex.Unlock()
+	if wait := next.Sub(now); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-w.stopCh:
+		}
+	}
+}
+
+// 處理單一請求：下載、分析並處理項目。
+func (w *myWorker) process(wreq WireRequest) (result WireResult) {
+	result.Id = wreq.Id
+	defer func() {
+		if p := recover(); p != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Fatal Worker Error: %s", p))
+		}
+	}()
+	addError := func(err error) {
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+		}
+	}
+	req, err := wreq.toRequest()
+	if err != nil {
+		addError(err)
+		return
+	}
+	downloader, err := w.dlpool.Take()
+	if err != nil {
+		addError(err)
+		return
+	}
+	resp, err := downloader.Download(*req)
+	addError(w.dlpool.Return(downloader))
+	if err != nil {
+		addError(err)
+		return
+	}
+	httpResp := resp.HttpResp()
+	defer httpResp.Body.Close()
+	result.StatusCode = httpResp.StatusCode
+
+	analyzer, err := w.analyzerPool.Take()
+	if err != nil {
+		addError(err)
+		return
+	}
+	dataList, errs := analyzer.Analyze(w.respParsers, *resp)
+	addError(w.analyzerPool.Return(analyzer))
+	for _, err := range errs {
+		addError(err)
+	}
+	for _, data := range dataList {
+		switch d := data.(type) {
+		case *base.Request:
+			child, err := toWireRequest(d)
+			if err != nil {
+				addError(err)
+				continue
+			}
+			result.Children = append(result.Children, child)
+		case *base.Item:
+			result.ItemCount++
+			for _, err := range w.itemPipeline.Send(*d) {
+				addError(err)
+			}
+		}
+	}
+	return
+}
@@ -1,8 +1,14 @@
 package downloader
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"logging"
 	"net/http"
+	"time"
 	base "webcrawler/base"
 	mdw "webcrawler/middleware"
 )
@@ -24,8 +30,101 @@ type PageDownloader interface {
 	Download(req base.Request) (*base.Response, error) // 根據請求下載網頁並傳回響應。
 }
 
+// 網頁下載器參數容器的描述範本。
+var downloaderArgsTemplate string = "{ headerPolicy: %s, maxRetries: %d," +
+	" retryInterval: %s }"
+
+// 網頁下載器參數的容器。
+type DownloaderArgs struct {
+	headerPolicy  HeaderPolicy  // 請求標頭策略。
+	maxRetries    uint32        // 最大重試次數。
+	retryInterval time.Duration // 重試的基本間隔時間。第n次重試之前會等待n倍的該時間。
+	description   string        // 描述。
+}
+
+// 建立網頁下載器參數的容器。
+// 參數headerPolicy可以為nil，此時不會為請求加入額外的標頭。
+func NewDownloaderArgs(
+	headerPolicy HeaderPolicy,
+	maxRetries uint32,
+	retryInterval time.Duration) DownloaderArgs {
+	return DownloaderArgs{
+		headerPolicy:  headerPolicy,
+		maxRetries:    maxRetries,
+		retryInterval: retryInterval,
+	}
+}
+
+func (args *DownloaderArgs) Check() error {
+	if args.retryInterval < 0 {
+		return errors.New("The retry interval can not be negative!\n")
+	}
+	return nil
+}
+
+func (args *DownloaderArgs) String() string {
+	if args.description == "" {
+		policy := "<none>"
+		if args.headerPolicy != nil {
+			policy = args.headerPolicy.String()
+		}
+		args.description =
+			fmt.Sprintf(downloaderArgsTemplate,
+				policy,
+				args.maxRetries,
+				args.retryInterval)
+	}
+	return args.description
+}
+
+// 獲得請求標頭策略。
+func (args *DownloaderArgs) HeaderPolicy() HeaderPolicy {
+	return args.headerPolicy
+}
+
+// 獲得最大重試次數。
+func (args *DownloaderArgs) MaxRetries() uint32 {
+	return args.maxRetries
+}
+
+// 獲得重試的基本間隔時間。
+func (args *DownloaderArgs) RetryInterval() time.Duration {
+	return args.retryInterval
+}
+
+// 下載嘗試的回呼函數的型態。
+// 網頁下載器每傳送一次請求（包括每一次重試）都會呼叫它，參數latency代表該次傳送所花費的時間。
+type AttemptHook func(latency time.Duration, httpResp *http.Response, err error)
+
+// 上下文中存放下載嘗試的回呼函數的鍵的型態。
+type attemptHookKey struct{}
+
+// 把下載嘗試的回呼函數附加到上下文中。以該上下文建立的請求在被下載時會觸發回呼。
+func WithAttemptHook(ctx context.Context, hook AttemptHook) context.Context {
+	return context.WithValue(ctx, attemptHookKey{}, hook)
+}
+
+// 從上下文中取得下載嘗試的回呼函數。不存在時結果值為nil。
+func attemptHookFrom(ctx context.Context) AttemptHook {
+	hook, _ := ctx.Value(attemptHookKey{}).(AttemptHook)
+	return hook
+}
+
 // 建立網頁下載器。
 func NewPageDownloader(client *http.Client) PageDownloader {
+	return newPageDownloader(client, DownloaderArgs{})
+}
+
+// 根據參數建立網頁下載器。參數不合法時會傳回錯誤。
+func NewPageDownloaderWithArgs(client *http.Client, args DownloaderArgs) (PageDownloader, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	return newPageDownloader(client, args), nil
+}
+
+// 建立網頁下載器。參數應已經過檢查。
+func newPageDownloader(client *http.Client, args DownloaderArgs) PageDownloader {
 	id := genDownloaderId()
 	if client == nil {
 		client = &http.Client{}
@@ -33,13 +132,15 @@ func NewPageDownloader(client *http.Client) PageDownloader {
 	return &myPageDownloader{
 		id:         id,
 		httpClient: *client,
+		args:       args,
 	}
 }
 
 // 網頁下載器的實現型態。
 type myPageDownloader struct {
-	id         uint32      // ID。
-	httpClient http.Client // HTTP用戶端。
+	id         uint32         // ID。
+	httpClient http.Client    // HTTP用戶端。
+	args       DownloaderArgs // 參數的容器。
 }
 
 func (dl *myPageDownloader) Id() uint32 {
@@ -49,9 +150,68 @@ func (dl *myPageDownloader) Id() uint32 {
 func (dl *myPageDownloader) Download(req base.Request) (*base.Response, error) {
 	httpReq := req.HttpReq()
 	logger.Infof("Do the request (url=%s)... \n", httpReq.URL)
-	httpResp, err := dl.httpClient.Do(httpReq)
+	replayable := req.Replayable()
+	hook := attemptHookFrom(httpReq.Context())
+	var attempt uint32
+	for {
+		sendReq, err := dl.prepare(httpReq, attempt)
+		if err != nil {
+			return nil, err
+		}
+		begin := time.Now()
+		httpResp, err := dl.httpClient.Do(sendReq)
+		if hook != nil {
+			hook(time.Since(begin), httpResp, err)
+		}
+		if attempt >= dl.args.MaxRetries() || !replayable || !shouldRetry(httpResp, err) {
+			if err != nil {
+				return nil, err
+			}
+			return base.NewResponseWithMeta(httpResp, req.Depth(), req.Meta()), nil
+		}
+		if httpResp != nil {
+			io.Copy(ioutil.Discard, httpResp.Body)
+			httpResp.Body.Close()
+		}
+		attempt++
+		logger.Warnf("Retry the request (url=%s, attempt=%d)... \n", httpReq.URL, attempt)
+		// 等待重試時請求仍可以被取消，以免停止爬取流程時被阻塞。
+		timer := time.NewTimer(dl.args.RetryInterval() * time.Duration(attempt))
+		select {
+		case <-httpReq.Context().Done():
+			timer.Stop()
+			return nil, httpReq.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// 準備即將被傳送的HTTP請求。
+// 原請求不會被修改。重試時請求體會被重新取得。
+func (dl *myPageDownloader) prepare(httpReq *http.Request, attempt uint32) (*http.Request, error) {
+	sendReq := httpReq.Clone(httpReq.Context())
+	if attempt > 0 && httpReq.GetBody != nil {
+		body, err := httpReq.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		sendReq.Body = body
+	}
+	if policy := dl.args.HeaderPolicy(); policy != nil {
+		policy.Apply(sendReq)
+	}
+	return sendReq, nil
+}
+
+// 判斷是否應該重試。網路錯誤、429以及5xx狀態碼都會觸發重試。
+func shouldRetry(httpResp *http.Response, err error) bool {
 	if err != nil {
-		return nil, err
+		return true
 	}
-	return base.NewResponse(httpResp, req.Depth()), nil
+	return IsRetryableStatus(httpResp.StatusCode)
+}
+
+// 判斷HTTP狀態碼是否代表暫時性的失敗，即：429或5xx。
+func IsRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
 }
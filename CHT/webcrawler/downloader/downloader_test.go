@@ -0,0 +1,133 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	base "webcrawler/base"
+)
+
+func TestHeaderPolicy(t *testing.T) {
+	defaults := http.Header{"Accept-Language": {"zh-TW"}, "X-Crawler": {"default"}}
+	userAgents := []string{"ua-1", "ua-2"}
+	domainHeaders := map[string]http.Header{
+		".example.com":    {"X-Crawler": {"example"}},
+		"api.example.com": {"User-Agent": {"api-ua"}},
+	}
+	policy := NewHeaderPolicy(defaults, userAgents, domainHeaders)
+	cases := []struct {
+		url       string
+		preset    http.Header
+		userAgent string
+		crawler   string
+	}{
+		{"http://other.org/", nil, "ua-1", "default"},
+		{"http://www.example.com/", nil, "ua-2", "example"},
+		{"http://api.example.com/", nil, "api-ua", "default"},
+		{"http://example.com/", http.Header{"X-Crawler": {"preset"}}, "ua-2", "preset"},
+	}
+	for i, c := range cases {
+		httpReq, _ := http.NewRequest("GET", c.url, nil)
+		for k, vs := range c.preset {
+			httpReq.Header[k] = vs
+		}
+		policy.Apply(httpReq)
+		if ua := httpReq.Header.Get("User-Agent"); ua != c.userAgent {
+			t.Errorf("ERROR: The User-Agent of case [%d] is %q, but should be %q!\n", i, ua, c.userAgent)
+		}
+		if crawler := httpReq.Header.Get("X-Crawler"); crawler != c.crawler {
+			t.Errorf("ERROR: The X-Crawler of case [%d] is %q, but should be %q!\n", i, crawler, c.crawler)
+		}
+		if lang := httpReq.Header.Get("Accept-Language"); lang != "zh-TW" {
+			t.Errorf("ERROR: The Accept-Language of case [%d] is %q!\n", i, lang)
+		}
+	}
+}
+
+func TestDownloadRetryWithBody(t *testing.T) {
+	var mutex sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := ioutil.ReadAll(r.Body)
+		mutex.Lock()
+		bodies = append(bodies, r.Method+" "+string(content)+" "+r.Header.Get("User-Agent"))
+		attempts := len(bodies)
+		mutex.Unlock()
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	policy := NewHeaderPolicy(nil, []string{"test-ua"}, nil)
+	if _, err := NewPageDownloaderWithArgs(nil, NewDownloaderArgs(policy, 3, -time.Second)); err == nil {
+		t.Errorf("ERROR: The page downloader should not be created with negative retry interval!\n")
+	}
+	dl, err := NewPageDownloaderWithArgs(nil, NewDownloaderArgs(policy, 3, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Page downloader initialization failing: %s\n", err)
+	}
+	req, err := base.NewRequestWithBody("POST", server.URL, "application/x-www-form-urlencoded",
+		[]byte("q=go"), 0)
+	if err != nil {
+		t.Fatalf("ERROR: Request initialization failing: %s\n", err)
+	}
+	var statuses []int
+	hook := func(latency time.Duration, httpResp *http.Response, err error) {
+		statuses = append(statuses, httpResp.StatusCode)
+	}
+	hookedReq := base.NewRequestWithMeta(
+		req.HttpReq().WithContext(WithAttemptHook(context.Background(), hook)), req.Depth(), req.Meta())
+	resp, err := dl.Download(*hookedReq)
+	if err != nil {
+		t.Fatalf("ERROR: Download failing: %s\n", err)
+	}
+	defer resp.HttpResp().Body.Close()
+	if fmt.Sprint(statuses) != "[503 503 200]" {
+		t.Errorf("ERROR: The statuses reported to the attempt hook are %v!\n", statuses)
+	}
+	if resp.HttpResp().StatusCode != 200 {
+		t.Errorf("ERROR: The status code is %d, but should be 200!\n", resp.HttpResp().StatusCode)
+	}
+	if len(bodies) != 3 {
+		t.Fatalf("ERROR: The attempt number is %d, but should be 3!\n", len(bodies))
+	}
+	for i, body := range bodies {
+		if body != "POST q=go test-ua" {
+			t.Errorf("ERROR: The attempt [%d] sent %q!\n", i, body)
+		}
+	}
+	if ua := req.HttpReq().Header.Get("User-Agent"); ua != "" {
+		t.Errorf("ERROR: The original request should not be modified! (User-Agent=%q)\n", ua)
+	}
+}
+
+func TestDownloadRetryCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	dl, err := NewPageDownloaderWithArgs(nil, NewDownloaderArgs(nil, 3, time.Hour))
+	if err != nil {
+		t.Fatalf("ERROR: Page downloader initialization failing: %s\n", err)
+	}
+	httpReq, _ := http.NewRequest("GET", server.URL, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	// 重試的等待時間長達一小時，但取消請求之後下載應立即結束。
+	begin := time.Now()
+	_, err = dl.Download(*base.NewRequest(httpReq.WithContext(ctx), 0))
+	if err != context.Canceled {
+		t.Errorf("ERROR: The error of the canceled download is %v!\n", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 5*time.Second {
+		t.Errorf("ERROR: The canceled download takes %s!\n", elapsed)
+	}
+}
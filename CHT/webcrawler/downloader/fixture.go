@@ -0,0 +1,174 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	base "webcrawler/base"
+)
+
+// 夾具檔案的副檔名。
+const FIXTURE_FILE_EXT = ".json"
+
+// 獲得URL的規範形式。它被用作夾具的鍵。
+// 協定和主機名稱會被轉為小寫，預設埠號和片段會被去掉，查詢參數會按鍵排序，空路徑會被視為「/」。
+func CanonicalUrl(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" &&
+		!(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonical := scheme + "://" + host + path
+	if query := u.Query(); len(query) > 0 {
+		for _, values := range query {
+			sort.Strings(values)
+		}
+		canonical += "?" + query.Encode()
+	}
+	return canonical
+}
+
+// 夾具。它是一個被記錄下來的響應。
+type fixture struct {
+	Url        string      `json:"url"`    // 請求的規範URL。
+	StatusCode int         `json:"status"` // 響應的HTTP狀態碼。
+	Header     http.Header `json:"header"` // 響應的標頭。
+	Body       []byte      `json:"body"`   // 響應體。
+}
+
+// 獲得規範URL所對應的夾具檔案的路徑。
+func fixturePath(dir string, canonicalUrl string) string {
+	sum := sha1.Sum([]byte(canonicalUrl))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+FIXTURE_FILE_EXT)
+}
+
+// 建立記錄下載結果的網頁下載器。
+// 它包裝了參數downloader，並把每一個響應以其請求的規範URL為鍵寫入參數dir所代表的夾具目錄。
+// 同一URL的夾具會被覆寫。響應體會被讀取，隨後以可重新讀取的形式放回。
+func NewRecordingDownloader(downloader PageDownloader, dir string) (PageDownloader, error) {
+	if downloader == nil {
+		return nil, errors.New("The recorded page downloader can not be nil!\n")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &recordingDownloader{downloader: downloader, dir: dir}, nil
+}
+
+// 記錄下載結果的網頁下載器的實現型態。
+type recordingDownloader struct {
+	downloader PageDownloader // 被包裝的網頁下載器。
+	dir        string         // 夾具目錄。
+}
+
+func (rd *recordingDownloader) Id() uint32 {
+	return rd.downloader.Id()
+}
+
+func (rd *recordingDownloader) Download(req base.Request) (*base.Response, error) {
+	resp, err := rd.downloader.Download(req)
+	if err != nil || resp == nil || resp.HttpResp() == nil {
+		return resp, err
+	}
+	httpResp := resp.HttpResp()
+	body, err := base.BufferBody(httpResp)
+	if err != nil {
+		return resp, err
+	}
+	canonicalUrl := CanonicalUrl(req.HttpReq().URL)
+	content, err := json.MarshalIndent(fixture{
+		Url:        canonicalUrl,
+		StatusCode: httpResp.StatusCode,
+		Header:     httpResp.Header,
+		Body:       body,
+	}, "", "  ")
+	if err != nil {
+		return resp, err
+	}
+	// 先寫入暫存檔案再更名，以免重播時讀到不完整的夾具。
+	path := fixturePath(rd.dir, canonicalUrl)
+	tmpFile, err := ioutil.TempFile(rd.dir, "fixture")
+	if err != nil {
+		return resp, err
+	}
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return resp, err
+	}
+	return resp, nil
+}
+
+// 建立重播夾具的網頁下載器。它不會存取網路。
+// 響應會依據請求的規範URL從參數dir所代表的夾具目錄中取得。夾具不存在時會傳回錯誤。
+func NewReplayDownloader(dir string) (PageDownloader, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(fmt.Sprintf("The fixture path '%s' is not a directory!\n", dir))
+	}
+	return &replayDownloader{id: genDownloaderId(), dir: dir}, nil
+}
+
+// 重播夾具的網頁下載器的實現型態。
+type replayDownloader struct {
+	id  uint32 // ID。
+	dir string // 夾具目錄。
+}
+
+func (rd *replayDownloader) Id() uint32 {
+	return rd.id
+}
+
+func (rd *replayDownloader) Download(req base.Request) (*base.Response, error) {
+	httpReq := req.HttpReq()
+	canonicalUrl := CanonicalUrl(httpReq.URL)
+	content, err := ioutil.ReadFile(fixturePath(rd.dir, canonicalUrl))
+	if err != nil {
+		cause := errors.New(fmt.Sprintf("No fixture for the request: %s", err))
+		detail := base.ErrorDetail{Url: httpReq.URL.String(), Depth: req.Depth()}
+		return nil, base.NewCrawlerErrorWithDetail(base.DOWNLOADER_ERROR, cause, detail)
+	}
+	var f fixture
+	if err := json.Unmarshal(content, &f); err != nil {
+		return nil, err
+	}
+	httpResp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.StatusCode, http.StatusText(f.StatusCode)),
+		StatusCode:    f.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        f.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(f.Body)),
+		ContentLength: int64(len(f.Body)),
+		Request:       httpReq,
+	}
+	if httpResp.Header == nil {
+		httpResp.Header = make(http.Header)
+	}
+	return base.NewResponseWithMeta(httpResp, req.Depth(), req.Meta()), nil
+}
@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	base "webcrawler/base"
+)
+
+func TestCanonicalUrl(t *testing.T) {
+	cases := map[string]string{
+		"HTTP://Example.COM:80":          "http://example.com/",
+		"http://example.com/a?b=2&a=1#x": "http://example.com/a?a=1&b=2",
+		"https://example.com:443/a":      "https://example.com/a",
+		"http://example.com:8080/a":      "http://example.com:8080/a",
+		"http://example.com/a?x=2&x=1":   "http://example.com/a?x=1&x=2",
+	}
+	for rawUrl, expected := range cases {
+		u, _ := url.Parse(rawUrl)
+		if canonical := CanonicalUrl(u); canonical != expected {
+			t.Errorf("ERROR: The canonical url of %s is %s, but should be %s!\n", rawUrl, canonical, expected)
+		}
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatalf("ERROR: Temp dir creation failing: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Page", r.URL.Path)
+		if r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusGone)
+		}
+		fmt.Fprintf(w, "page %s", r.URL.RawQuery)
+	}))
+	recorder, err := NewRecordingDownloader(NewPageDownloader(&http.Client{}), dir)
+	if err != nil {
+		t.Fatalf("ERROR: Recording downloader initialization failing: %s\n", err)
+	}
+	for _, path := range []string{"/a?y=2&x=1", "/gone"} {
+		httpReq, _ := http.NewRequest("GET", server.URL+path, nil)
+		resp, err := recorder.Download(*base.NewRequest(httpReq, 0))
+		if err != nil {
+			t.Fatalf("ERROR: Recording download failing: %s\n", err)
+		}
+		if body, _ := ioutil.ReadAll(resp.HttpResp().Body); len(body) == 0 {
+			t.Errorf("ERROR: The response body of %s is lost after recording!\n", path)
+		}
+	}
+	server.Close()
+
+	replayer, err := NewReplayDownloader(dir)
+	if err != nil {
+		t.Fatalf("ERROR: Replay downloader initialization failing: %s\n", err)
+	}
+	// 查詢參數的順序不同，但規範URL相同。
+	httpReq, _ := http.NewRequest("GET", server.URL+"/a?x=1&y=2", nil)
+	meta := base.NewMeta(map[string]interface{}{"k": "v"})
+	resp, err := replayer.Download(*base.NewRequestWithMeta(httpReq, 3, meta))
+	if err != nil {
+		t.Fatalf("ERROR: Replay download failing: %s\n", err)
+	}
+	httpResp := resp.HttpResp()
+	body, _ := ioutil.ReadAll(httpResp.Body)
+	if httpResp.StatusCode != http.StatusOK || string(body) != "page y=2&x=1" ||
+		httpResp.Header.Get("X-Page") != "/a" {
+		t.Errorf("ERROR: The replayed response (status=%d, body=%q, header=%v) is wrong!\n",
+			httpResp.StatusCode, body, httpResp.Header)
+	}
+	if resp.Depth() != 3 || resp.Meta().GetString("k") != "v" || httpResp.Request != httpReq {
+		t.Errorf("ERROR: The replayed response does not carry the request information!\n")
+	}
+	httpReq, _ = http.NewRequest("GET", server.URL+"/gone", nil)
+	resp, err = replayer.Download(*base.NewRequest(httpReq, 0))
+	if err != nil || resp.HttpResp().StatusCode != http.StatusGone {
+		t.Errorf("ERROR: The replayed status code is wrong! (err=%v)\n", err)
+	}
+	httpReq, _ = http.NewRequest("GET", server.URL+"/unknown", nil)
+	if _, err := replayer.Download(*base.NewRequest(httpReq, 0)); err == nil {
+		t.Errorf("ERROR: The replay of a request without fixture should fail!\n")
+	} else if ce, ok := err.(base.CrawlerError); !ok || ce.Url() != httpReq.URL.String() {
+		t.Errorf("ERROR: The replay error %v should be a crawler error with url!\n", err)
+	}
+}
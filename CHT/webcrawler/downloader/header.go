@@ -0,0 +1,104 @@
+package downloader
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// 請求標頭策略的接口型態。
+type HeaderPolicy interface {
+	// 為HTTP請求加入標頭。請求中已存在的標頭不會被覆蓋。
+	Apply(httpReq *http.Request)
+	// 獲得請求標頭策略的字串表現形式。
+	String() string
+}
+
+// 建立請求標頭策略。
+// 參數defaults代表所有請求共用的預設標頭。
+// 參數userAgents代表輪流使用的User-Agent的清單。
+// 參數domainHeaders代表針對域名的標頭，它們會覆蓋預設標頭和輪流使用的User-Agent。
+// 其中的鍵可以是完整的主機名，也可以是以“.”開頭的域名後綴。
+// 標頭的優先順序由高到低依次為：請求自身的標頭、域名標頭、預設標頭。
+func NewHeaderPolicy(
+	defaults http.Header,
+	userAgents []string,
+	domainHeaders map[string]http.Header) HeaderPolicy {
+	policy := &myHeaderPolicy{
+		defaults:      cloneHeader(defaults),
+		domainHeaders: make(map[string]http.Header),
+	}
+	for _, ua := range userAgents {
+		if ua = strings.TrimSpace(ua); ua != "" {
+			policy.userAgents = append(policy.userAgents, ua)
+		}
+	}
+	for domain, header := range domainHeaders {
+		policy.domainHeaders[strings.ToLower(domain)] = cloneHeader(header)
+	}
+	return policy
+}
+
+// 請求標頭策略的實現型態。
+type myHeaderPolicy struct {
+	defaults      http.Header            // 預設標頭。
+	userAgents    []string               // 輪流使用的User-Agent的清單。
+	domainHeaders map[string]http.Header // 針對域名的標頭。
+	uaIndex       uint32                 // 下一個被使用的User-Agent的序號。
+}
+
+func (policy *myHeaderPolicy) Apply(httpReq *http.Request) {
+	if httpReq.Header == nil {
+		httpReq.Header = make(http.Header)
+	}
+	merged := cloneHeader(policy.defaults)
+	if merged.Get("User-Agent") == "" && len(policy.userAgents) > 0 {
+		index := atomic.AddUint32(&policy.uaIndex, 1) - 1
+		merged.Set("User-Agent", policy.userAgents[int(index%uint32(len(policy.userAgents)))])
+	}
+	if httpReq.URL != nil {
+		for k, vs := range policy.matchDomain(httpReq.URL.Hostname()) {
+			merged[k] = append([]string(nil), vs...)
+		}
+	}
+	for k, vs := range merged {
+		if _, ok := httpReq.Header[k]; !ok {
+			httpReq.Header[k] = vs
+		}
+	}
+}
+
+// 查找與主機名匹配的域名標頭。完整的主機名優先於最長的域名後綴。
+func (policy *myHeaderPolicy) matchDomain(host string) http.Header {
+	host = strings.ToLower(host)
+	if header, ok := policy.domainHeaders[host]; ok {
+		return header
+	}
+	var matched http.Header
+	var matchedLen int
+	for domain, header := range policy.domainHeaders {
+		if !strings.HasPrefix(domain, ".") {
+			continue
+		}
+		if (strings.HasSuffix(host, domain) || host == domain[1:]) && len(domain) > matchedLen {
+			matched = header
+			matchedLen = len(domain)
+		}
+	}
+	return matched
+}
+
+func (policy *myHeaderPolicy) String() string {
+	return fmt.Sprintf("{ defaults: %d, userAgents: %d, domains: %d }",
+		len(policy.defaults), len(policy.userAgents), len(policy.domainHeaders))
+}
+
+// 複製標頭。
+func cloneHeader(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for k, vs := range header {
+		result[http.CanonicalHeaderKey(k)] = append([]string(nil), vs...)
+	}
+	return result
+}
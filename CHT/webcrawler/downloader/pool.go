@@ -3,7 +3,7 @@ package downloader
 import (
 	"errors"
 	"fmt"
-	"reflect"
+	"time"
 	mdw "webcrawler/middleware"
 )
 
@@ -12,32 +12,49 @@ type GenPageDownloader func() PageDownloader
 
 // 網頁下載器池的接口型態。
 type PageDownloaderPool interface {
-	Take() (PageDownloader, error)  // 從池中取出一個網頁下載器。
+	Take() (PageDownloader, error) // 從池中取出一個網頁下載器。
+	// 從池中取出一個網頁下載器。至多等待參數timeout所代表的時間。
+	TakeTimeout(timeout time.Duration) (PageDownloader, error)
 	Return(dl PageDownloader) error // 把一個網頁下載器歸還給池。
 	Total() uint32                  // 獲得池的總容量。
 	Used() uint32                   // 獲得正在被使用的網頁下載器的數量。
+	Resize(total uint32) error      // 調整池的總容量。
+	// 設定網頁下載器的健康檢查函數。未通過檢查的網頁下載器會被新產生的網頁下載器替換。
+	SetHealthCheck(check func(dl PageDownloader) bool)
+	Stats() mdw.PoolStats // 獲得池的使用統計。
 }
 
 // 建立網頁下載器池。
 func NewPageDownloaderPool(
 	total uint32,
 	gen GenPageDownloader) (PageDownloaderPool, error) {
-	etype := reflect.TypeOf(gen())
+	if gen == nil {
+		return nil, errors.New("The page downloader generator can not be nil!\n")
+	}
 	genEntity := func() mdw.Entity {
 		return gen()
 	}
-	pool, err := mdw.NewPool(total, etype, genEntity)
+	pool, err := mdw.NewPool(total, genEntity)
 	if err != nil {
 		return nil, err
 	}
-	dlpool := &myDownloaderPool{pool: pool, etype: etype}
+	dlpool := &myDownloaderPool{pool: pool}
 	return dlpool, nil
 }
 
 // 網頁下載器池的實現型態。
 type myDownloaderPool struct {
-	pool  mdw.Pool     // 實體池。
-	etype reflect.Type // 池內實體的型態。
+	pool mdw.Pool // 實體池。
+}
+
+// 把實體轉換為網頁下載器。
+func toPageDownloader(entity mdw.Entity) PageDownloader {
+	dl, ok := entity.(PageDownloader)
+	if !ok {
+		errMsg := fmt.Sprintf("The type of entity %T is NOT a page downloader!\n", entity)
+		panic(errors.New(errMsg))
+	}
+	return dl
 }
 
 func (dlpool *myDownloaderPool) Take() (PageDownloader, error) {
@@ -45,12 +62,15 @@ func (dlpool *myDownloaderPool) Take() (PageDownloader, error) {
 	if err != nil {
 		return nil, err
 	}
-	dl, ok := entity.(PageDownloader)
-	if !ok {
-		errMsg := fmt.Sprintf("The type of entity is NOT %s!\n", dlpool.etype)
-		panic(errors.New(errMsg))
+	return toPageDownloader(entity), nil
+}
+
+func (dlpool *myDownloaderPool) TakeTimeout(timeout time.Duration) (PageDownloader, error) {
+	entity, err := dlpool.pool.TakeTimeout(timeout)
+	if err != nil {
+		return nil, err
 	}
-	return dl, nil
+	return toPageDownloader(entity), nil
 }
 
 func (dlpool *myDownloaderPool) Return(dl PageDownloader) error {
@@ -60,6 +80,25 @@ func (dlpool *myDownloaderPool) Return(dl PageDownloader) error {
 func (dlpool *myDownloaderPool) Total() uint32 {
 	return dlpool.pool.Total()
 }
+
 func (dlpool *myDownloaderPool) Used() uint32 {
 	return dlpool.pool.Used()
 }
+
+func (dlpool *myDownloaderPool) Resize(total uint32) error {
+	return dlpool.pool.Resize(total)
+}
+
+func (dlpool *myDownloaderPool) SetHealthCheck(check func(dl PageDownloader) bool) {
+	if check == nil {
+		dlpool.pool.SetHealthCheck(nil)
+		return
+	}
+	dlpool.pool.SetHealthCheck(func(entity mdw.Entity) bool {
+		return check(toPageDownloader(entity))
+	})
+}
+
+func (dlpool *myDownloaderPool) Stats() mdw.PoolStats {
+	return dlpool.pool.Stats()
+}
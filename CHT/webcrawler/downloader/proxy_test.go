@@ -0,0 +1,370 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 啟動用於測試的HTTP代理替身。它不會真的轉發請求，而是直接在響應中標明經由的代理及請求的URL。
+// 參數down不為nil且其值不為0時，替身會以407狀態碼響應。
+func startHttpProxy(name string, down *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down != nil && atomic.LoadInt32(down) != 0 {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.Header().Set("X-Via", name)
+		fmt.Fprint(w, r.URL.String())
+	}))
+}
+
+// 啟動用於測試的SOCKS5代理替身。它只支援無認證的CONNECT命令。
+// 傳回其位址以及記錄已建立的連接的數量的計數器。
+func startSocks5Proxy(t *testing.T) (net.Listener, *int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ERROR: SOCKS5 proxy listening failing: %s\n", err)
+	}
+	var connects int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				target, err := socks5Handshake(conn)
+				if err != nil {
+					return
+				}
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer upstream.Close()
+				atomic.AddInt32(&connects, 1)
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+	return listener, &connects
+}
+
+// 進行SOCKS5的握手，並傳回CONNECT命令的目標位址。
+func socks5Handshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != 5 {
+		return "", errors.New("Invalid SOCKS5 greeting!\n")
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return "", err
+	}
+	conn.Write([]byte{5, 0})
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil || request[1] != 1 {
+		return "", errors.New("Invalid SOCKS5 request!\n")
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		addr := make([]byte, 4)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		addr := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = string(addr)
+	default:
+		return "", errors.New("Unsupported SOCKS5 address type!\n")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// 經由用戶端請求URL，並傳回響應中標明的代理。
+func getVia(client *http.Client, rawUrl string) (string, error) {
+	resp, err := client.Get(rawUrl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != rawUrl {
+		return "", errors.New(fmt.Sprintf("Unexpected body %q!\n", body))
+	}
+	return resp.Header.Get("X-Via"), nil
+}
+
+func TestProxyAssignment(t *testing.T) {
+	proxyA := startHttpProxy("a", nil)
+	defer proxyA.Close()
+	proxyB := startHttpProxy("b", nil)
+	defer proxyB.Close()
+	args := NewProxyManagerArgs([]string{proxyA.URL, proxyB.URL}, 3, time.Minute, "", 0)
+
+	manager, err := NewProxyManager(args)
+	if err != nil {
+		t.Fatalf("ERROR: Proxy manager initialization failing: %s\n", err)
+	}
+	defer manager.Close()
+	client := manager.Wrap(&http.Client{Timeout: time.Second}, PROXY_PER_REQUEST)
+	var vias []string
+	for i := 0; i < 4; i++ {
+		via, err := getVia(client, fmt.Sprintf("http://example.com/%d", i))
+		if err != nil {
+			t.Fatalf("ERROR: Request via proxy failing: %s\n", err)
+		}
+		vias = append(vias, via)
+	}
+	if strings.Join(vias, "") != "abab" {
+		t.Errorf("ERROR: The per-request proxies are %v, but should rotate!\n", vias)
+	}
+
+	manager, err = NewProxyManager(args)
+	if err != nil {
+		t.Fatalf("ERROR: Proxy manager initialization failing: %s\n", err)
+	}
+	defer manager.Close()
+	clients := []*http.Client{
+		manager.Wrap(&http.Client{}, PROXY_PER_DOWNLOADER),
+		manager.Wrap(&http.Client{}, PROXY_PER_DOWNLOADER),
+	}
+	for i := 0; i < 3; i++ {
+		for j, expected := range []string{"a", "b"} {
+			via, err := getVia(clients[j], "http://example.com/")
+			if err != nil || via != expected {
+				t.Errorf("ERROR: The proxy of client [%d] is %q, but should be %q! (err=%v)\n", j, via, expected, err)
+			}
+		}
+	}
+	for _, stats := range manager.Stats() {
+		if stats.Successes != 3 || stats.Failures != 0 || stats.Banned {
+			t.Errorf("ERROR: The proxy stats %s are wrong!\n", stats)
+		}
+	}
+}
+
+// 在請求中加入標頭的分層傳輸器。
+type headerTransport struct {
+	base http.RoundTripper
+}
+
+func (ht *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	newReq := req.Clone(req.Context())
+	newReq.Header.Set("X-Layer", "yes")
+	return ht.base.RoundTrip(newReq)
+}
+
+func (ht *headerTransport) BaseTransport() http.RoundTripper {
+	return ht.base
+}
+
+func (ht *headerTransport) WithBaseTransport(base http.RoundTripper) http.RoundTripper {
+	return &headerTransport{base: base}
+}
+
+func TestProxyLayeredTransport(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Via", "a")
+		fmt.Fprint(w, r.Header.Get("X-Layer"))
+	}))
+	defer proxy.Close()
+	manager, err := NewProxyManager(NewProxyManagerArgs([]string{proxy.URL}, 3, time.Minute, "", 0))
+	if err != nil {
+		t.Fatalf("ERROR: Proxy manager initialization failing: %s\n", err)
+	}
+	defer manager.Close()
+	layer := &headerTransport{base: http.DefaultTransport}
+	client := manager.Wrap(&http.Client{Transport: layer}, PROXY_PER_DOWNLOADER)
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatalf("ERROR: Request via proxy failing: %s\n", err)
+	}
+	content, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("X-Via") != "a" || string(content) != "yes" {
+		t.Errorf("ERROR: The layered transport is not kept under the proxy! (via=%q, layer=%q)\n",
+			resp.Header.Get("X-Via"), content)
+	}
+	if layer.base != http.DefaultTransport {
+		t.Errorf("ERROR: The original layered transport should not be modified!\n")
+	}
+}
+
+func TestProxyBan(t *testing.T) {
+	proxy := startHttpProxy("a", nil)
+	defer proxy.Close()
+	// 關閉的替身所佔用的位址會拒絕連接。
+	dead := startHttpProxy("dead", nil)
+	dead.Close()
+	args := NewProxyManagerArgs([]string{dead.URL, "http://user:secret@" + proxy.Listener.Addr().String()},
+		2, 100*time.Millisecond, "", 0)
+	manager, err := NewProxyManager(args)
+	if err != nil {
+		t.Fatalf("ERROR: Proxy manager initialization failing: %s\n", err)
+	}
+	defer manager.Close()
+	client := manager.Wrap(&http.Client{Timeout: time.Second}, PROXY_PER_REQUEST)
+	failures := 0
+	for i := 0; i < 6; i++ {
+		if _, err := getVia(client, "http://example.com/"); err != nil {
+			failures++
+		}
+	}
+	// 失敗的代理在第二次失敗之後被禁用，其後的請求都經由可用的代理。
+	if failures != 2 {
+		t.Errorf("ERROR: The number of failures is %d, but should be %d!\n", failures, 2)
+	}
+	stats := manager.Stats()
+	if !stats[0].Banned || stats[0].Failures != 2 || stats[1].Banned || stats[1].Successes != 4 {
+		t.Errorf("ERROR: The proxy stats %v are wrong!\n", stats)
+	}
+	summary := manager.Summary()
+	if strings.Contains(summary, "secret") || !strings.Contains(summary, "banned") {
+		t.Errorf("ERROR: The summary %q is wrong!\n", summary)
+	}
+
+	// 禁用時間結束後，代理再失敗一次就會被再次禁用。
+	time.Sleep(150 * time.Millisecond)
+	deadUrl, _ := parseProxyUrl(dead.URL)
+	if !manager.Available(deadUrl) {
+		t.Fatalf("ERROR: The proxy should be available after the ban expires!\n")
+	}
+	manager.Report(deadUrl, 0, errors.New("Connection refused!\n"))
+	if manager.Available(deadUrl) {
+		t.Errorf("ERROR: The proxy should be banned again after one more failure!\n")
+	}
+
+	proxyUrl, _ := parseProxyUrl(proxy.URL)
+	args = NewProxyManagerArgs([]string{proxy.URL}, 1, time.Minute, "", 0)
+	manager, err = NewProxyManager(args)
+	if err != nil {
+		t.Fatalf("ERROR: Proxy manager initialization failing: %s\n", err)
+	}
+	defer manager.Close()
+	manager.Report(proxyUrl, 0, errors.New("Timeout!\n"))
+	if _, err := manager.Wrap(nil, PROXY_PER_DOWNLOADER).Get("http://example.com/"); err == nil {
+		t.Errorf("ERROR: The request should fail when no proxy is available!\n")
+	}
+}
+
+func TestProxyHealthCheck(t *testing.T) {
+	var down int32 = 1
+	proxy := startHttpProxy("a", &down)
+	defer proxy.Close()
+	args := NewProxyManagerArgs([]string{proxy.URL}, 1, time.Hour,
+		"http://example.com/health", 10*time.Millisecond)
+	manager, err := NewProxyManager(args)
+	if err != nil {
+		t.Fatalf("ERROR: Proxy manager initialization failing: %s\n", err)
+	}
+	defer manager.Close()
+	proxyUrl, _ := parseProxyUrl(proxy.URL)
+	waitFor := func(available bool) bool {
+		for i := 0; i < 200; i++ {
+			if manager.Available(proxyUrl) == available {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+	if !waitFor(false) {
+		t.Fatalf("ERROR: The proxy should be banned after failed health check!\n")
+	}
+	// 代理恢復後，下一次成功的健康檢查會立即解除禁用，而無需等待禁用時間結束。
+	atomic.StoreInt32(&down, 0)
+	if !waitFor(true) {
+		t.Fatalf("ERROR: The proxy should be restored after successful health check!\n")
+	}
+	// 健康檢查的延遲與請求的延遲分開記錄。
+	if stats := manager.Stats()[0]; stats.Successes != 0 || stats.Failures == 0 || stats.HealthCheckLatency <= 0 {
+		t.Errorf("ERROR: The proxy stats %s are wrong!\n", stats)
+	}
+
+	// 成功的健康檢查不會重設未被禁用的代理的連續失敗次數。
+	args = NewProxyManagerArgs([]string{proxy.URL}, 3, time.Hour,
+		"http://example.com/health", 10*time.Millisecond)
+	manager, err = NewProxyManager(args)
+	if err != nil {
+		t.Fatalf("ERROR: Proxy manager initialization failing: %s\n", err)
+	}
+	defer manager.Close()
+	for i := 0; i < 200 && manager.Stats()[0].HealthCheckLatency == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	manager.Report(proxyUrl, 0, errors.New("Timeout!\n"))
+	manager.Report(proxyUrl, 0, errors.New("Timeout!\n"))
+	time.Sleep(50 * time.Millisecond)
+	if stats := manager.Stats()[0]; stats.ConsecutiveFailures != 2 || stats.Banned {
+		t.Errorf("ERROR: The proxy stats %s are wrong after health checks!\n", stats)
+	}
+}
+
+func TestSocks5Proxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer server.Close()
+	listener, connects := startSocks5Proxy(t)
+	defer listener.Close()
+	args := NewProxyManagerArgs([]string{"socks5://" + listener.Addr().String()}, 1, time.Minute, "", 0)
+	manager, err := NewProxyManager(args)
+	if err != nil {
+		t.Fatalf("ERROR: Proxy manager initialization failing: %s\n", err)
+	}
+	defer manager.Close()
+	client := manager.Wrap(&http.Client{Timeout: time.Second}, PROXY_PER_DOWNLOADER)
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("ERROR: Request via SOCKS5 proxy failing: %s\n", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" || atomic.LoadInt32(connects) != 1 {
+		t.Errorf("ERROR: The body %q or the number of connects %d is wrong!\n", body, atomic.LoadInt32(connects))
+	}
+	if stats := manager.Stats()[0]; stats.Successes != 1 || stats.AvgLatency <= 0 {
+		t.Errorf("ERROR: The proxy stats %s are wrong!\n", stats)
+	}
+}
+
+func TestProxyManagerArgs(t *testing.T) {
+	invalids := []ProxyManagerArgs{
+		NewProxyManagerArgs(nil, 1, time.Minute, "", 0),
+		NewProxyManagerArgs([]string{"ftp://127.0.0.1:21"}, 1, time.Minute, "", 0),
+		NewProxyManagerArgs([]string{"http://127.0.0.1:8080"}, 0, time.Minute, "", 0),
+		NewProxyManagerArgs([]string{"http://127.0.0.1:8080"}, 1, time.Minute, "http://example.com/", 0),
+	}
+	for i, args := range invalids {
+		if err := args.Check(); err == nil {
+			t.Errorf("ERROR: The proxy manager args [%d] %s should be invalid!\n", i, args.String())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package fingerprint
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// 重復型態。
+type DupType uint8
+
+// 重復型態常數。
+const (
+	DUP_TYPE_NONE  DupType = 0 // 未重復。
+	DUP_TYPE_EXACT DupType = 1 // 內容完全相同。
+	DUP_TYPE_NEAR  DupType = 2 // 內容近似相同。
+)
+
+// 表示重復型態與其名稱之間的映射關系的字典。
+var dupTypeNameMap = map[DupType]string{
+	DUP_TYPE_NONE:  "none",
+	DUP_TYPE_EXACT: "exact",
+	DUP_TYPE_NEAR:  "near",
+}
+
+func (dt DupType) String() string {
+	name, ok := dupTypeNameMap[dt]
+	if !ok {
+		name = fmt.Sprintf("%d", dt)
+	}
+	return name
+}
+
+// 近似重復判定所允許的最大漢明距離的上限。
+const MAX_HAMMING_DISTANCE = 15
+
+// 內容指紋。
+type Fingerprint struct {
+	Exact   [sha1.Size]byte // 對提取出的文字計算的精確雜湊值。
+	SimHash uint64          // 對提取出的文字計算的SimHash值。
+}
+
+// 內容去重器的接口型態。
+type ContentDeduper interface {
+	// 檢查內容是否已經出現過，並記錄其指紋。
+	// 參數content代表HTML形式的網頁內容。
+	// 提取不出文字的內容（例如重定向的響應體、圖片和JSON）無從比較，它不會被檢查和記錄，結果總是未重復。
+	Check(content []byte) (DupType, Fingerprint)
+	// 獲得已檢查的內容的數量、完全重復的數量和近似重復的數量。
+	// 作為結果值的切片總會有三個元素值。
+	Count() []uint64
+	// 取得摘要訊息。
+	Summary() string
+}
+
+// 建立內容去重器。
+// 參數maxDistance代表了近似重復判定所允許的最大漢明距離。為0時只判定完全重復。
+func NewContentDeduper(maxDistance uint8) (ContentDeduper, error) {
+	if maxDistance > MAX_HAMMING_DISTANCE {
+		errMsg := fmt.Sprintf("The max hamming distance can not be greater than %d! (maxDistance=%d)\n",
+			MAX_HAMMING_DISTANCE, maxDistance)
+		return nil, errors.New(errMsg)
+	}
+	return &myContentDeduper{
+		maxDistance: int(maxDistance),
+		exactSet:    make(map[[sha1.Size]byte]bool),
+		bandMap:     make(map[bandKey][]uint64),
+	}, nil
+}
+
+// 分段索引的鍵。
+type bandKey struct {
+	index uint8  // 分段的序號。
+	value uint64 // 分段上的位元值。
+}
+
+// 內容去重器的實現型態。
+// 近似重復的查找基於鴿巢原理：把SimHash值切分為maxDistance+1段，
+// 漢明距離不超過maxDistance的兩個值至少在某一段上完全相同。
+type myContentDeduper struct {
+	maxDistance int                      // 允許的最大漢明距離。
+	exactSet    map[[sha1.Size]byte]bool // 精確雜湊值的集合。
+	bandMap     map[bandKey][]uint64     // SimHash值的分段索引。
+	checked     uint64                   // 已檢查的內容的數量。
+	exactDups   uint64                   // 完全重復的內容的數量。
+	nearDups    uint64                   // 近似重復的內容的數量。
+	mutex       sync.Mutex               // 互斥鎖。
+}
+
+func (cd *myContentDeduper) Check(content []byte) (DupType, Fingerprint) {
+	text := ExtractText(content)
+	if text == "" {
+		return DUP_TYPE_NONE, Fingerprint{}
+	}
+	fp := Fingerprint{
+		Exact:   sha1.Sum([]byte(text)),
+		SimHash: SimHash(text),
+	}
+	cd.mutex.Lock()
+	defer cd.mutex.Unlock()
+	cd.checked++
+	if cd.exactSet[fp.Exact] {
+		cd.exactDups++
+		return DUP_TYPE_EXACT, fp
+	}
+	cd.exactSet[fp.Exact] = true
+	keys := cd.bandKeys(fp.SimHash)
+	if cd.maxDistance > 0 {
+		for _, key := range keys {
+			for _, other := range cd.bandMap[key] {
+				if HammingDistance(fp.SimHash, other) <= cd.maxDistance {
+					cd.nearDups++
+					return DUP_TYPE_NEAR, fp
+				}
+			}
+		}
+	}
+	for _, key := range keys {
+		cd.bandMap[key] = append(cd.bandMap[key], fp.SimHash)
+	}
+	return DUP_TYPE_NONE, fp
+}
+
+// 把SimHash值切分為分段索引的鍵。
+func (cd *myContentDeduper) bandKeys(simHash uint64) []bandKey {
+	bands := cd.maxDistance + 1
+	width := 64 / bands
+	keys := make([]bandKey, bands)
+	for i := 0; i < bands; i++ {
+		shift := uint(i * width)
+		var mask uint64
+		if i == bands-1 {
+			mask = ^uint64(0) >> shift
+		} else {
+			mask = (uint64(1) << uint(width)) - 1
+		}
+		keys[i] = bandKey{index: uint8(i), value: (simHash >> shift) & mask}
+	}
+	return keys
+}
+
+func (cd *myContentDeduper) Count() []uint64 {
+	cd.mutex.Lock()
+	defer cd.mutex.Unlock()
+	return []uint64{cd.checked, cd.exactDups, cd.nearDups}
+}
+
+var summaryTemplate = "maxDistance: %d, checked: %d, exactDuplicates: %d, nearDuplicates: %d"
+
+func (cd *myContentDeduper) Summary() string {
+	counts := cd.Count()
+	return fmt.Sprintf(summaryTemplate,
+		cd.maxDistance, counts[0], counts[1], counts[2])
+}
@@ -0,0 +1,57 @@
+package fingerprint
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExtractText(t *testing.T) {
+	content := []byte("<html><head><style>p {}</style><script>var a = '<p>';</script></head>" +
+		"<body><!-- note --><p>Hello&amp;<b>World</b></p>\n\n<p>  Go </p></body></html>")
+	expected := "Hello& World Go"
+	text := ExtractText(content)
+	if text != expected {
+		t.Errorf("ERROR: The extracted text is %q, but should be %q!\n", text, expected)
+	}
+}
+
+func TestContentDeduper(t *testing.T) {
+	deduper, err := NewContentDeduper(3)
+	if err != nil {
+		t.Fatalf("ERROR: Content deduper initialization failing: %s\n", err)
+	}
+	var words string
+	for i := 0; i < 200; i++ {
+		words += fmt.Sprintf(" word%d", i)
+	}
+	page := "<html><body><p>" + words + "</p></body></html>"
+	mirror := "<html><head><title></title></head><body><div>" + words + "</div></body></html>"
+	near := "<html><body><p>" + words + " footer</p></body></html>"
+	other := "<html><body><p>a completely different page about something else</p></body></html>"
+	cases := []struct {
+		content  string
+		expected DupType
+	}{
+		{page, DUP_TYPE_NONE},
+		{mirror, DUP_TYPE_EXACT},
+		{near, DUP_TYPE_NEAR},
+		{other, DUP_TYPE_NONE},
+		// 提取不出文字的內容不會被檢查。
+		{"<html><body></body></html>", DUP_TYPE_NONE},
+		{"<html><body><script>var a;</script></body></html>", DUP_TYPE_NONE},
+	}
+	for i, c := range cases {
+		dupType, _ := deduper.Check([]byte(c.content))
+		if dupType != c.expected {
+			t.Errorf("ERROR: The duplicate type of content [%d] is %s, but should be %s!\n",
+				i, dupType, c.expected)
+		}
+	}
+	counts := deduper.Count()
+	if counts[0] != 4 || counts[1] != 1 || counts[2] != 1 {
+		t.Errorf("ERROR: Unexpected counts %v!\n", counts)
+	}
+	if _, err := NewContentDeduper(MAX_HAMMING_DISTANCE + 1); err == nil {
+		t.Errorf("ERROR: The content deduper should not be initialized with too large distance!\n")
+	}
+}
@@ -0,0 +1,51 @@
+package fingerprint
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+)
+
+// 產生SimHash值時所用的詞組（shingle）的長度。
+const shingleSize = 2
+
+// 計算文字的64位SimHash值。
+// 文字會被切分為小寫的詞，每相鄰的shingleSize個詞組成一個特征。
+func SimHash(text string) uint64 {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) == 0 {
+		return 0
+	}
+	var weights [64]int
+	addFeature := func(feature string) {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		for i := uint(0); i < 64; i++ {
+			if sum&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+	if len(words) < shingleSize {
+		addFeature(strings.Join(words, " "))
+	} else {
+		for i := 0; i+shingleSize <= len(words); i++ {
+			addFeature(strings.Join(words[i:i+shingleSize], " "))
+		}
+	}
+	var result uint64
+	for i := uint(0); i < 64; i++ {
+		if weights[i] > 0 {
+			result |= 1 << i
+		}
+	}
+	return result
+}
+
+// 計算兩個SimHash值之間的漢明距離。
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,34 @@
+package fingerprint
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// 被用來移除不含可見文字的元素的正規表示式。
+var regexpForInvisible = regexp.MustCompile(`(?is)<(script|style|noscript|template)\b.*?</(script|style|noscript|template)\s*>`)
+
+// 被用來移除注解的正規表示式。
+var regexpForComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+
+// 被用來移除標簽的正規表示式。
+var regexpForTag = regexp.MustCompile(`(?s)<[^>]*>`)
+
+// 從HTML內容中提取可見文字。
+// 結果中的空白字元會被合並為單一空格，HTML實體會被還原。
+func ExtractText(content []byte) string {
+	content = regexpForComment.ReplaceAll(content, []byte(" "))
+	content = regexpForInvisible.ReplaceAll(content, []byte(" "))
+	content = regexpForTag.ReplaceAll(content, []byte(" "))
+	text := html.UnescapeString(string(content))
+	var buffer bytes.Buffer
+	for _, field := range strings.Fields(text) {
+		if buffer.Len() > 0 {
+			buffer.WriteByte(' ')
+		}
+		buffer.WriteString(field)
+	}
+	return buffer.String()
+}
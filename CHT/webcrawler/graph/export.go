@@ -0,0 +1,123 @@
+package graph
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 以GraphML格式輸出爬取圖。
+// 網頁的深度、狀態碼以及是否已被下載會作為節點的資料被輸出，邊是否是重新導向則作為邊的資料被輸出。
+func WriteGraphML(w io.Writer, cg CrawlGraph) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	bw.WriteString(`  <key id="depth" for="node" attr.name="depth" attr.type="int"/>` + "\n")
+	bw.WriteString(`  <key id="status" for="node" attr.name="status" attr.type="int"/>` + "\n")
+	bw.WriteString(`  <key id="fetched" for="node" attr.name="fetched" attr.type="boolean"/>` + "\n")
+	bw.WriteString(`  <key id="redirect" for="edge" attr.name="redirect" attr.type="boolean"/>` + "\n")
+	bw.WriteString(`  <graph id="crawl" edgedefault="directed">` + "\n")
+	for _, page := range cg.Pages() {
+		bw.WriteString(fmt.Sprintf("    <node id=\"%s\">\n", escapeXml(page.Url)))
+		bw.WriteString(fmt.Sprintf("      <data key=\"depth\">%d</data>\n", page.Depth))
+		bw.WriteString(fmt.Sprintf("      <data key=\"status\">%d</data>\n", page.StatusCode))
+		bw.WriteString(fmt.Sprintf("      <data key=\"fetched\">%v</data>\n", page.Fetched))
+		bw.WriteString("    </node>\n")
+	}
+	for _, link := range cg.Links() {
+		if !link.Redirect {
+			bw.WriteString(fmt.Sprintf("    <edge source=\"%s\" target=\"%s\"/>\n",
+				escapeXml(link.Source), escapeXml(link.Target)))
+			continue
+		}
+		bw.WriteString(fmt.Sprintf("    <edge source=\"%s\" target=\"%s\">\n",
+			escapeXml(link.Source), escapeXml(link.Target)))
+		bw.WriteString("      <data key=\"redirect\">true</data>\n")
+		bw.WriteString("    </edge>\n")
+	}
+	bw.WriteString("  </graph>\n")
+	bw.WriteString("</graphml>\n")
+	return bw.Flush()
+}
+
+// 以DOT格式輸出爬取圖。
+// 失效的網頁會以紅色標示，未被下載的網頁會以虛線標示，重新導向的邊會以粗線標示。
+func WriteDOT(w io.Writer, cg CrawlGraph) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("digraph crawl {\n")
+	for _, page := range cg.Pages() {
+		attrs := fmt.Sprintf("depth=%d, status=%d", page.Depth, page.StatusCode)
+		switch {
+		case !page.Fetched:
+			attrs += ", style=dashed"
+		case IsBrokenStatus(page.StatusCode):
+			attrs += ", color=red"
+		}
+		bw.WriteString(fmt.Sprintf("  %s [%s];\n", quoteDot(page.Url), attrs))
+	}
+	for _, link := range cg.Links() {
+		attrs := ""
+		if link.Redirect {
+			attrs = " [style=bold]"
+		}
+		bw.WriteString(fmt.Sprintf("  %s -> %s%s;\n", quoteDot(link.Source), quoteDot(link.Target), attrs))
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+// JSON格式的爬取圖。
+type jsonGraph struct {
+	Pages     []jsonPage          `json:"pages"`
+	Adjacency map[string][]string `json:"adjacency"`
+}
+
+// JSON格式的網頁。
+type jsonPage struct {
+	Url        string `json:"url"`
+	Depth      uint32 `json:"depth"`
+	StatusCode int    `json:"status"`
+	Fetched    bool   `json:"fetched"`
+}
+
+// 以JSON格式的鄰接表輸出爬取圖。
+// 鄰接表以父網頁的URL為鍵，以子網頁的URL的清單為值。沒有子網頁的網頁的清單為空。
+func WriteJSON(w io.Writer, cg CrawlGraph) error {
+	pages := cg.Pages()
+	graph := jsonGraph{
+		Pages:     make([]jsonPage, 0, len(pages)),
+		Adjacency: make(map[string][]string, len(pages)),
+	}
+	for _, page := range pages {
+		graph.Pages = append(graph.Pages, jsonPage{
+			Url:        page.Url,
+			Depth:      page.Depth,
+			StatusCode: page.StatusCode,
+			Fetched:    page.Fetched,
+		})
+		graph.Adjacency[page.Url] = []string{}
+	}
+	for _, link := range cg.Links() {
+		graph.Adjacency[link.Source] = append(graph.Adjacency[link.Source], link.Target)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(graph)
+}
+
+// 轉義XML屬性值。
+func escapeXml(s string) string {
+	var builder strings.Builder
+	xml.EscapeText(&builder, []byte(s))
+	return builder.String()
+}
+
+// 把字串轉換為DOT格式的帶引號的識別字。
+func quoteDot(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,191 @@
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// 網頁。它是爬取圖中的節點。
+type Page struct {
+	Url        string // 網頁的URL。
+	Depth      uint32 // 網頁的深度。未被下載的網頁的深度由其父網頁推算而得。
+	StatusCode int    // 響應的HTTP狀態碼。未被下載或下載失敗時為0。
+	Fetched    bool   // 網頁是否已被下載。
+}
+
+// 連結。它是爬取圖中由父網頁指向子網頁的有向邊。
+type Link struct {
+	Source   string // 父網頁的URL。
+	Target   string // 子網頁的URL。
+	Redirect bool   // 是否是由原網頁指向重新導向的目的網頁的邊。
+}
+
+// 爬取圖的接口型態。它記錄已下載的網頁以及網頁之間的連結。
+type CrawlGraph interface {
+	// 記錄一個已被下載的網頁。參數statusCode為0時表示下載失敗。
+	AddPage(url string, depth uint32, statusCode int)
+	// 記錄一條由父網頁指向子網頁的連結。指向自身的連結會被忽略。
+	// 子網頁不存在時會被記錄為未下載的網頁。
+	AddLink(source string, target string)
+	// 記錄一次重新導向。原網頁會被記錄為以參數statusCode響應的已下載的網頁，
+	// 並以一條重新導向的邊指向目的網頁。目的網頁不存在時會被記錄為與原網頁同深度的未下載的網頁。
+	AddRedirect(source string, target string, depth uint32, statusCode int)
+	// 獲得網頁。第二個結果值表示網頁是否存在。
+	Page(url string) (Page, bool)
+	// 獲得按URL排序的所有網頁。
+	Pages() []Page
+	// 獲得按父網頁和子網頁的URL排序的所有連結。
+	Links() []Link
+	// 獲得網頁的數量。
+	PageCount() uint64
+	// 獲得連結的數量。
+	LinkCount() uint64
+	// 產生爬取報告。
+	Report() Report
+	// 獲得摘要訊息。
+	Summary() string
+}
+
+// 建立爬取圖。
+func NewCrawlGraph() CrawlGraph {
+	return &myCrawlGraph{
+		pages: make(map[string]*Page),
+		links: make(map[string]map[string]bool),
+	}
+}
+
+// 爬取圖的實現型態。
+type myCrawlGraph struct {
+	pages     map[string]*Page           // 網頁的字典，以URL為鍵。
+	links     map[string]map[string]bool // 連結的字典，以父網頁的URL為鍵，值表示是否是重新導向的邊。
+	linkCount uint64                     // 連結的數量。
+	rwmutex   sync.RWMutex               // 讀寫鎖。
+}
+
+func (cg *myCrawlGraph) AddPage(url string, depth uint32, statusCode int) {
+	cg.rwmutex.Lock()
+	defer cg.rwmutex.Unlock()
+	cg.addPage(url, depth, statusCode)
+}
+
+// 記錄已被下載的網頁。呼叫方應持有寫鎖。
+func (cg *myCrawlGraph) addPage(url string, depth uint32, statusCode int) {
+	page, ok := cg.pages[url]
+	if !ok {
+		page = &Page{Url: url}
+		cg.pages[url] = page
+	}
+	page.Depth = depth
+	page.StatusCode = statusCode
+	page.Fetched = true
+}
+
+func (cg *myCrawlGraph) AddLink(source string, target string) {
+	if source == target {
+		return
+	}
+	cg.rwmutex.Lock()
+	defer cg.rwmutex.Unlock()
+	sourcePage, ok := cg.pages[source]
+	if !ok {
+		sourcePage = &Page{Url: source}
+		cg.pages[source] = sourcePage
+	}
+	if _, ok := cg.pages[target]; !ok {
+		cg.pages[target] = &Page{Url: target, Depth: sourcePage.Depth + 1}
+	}
+	cg.addEdge(source, target, false)
+}
+
+func (cg *myCrawlGraph) AddRedirect(source string, target string, depth uint32, statusCode int) {
+	if source == target {
+		return
+	}
+	cg.rwmutex.Lock()
+	defer cg.rwmutex.Unlock()
+	cg.addPage(source, depth, statusCode)
+	if _, ok := cg.pages[target]; !ok {
+		cg.pages[target] = &Page{Url: target, Depth: depth}
+	}
+	cg.addEdge(source, target, true)
+}
+
+// 記錄一條邊。已存在的邊不會被重複計數。呼叫方應持有寫鎖。
+func (cg *myCrawlGraph) addEdge(source string, target string, redirect bool) {
+	targets, ok := cg.links[source]
+	if !ok {
+		targets = make(map[string]bool)
+		cg.links[source] = targets
+	}
+	if _, ok := targets[target]; !ok {
+		cg.linkCount++
+	}
+	targets[target] = targets[target] || redirect
+}
+
+func (cg *myCrawlGraph) Page(url string) (Page, bool) {
+	cg.rwmutex.RLock()
+	defer cg.rwmutex.RUnlock()
+	page, ok := cg.pages[url]
+	if !ok {
+		return Page{}, false
+	}
+	return *page, true
+}
+
+func (cg *myCrawlGraph) Pages() []Page {
+	cg.rwmutex.RLock()
+	defer cg.rwmutex.RUnlock()
+	pages := make([]Page, 0, len(cg.pages))
+	for _, page := range cg.pages {
+		pages = append(pages, *page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Url < pages[j].Url
+	})
+	return pages
+}
+
+func (cg *myCrawlGraph) Links() []Link {
+	cg.rwmutex.RLock()
+	defer cg.rwmutex.RUnlock()
+	links := make([]Link, 0, cg.linkCount)
+	for source, targets := range cg.links {
+		for target, redirect := range targets {
+			links = append(links, Link{Source: source, Target: target, Redirect: redirect})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Source != links[j].Source {
+			return links[i].Source < links[j].Source
+		}
+		return links[i].Target < links[j].Target
+	})
+	return links
+}
+
+func (cg *myCrawlGraph) PageCount() uint64 {
+	cg.rwmutex.RLock()
+	defer cg.rwmutex.RUnlock()
+	return uint64(len(cg.pages))
+}
+
+func (cg *myCrawlGraph) LinkCount() uint64 {
+	cg.rwmutex.RLock()
+	defer cg.rwmutex.RUnlock()
+	return cg.linkCount
+}
+
+func (cg *myCrawlGraph) Summary() string {
+	cg.rwmutex.RLock()
+	defer cg.rwmutex.RUnlock()
+	var fetched int
+	for _, page := range cg.pages {
+		if page.Fetched {
+			fetched++
+		}
+	}
+	return fmt.Sprintf("pages: %d, fetched: %d, links: %d",
+		len(cg.pages), fetched, cg.linkCount)
+}
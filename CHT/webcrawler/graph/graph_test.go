@@ -0,0 +1,190 @@
+package graph
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+// 建立測試用的爬取圖：
+// a -> b, a -> c, b -> c, c -> a, c -> d（404）, b -> "e&f"（未下載），
+// 另有深度不為0且無入度的孤立網頁 g。
+func newTestGraph() CrawlGraph {
+	cg := NewCrawlGraph()
+	cg.AddPage("a", 0, 200)
+	cg.AddLink("a", "b")
+	cg.AddLink("a", "c")
+	cg.AddLink("a", "a")
+	cg.AddPage("b", 1, 200)
+	cg.AddPage("c", 1, 200)
+	cg.AddLink("b", "c")
+	cg.AddLink("b", "e&f")
+	cg.AddLink("c", "a")
+	cg.AddLink("c", "d")
+	cg.AddLink("c", "d")
+	cg.AddPage("d", 2, 404)
+	cg.AddPage("g", 3, 200)
+	return cg
+}
+
+func TestCrawlGraph(t *testing.T) {
+	cg := newTestGraph()
+	if count := cg.PageCount(); count != 6 {
+		t.Errorf("ERROR: The page count is %d, but should be %d!\n", count, 6)
+	}
+	if count := cg.LinkCount(); count != 6 {
+		t.Errorf("ERROR: The link count is %d, but should be %d!\n", count, 6)
+	}
+	page, ok := cg.Page("e&f")
+	if !ok || page.Fetched || page.Depth != 2 {
+		t.Errorf("ERROR: The unfetched page is %v (exists=%v)!\n", page, ok)
+	}
+	page, ok = cg.Page("d")
+	if !ok || !page.Fetched || page.StatusCode != 404 {
+		t.Errorf("ERROR: The fetched page is %v (exists=%v)!\n", page, ok)
+	}
+	links := cg.Links()
+	if links[0] != (Link{Source: "a", Target: "b"}) {
+		t.Errorf("ERROR: The links %v are not sorted!\n", links)
+	}
+}
+
+func TestCrawlGraphRedirect(t *testing.T) {
+	cg := NewCrawlGraph()
+	cg.AddPage("a", 0, 200)
+	cg.AddLink("a", "old")
+	cg.AddPage("new", 1, 200)
+	cg.AddRedirect("old", "new", 1, 301)
+	cg.AddLink("new", "b")
+	page, _ := cg.Page("old")
+	if !page.Fetched || page.StatusCode != 301 || page.Depth != 1 {
+		t.Errorf("ERROR: The redirected page is %v!\n", page)
+	}
+	expected := []Link{
+		{Source: "a", Target: "old"},
+		{Source: "new", Target: "b"},
+		{Source: "old", Target: "new", Redirect: true},
+	}
+	links := cg.Links()
+	if len(links) != len(expected) {
+		t.Fatalf("ERROR: The links are %v, but should be %v!\n", links, expected)
+	}
+	for i, link := range links {
+		if link != expected[i] {
+			t.Errorf("ERROR: The link [%d] is %v, but should be %v!\n", i, link, expected[i])
+		}
+	}
+	report := cg.Report()
+	if len(report.Orphans) != 0 || report.OutDegree["old"] != 1 || report.InDegree["new"] != 1 {
+		t.Errorf("ERROR: The report of the redirect is wrong!\n%s", report)
+	}
+	var buffer bytes.Buffer
+	WriteDOT(&buffer, cg)
+	if !strings.Contains(buffer.String(), `"old" -> "new" [style=bold];`) {
+		t.Errorf("ERROR: The DOT output does not mark the redirect edge!\n%s", buffer.String())
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := newTestGraph().Report()
+	if report.FetchedCount != 5 {
+		t.Errorf("ERROR: The fetched count is %d, but should be %d!\n", report.FetchedCount, 5)
+	}
+	expectedIn := map[string]uint32{"a": 1, "b": 1, "c": 2, "d": 1, "e&f": 1, "g": 0}
+	expectedOut := map[string]uint32{"a": 2, "b": 2, "c": 2, "d": 0, "e&f": 0, "g": 0}
+	for url, in := range expectedIn {
+		if report.InDegree[url] != in {
+			t.Errorf("ERROR: The in-degree of %s is %d, but should be %d!\n", url, report.InDegree[url], in)
+		}
+		if report.OutDegree[url] != expectedOut[url] {
+			t.Errorf("ERROR: The out-degree of %s is %d, but should be %d!\n",
+				url, report.OutDegree[url], expectedOut[url])
+		}
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0] != "g" {
+		t.Errorf("ERROR: The orphans are %v, but should be [g]!\n", report.Orphans)
+	}
+	expectedBroken := BrokenLink{Source: "c", Target: "d", StatusCode: 404}
+	if len(report.BrokenLinks) != 1 || report.BrokenLinks[0] != expectedBroken {
+		t.Errorf("ERROR: The broken links are %v, but should be [%v]!\n",
+			report.BrokenLinks, expectedBroken)
+	}
+	if !strings.Contains(report.String(), "c -> d (status=404)") {
+		t.Errorf("ERROR: The report does not contain the broken link: %s\n", report)
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := WriteGraphML(&buffer, newTestGraph()); err != nil {
+		t.Fatalf("ERROR: GraphML writing failing: %s\n", err)
+	}
+	var doc struct {
+		Graph struct {
+			Nodes []struct {
+				Id string `xml:"id,attr"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+				Target string `xml:"target,attr"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(buffer.Bytes(), &doc); err != nil {
+		t.Fatalf("ERROR: GraphML parsing failing: %s\n", err)
+	}
+	if len(doc.Graph.Nodes) != 6 || len(doc.Graph.Edges) != 6 {
+		t.Errorf("ERROR: The GraphML has %d nodes and %d edges, but should have %d and %d!\n",
+			len(doc.Graph.Nodes), len(doc.Graph.Edges), 6, 6)
+	}
+	var found bool
+	for _, node := range doc.Graph.Nodes {
+		if node.Id == "e&f" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("ERROR: The node 'e&f' is not found in GraphML!\n")
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := WriteDOT(&buffer, newTestGraph()); err != nil {
+		t.Fatalf("ERROR: DOT writing failing: %s\n", err)
+	}
+	dot := buffer.String()
+	expectedLines := []string{
+		"digraph crawl {",
+		`"c" -> "d";`,
+		`"d" [depth=2, status=404, color=red];`,
+		`"e&f" [depth=2, status=0, style=dashed];`,
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(dot, line) {
+			t.Errorf("ERROR: The DOT output does not contain %q!\n", line)
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := WriteJSON(&buffer, newTestGraph()); err != nil {
+		t.Fatalf("ERROR: JSON writing failing: %s\n", err)
+	}
+	var graph jsonGraph
+	if err := json.Unmarshal(buffer.Bytes(), &graph); err != nil {
+		t.Fatalf("ERROR: JSON parsing failing: %s\n", err)
+	}
+	if len(graph.Pages) != 6 {
+		t.Errorf("ERROR: The JSON has %d pages, but should have %d!\n", len(graph.Pages), 6)
+	}
+	if targets := graph.Adjacency["c"]; len(targets) != 2 || targets[0] != "a" || targets[1] != "d" {
+		t.Errorf("ERROR: The adjacency of c is %v, but should be [a d]!\n", targets)
+	}
+	if targets, ok := graph.Adjacency["d"]; !ok || len(targets) != 0 {
+		t.Errorf("ERROR: The adjacency of d is %v (exists=%v), but should be empty!\n", targets, ok)
+	}
+}
@@ -0,0 +1,81 @@
+package graph
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// 失效連結。它的子網頁的響應狀態碼為4xx或5xx。
+type BrokenLink struct {
+	Source     string // 父網頁的URL。
+	Target     string // 子網頁的URL。
+	StatusCode int    // 子網頁的響應狀態碼。
+}
+
+// 爬取報告。
+type Report struct {
+	PageCount    uint64            // 網頁的數量。
+	FetchedCount uint64            // 已下載的網頁的數量。
+	LinkCount    uint64            // 連結的數量。
+	InDegree     map[string]uint32 // 各網頁的入度，以URL為鍵。
+	OutDegree    map[string]uint32 // 各網頁的出度，以URL為鍵。
+	Orphans      []string          // 孤立網頁的URL。它們已被下載、深度不為0且沒有任何網頁連結到它們。
+	BrokenLinks  []BrokenLink      // 失效連結。
+}
+
+// 判斷HTTP狀態碼是否代表失效的網頁。
+func IsBrokenStatus(statusCode int) bool {
+	return statusCode >= 400 && statusCode < 600
+}
+
+func (cg *myCrawlGraph) Report() Report {
+	pages := cg.Pages()
+	links := cg.Links()
+	report := Report{
+		PageCount:   uint64(len(pages)),
+		LinkCount:   uint64(len(links)),
+		InDegree:    make(map[string]uint32, len(pages)),
+		OutDegree:   make(map[string]uint32, len(pages)),
+		Orphans:     []string{},
+		BrokenLinks: []BrokenLink{},
+	}
+	statusCodes := make(map[string]int, len(pages))
+	for _, page := range pages {
+		report.InDegree[page.Url] = 0
+		report.OutDegree[page.Url] = 0
+		if page.Fetched {
+			report.FetchedCount++
+			statusCodes[page.Url] = page.StatusCode
+		}
+	}
+	for _, link := range links {
+		report.OutDegree[link.Source]++
+		report.InDegree[link.Target]++
+		if statusCode := statusCodes[link.Target]; IsBrokenStatus(statusCode) {
+			report.BrokenLinks = append(report.BrokenLinks,
+				BrokenLink{Source: link.Source, Target: link.Target, StatusCode: statusCode})
+		}
+	}
+	for _, page := range pages {
+		if page.Fetched && page.Depth > 0 && report.InDegree[page.Url] == 0 {
+			report.Orphans = append(report.Orphans, page.Url)
+		}
+	}
+	return report
+}
+
+func (report Report) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("Pages: %d (fetched: %d)\n", report.PageCount, report.FetchedCount))
+	buffer.WriteString(fmt.Sprintf("Links: %d\n", report.LinkCount))
+	buffer.WriteString(fmt.Sprintf("Orphans(%d):\n", len(report.Orphans)))
+	for _, url := range report.Orphans {
+		buffer.WriteString(fmt.Sprintf("  %s\n", url))
+	}
+	buffer.WriteString(fmt.Sprintf("Broken links(%d):\n", len(report.BrokenLinks)))
+	for _, link := range report.BrokenLinks {
+		buffer.WriteString(fmt.Sprintf("  %s -> %s (status=%d)\n",
+			link.Source, link.Target, link.StatusCode))
+	}
+	return buffer.String()
+}
@@ -0,0 +1,124 @@
+package itemproc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+	base "webcrawler/base"
+)
+
+// 死信，即：未通過驗證或處理失敗的項目及其原因。
+type DeadLetter struct {
+	Item   base.Item // 項目。
+	Kind   string    // 項目的種類。
+	Stage  string    // 處理失敗的階段的名稱。未通過驗證時為空字串。
+	Reason string    // 未通過驗證或處理失敗的原因。
+	Time   time.Time // 產生的時間。
+}
+
+// 死信接收器的接口型態。它的方法可能會被並發的呼叫。
+type DeadLetterSink interface {
+	// 放入死信。
+	Put(letter DeadLetter) error
+}
+
+// 建立在記憶體中保存死信的接收器。
+// 參數capacity代表最多保存的死信的數量，超出後最早的死信會被丟棄。
+func NewMemoryDeadLetterSink(capacity uint32) (MemoryDeadLetterSink, error) {
+	if capacity == 0 {
+		return nil, errors.New("The capacity of dead letter sink can not be 0!\n")
+	}
+	return &myMemoryDeadLetterSink{capacity: capacity}, nil
+}
+
+// 在記憶體中保存死信的接收器的接口型態。
+type MemoryDeadLetterSink interface {
+	DeadLetterSink
+	// 獲得目前保存的死信，按放入的先後排序。
+	Letters() []DeadLetter
+	// 獲得被丟棄的死信的數量。
+	Dropped() uint64
+}
+
+// 在記憶體中保存死信的接收器的實現型態。
+type myMemoryDeadLetterSink struct {
+	capacity uint32       // 容量。
+	letters  []DeadLetter // 死信的列表。
+	dropped  uint64       // 被丟棄的死信的數量。
+	mutex    sync.Mutex   // 互斥鎖。
+}
+
+func (sink *myMemoryDeadLetterSink) Put(letter DeadLetter) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if uint32(len(sink.letters)) >= sink.capacity {
+		sink.letters = sink.letters[1:]
+		sink.dropped++
+	}
+	sink.letters = append(sink.letters, letter)
+	return nil
+}
+
+func (sink *myMemoryDeadLetterSink) Letters() []DeadLetter {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	letters := make([]DeadLetter, len(sink.letters))
+	copy(letters, sink.letters)
+	return letters
+}
+
+func (sink *myMemoryDeadLetterSink) Dropped() uint64 {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return sink.dropped
+}
+
+// 建立以JSON行的形式寫入死信的接收器。
+// 項目的中繼資料不會被寫入，取而代之的是產生該項目的網頁的URL。
+// 無法被編碼為JSON的字段值會以其字串形式被寫入。
+func NewJSONDeadLetterSink(w io.Writer) DeadLetterSink {
+	return &jsonDeadLetterSink{encoder: json.NewEncoder(w)}
+}
+
+// 以JSON行的形式寫入死信的接收器的實現型態。
+type jsonDeadLetterSink struct {
+	encoder *json.Encoder // JSON編碼器。
+	mutex   sync.Mutex    // 互斥鎖。
+}
+
+// JSON格式的死信。
+type jsonDeadLetter struct {
+	Kind    string                 `json:"kind"`
+	Stage   string                 `json:"stage,omitempty"`
+	Reason  string                 `json:"reason"`
+	Time    time.Time              `json:"time"`
+	PageUrl string                 `json:"page_url,omitempty"`
+	Item    map[string]interface{} `json:"item"`
+}
+
+func (sink *jsonDeadLetterSink) Put(letter DeadLetter) error {
+	item := make(map[string]interface{}, len(letter.Item))
+	for k, v := range letter.Item {
+		if k == base.ITEM_META_KEY {
+			continue
+		}
+		if _, err := json.Marshal(v); err != nil {
+			v = fmt.Sprint(v)
+		}
+		item[k] = v
+	}
+	jdl := jsonDeadLetter{
+		Kind:    letter.Kind,
+		Stage:   letter.Stage,
+		Reason:  letter.Reason,
+		Time:    letter.Time,
+		PageUrl: letter.Item.Meta().GetString(base.META_KEY_PAGE_URL),
+		Item:    item,
+	}
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return sink.encoder.Encode(jdl)
+}
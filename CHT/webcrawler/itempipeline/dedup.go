@@ -0,0 +1,337 @@
+package itemproc
+
+import (
+	"basic/map1"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	base "webcrawler/base"
+)
+
+// 去重的策略。
+type DedupStrategy byte
+
+// 去重策略的常數。
+const (
+	DEDUP_FIRST_WINS DedupStrategy = iota // 保留最先出現的項目，之後的重覆項目都會被丟棄。
+	DEDUP_LAST_WINS                       // 以最後出現的項目為準，內容變化時會再次產生項目。
+	DEDUP_MERGE                           // 合並各次出現的項目的字段，內容變化時會再次產生項目。
+)
+
+func (strategy DedupStrategy) String() string {
+	switch strategy {
+	case DEDUP_FIRST_WINS:
+		return "first-wins"
+	case DEDUP_LAST_WINS:
+		return "last-wins"
+	case DEDUP_MERGE:
+		return "merge"
+	}
+	return fmt.Sprintf("strategy(%d)", byte(strategy))
+}
+
+// 去重鍵的產生函數的型態。項目無法產生鍵時第二個結果值為false，這樣的項目不會被去重。
+type DedupKeyFunc func(item base.Item) (string, bool)
+
+// 建立以指定字段的值作為去重鍵的函數。鍵中會包括項目的種類，以免不同種類的項目相互衝突。
+// 任何一個字段不存在時項目都無法產生鍵。
+func FieldsKey(fields ...string) DedupKeyFunc {
+	return func(item base.Item) (string, bool) {
+		parts := make([]string, 0, len(fields)+1)
+		parts = append(parts, item.Kind())
+		for _, field := range fields {
+			value, ok := item[field]
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, fmt.Sprint(value))
+		}
+		return strings.Join(parts, "\x1f"), true
+	}
+}
+
+// 建立以指定字段的值的SHA-1雜湊值作為去重鍵的函數。它適用於字段值較長的情況。
+func HashKey(fields ...string) DedupKeyFunc {
+	fieldsKey := FieldsKey(fields...)
+	return func(item base.Item) (string, bool) {
+		key, ok := fieldsKey(item)
+		if !ok {
+			return "", false
+		}
+		sum := sha1.Sum([]byte(key))
+		return hex.EncodeToString(sum[:]), true
+	}
+}
+
+// 去重儲存的接口型態。它存放去重鍵與最新的項目的對應。
+type DedupStore interface {
+	// 取得與鍵對應的項目。
+	Get(key string) (base.Item, bool, error)
+	// 存放與鍵對應的項目。
+	Put(key string, item base.Item) error
+	// 獲得鍵的數量。
+	Len() uint64
+	// 關閉儲存。
+	Close() error
+}
+
+// 建立基於記憶體的去重儲存。它使用並發安全的字典存放項目。
+func NewMemoryDedupStore() DedupStore {
+	return &memoryDedupStore{
+		cmap: map1.NewConcurrentMap(reflect.TypeOf(""), reflect.TypeOf(base.Item{})),
+	}
+}
+
+// 基於記憶體的去重儲存的實現型態。
+type memoryDedupStore struct {
+	cmap map1.ConcurrentMap // 並發安全的字典。
+}
+
+func (store *memoryDedupStore) Get(key string) (base.Item, bool, error) {
+	item, ok := store.cmap.Get(key).(base.Item)
+	return item, ok, nil
+}
+
+func (store *memoryDedupStore) Put(key string, item base.Item) error {
+	if _, ok := store.cmap.Put(key, item); !ok {
+		return errors.New(fmt.Sprintf("Dedup store putting failing! (key=%s)\n", key))
+	}
+	return nil
+}
+
+func (store *memoryDedupStore) Len() uint64 {
+	return uint64(store.cmap.Len())
+}
+
+func (store *memoryDedupStore) Close() error {
+	return nil
+}
+
+// 建立基於磁碟的去重儲存。
+// 項目以JSON行的形式被追加到檔案中，記憶體中只保存鍵與檔案位置的對應。
+// 若果參數path所指的檔案已存在，那麼其中的內容會被載入，以便繼續之前的爬取流程。
+// 項目的中繼資料不會被存放，數值在讀回時會成為float64型態。
+func NewDiskDedupStore(path string) (DedupStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	store := &diskDedupStore{file: file, offsets: make(map[string]int64)}
+	if err := store.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// 基於磁碟的去重儲存的實現型態。
+type diskDedupStore struct {
+	file    *os.File         // 檔案。
+	offsets map[string]int64 // 鍵與最新記錄在檔案中的位置的對應。
+	size    int64            // 檔案的長度。
+	mutex   sync.Mutex       // 互斥鎖。
+}
+
+// 磁碟去重儲存中的記錄。
+type diskDedupRecord struct {
+	Key  string                 `json:"key"`
+	Item map[string]interface{} `json:"item"`
+}
+
+// 載入檔案中已有的記錄。不完整的最後一行會被截去。
+func (store *diskDedupStore) load() error {
+	reader := bufio.NewReader(store.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var record diskDedupRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			errMsg := fmt.Sprintf("Broken dedup record at offset %d: %s\n", offset, err)
+			return errors.New(errMsg)
+		}
+		store.offsets[record.Key] = offset
+		offset += int64(len(line))
+	}
+	store.size = offset
+	return store.file.Truncate(offset)
+}
+
+func (store *diskDedupStore) Get(key string) (base.Item, bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	offset, ok := store.offsets[key]
+	if !ok {
+		return nil, false, nil
+	}
+	reader := bufio.NewReader(io.NewSectionReader(store.file, offset, store.size-offset))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, false, err
+	}
+	var record diskDedupRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, false, err
+	}
+	return base.Item(record.Item), true, nil
+}
+
+func (store *diskDedupStore) Put(key string, item base.Item) error {
+	fields := make(map[string]interface{}, len(item))
+	for k, v := range item {
+		if k != base.ITEM_META_KEY {
+			fields[k] = v
+		}
+	}
+	line, err := json.Marshal(diskDedupRecord{Key: key, Item: fields})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, err := store.file.WriteAt(line, store.size); err != nil {
+		return err
+	}
+	store.offsets[key] = store.size
+	store.size += int64(len(line))
+	return nil
+}
+
+func (store *diskDedupStore) Len() uint64 {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return uint64(len(store.offsets))
+}
+
+func (store *diskDedupStore) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.file.Close()
+}
+
+// 去重時所使用的鎖的數量。同一個鍵總會使用同一把鎖。
+const DEDUP_LOCK_NUMBER = 64
+
+// 建立去重階段。
+// 具有相同去重鍵的項目被視為同一個實體。在先出策略下，只有實體第一次出現時項目才會被交給下一個階段；
+// 在後出和合並策略下，實體的內容每次發生變化時都會產生更新後的項目，內容未變化的重覆項目則會被丟棄。
+// 產生的項目中的 base.ITEM_DEDUP_KEY 字段會存放去重鍵，以便接收端按鍵進行更新插入。
+// 參數store為nil時會使用基於記憶體的去重儲存。
+func NewDedupStage(name string, key DedupKeyFunc, strategy DedupStrategy, store DedupStore) (Stage, error) {
+	if key == nil {
+		return Stage{}, errors.New("The dedup key function is invalid!\n")
+	}
+	if strategy > DEDUP_MERGE {
+		errMsg := fmt.Sprintf("Unsupported dedup strategy %s!\n", strategy)
+		return Stage{}, errors.New(errMsg)
+	}
+	if store == nil {
+		store = NewMemoryDedupStore()
+	}
+	deduper := &itemDeduper{key: key, strategy: strategy, store: store}
+	return NewStage(name, deduper.dedup, 0), nil
+}
+
+// 項目去重器。
+type itemDeduper struct {
+	key      DedupKeyFunc                  // 去重鍵的產生函數。
+	strategy DedupStrategy                 // 去重的策略。
+	store    DedupStore                    // 去重儲存。
+	locks    [DEDUP_LOCK_NUMBER]sync.Mutex // 按鍵分配的鎖。
+}
+
+// 對項目去重。
+func (deduper *itemDeduper) dedup(item base.Item) (Emission, error) {
+	key, ok := deduper.key(item)
+	if !ok {
+		return Emit(item), nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	lock := &deduper.locks[h.Sum32()%DEDUP_LOCK_NUMBER]
+	lock.Lock()
+	defer lock.Unlock()
+	old, exists, err := deduper.store.Get(key)
+	if err != nil {
+		return Drop(), err
+	}
+	result := item
+	if exists {
+		switch deduper.strategy {
+		case DEDUP_FIRST_WINS:
+			return Drop(), nil
+		case DEDUP_MERGE:
+			result = mergeItems(old, item)
+		}
+		if sameFields(old, result) {
+			return Drop(), nil
+		}
+	}
+	if err := deduper.store.Put(key, result); err != nil {
+		return Drop(), err
+	}
+	emitted := make(base.Item, len(result)+1)
+	for k, v := range result {
+		emitted[k] = v
+	}
+	emitted[base.ITEM_DEDUP_KEY] = key
+	return Emit(emitted), nil
+}
+
+// 合並兩個項目。新項目中的字段會覆蓋舊項目中的同名字段，中繼資料以新項目為準。
+func mergeItems(old, new base.Item) base.Item {
+	merged := make(base.Item, len(old)+len(new))
+	for k, v := range old {
+		merged[k] = v
+	}
+	for k, v := range new {
+		merged[k] = v
+	}
+	if _, ok := new[base.ITEM_META_KEY]; !ok {
+		delete(merged, base.ITEM_META_KEY)
+	}
+	return merged
+}
+
+// 判斷兩個項目的字段是否相同。中繼資料和去重鍵不在比較之列。
+// 數值會被轉換為字串之後再比較，以免因存放時的型態轉換而誤判。
+func sameFields(a, b base.Item) bool {
+	ignored := func(k string) bool {
+		return k == base.ITEM_META_KEY || k == base.ITEM_DEDUP_KEY
+	}
+	count := 0
+	for k, va := range a {
+		if ignored(k) {
+			continue
+		}
+		count++
+		vb, ok := b[k]
+		if !ok {
+			return false
+		}
+		if !reflect.DeepEqual(va, vb) && fmt.Sprint(va) != fmt.Sprint(vb) {
+			return false
+		}
+	}
+	for k := range b {
+		if !ignored(k) {
+			count--
+		}
+	}
+	return count == 0
+}
@@ -0,0 +1,123 @@
+package itemproc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	base "webcrawler/base"
+)
+
+// 讓項目流經只有去重階段的管線，並收集交給接收端的項目。
+func runDedup(t *testing.T, strategy DedupStrategy, store DedupStore, items []base.Item) []base.Item {
+	dedupStage, err := NewDedupStage("dedup", FieldsKey("sku"), strategy, store)
+	if err != nil {
+		t.Fatalf("ERROR: Dedup stage initialization failing: %s\n", err)
+	}
+	var mutex sync.Mutex
+	var received []base.Item
+	sinkStage := NewStage("sink", func(item base.Item) (Emission, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		received = append(received, item)
+		return Emit(item), nil
+	}, 0)
+	pipeline, err := NewStagedItemPipeline([]Stage{dedupStage, sinkStage})
+	if err != nil {
+		t.Fatalf("ERROR: Staged item pipeline initialization failing: %s\n", err)
+	}
+	for _, item := range items {
+		if errs := pipeline.Send(item); len(errs) > 0 {
+			t.Fatalf("ERROR: Item sending failing: %v\n", errs)
+		}
+	}
+	return received
+}
+
+// 產生用於測試的商品項目。
+func products() []base.Item {
+	return []base.Item{
+		{base.ITEM_KIND_KEY: "product", "sku": "a", "name": "apple", "category": "fruit"},
+		{base.ITEM_KIND_KEY: "product", "sku": "b", "name": "bread"},
+		{base.ITEM_KIND_KEY: "product", "sku": "a", "name": "apple", "category": "fruit"},
+		{base.ITEM_KIND_KEY: "product", "sku": "a", "name": "apple", "price": 3},
+		{base.ITEM_KIND_KEY: "product", "name": "no sku"},
+	}
+}
+
+func TestDedupStrategies(t *testing.T) {
+	received := runDedup(t, DEDUP_FIRST_WINS, nil, products())
+	if len(received) != 3 || received[0]["category"] != "fruit" || received[2]["name"] != "no sku" {
+		t.Errorf("ERROR: The first-wins items %v are wrong!\n", received)
+	}
+	if received[0][base.ITEM_DEDUP_KEY] == nil || received[2][base.ITEM_DEDUP_KEY] != nil {
+		t.Errorf("ERROR: The dedup keys of items %v are wrong!\n", received)
+	}
+	received = runDedup(t, DEDUP_LAST_WINS, nil, products())
+	if len(received) != 4 || received[2]["price"] != 3 || received[2]["category"] != nil {
+		t.Errorf("ERROR: The last-wins items %v are wrong!\n", received)
+	}
+	received = runDedup(t, DEDUP_MERGE, nil, products())
+	if len(received) != 4 || received[2]["price"] != 3 || received[2]["category"] != "fruit" {
+		t.Errorf("ERROR: The merged items %v are wrong!\n", received)
+	}
+	if _, err := NewDedupStage("dedup", nil, DEDUP_MERGE, nil); err == nil {
+		t.Errorf("ERROR: The dedup stage without key function should be invalid!\n")
+	}
+}
+
+func TestDedupKeys(t *testing.T) {
+	item := base.Item{base.ITEM_KIND_KEY: "product", "sku": "a", "shop": 1}
+	other := base.Item{base.ITEM_KIND_KEY: "review", "sku": "a", "shop": 1}
+	for _, keyFunc := range []DedupKeyFunc{FieldsKey("sku", "shop"), HashKey("sku", "shop")} {
+		key1, ok1 := keyFunc(item)
+		key2, ok2 := keyFunc(other)
+		if !ok1 || !ok2 || key1 == key2 {
+			t.Errorf("ERROR: The keys of items with different kinds should be different! (%q, %q)\n", key1, key2)
+		}
+		if _, ok := keyFunc(base.Item{"sku": "a"}); ok {
+			t.Errorf("ERROR: The item without all key fields should not have a key!\n")
+		}
+	}
+	if key, _ := HashKey("sku")(item); len(key) != 40 {
+		t.Errorf("ERROR: The hash key %q should be a SHA-1 hex string!\n", key)
+	}
+}
+
+func TestDiskDedupStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatalf("ERROR: Temp dir creation failing: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dedup.jsonl")
+	store, err := NewDiskDedupStore(path)
+	if err != nil {
+		t.Fatalf("ERROR: Disk dedup store initialization failing: %s\n", err)
+	}
+	received := runDedup(t, DEDUP_MERGE, store, products()[:2])
+	if len(received) != 2 || store.Len() != 2 {
+		t.Errorf("ERROR: The items %v are wrong!\n", received)
+	}
+	store.Close()
+	// 模擬寫入到一半的記錄。
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	file.WriteString(`{"key":"broken`)
+	file.Close()
+
+	store, err = NewDiskDedupStore(path)
+	if err != nil {
+		t.Fatalf("ERROR: Disk dedup store reopening failing: %s\n", err)
+	}
+	defer store.Close()
+	// 重新開啟後，已存放的實體仍被視為重覆，合並後的數值在內容未變化時不會再次產生項目。
+	received = runDedup(t, DEDUP_MERGE, store, products()[2:4])
+	if len(received) != 1 || received[0]["price"] != 3 || received[0]["category"] != "fruit" {
+		t.Errorf("ERROR: The items %v after reopening are wrong!\n", received)
+	}
+	received = runDedup(t, DEDUP_MERGE, store, products()[3:4])
+	if len(received) != 0 || store.Len() != 2 {
+		t.Errorf("ERROR: The unchanged item should be dropped, but got %v!\n", received)
+	}
+}
@@ -3,11 +3,18 @@ package itemproc
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 	base "webcrawler/base"
 )
 
 // 項目處理管線的接口型態。
+// 項目處理管線由主幹和若干分支組成，它們都是階段的序列。
+// 項目會先被驗證，然後依序流經主幹中的各個階段。階段可以丟棄項目、轉換項目、
+// 產生多個項目或者把項目轉送到某個分支。
 type ItemPipeline interface {
 	// 傳送項目。
 	Send(item base.Item) []error
@@ -20,35 +27,118 @@ type ItemPipeline interface {
 	// 獲得已傳送、已接受和已處理的項目的計數值。
 	// 更確切地說，作為結果值的切片總會有三個元素值。這三個值會分別代表前述的三個計數。
 	Count() []uint64
+	// 獲得各階段的計數值。主幹中的階段在前，分支中的階段按分支名稱排序在後。
+	StageCounts() []StageCount
 	// 取得正在被處理的項目的數量。
 	ProcessingNumber() uint64
+	// 設定項目結構註冊表。項目會在被項目處理器處理之前依據它被驗證。
+	SetSchemaRegistry(registry SchemaRegistry)
+	// 設定死信接收器。未通過驗證的項目以及處理失敗的項目會被放入其中。可以為nil。
+	SetDeadLetterSink(sink DeadLetterSink)
+	// 獲得按項目種類區分的計數值。未指定種類的項目的種類為空字串。
+	KindCounts() map[string]KindCount
 	// 取得摘要訊息。
 	Summary() string
 }
 
-// 建立項目處理管線。
+// 某一種類的項目的計數值。
+type KindCount struct {
+	Valid   uint64 // 通過驗證的項目的數量。
+	Invalid uint64 // 未通過驗證的項目的數量。
+}
+
+// 項目被轉送到分支的最大次數。超過時項目會被視為處理失敗，以免分支之間的循環轉送。
+const MAX_ROUTE_HOPS = 8
+
+// 建立項目處理管線。每個項目處理器都會成為主幹中的一個階段。
 func NewItemPipeline(itemProcessors []ProcessItem) ItemPipeline {
 	if itemProcessors == nil {
 		panic(errors.New(fmt.Sprintln("Invalid item processor list!")))
 	}
-	innerItemProcessors := make([]ProcessItem, 0)
+	stages := make([]Stage, 0, len(itemProcessors))
 	for i, ip := range itemProcessors {
 		if ip == nil {
 			panic(errors.New(fmt.Sprintf("Invalid item processor[%d]!\n", i)))
 		}
-		innerItemProcessors = append(innerItemProcessors, ip)
+		stages = append(stages, NewProcessorStage(fmt.Sprintf("processor-%d", i), ip))
+	}
+	pipeline, err := NewStagedItemPipeline(stages)
+	if err != nil {
+		panic(err)
+	}
+	return pipeline
+}
+
+// 建立由階段組成的項目處理管線。參數stages代表主幹，參數branches代表分支。
+// 同一序列中的階段的名稱不能重覆，分支的名稱也不能重覆。
+func NewStagedItemPipeline(stages []Stage, branches ...Branch) (ItemPipeline, error) {
+	ip := &myItemPipeline{branches: make(map[string][]*runningStage)}
+	var err error
+	if ip.stages, err = newRunningStages("", stages); err != nil {
+		return nil, err
+	}
+	for _, branch := range branches {
+		if branch.name == "" {
+			return nil, errors.New("The branch name can not be empty!\n")
+		}
+		if _, ok := ip.branches[branch.name]; ok {
+			errMsg := fmt.Sprintf("Duplicate branch %s!\n", branch.name)
+			return nil, errors.New(errMsg)
+		}
+		if len(branch.stages) == 0 {
+			errMsg := fmt.Sprintf("The branch %s has no stage!\n", branch.name)
+			return nil, errors.New(errMsg)
+		}
+		if ip.branches[branch.name], err = newRunningStages(branch.name, branch.stages); err != nil {
+			return nil, err
+		}
+		ip.branchNames = append(ip.branchNames, branch.name)
 	}
-	return &myItemPipeline{itemProcessors: innerItemProcessors}
+	sort.Strings(ip.branchNames)
+	return ip, nil
+}
+
+// 建立執行中的階段的序列。參數branch代表分支的名稱，主幹的名稱為空字串。
+func newRunningStages(branch string, stages []Stage) ([]*runningStage, error) {
+	names := make(map[string]bool)
+	runningStages := make([]*runningStage, 0, len(stages))
+	for i, stage := range stages {
+		fullName := stage.name
+		if branch != "" {
+			fullName = branch + "/" + stage.name
+		}
+		if stage.name == "" {
+			errMsg := fmt.Sprintf("The name of stage[%d] is empty! (branch=%s)\n", i, branch)
+			return nil, errors.New(errMsg)
+		}
+		if names[stage.name] {
+			errMsg := fmt.Sprintf("Duplicate stage %s!\n", fullName)
+			return nil, errors.New(errMsg)
+		}
+		if stage.fn == nil {
+			errMsg := fmt.Sprintf("Invalid function of stage %s!\n", fullName)
+			return nil, errors.New(errMsg)
+		}
+		names[stage.name] = true
+		runningStages = append(runningStages, newRunningStage(stage, fullName))
+	}
+	return runningStages, nil
 }
 
 // 項目處理管線的實現型態。
 type myItemPipeline struct {
-	itemProcessors   []ProcessItem // 項目處理器的清單。
-	failFast         bool          // 表示處理是否需要快速失敗的標志位。
-	sent             uint64        // 已被傳送的項目的數量。
-	accepted         uint64        // 已被接受的項目的數量。
-	processed        uint64        // 已被處理的項目的數量。
-	processingNumber uint64        // 正在被處理的項目的數量。
+	stages           []*runningStage            // 主幹中的階段。
+	branches         map[string][]*runningStage // 分支名稱與分支中的階段的對應。
+	branchNames      []string                   // 已排序的分支名稱。
+	failFast         bool                       // 表示處理是否需要快速失敗的標志位。
+	sent             uint64                     // 已被傳送的項目的數量。
+	accepted         uint64                     // 已被接受的項目的數量。
+	processed        uint64                     // 已被處理的項目的數量。
+	processingNumber uint64                     // 正在被處理的項目的數量。
+	registry         SchemaRegistry             // 項目結構註冊表。
+	deadLetterSink   DeadLetterSink             // 死信接收器。
+	kindCounts       map[string]KindCount       // 按項目種類區分的計數值。
+	kindMutex        sync.Mutex                 // 計數值的互斥鎖。
 }
 
 func (ip *myItemPipeline) Send(item base.Item) []error {
@@ -61,23 +151,142 @@ func (ip *myItemPipeline) Send(item base.Item) []error {
 		return errs
 	}
 	atomic.AddUint64(&ip.accepted, 1)
-	var currentItem base.Item = item
-	for _, itemProcessor := range ip.itemProcessors {
-		processedItem, err := itemProcessor(currentItem)
+	if err := ip.validate(item); err != nil {
+		errs = append(errs, err)
+		return errs
+	}
+	errs = ip.flow(ip.stages, 0, item, 0, errs)
+	atomic.AddUint64(&ip.processed, 1)
+	return errs
+}
+
+// 讓項目從指定的位置開始流經階段的序列。參數hops代表項目已被轉送到分支的次數。
+// 處理過程中產生的錯誤會被追加到參數errs中並傳回。
+func (ip *myItemPipeline) flow(
+	stages []*runningStage, start int, item base.Item, hops int, errs []error) []error {
+	for i := start; i < len(stages); i++ {
+		rs := stages[i]
+		emission, err := rs.process(item)
+		items := make([]base.Item, 0, len(emission.Items))
+		for _, emitted := range emission.Items {
+			if emitted != nil {
+				items = append(items, emitted)
+			}
+		}
 		if err != nil {
+			atomic.AddUint64(&rs.failed, 1)
 			errs = append(errs, err)
-			if ip.failFast {
-				break
+			if ip.failFast || len(items) == 0 {
+				return ip.deadLetter(item, rs.fullName, err, errs)
 			}
 		}
-		if processedItem != nil {
-			currentItem = processedItem
+		if len(items) == 0 {
+			atomic.AddUint64(&rs.dropped, 1)
+			return errs
 		}
+		if emission.Branch != "" {
+			branch, ok := ip.branches[emission.Branch]
+			if !ok || hops >= MAX_ROUTE_HOPS {
+				atomic.AddUint64(&rs.failed, 1)
+				var errMsg string
+				if !ok {
+					errMsg = fmt.Sprintf("Unknown branch %s! (stage=%s)\n", emission.Branch, rs.fullName)
+				} else {
+					errMsg = fmt.Sprintf("Too many routes to branch %s! (stage=%s)\n", emission.Branch, rs.fullName)
+				}
+				err := errors.New(errMsg)
+				return ip.deadLetter(item, rs.fullName, err, append(errs, err))
+			}
+			atomic.AddUint64(&rs.routed, uint64(len(items)))
+			for _, routed := range items {
+				errs = ip.flow(branch, 0, routed, hops+1, errs)
+			}
+			return errs
+		}
+		atomic.AddUint64(&rs.emitted, uint64(len(items)))
+		if len(items) > 1 {
+			for _, emitted := range items {
+				errs = ip.flow(stages, i+1, emitted, hops, errs)
+			}
+			return errs
+		}
+		item = items[0]
 	}
-	atomic.AddUint64(&ip.processed, 1)
 	return errs
 }
 
+// 把處理失敗的項目放入死信接收器。放入失敗時產生的錯誤會被追加到參數errs中並傳回。
+func (ip *myItemPipeline) deadLetter(item base.Item, stage string, cause error, errs []error) []error {
+	if err := ip.putDeadLetter(item, stage, cause); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// 放入死信。未設定死信接收器時不做任何事。
+func (ip *myItemPipeline) putDeadLetter(item base.Item, stage string, cause error) error {
+	if ip.deadLetterSink == nil {
+		return nil
+	}
+	letter := DeadLetter{
+		Item:   item,
+		Kind:   item.Kind(),
+		Stage:  stage,
+		Reason: strings.TrimRight(cause.Error(), "\n"),
+		Time:   time.Now(),
+	}
+	if err := ip.deadLetterSink.Put(letter); err != nil {
+		errMsg := fmt.Sprintf("Dead letter putting failing: %s (reason=%s)\n", err, letter.Reason)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// 驗證項目並更新計數值。未通過驗證的項目會被放入死信接收器。
+func (ip *myItemPipeline) validate(item base.Item) error {
+	var err error
+	if ip.registry != nil {
+		err = ip.registry.Validate(item)
+	}
+	kind := item.Kind()
+	ip.kindMutex.Lock()
+	if ip.kindCounts == nil {
+		ip.kindCounts = make(map[string]KindCount)
+	}
+	count := ip.kindCounts[kind]
+	if err == nil {
+		count.Valid++
+	} else {
+		count.Invalid++
+	}
+	ip.kindCounts[kind] = count
+	ip.kindMutex.Unlock()
+	if err != nil {
+		if sinkErr := ip.putDeadLetter(item, "", err); sinkErr != nil {
+			return sinkErr
+		}
+	}
+	return err
+}
+
+func (ip *myItemPipeline) SetSchemaRegistry(registry SchemaRegistry) {
+	ip.registry = registry
+}
+
+func (ip *myItemPipeline) SetDeadLetterSink(sink DeadLetterSink) {
+	ip.deadLetterSink = sink
+}
+
+func (ip *myItemPipeline) KindCounts() map[string]KindCount {
+	ip.kindMutex.Lock()
+	defer ip.kindMutex.Unlock()
+	counts := make(map[string]KindCount, len(ip.kindCounts))
+	for kind, count := range ip.kindCounts {
+		counts[kind] = count
+	}
+	return counts
+}
+
 func (ip *myItemPipeline) FailFast() bool {
 	return ip.failFast
 }
@@ -94,17 +303,54 @@ func (ip *myItemPipeline) Count() []uint64 {
 	return counts
 }
 
+func (ip *myItemPipeline) StageCounts() []StageCount {
+	counts := make([]StageCount, 0, len(ip.stages))
+	for _, rs := range ip.stages {
+		counts = append(counts, rs.count())
+	}
+	for _, name := range ip.branchNames {
+		for _, rs := range ip.branches[name] {
+			counts = append(counts, rs.count())
+		}
+	}
+	return counts
+}
+
 func (ip *myItemPipeline) ProcessingNumber() uint64 {
 	return atomic.LoadUint64(&ip.processingNumber)
 }
 
-var summaryTemplate = "failFast: %v, processorNumber: %d," +
-	" sent: %d, accepted: %d, processed: %d, processingNumber: %d"
+var summaryTemplate = "failFast: %v, processingNumber: %d," +
+	" stages: [%s], kinds: [%s]"
 
 func (ip *myItemPipeline) Summary() string {
-	counts := ip.Count()
+	stageCounts := ip.StageCounts()
+	parts := make([]string, 0, len(stageCounts))
+	for _, count := range stageCounts {
+		parts = append(parts, count.String())
+	}
 	summary := fmt.Sprintf(summaryTemplate,
-		ip.failFast, len(ip.itemProcessors),
-		counts[0], counts[1], counts[2], ip.ProcessingNumber())
+		ip.failFast, ip.ProcessingNumber(),
+		strings.Join(parts, "; "),
+		kindCountsSummary(ip.KindCounts()))
 	return summary
 }
+
+// 產生按項目種類區分的計數值的摘要訊息。
+func kindCountsSummary(kindCounts map[string]KindCount) string {
+	kinds := make([]string, 0, len(kindCounts))
+	for kind := range kindCounts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		name := kind
+		if name == "" {
+			name = "<none>"
+		}
+		count := kindCounts[kind]
+		parts = append(parts, fmt.Sprintf("%s: %d valid, %d invalid", name, count.Valid, count.Invalid))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,201 @@
+package itemproc
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	base "webcrawler/base"
+)
+
+func TestStagedPipeline(t *testing.T) {
+	var mutex sync.Mutex
+	stored := make(map[string][]string)
+	store := func(name string) StageFunc {
+		return func(item base.Item) (Emission, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			stored[name] = append(stored[name], item["text"].(string))
+			return Emit(item), nil
+		}
+	}
+	stages := []Stage{
+		// 丟棄空白的項目。
+		NewStage("filter", func(item base.Item) (Emission, error) {
+			if strings.TrimSpace(item["text"].(string)) == "" {
+				return Drop(), nil
+			}
+			return Emit(item), nil
+		}, 0),
+		// 把以逗號分隔的文字拆分成多個項目。
+		NewStage("split", func(item base.Item) (Emission, error) {
+			var items []base.Item
+			for _, part := range strings.Split(item["text"].(string), ",") {
+				items = append(items, base.Item{"text": part})
+			}
+			return Emit(items...), nil
+		}, 0),
+		// 把連結轉送到分支。
+		NewStage("route", func(item base.Item) (Emission, error) {
+			if strings.HasPrefix(item["text"].(string), "http") {
+				return Route("links", item), nil
+			}
+			if item["text"] == "bad" {
+				return Drop(), errors.New("bad item")
+			}
+			return Emit(item), nil
+		}, 0),
+		NewStage("store", store("main"), 0),
+	}
+	pipeline, err := NewStagedItemPipeline(stages, NewBranch("links", NewStage("store", store("links"), 0)))
+	if err != nil {
+		t.Fatalf("ERROR: Staged item pipeline initialization failing: %s\n", err)
+	}
+	sink, _ := NewMemoryDeadLetterSink(10)
+	pipeline.SetDeadLetterSink(sink)
+	errCount := 0
+	for _, text := range []string{"a,b", " ", "http://x,c", "bad"} {
+		errCount += len(pipeline.Send(base.Item{"text": text}))
+	}
+	if strings.Join(stored["main"], ",") != "a,b,c" || strings.Join(stored["links"], ",") != "http://x" {
+		t.Errorf("ERROR: The stored items %v are wrong!\n", stored)
+	}
+	if errCount != 1 {
+		t.Errorf("ERROR: The number of errors is %d, but should be %d!\n", errCount, 1)
+	}
+	if letters := sink.Letters(); len(letters) != 1 || letters[0].Stage != "route" || letters[0].Reason != "bad item" {
+		t.Errorf("ERROR: The dead letters %v are wrong!\n", letters)
+	}
+	expected := []StageCount{
+		{Name: "filter", Received: 4, Emitted: 3, Dropped: 1},
+		{Name: "split", Received: 3, Emitted: 5},
+		{Name: "route", Received: 5, Emitted: 3, Routed: 1, Failed: 1},
+		{Name: "store", Received: 3, Emitted: 3},
+		{Name: "links/store", Received: 1, Emitted: 1},
+	}
+	counts := pipeline.StageCounts()
+	if len(counts) != len(expected) {
+		t.Fatalf("ERROR: The stage counts %v are wrong!\n", counts)
+	}
+	for i, count := range counts {
+		if count != expected[i] {
+			t.Errorf("ERROR: The stage count is %v, but should be %v!\n", count, expected[i])
+		}
+	}
+	if summary := pipeline.Summary(); !strings.Contains(summary, "links/store: in 1, out 1") {
+		t.Errorf("ERROR: The summary %q does not contain the stage counts!\n", summary)
+	}
+}
+
+func TestStagedPipelineRouting(t *testing.T) {
+	loop := NewStage("loop", func(item base.Item) (Emission, error) {
+		return Route("loop", item), nil
+	}, 0)
+	pipeline, err := NewStagedItemPipeline([]Stage{loop}, NewBranch("loop", loop))
+	if err != nil {
+		t.Fatalf("ERROR: Staged item pipeline initialization failing: %s\n", err)
+	}
+	if errs := pipeline.Send(base.Item{}); len(errs) != 1 || !strings.Contains(errs[0].Error(), "Too many routes") {
+		t.Errorf("ERROR: The endless routing should fail, but the errors are %v!\n", errs)
+	}
+	unknown := NewStage("unknown", func(item base.Item) (Emission, error) {
+		return Route("nowhere", item), nil
+	}, 0)
+	pipeline, _ = NewStagedItemPipeline([]Stage{unknown})
+	if errs := pipeline.Send(base.Item{}); len(errs) != 1 {
+		t.Errorf("ERROR: The routing to unknown branch should fail!\n")
+	}
+	if _, err := NewStagedItemPipeline([]Stage{loop, loop}); err == nil {
+		t.Errorf("ERROR: The pipeline with duplicate stages should be invalid!\n")
+	}
+	if _, err := NewStagedItemPipeline(nil, NewBranch("empty")); err == nil {
+		t.Errorf("ERROR: The pipeline with empty branch should be invalid!\n")
+	}
+}
+
+func TestProcessorFailFast(t *testing.T) {
+	calls := 0
+	failing := func(item base.Item) (base.Item, error) {
+		calls++
+		return nil, errors.New("failing")
+	}
+	counting := func(item base.Item) (base.Item, error) {
+		calls++
+		return item, nil
+	}
+	pipeline := NewItemPipeline([]ProcessItem{failing, counting})
+	if errs := pipeline.Send(base.Item{}); len(errs) != 1 || calls != 2 {
+		t.Errorf("ERROR: The item should pass the rest processors when not failing fast!\n")
+	}
+	pipeline.SetFailFast(true)
+	calls = 0
+	if errs := pipeline.Send(base.Item{}); len(errs) != 1 || calls != 1 {
+		t.Errorf("ERROR: The item should not pass the rest processors when failing fast!\n")
+	}
+	if counts := pipeline.Count(); counts[0] != 2 || counts[2] != 2 {
+		t.Errorf("ERROR: The counts %v are wrong!\n", counts)
+	}
+}
+
+func TestPipelineItemMeta(t *testing.T) {
+	var seen []base.Item
+	failing := func(item base.Item) (base.Item, error) {
+		seen = append(seen, item)
+		return nil, errors.New("failing")
+	}
+	pipeline := NewItemPipeline([]ProcessItem{failing})
+	pipeline.SetFailFast(true)
+	sink, _ := NewMemoryDeadLetterSink(1)
+	pipeline.SetDeadLetterSink(sink)
+	// 中繼資料不在項目結構的驗證之列。
+	schema, err := NewItemSchema("article",
+		NewField("title", FIELD_TYPE_STRING, true),
+		NewField(base.ITEM_META_KEY, FIELD_TYPE_STRING, true))
+	if err != nil {
+		t.Fatalf("ERROR: Item schema initialization failing: %s\n", err)
+	}
+	registry := NewSchemaRegistry()
+	registry.Register(schema)
+	pipeline.SetSchemaRegistry(registry)
+	meta := base.NewMeta(map[string]interface{}{base.META_KEY_PAGE_URL: "http://a/p"})
+	pipeline.Send(base.Item{base.ITEM_KIND_KEY: "article", base.ITEM_META_KEY: meta, "title": "go"})
+	// 項目處理器和死信接收器收到的項目都帶有中繼資料。
+	if len(seen) != 1 || seen[0].Meta().GetString(base.META_KEY_PAGE_URL) != "http://a/p" {
+		t.Errorf("ERROR: The processed items %v are wrong!\n", seen)
+	}
+	letters := sink.Letters()
+	if len(letters) != 1 || letters[0].Item.Meta().GetString(base.META_KEY_PAGE_URL) != "http://a/p" {
+		t.Errorf("ERROR: The dead letters %v are wrong!\n", letters)
+	}
+}
+
+func TestStageConcurrency(t *testing.T) {
+	var current, max int32
+	slow := NewStage("slow", func(item base.Item) (Emission, error) {
+		n := atomic.AddInt32(&current, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&current, -1)
+		return Emit(item), nil
+	}, 2)
+	pipeline, _ := NewStagedItemPipeline([]Stage{slow})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pipeline.Send(base.Item{})
+		}()
+	}
+	wg.Wait()
+	if max > 2 {
+		t.Errorf("ERROR: The max concurrency of stage is %d, but should not be greater than %d!\n", max, 2)
+	}
+}
@@ -0,0 +1,332 @@
+package itemproc
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	base "webcrawler/base"
+)
+
+// 字段的型態。
+type FieldType byte
+
+// 字段型態的常數。
+const (
+	FIELD_TYPE_ANY    FieldType = iota // 任意型態。
+	FIELD_TYPE_STRING                  // 字串。
+	FIELD_TYPE_INT                     // 整數，包括無號整數。
+	FIELD_TYPE_FLOAT                   // 浮點數。整數也被接受。
+	FIELD_TYPE_BOOL                    // 布爾值。
+	FIELD_TYPE_LIST                    // 切片或陣列。
+	FIELD_TYPE_MAP                     // 字典。
+)
+
+// 字段型態的名稱。
+var fieldTypeNames = map[FieldType]string{
+	FIELD_TYPE_ANY:    "any",
+	FIELD_TYPE_STRING: "string",
+	FIELD_TYPE_INT:    "int",
+	FIELD_TYPE_FLOAT:  "float",
+	FIELD_TYPE_BOOL:   "bool",
+	FIELD_TYPE_LIST:   "list",
+	FIELD_TYPE_MAP:    "map",
+}
+
+func (fieldType FieldType) String() string {
+	if name, ok := fieldTypeNames[fieldType]; ok {
+		return name
+	}
+	return fmt.Sprintf("type(%d)", byte(fieldType))
+}
+
+// 判斷值是否符合字段型態。
+func (fieldType FieldType) Match(value interface{}) bool {
+	if fieldType == FIELD_TYPE_ANY {
+		return true
+	}
+	if value == nil {
+		return false
+	}
+	kind := reflect.TypeOf(value).Kind()
+	switch fieldType {
+	case FIELD_TYPE_STRING:
+		return kind == reflect.String
+	case FIELD_TYPE_INT:
+		return isIntKind(kind)
+	case FIELD_TYPE_FLOAT:
+		return kind == reflect.Float32 || kind == reflect.Float64 || isIntKind(kind)
+	case FIELD_TYPE_BOOL:
+		return kind == reflect.Bool
+	case FIELD_TYPE_LIST:
+		return kind == reflect.Slice || kind == reflect.Array
+	case FIELD_TYPE_MAP:
+		return kind == reflect.Map
+	}
+	return false
+}
+
+// 判斷是否為整數的種類。
+func isIntKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// 字段值驗證函數的型態。值不合法時傳回說明原因的錯誤。
+type Validator func(value interface{}) error
+
+// 字段的定義。
+type Field struct {
+	name       string      // 字段的名稱。
+	fieldType  FieldType   // 字段的型態。
+	required   bool        // 是否為必要字段。
+	validators []Validator // 字段值驗證函數的列表。
+}
+
+// 建立字段的定義。
+func NewField(name string, fieldType FieldType, required bool, validators ...Validator) Field {
+	return Field{
+		name:       name,
+		fieldType:  fieldType,
+		required:   required,
+		validators: validators,
+	}
+}
+
+// 獲得字段的名稱。
+func (field Field) Name() string {
+	return field.name
+}
+
+// 獲得字段的型態。
+func (field Field) Type() FieldType {
+	return field.fieldType
+}
+
+// 判斷是否為必要字段。
+func (field Field) Required() bool {
+	return field.required
+}
+
+// 驗證錯誤。
+type ValidationError struct {
+	Kind   string // 項目的種類。
+	Field  string // 不合法的字段的名稱。
+	Reason string // 不合法的原因。
+}
+
+func (ve ValidationError) Error() string {
+	return fmt.Sprintf("Invalid item (kind=%s, field=%s): %s\n", ve.Kind, ve.Field, ve.Reason)
+}
+
+// 項目結構的接口型態。
+type ItemSchema interface {
+	// 獲得項目的種類。
+	Kind() string
+	// 獲得字段定義的列表。
+	Fields() []Field
+	// 驗證項目。項目不合法時傳回 ValidationError 型態的錯誤。
+	// 未被定義的字段會被忽略。
+	Validate(item base.Item) error
+}
+
+// 建立項目結構。
+func NewItemSchema(kind string, fields ...Field) (ItemSchema, error) {
+	if kind == "" {
+		return nil, errors.New("The item kind can not be empty!\n")
+	}
+	names := make(map[string]bool)
+	for i, field := range fields {
+		if field.name == "" {
+			errMsg := fmt.Sprintf("The name of field[%d] is empty! (kind=%s)\n", i, kind)
+			return nil, errors.New(errMsg)
+		}
+		if names[field.name] {
+			errMsg := fmt.Sprintf("Duplicate field %s! (kind=%s)\n", field.name, kind)
+			return nil, errors.New(errMsg)
+		}
+		for j, validator := range field.validators {
+			if validator == nil {
+				errMsg := fmt.Sprintf("Invalid validator[%d] of field %s! (kind=%s)\n", j, field.name, kind)
+				return nil, errors.New(errMsg)
+			}
+		}
+		names[field.name] = true
+	}
+	return &myItemSchema{kind: kind, fields: fields}, nil
+}
+
+// 項目結構的實現型態。
+type myItemSchema struct {
+	kind   string  // 項目的種類。
+	fields []Field // 字段定義的列表。
+}
+
+func (schema *myItemSchema) Kind() string {
+	return schema.kind
+}
+
+func (schema *myItemSchema) Fields() []Field {
+	fields := make([]Field, len(schema.fields))
+	copy(fields, schema.fields)
+	return fields
+}
+
+func (schema *myItemSchema) Validate(item base.Item) error {
+	for _, field := range schema.fields {
+		// 中繼資料由分析器附加在項目上，它不屬於項目的字段。
+		if field.name == base.ITEM_META_KEY {
+			continue
+		}
+		value, ok := item[field.name]
+		if !ok {
+			if field.required {
+				return ValidationError{Kind: schema.kind, Field: field.name, Reason: "missing required field"}
+			}
+			continue
+		}
+		if !field.fieldType.Match(value) {
+			reason := fmt.Sprintf("type %T is not %s", value, field.fieldType)
+			return ValidationError{Kind: schema.kind, Field: field.name, Reason: reason}
+		}
+		for _, validator := range field.validators {
+			if err := validator(value); err != nil {
+				reason := strings.TrimRight(err.Error(), "\n")
+				return ValidationError{Kind: schema.kind, Field: field.name, Reason: reason}
+			}
+		}
+	}
+	return nil
+}
+
+// 項目結構註冊表的接口型態。項目結構按項目的種類被註冊。
+type SchemaRegistry interface {
+	// 註冊項目結構。同一種類的項目結構只能被註冊一次。
+	Register(schema ItemSchema) error
+	// 取得與項目種類對應的項目結構。
+	Get(kind string) (ItemSchema, bool)
+	// 獲得已排序的項目種類的列表。
+	Kinds() []string
+	// 驗證項目。沒有對應的項目結構的項目總是合法的。
+	Validate(item base.Item) error
+}
+
+// 建立項目結構註冊表。
+func NewSchemaRegistry() SchemaRegistry {
+	return &mySchemaRegistry{schemas: make(map[string]ItemSchema)}
+}
+
+// 項目結構註冊表的實現型態。
+type mySchemaRegistry struct {
+	schemas map[string]ItemSchema // 項目種類與項目結構的對應。
+	rwmutex sync.RWMutex          // 讀寫鎖。
+}
+
+func (registry *mySchemaRegistry) Register(schema ItemSchema) error {
+	if schema == nil {
+		return errors.New("The item schema is invalid!\n")
+	}
+	registry.rwmutex.Lock()
+	defer registry.rwmutex.Unlock()
+	if _, ok := registry.schemas[schema.Kind()]; ok {
+		errMsg := fmt.Sprintf("The schema of kind %s has been registered!\n", schema.Kind())
+		return errors.New(errMsg)
+	}
+	registry.schemas[schema.Kind()] = schema
+	return nil
+}
+
+func (registry *mySchemaRegistry) Get(kind string) (ItemSchema, bool) {
+	registry.rwmutex.RLock()
+	defer registry.rwmutex.RUnlock()
+	schema, ok := registry.schemas[kind]
+	return schema, ok
+}
+
+func (registry *mySchemaRegistry) Kinds() []string {
+	registry.rwmutex.RLock()
+	defer registry.rwmutex.RUnlock()
+	kinds := make([]string, 0, len(registry.schemas))
+	for kind := range registry.schemas {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func (registry *mySchemaRegistry) Validate(item base.Item) error {
+	schema, ok := registry.Get(item.Kind())
+	if !ok {
+		return nil
+	}
+	return schema.Validate(item)
+}
+
+// 建立檢查值不為空的驗證函數。它適用於字串、切片、陣列和字典。
+func NotEmpty() Validator {
+	return func(value interface{}) error {
+		v := reflect.ValueOf(value)
+		switch v.Kind() {
+		case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+			if v.Len() == 0 {
+				return errors.New("value is empty")
+			}
+		}
+		return nil
+	}
+}
+
+// 建立檢查字串的長度不大於參數max的驗證函數。
+func MaxLength(max int) Validator {
+	return func(value interface{}) error {
+		s, ok := value.(string)
+		if ok && len(s) > max {
+			return errors.New(fmt.Sprintf("length %d is greater than %d", len(s), max))
+		}
+		return nil
+	}
+}
+
+// 建立檢查字串符合正則表達式的驗證函數。
+func MatchPattern(expr string) (Validator, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return func(value interface{}) error {
+		s, ok := value.(string)
+		if ok && !re.MatchString(s) {
+			return errors.New(fmt.Sprintf("%q does not match %s", s, expr))
+		}
+		return nil
+	}, nil
+}
+
+// 建立檢查數值位於閉區間[min, max]內的驗證函數。
+func InRange(min, max float64) Validator {
+	return func(value interface{}) error {
+		v := reflect.ValueOf(value)
+		var f float64
+		switch {
+		case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+			f = v.Float()
+		case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+			f = float64(v.Int())
+		case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64:
+			f = float64(v.Uint())
+		default:
+			return nil
+		}
+		if f < min || f > max {
+			return errors.New(fmt.Sprintf("%v is out of range [%v, %v]", value, min, max))
+		}
+		return nil
+	}
+}
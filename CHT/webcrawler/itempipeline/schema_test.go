@@ -0,0 +1,116 @@
+package itemproc
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	base "webcrawler/base"
+)
+
+// 建立用於測試的項目結構註冊表。
+func newTestRegistry(t *testing.T) SchemaRegistry {
+	pattern, err := MatchPattern(`^https?://`)
+	if err != nil {
+		t.Fatalf("ERROR: Pattern validator initialization failing: %s\n", err)
+	}
+	schema, err := NewItemSchema("article",
+		NewField("title", FIELD_TYPE_STRING, true, NotEmpty(), MaxLength(10)),
+		NewField("url", FIELD_TYPE_STRING, true, pattern),
+		NewField("score", FIELD_TYPE_FLOAT, false, InRange(0, 1)),
+		NewField("tags", FIELD_TYPE_LIST, false))
+	if err != nil {
+		t.Fatalf("ERROR: Item schema initialization failing: %s\n", err)
+	}
+	registry := NewSchemaRegistry()
+	if err := registry.Register(schema); err != nil {
+		t.Fatalf("ERROR: Item schema registration failing: %s\n", err)
+	}
+	if err := registry.Register(schema); err == nil {
+		t.Errorf("ERROR: The duplicate registration should fail!\n")
+	}
+	return registry
+}
+
+func TestSchemaValidate(t *testing.T) {
+	registry := newTestRegistry(t)
+	valid := base.Item{base.ITEM_KIND_KEY: "article", "title": "go", "url": "http://a", "score": 1, "extra": true}
+	if err := registry.Validate(valid); err != nil {
+		t.Errorf("ERROR: The item %v should be valid: %s\n", valid, err)
+	}
+	if err := registry.Validate(base.Item{"title": 1}); err != nil {
+		t.Errorf("ERROR: The item without schema should be valid: %s\n", err)
+	}
+	cases := map[string]base.Item{
+		"title": {"url": "http://a"},
+		"url":   {"title": "go", "url": "ftp://a"},
+		"score": {"title": "go", "url": "http://a", "score": 1.5},
+		"tags":  {"title": "go", "url": "http://a", "tags": "x"},
+	}
+	for field, item := range cases {
+		item[base.ITEM_KIND_KEY] = "article"
+		err := registry.Validate(item)
+		if ve, ok := err.(ValidationError); !ok || ve.Field != field || ve.Kind != "article" {
+			t.Errorf("ERROR: The item %v should be invalid on field %s, but the error is %v!\n", item, field, err)
+		}
+	}
+	if _, err := NewItemSchema("dup", NewField("a", FIELD_TYPE_ANY, false), NewField("a", FIELD_TYPE_ANY, false)); err == nil {
+		t.Errorf("ERROR: The schema with duplicate fields should be invalid!\n")
+	}
+	if _, err := NewItemSchema(""); err == nil {
+		t.Errorf("ERROR: The schema without kind should be invalid!\n")
+	}
+}
+
+func TestPipelineDeadLetter(t *testing.T) {
+	processed := 0
+	pipeline := NewItemPipeline([]ProcessItem{func(item base.Item) (base.Item, error) {
+		processed++
+		return item, nil
+	}})
+	sink, _ := NewMemoryDeadLetterSink(1)
+	pipeline.SetSchemaRegistry(newTestRegistry(t))
+	pipeline.SetDeadLetterSink(sink)
+	items := []base.Item{
+		{base.ITEM_KIND_KEY: "article", "title": "go", "url": "http://a"},
+		{base.ITEM_KIND_KEY: "article", "title": ""},
+		{base.ITEM_KIND_KEY: "article", "title": "go"},
+		{"text": "plain"},
+	}
+	errCount := 0
+	for _, item := range items {
+		errCount += len(pipeline.Send(item))
+	}
+	if processed != 2 || errCount != 2 {
+		t.Errorf("ERROR: %d items are processed with %d errors, but should be 2 and 2!\n", processed, errCount)
+	}
+	counts := pipeline.KindCounts()
+	if counts["article"] != (KindCount{Valid: 1, Invalid: 2}) || counts[""] != (KindCount{Valid: 1}) {
+		t.Errorf("ERROR: The kind counts %v are wrong!\n", counts)
+	}
+	letters := sink.Letters()
+	if len(letters) != 1 || sink.Dropped() != 1 || !strings.Contains(letters[0].Reason, "url") {
+		t.Errorf("ERROR: The dead letters %v are wrong!\n", letters)
+	}
+	if summary := pipeline.Summary(); !strings.Contains(summary, "<none>: 1 valid, 0 invalid; article: 1 valid, 2 invalid") {
+		t.Errorf("ERROR: The summary %q does not contain the kind counts!\n", summary)
+	}
+}
+
+func TestJSONDeadLetterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewJSONDeadLetterSink(&buf)
+	meta := base.NewMeta(map[string]interface{}{base.META_KEY_PAGE_URL: "http://a/p"})
+	item := base.Item{base.ITEM_META_KEY: meta, "title": "go", "ch": make(chan int)}
+	if err := sink.Put(DeadLetter{Item: item, Kind: "article", Reason: "bad"}); err != nil {
+		t.Fatalf("ERROR: Dead letter putting failing: %s\n", err)
+	}
+	var letter map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &letter); err != nil {
+		t.Fatalf("ERROR: The dead letter %q is not valid JSON: %s\n", buf.String(), err)
+	}
+	fields := letter["item"].(map[string]interface{})
+	if letter["page_url"] != "http://a/p" || fields["title"] != "go" || fields[base.ITEM_META_KEY] != nil {
+		t.Errorf("ERROR: The dead letter %v is wrong!\n", letter)
+	}
+}
@@ -0,0 +1,151 @@
+package itemproc
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	base "webcrawler/base"
+)
+
+// 階段的產出。
+type Emission struct {
+	Items  []base.Item // 產生的項目。為空時表示項目被丟棄。
+	Branch string      // 分支的名稱。為空時表示把項目交給下一個階段。
+}
+
+// 傳回表示丟棄項目的產出。
+func Drop() Emission {
+	return Emission{}
+}
+
+// 傳回把項目交給下一個階段的產出。
+func Emit(items ...base.Item) Emission {
+	return Emission{Items: items}
+}
+
+// 傳回把項目轉送到指定分支的產出。
+func Route(branch string, items ...base.Item) Emission {
+	return Emission{Items: items, Branch: branch}
+}
+
+// 階段處理函數的型態。
+// 傳回錯誤時，若管線是快速失敗的或者產出中沒有項目，則原項目會被放入死信接收器，
+// 否則產出的項目會繼續被處理。
+type StageFunc func(item base.Item) (Emission, error)
+
+// 項目處理管線中的階段。
+type Stage struct {
+	name        string    // 階段的名稱。
+	fn          StageFunc // 階段處理函數。
+	concurrency uint32    // 並行處理的上限。0代表不限制。
+}
+
+// 建立階段。參數concurrency代表該階段同時處理的項目的數量上限，0代表不限制。
+func NewStage(name string, fn StageFunc, concurrency uint32) Stage {
+	return Stage{name: name, fn: fn, concurrency: concurrency}
+}
+
+// 依據項目處理器建立階段。處理器傳回的項目為nil時，原項目會被交給下一個階段。
+func NewProcessorStage(name string, processor ProcessItem) Stage {
+	var fn StageFunc
+	if processor != nil {
+		fn = func(item base.Item) (Emission, error) {
+			result, err := processor(item)
+			if result == nil {
+				result = item
+			}
+			return Emit(result), err
+		}
+	}
+	return NewStage(name, fn, 0)
+}
+
+// 獲得階段的名稱。
+func (stage Stage) Name() string {
+	return stage.name
+}
+
+// 獲得並行處理的上限。
+func (stage Stage) Concurrency() uint32 {
+	return stage.concurrency
+}
+
+// 分支。它是一個具名的階段序列，項目可以被階段轉送到分支的起點。
+// 走完分支的項目不會再回到主幹。
+type Branch struct {
+	name   string  // 分支的名稱。
+	stages []Stage // 階段的序列。
+}
+
+// 建立分支。
+func NewBranch(name string, stages ...Stage) Branch {
+	return Branch{name: name, stages: stages}
+}
+
+// 獲得分支的名稱。
+func (branch Branch) Name() string {
+	return branch.name
+}
+
+// 某一階段的計數值。
+type StageCount struct {
+	Name     string // 階段的名稱。分支中的階段會以「分支名稱/階段名稱」的形式表示。
+	Received uint64 // 收到的項目的數量。
+	Emitted  uint64 // 交給下一個階段的項目的數量。
+	Dropped  uint64 // 被丟棄的項目的數量。
+	Routed   uint64 // 被轉送到分支的項目的數量。
+	Failed   uint64 // 處理失敗的次數。
+}
+
+func (count StageCount) String() string {
+	return fmt.Sprintf("%s: in %d, out %d, dropped %d, routed %d, failed %d",
+		count.Name, count.Received, count.Emitted, count.Dropped, count.Routed, count.Failed)
+}
+
+// 執行中的階段。
+type runningStage struct {
+	Stage
+	fullName  string        // 完整的名稱。
+	semaphore chan struct{} // 限制並行處理數量的訊號量。不限制時為nil。
+	received  uint64        // 收到的項目的數量。
+	emitted   uint64        // 交給下一個階段的項目的數量。
+	dropped   uint64        // 被丟棄的項目的數量。
+	routed    uint64        // 被轉送到分支的項目的數量。
+	failed    uint64        // 處理失敗的次數。
+}
+
+// 建立執行中的階段。
+func newRunningStage(stage Stage, fullName string) *runningStage {
+	rs := &runningStage{Stage: stage, fullName: fullName}
+	if stage.concurrency > 0 {
+		rs.semaphore = make(chan struct{}, stage.concurrency)
+	}
+	return rs
+}
+
+// 處理項目。它會在並行處理的數量達到上限時被阻塞。
+func (rs *runningStage) process(item base.Item) (emission Emission, err error) {
+	atomic.AddUint64(&rs.received, 1)
+	if rs.semaphore != nil {
+		rs.semaphore <- struct{}{}
+		defer func() { <-rs.semaphore }()
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = errors.New(fmt.Sprintf("Stage %s panics: %v\n", rs.fullName, p))
+		}
+	}()
+	return rs.fn(item)
+}
+
+// 獲得計數值。
+func (rs *runningStage) count() StageCount {
+	return StageCount{
+		Name:     rs.fullName,
+		Received: atomic.LoadUint64(&rs.received),
+		Emitted:  atomic.LoadUint64(&rs.emitted),
+		Dropped:  atomic.LoadUint64(&rs.dropped),
+		Routed:   atomic.LoadUint64(&rs.routed),
+		Failed:   atomic.LoadUint64(&rs.failed),
+	}
+}
@@ -0,0 +1,435 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	base "webcrawler/base"
+)
+
+// 溢出策略的型態。它決定了訂閱者的緩沖區已滿時如何處理新的錯誤。
+type OverflowPolicy uint8
+
+const (
+	OVERFLOW_POLICY_DROP_OLDEST OverflowPolicy = 0 // 丟棄最早的錯誤以容納新的錯誤。
+	OVERFLOW_POLICY_BLOCK       OverflowPolicy = 1 // 阻塞錯誤的傳送方，直到緩沖區有空位。
+	OVERFLOW_POLICY_SAMPLE      OverflowPolicy = 2 // 對溢出的錯誤進行抽樣，只有被抽中的錯誤會取代最早的錯誤。
+)
+
+// 表示溢出策略與其名稱之間的映射關系的字典。
+var overflowPolicyNameMap = map[OverflowPolicy]string{
+	OVERFLOW_POLICY_DROP_OLDEST: "drop-oldest",
+	OVERFLOW_POLICY_BLOCK:       "block",
+	OVERFLOW_POLICY_SAMPLE:      "sample",
+}
+
+func (policy OverflowPolicy) String() string {
+	if name, ok := overflowPolicyNameMap[policy]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", policy)
+}
+
+// 無法識別型態的錯誤在計數時所使用的錯誤型態。
+const UNKNOWN_ERROR_TYPE base.ErrorType = "Unknown Error"
+
+// 錯誤匯集器參數容器的描述範本。
+var errorAggregatorArgsTemplate string = "{ capacity: %d, policy: %s, sampleInterval: %d }"
+
+// 錯誤匯集器參數的容器。
+type ErrorAggregatorArgs struct {
+	capacity       uint32         // 最近錯誤環形緩沖區的容量。
+	policy         OverflowPolicy // 溢出策略。
+	sampleInterval uint32         // 抽樣間隔。在抽樣策略下，每這麼多個溢出的錯誤中會有一個被保留。
+	description    string         // 描述。
+}
+
+// 建立錯誤匯集器參數的容器。
+func NewErrorAggregatorArgs(
+	capacity uint32,
+	policy OverflowPolicy,
+	sampleInterval uint32) ErrorAggregatorArgs {
+	return ErrorAggregatorArgs{
+		capacity:       capacity,
+		policy:         policy,
+		sampleInterval: sampleInterval,
+	}
+}
+
+func (args *ErrorAggregatorArgs) Check() error {
+	if args.capacity == 0 {
+		return errors.New("The capacity of error aggregator can not be 0!\n")
+	}
+	if _, ok := overflowPolicyNameMap[args.policy]; !ok {
+		errMsg := fmt.Sprintf("Unsupported overflow policy %d!\n", args.policy)
+		return errors.New(errMsg)
+	}
+	if args.policy == OVERFLOW_POLICY_SAMPLE && args.sampleInterval == 0 {
+		return errors.New("The sample interval can not be 0!\n")
+	}
+	return nil
+}
+
+func (args *ErrorAggregatorArgs) String() string {
+	if args.description == "" {
+		args.description =
+			fmt.Sprintf(errorAggregatorArgsTemplate,
+				args.capacity,
+				args.policy,
+				args.sampleInterval)
+	}
+	return args.description
+}
+
+// 獲得最近錯誤環形緩沖區的容量。
+func (args *ErrorAggregatorArgs) Capacity() uint32 {
+	return args.capacity
+}
+
+// 獲得溢出策略。
+func (args *ErrorAggregatorArgs) Policy() OverflowPolicy {
+	return args.policy
+}
+
+// 獲得抽樣間隔。
+func (args *ErrorAggregatorArgs) SampleInterval() uint32 {
+	return args.sampleInterval
+}
+
+// 錯誤匯集器的接口型態。
+// 它保存最近的錯誤、按型態計數，並把錯誤分發給所有的訂閱者。
+type ErrorAggregator interface {
+	// 放入錯誤。若果錯誤匯集器已被關閉，那麼該方法會傳回false。
+	// 在阻塞策略下，該方法會一直等待到所有訂閱者都接收了錯誤或者錯誤匯集器被關閉。
+	Put(err error) bool
+	// 只為錯誤型態計數，而不保存或分發錯誤，也不計入錯誤的總數。
+	// 它用於頻繁發生且通常無需處理的錯誤，例如被過濾的請求。若果錯誤匯集器已被關閉，那麼該方法會傳回false。
+	Count(errType base.ErrorType) bool
+	// 訂閱錯誤。參數bufferSize代表訂閱者的緩沖區的尺寸，為0時會使用1。
+	// 錯誤匯集器被關閉後，所有訂閱者的錯誤通道都會被關閉。
+	Subscribe(bufferSize uint32) ErrorSubscription
+	// 以指定的溢出策略訂閱錯誤。參數policy只對該訂閱者有效，抽樣間隔仍取自錯誤匯集器參數。
+	// 不能確定會被持續接收的訂閱應使用不會阻塞的策略，以免阻塞錯誤的傳送方。
+	SubscribeWithPolicy(bufferSize uint32, policy OverflowPolicy) ErrorSubscription
+	// 取得最近的至多n個錯誤，按照放入的先後排列。
+	Recent(n uint32) []error
+	// 取得各錯誤型態的計數。
+	Counts() map[base.ErrorType]uint64
+	// 取得已放入的錯誤的總數。
+	Total() uint64
+	// 取得所有訂閱者因溢出而丟棄的錯誤的總數。
+	Dropped() uint64
+	// 關閉錯誤匯集器。若果先前已被關閉，那麼該方法會傳回false。
+	// 關閉後仍然可以取得最近的錯誤與計數。
+	Close() bool
+	// 判斷錯誤匯集器是否已被關閉。
+	Closed() bool
+	// 取得摘要訊息。
+	Summary() string
+}
+
+// 錯誤訂閱的接口型態。
+type ErrorSubscription interface {
+	// 取得錯誤通道。它會在訂閱被取消或錯誤匯集器被關閉時被關閉。
+	Errors() <-chan error
+	// 取得因溢出而丟棄的錯誤的數量。
+	Dropped() uint64
+	// 取消訂閱。
+	Cancel()
+}
+
+// 建立錯誤匯集器。
+func NewErrorAggregator(args ErrorAggregatorArgs) (ErrorAggregator, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	return &myErrorAggregator{
+		args:   args,
+		ring:   make([]error, args.Capacity()),
+		counts: make(map[base.ErrorType]uint64),
+		subs:   make(map[uint64]*mySubscription),
+	}, nil
+}
+
+// 錯誤匯集器的實現型態。
+type myErrorAggregator struct {
+	args          ErrorAggregatorArgs        // 參數。
+	ring          []error                    // 最近錯誤的環形緩沖區。
+	next          uint32                     // 下一個錯誤在環形緩沖區中的位置。
+	size          uint32                     // 環形緩沖區中的錯誤的數量。
+	counts        map[base.ErrorType]uint64  // 各錯誤型態的計數。
+	total         uint64                     // 已放入的錯誤的總數。
+	subs          map[uint64]*mySubscription // 訂閱者的字典。
+	subSn         uint64                     // 訂閱者的序號。
+	droppedByGone uint64                     // 已被取消的訂閱者丟棄的錯誤的數量。
+	closed        bool                       // 是否已被關閉。
+	mutex         sync.Mutex                 // 互斥鎖。
+}
+
+// 獲得錯誤的型態。
+func errorTypeOf(err error) base.ErrorType {
+	if ce, ok := err.(base.CrawlerError); ok && ce.Type() != "" {
+		return ce.Type()
+	}
+	return UNKNOWN_ERROR_TYPE
+}
+
+func (agg *myErrorAggregator) Put(err error) bool {
+	if err == nil {
+		return false
+	}
+	agg.mutex.Lock()
+	if agg.closed {
+		agg.mutex.Unlock()
+		return false
+	}
+	agg.ring[agg.next] = err
+	agg.next = (agg.next + 1) % agg.args.Capacity()
+	if agg.size < agg.args.Capacity() {
+		agg.size++
+	}
+	agg.counts[errorTypeOf(err)]++
+	agg.total++
+	subs := make([]*mySubscription, 0, len(agg.subs))
+	for _, sub := range agg.subs {
+		subs = append(subs, sub)
+	}
+	agg.mutex.Unlock()
+	for _, sub := range subs {
+		sub.deliver(err, agg.args.SampleInterval())
+	}
+	return true
+}
+
+func (agg *myErrorAggregator) Count(errType base.ErrorType) bool {
+	agg.mutex.Lock()
+	defer agg.mutex.Unlock()
+	if agg.closed {
+		return false
+	}
+	agg.counts[errType]++
+	return true
+}
+
+func (agg *myErrorAggregator) Subscribe(bufferSize uint32) ErrorSubscription {
+	return agg.SubscribeWithPolicy(bufferSize, agg.args.Policy())
+}
+
+func (agg *myErrorAggregator) SubscribeWithPolicy(bufferSize uint32, policy OverflowPolicy) ErrorSubscription {
+	if _, ok := overflowPolicyNameMap[policy]; !ok {
+		policy = agg.args.Policy()
+	}
+	if bufferSize == 0 {
+		bufferSize = 1
+	}
+	agg.mutex.Lock()
+	defer agg.mutex.Unlock()
+	agg.subSn++
+	sub := &mySubscription{
+		id:     agg.subSn,
+		agg:    agg,
+		ch:     make(chan error, bufferSize),
+		doneCh: make(chan struct{}),
+		policy: policy,
+	}
+	if agg.closed {
+		sub.close()
+		return sub
+	}
+	agg.subs[sub.id] = sub
+	return sub
+}
+
+func (agg *myErrorAggregator) Recent(n uint32) []error {
+	agg.mutex.Lock()
+	defer agg.mutex.Unlock()
+	if n > agg.size {
+		n = agg.size
+	}
+	capacity := agg.args.Capacity()
+	result := make([]error, n)
+	start := (agg.next + capacity - n) % capacity
+	for i := uint32(0); i < n; i++ {
+		result[i] = agg.ring[(start+i)%capacity]
+	}
+	return result
+}
+
+func (agg *myErrorAggregator) Counts() map[base.ErrorType]uint64 {
+	agg.mutex.Lock()
+	defer agg.mutex.Unlock()
+	counts := make(map[base.ErrorType]uint64, len(agg.counts))
+	for errType, count := range agg.counts {
+		counts[errType] = count
+	}
+	return counts
+}
+
+func (agg *myErrorAggregator) Total() uint64 {
+	agg.mutex.Lock()
+	defer agg.mutex.Unlock()
+	return agg.total
+}
+
+func (agg *myErrorAggregator) Dropped() uint64 {
+	agg.mutex.Lock()
+	defer agg.mutex.Unlock()
+	dropped := agg.droppedByGone
+	for _, sub := range agg.subs {
+		dropped += sub.Dropped()
+	}
+	return dropped
+}
+
+func (agg *myErrorAggregator) Close() bool {
+	agg.mutex.Lock()
+	if agg.closed {
+		agg.mutex.Unlock()
+		return false
+	}
+	agg.closed = true
+	subs := agg.subs
+	agg.subs = make(map[uint64]*mySubscription)
+	for _, sub := range subs {
+		agg.droppedByGone += sub.Dropped()
+	}
+	agg.mutex.Unlock()
+	for _, sub := range subs {
+		sub.close()
+	}
+	return true
+}
+
+func (agg *myErrorAggregator) Closed() bool {
+	agg.mutex.Lock()
+	defer agg.mutex.Unlock()
+	return agg.closed
+}
+
+// 從訂閱者的字典中刪除已被取消的訂閱者。
+func (agg *myErrorAggregator) remove(sub *mySubscription) {
+	agg.mutex.Lock()
+	defer agg.mutex.Unlock()
+	if _, ok := agg.subs[sub.id]; ok {
+		delete(agg.subs, sub.id)
+		agg.droppedByGone += sub.Dropped()
+	}
+}
+
+var errorAggregatorSummaryTemplate = "closed: %v, total: %d, counts: %s," +
+	" recent: %d/%d, subscribers: %d, dropped: %d, args: %s"
+
+func (agg *myErrorAggregator) Summary() string {
+	counts := agg.Counts()
+	errTypes := make([]string, 0, len(counts))
+	for errType := range counts {
+		errTypes = append(errTypes, string(errType))
+	}
+	sort.Strings(errTypes)
+	var buffer bytes.Buffer
+	buffer.WriteString("{")
+	for i, errType := range errTypes {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(fmt.Sprintf("%s: %d", errType, counts[base.ErrorType(errType)]))
+	}
+	buffer.WriteString("}")
+	dropped := agg.Dropped()
+	agg.mutex.Lock()
+	defer agg.mutex.Unlock()
+	return fmt.Sprintf(errorAggregatorSummaryTemplate,
+		agg.closed, agg.total, buffer.String(),
+		agg.size, agg.args.Capacity(), len(agg.subs), dropped,
+		agg.args.String())
+}
+
+// 錯誤訂閱的實現型態。
+type mySubscription struct {
+	id         uint64             // 序號。
+	agg        *myErrorAggregator // 所屬的錯誤匯集器。
+	ch         chan error         // 錯誤通道。
+	doneCh     chan struct{}      // 取消通知通道。
+	policy     OverflowPolicy     // 溢出策略。
+	closeOnce  sync.Once          // 確保只被關閉一次。
+	closed     bool               // 錯誤通道是否已被關閉。
+	dropped    uint64             // 因溢出而丟棄的錯誤的數量。
+	overflowed uint64             // 溢出的錯誤的數量，用於抽樣。
+	rwmutex    sync.RWMutex       // 讀寫鎖。阻塞式傳送時持有讀鎖，其他動作持有寫鎖。
+}
+
+// 向訂閱者傳遞錯誤。
+func (sub *mySubscription) deliver(err error, sampleInterval uint32) {
+	policy := sub.policy
+	if policy == OVERFLOW_POLICY_SAMPLE && sampleInterval == 0 {
+		sampleInterval = 1
+	}
+	if policy == OVERFLOW_POLICY_BLOCK {
+		sub.rwmutex.RLock()
+		defer sub.rwmutex.RUnlock()
+		if sub.closed {
+			return
+		}
+		select {
+		case sub.ch <- err:
+		case <-sub.doneCh:
+		}
+		return
+	}
+	sub.rwmutex.Lock()
+	defer sub.rwmutex.Unlock()
+	if sub.closed {
+		return
+	}
+	select {
+	case sub.ch <- err:
+		return
+	default:
+	}
+	if policy == OVERFLOW_POLICY_SAMPLE {
+		sub.overflowed++
+		if sub.overflowed%uint64(sampleInterval) != 0 {
+			sub.dropped++
+			return
+		}
+	}
+	// 丟棄最早的錯誤以容納新的錯誤。
+	select {
+	case <-sub.ch:
+		sub.dropped++
+	default:
+	}
+	select {
+	case sub.ch <- err:
+	default:
+		sub.dropped++
+	}
+}
+
+// 關閉錯誤通道。
+func (sub *mySubscription) close() {
+	sub.closeOnce.Do(func() {
+		close(sub.doneCh)
+		sub.rwmutex.Lock()
+		defer sub.rwmutex.Unlock()
+		sub.closed = true
+		close(sub.ch)
+	})
+}
+
+func (sub *mySubscription) Errors() <-chan error {
+	return sub.ch
+}
+
+func (sub *mySubscription) Dropped() uint64 {
+	sub.rwmutex.RLock()
+	defer sub.rwmutex.RUnlock()
+	return sub.dropped
+}
+
+func (sub *mySubscription) Cancel() {
+	sub.agg.remove(sub)
+	sub.close()
+}
@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+	base "webcrawler/base"
+)
+
+func TestErrorAggregatorArgs(t *testing.T) {
+	invalidArgsList := []ErrorAggregatorArgs{
+		NewErrorAggregatorArgs(0, OVERFLOW_POLICY_DROP_OLDEST, 0),
+		NewErrorAggregatorArgs(10, OverflowPolicy(9), 0),
+		NewErrorAggregatorArgs(10, OVERFLOW_POLICY_SAMPLE, 0),
+	}
+	for _, args := range invalidArgsList {
+		if _, err := NewErrorAggregator(args); err == nil {
+			t.Errorf("ERROR: No error with invalid args %s!\n", args.String())
+		}
+	}
+}
+
+func TestErrorAggregatorRecentAndCounts(t *testing.T) {
+	agg, err := NewErrorAggregator(NewErrorAggregatorArgs(3, OVERFLOW_POLICY_DROP_OLDEST, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Error aggregator initialization failing: %s\n", err)
+	}
+	for i := 0; i < 5; i++ {
+		agg.Put(base.NewCrawlerError(base.DOWNLOADER_ERROR, fmt.Sprintf("e%d", i)))
+	}
+	agg.Put(errors.New("plain"))
+	recent := agg.Recent(10)
+	if len(recent) != 3 {
+		t.Fatalf("ERROR: The number of recent errors is %d, but should be 3!\n", len(recent))
+	}
+	if recent[0].Error() != base.NewCrawlerError(base.DOWNLOADER_ERROR, "e3").Error() ||
+		recent[2].Error() != "plain" {
+		t.Errorf("ERROR: Unexpected recent errors: %v!\n", recent)
+	}
+	if recent := agg.Recent(1); len(recent) != 1 || recent[0].Error() != "plain" {
+		t.Errorf("ERROR: Unexpected most recent error: %v!\n", recent)
+	}
+	counts := agg.Counts()
+	if counts[base.DOWNLOADER_ERROR] != 5 || counts[UNKNOWN_ERROR_TYPE] != 1 {
+		t.Errorf("ERROR: Unexpected counts: %v!\n", counts)
+	}
+	if agg.Total() != 6 {
+		t.Errorf("ERROR: The total is %d, but should be 6!\n", agg.Total())
+	}
+	agg.Count(base.FILTER_ERROR)
+	agg.Count(base.FILTER_ERROR)
+	if count := agg.Counts()[base.FILTER_ERROR]; count != 2 || agg.Total() != 6 || len(agg.Recent(10)) != 3 {
+		t.Errorf("ERROR: The filter error count is %d and the total is %d!\n", count, agg.Total())
+	}
+}
+
+func TestErrorAggregatorDropOldest(t *testing.T) {
+	agg, _ := NewErrorAggregator(NewErrorAggregatorArgs(10, OVERFLOW_POLICY_DROP_OLDEST, 0))
+	sub := agg.Subscribe(2)
+	for i := 0; i < 5; i++ {
+		agg.Put(fmt.Errorf("e%d", i))
+	}
+	if sub.Dropped() != 3 || agg.Dropped() != 3 {
+		t.Errorf("ERROR: The dropped number is %d/%d, but should be 3!\n", sub.Dropped(), agg.Dropped())
+	}
+	agg.Close()
+	received := make([]string, 0)
+	for err := range sub.Errors() {
+		received = append(received, err.Error())
+	}
+	if fmt.Sprint(received) != "[e3 e4]" {
+		t.Errorf("ERROR: The received errors are %v, but should be [e3 e4]!\n", received)
+	}
+}
+
+func TestErrorAggregatorSample(t *testing.T) {
+	agg, _ := NewErrorAggregator(NewErrorAggregatorArgs(10, OVERFLOW_POLICY_SAMPLE, 3))
+	sub := agg.Subscribe(1)
+	// 第一個錯誤進入緩沖區，其後的6個錯誤溢出，其中第3個和第6個會被抽中。
+	for i := 0; i < 7; i++ {
+		agg.Put(fmt.Errorf("e%d", i))
+	}
+	err := <-sub.Errors()
+	if err.Error() != "e6" {
+		t.Errorf("ERROR: The received error is %s, but should be e6!\n", err)
+	}
+	if sub.Dropped() != 6 {
+		t.Errorf("ERROR: The dropped number is %d, but should be 6!\n", sub.Dropped())
+	}
+	sub.Cancel()
+	if _, ok := <-sub.Errors(); ok {
+		t.Errorf("ERROR: The error channel is not closed after canceling!\n")
+	}
+	if agg.Dropped() != 6 {
+		t.Errorf("ERROR: The dropped number of aggregator is %d, but should be 6!\n", agg.Dropped())
+	}
+}
+
+func TestErrorAggregatorBlock(t *testing.T) {
+	agg, _ := NewErrorAggregator(NewErrorAggregatorArgs(10, OVERFLOW_POLICY_BLOCK, 0))
+	sub := agg.Subscribe(1)
+	agg.Put(errors.New("e0"))
+	putDone := make(chan bool)
+	go func() {
+		putDone <- agg.Put(errors.New("e1"))
+	}()
+	select {
+	case <-putDone:
+		t.Fatalf("ERROR: The put is not blocked when the buffer is full!\n")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-sub.Errors()
+	select {
+	case <-putDone:
+	case <-time.After(time.Second):
+		t.Fatalf("ERROR: The put is still blocked after receiving!\n")
+	}
+	// 關閉錯誤匯集器會解除阻塞。
+	<-sub.Errors()
+	agg.Put(errors.New("e2"))
+	go func() {
+		putDone <- agg.Put(errors.New("e3"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	agg.Close()
+	select {
+	case <-putDone:
+	case <-time.After(time.Second):
+		t.Fatalf("ERROR: The put is still blocked after closing!\n")
+	}
+	if sub.Dropped() != 0 {
+		t.Errorf("ERROR: Some errors are dropped under block policy!\n")
+	}
+}
+
+func TestErrorAggregatorSubscribeWithPolicy(t *testing.T) {
+	agg, _ := NewErrorAggregator(NewErrorAggregatorArgs(10, OVERFLOW_POLICY_BLOCK, 0))
+	sub := agg.SubscribeWithPolicy(1, OVERFLOW_POLICY_DROP_OLDEST)
+	putDone := make(chan bool)
+	go func() {
+		for i := 0; i < 3; i++ {
+			agg.Put(fmt.Errorf("e%d", i))
+		}
+		putDone <- true
+	}()
+	select {
+	case <-putDone:
+	case <-time.After(time.Second):
+		t.Fatalf("ERROR: The put is blocked by the drop-oldest subscription!\n")
+	}
+	if err := <-sub.Errors(); err.Error() != "e2" || sub.Dropped() != 2 {
+		t.Errorf("ERROR: The subscription received %v and dropped %d!\n", err, sub.Dropped())
+	}
+	agg.Close()
+}
+
+func TestErrorAggregatorClose(t *testing.T) {
+	agg, _ := NewErrorAggregator(NewErrorAggregatorArgs(10, OVERFLOW_POLICY_DROP_OLDEST, 0))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				agg.Put(fmt.Errorf("e%d-%d", i, j))
+			}
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		sub := agg.Subscribe(4)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range sub.Errors() {
+			}
+		}()
+	}
+	time.Sleep(time.Millisecond)
+	if !agg.Close() {
+		t.Errorf("ERROR: The aggregator can not be closed!\n")
+	}
+	wg.Wait()
+	if agg.Close() {
+		t.Errorf("ERROR: The aggregator is closed twice!\n")
+	}
+	if agg.Put(errors.New("late")) {
+		t.Errorf("ERROR: The error is accepted after closing!\n")
+	}
+	if _, ok := <-agg.Subscribe(1).Errors(); ok {
+		t.Errorf("ERROR: The subscription after closing is not closed!\n")
+	}
+	if total := agg.Total(); total == 0 || len(agg.Recent(100)) != 10 && total >= 10 {
+		t.Errorf("ERROR: Unexpected total %d or recent errors after closing!\n", total)
+	}
+}
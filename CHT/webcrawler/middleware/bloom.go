@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// 可擴展布隆過濾器的擴展參數。
+const (
+	bloomGrowthFactor     = 2   // 每個新的子過濾器的容量相對於前一個的倍數。
+	bloomTighteningRatio  = 0.5 // 每個新的子過濾器的誤判率相對於前一個的比例。
+	bloomMinFilterBitsLen = 64  // 子過濾器的最小位元數。
+)
+
+// 建立基於可擴展布隆過濾器的已見集合。
+// 它會在容量不足時加入新的子過濾器，因此總體的誤判率始終不會超過參數fpRate。
+// 其結果只會有誤判（把未出現過的鍵判斷為已存在），而不會有漏判。
+// 參數initialCapacity代表首個子過濾器的容量。
+// 參數fpRate代表期望的總體誤判率，其取值範圍為(0, 1)。
+func NewBloomSeenSet(initialCapacity uint64, fpRate float64) (SeenSet, error) {
+	if initialCapacity == 0 {
+		return nil, errors.New("The initial capacity of bloom filter can not be 0!\n")
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		errMsg := fmt.Sprintf("Invalid false positive rate %v of bloom filter!\n", fpRate)
+		return nil, errors.New(errMsg)
+	}
+	ss := &bloomSeenSet{
+		initialCapacity: initialCapacity,
+		fpRate:          fpRate,
+	}
+	ss.grow()
+	return ss, nil
+}
+
+// 基於可擴展布隆過濾器的已見集合。
+type bloomSeenSet struct {
+	initialCapacity uint64         // 首個子過濾器的容量。
+	fpRate          float64        // 期望的總體誤判率。
+	filters         []*bloomFilter // 子過濾器的清單。
+	length          uint64         // 已加入的鍵的數量。
+	mutex           sync.Mutex     // 互斥鎖。
+}
+
+// 子過濾器。
+type bloomFilter struct {
+	bits     []uint64 // 位元陣列。
+	bitsLen  uint64   // 位元陣列的有效長度。
+	hashes   uint32   // 雜湊函數的數量。
+	capacity uint64   // 容量。
+	count    uint64   // 已加入的鍵的數量。
+}
+
+// 加入新的子過濾器。呼叫方應持有互斥鎖。
+func (ss *bloomSeenSet) grow() {
+	n := len(ss.filters)
+	capacity := ss.initialCapacity
+	for i := 0; i < n; i++ {
+		capacity *= bloomGrowthFactor
+	}
+	// 各子過濾器的誤判率構成等比數列，其總和不超過期望的總體誤判率。
+	p := ss.fpRate * (1 - bloomTighteningRatio) * math.Pow(bloomTighteningRatio, float64(n))
+	bitsLen := uint64(math.Ceil(-float64(capacity) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if bitsLen < bloomMinFilterBitsLen {
+		bitsLen = bloomMinFilterBitsLen
+	}
+	hashes := uint32(math.Ceil(math.Log2(1 / p)))
+	if hashes == 0 {
+		hashes = 1
+	}
+	ss.filters = append(ss.filters, &bloomFilter{
+		bits:     make([]uint64, (bitsLen+63)/64),
+		bitsLen:  bitsLen,
+		hashes:   hashes,
+		capacity: capacity,
+	})
+}
+
+// 計算鍵的兩個基本雜湊值，其他雜湊值由它們線性組合產生。
+func bloomHashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h1 := h.Sum64()
+	h.Write([]byte{0x9e})
+	h2 := h.Sum64() | 1
+	return h1, h2
+}
+
+func (f *bloomFilter) contains(h1, h2 uint64) bool {
+	for i := uint32(0); i < f.hashes; i++ {
+		pos := (h1 + uint64(i)*h2) % f.bitsLen
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for i := uint32(0); i < f.hashes; i++ {
+		pos := (h1 + uint64(i)*h2) % f.bitsLen
+		f.bits[pos/64] |= 1 << (pos % 64)
+	}
+	f.count++
+}
+
+func (ss *bloomSeenSet) Add(key string) bool {
+	h1, h2 := bloomHashes(key)
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	for _, f := range ss.filters {
+		if f.contains(h1, h2) {
+			return false
+		}
+	}
+	last := ss.filters[len(ss.filters)-1]
+	if last.count >= last.capacity {
+		ss.grow()
+		last = ss.filters[len(ss.filters)-1]
+	}
+	last.add(h1, h2)
+	ss.length++
+	return true
+}
+
+func (ss *bloomSeenSet) Contains(key string) bool {
+	h1, h2 := bloomHashes(key)
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	for _, f := range ss.filters {
+		if f.contains(h1, h2) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ss *bloomSeenSet) Len() uint64 {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	return ss.length
+}
+
+func (ss *bloomSeenSet) Close() error {
+	return nil
+}
+
+func (ss *bloomSeenSet) Summary() string {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	var bits uint64
+	for _, f := range ss.filters {
+		bits += f.bitsLen
+	}
+	return fmt.Sprintf("type: bloom, length: %d, fpRate: %v, filters: %d, bytes: %d",
+		ss.length, ss.fpRate, len(ss.filters), bits/8)
+}
@@ -148,6 +148,8 @@ func (chanman *myChannelManager) checkStatus() error {
 }
 
 func (chanman *myChannelManager) Status() ChannelManagerStatus {
+	chanman.rwmutex.RLock()
+	defer chanman.rwmutex.RUnlock()
 	return chanman.status
 }
 
@@ -158,6 +160,8 @@ var chanmanSummaryTemplate = "status: %s, " +
 	"errorChannel: %d/%d"
 
 func (chanman *myChannelManager) Summary() string {
+	chanman.rwmutex.RLock()
+	defer chanman.rwmutex.RUnlock()
 	summary := fmt.Sprintf(chanmanSummaryTemplate,
 		statusNameMap[chanman.status],
 		len(chanman.reqCh), cap(chanman.reqCh),
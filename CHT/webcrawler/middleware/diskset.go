@@ -0,0 +1,300 @@
+package middleware
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sync"
+)
+
+// 磁碟已見集合的檔案格式常數。
+const (
+	diskSetMagic      = "WCSS" // 檔案標記。
+	diskSetHeaderSize = 24     // 檔案頭的長度：標記(4)、版本(4)、槽數(8)、鍵數(8)。
+	diskSetVersion    = 1      // 檔案格式的版本。
+	diskSetSlotSize   = 8      // 每個槽的長度。
+	diskSetReadSlots  = 64     // 探測時每次讀取的槽的數量。
+	diskSetMinSlots   = 1024   // 最小的槽數。
+)
+
+// 建立基於磁碟的已見集合。
+// 它在檔案中以開放定址雜湊表的形式存放鍵的64位雜湊值，記憶體佔用與鍵的數量無關。
+// 由於只存放雜湊值，極少數情況下不同的鍵可能被判斷為相同。
+// 若果參數path所指的檔案已存在，那麼其中的內容會被載入，以便繼續之前的爬取流程。
+// 參數initialSlots代表新建檔案時的槽數，雜湊表會在負載超過一半時自動擴容。
+func NewDiskSeenSet(path string, initialSlots uint64) (SeenSet, error) {
+	if initialSlots < diskSetMinSlots {
+		initialSlots = diskSetMinSlots
+	}
+	ss := &diskSeenSet{path: path}
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err == nil {
+		ss.file = file
+		if err := ss.readHeader(); err != nil {
+			file.Close()
+			return nil, err
+		}
+		// 檔案頭只在擴容和關閉時被寫入，未被正常關閉的檔案中的鍵數可能偏小，因此需要重新計數。
+		ss.count = 0
+		err := eachHash(file, ss.slots, func(hash uint64) error {
+			ss.count++
+			return nil
+		})
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return ss, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err = createDiskSetFile(path, initialSlots)
+	if err != nil {
+		return nil, err
+	}
+	ss.file = file
+	ss.slots = initialSlots
+	return ss, nil
+}
+
+// 基於磁碟的已見集合。
+type diskSeenSet struct {
+	path   string     // 檔案路徑。
+	file   *os.File   // 檔案。
+	slots  uint64     // 槽數。
+	count  uint64     // 已加入的鍵的數量。
+	closed bool       // 是否已關閉。
+	mutex  sync.Mutex // 互斥鎖。
+}
+
+// 建立一個空的雜湊表檔案。
+func createDiskSetFile(path string, slots uint64) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(int64(diskSetHeaderSize + slots*diskSetSlotSize)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := writeDiskSetHeader(file, slots, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// 寫入檔案頭。
+func writeDiskSetHeader(file *os.File, slots uint64, count uint64) error {
+	header := make([]byte, diskSetHeaderSize)
+	copy(header, diskSetMagic)
+	binary.LittleEndian.PutUint32(header[4:], diskSetVersion)
+	binary.LittleEndian.PutUint64(header[8:], slots)
+	binary.LittleEndian.PutUint64(header[16:], count)
+	_, err := file.WriteAt(header, 0)
+	return err
+}
+
+// 讀取檔案頭。
+func (ss *diskSeenSet) readHeader() error {
+	header := make([]byte, diskSetHeaderSize)
+	if _, err := ss.file.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if string(header[:4]) != diskSetMagic ||
+		binary.LittleEndian.Uint32(header[4:]) != diskSetVersion {
+		errMsg := fmt.Sprintf("The file '%s' is not a seen set file!\n", ss.path)
+		return errors.New(errMsg)
+	}
+	ss.slots = binary.LittleEndian.Uint64(header[8:])
+	ss.count = binary.LittleEndian.Uint64(header[16:])
+	if ss.slots == 0 {
+		errMsg := fmt.Sprintf("The seen set file '%s' is broken!\n", ss.path)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+// 計算鍵的雜湊值。0被用來表示空槽，因此不會作為結果。
+func diskSetHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	if sum == 0 {
+		sum = 1
+	}
+	return sum
+}
+
+// 在雜湊表中查找雜湊值。
+// 結果值分別代表：是否已存在、用於放入該值的空槽的序號。
+func findSlot(file *os.File, slots uint64, hash uint64) (bool, uint64, error) {
+	buf := make([]byte, diskSetReadSlots*diskSetSlotSize)
+	index := hash % slots
+	for probed := uint64(0); probed < slots; {
+		n := uint64(diskSetReadSlots)
+		if index+n > slots {
+			n = slots - index
+		}
+		chunk := buf[:n*diskSetSlotSize]
+		offset := int64(diskSetHeaderSize + index*diskSetSlotSize)
+		if _, err := file.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return false, 0, err
+		}
+		for i := uint64(0); i < n; i++ {
+			v := binary.LittleEndian.Uint64(chunk[i*diskSetSlotSize:])
+			if v == hash {
+				return true, 0, nil
+			}
+			if v == 0 {
+				return false, index + i, nil
+			}
+		}
+		probed += n
+		index = (index + n) % slots
+	}
+	return false, 0, errors.New("The seen set file is full!")
+}
+
+// 依次以雜湊表中的每個雜湊值呼叫參數fn。空槽會被略過。參數fn傳回錯誤時遍歷會被中止。
+func eachHash(file *os.File, slots uint64, fn func(hash uint64) error) error {
+	buf := make([]byte, diskSetReadSlots*diskSetSlotSize)
+	for index := uint64(0); index < slots; index += diskSetReadSlots {
+		n := uint64(diskSetReadSlots)
+		if index+n > slots {
+			n = slots - index
+		}
+		chunk := buf[:n*diskSetSlotSize]
+		if _, err := file.ReadAt(chunk, int64(diskSetHeaderSize+index*diskSetSlotSize)); err != nil && err != io.EOF {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			v := binary.LittleEndian.Uint64(chunk[i*diskSetSlotSize:])
+			if v == 0 {
+				continue
+			}
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 把雜湊值寫入指定的槽。
+func writeSlot(file *os.File, slot uint64, hash uint64) error {
+	buf := make([]byte, diskSetSlotSize)
+	binary.LittleEndian.PutUint64(buf, hash)
+	_, err := file.WriteAt(buf, int64(diskSetHeaderSize+slot*diskSetSlotSize))
+	return err
+}
+
+// 把雜湊表擴容為原來的兩倍。呼叫方應持有互斥鎖。
+func (ss *diskSeenSet) grow() error {
+	newSlots := ss.slots * 2
+	tmpPath := ss.path + ".tmp"
+	newFile, err := createDiskSetFile(tmpPath, newSlots)
+	if err != nil {
+		return err
+	}
+	err = eachHash(ss.file, ss.slots, func(hash uint64) error {
+		_, slot, err := findSlot(newFile, newSlots, hash)
+		if err != nil {
+			return err
+		}
+		return writeSlot(newFile, slot, hash)
+	})
+	if err != nil {
+		newFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := writeDiskSetHeader(newFile, newSlots, ss.count); err != nil {
+		newFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	// 只有在新檔案取代了原檔案之後才切換檔案，否則繼續使用原檔案。
+	if err := os.Rename(tmpPath, ss.path); err != nil {
+		newFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	ss.file.Close()
+	ss.file = newFile
+	ss.slots = newSlots
+	return nil
+}
+
+func (ss *diskSeenSet) Add(key string) bool {
+	hash := diskSetHash(key)
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.closed {
+		return false
+	}
+	if (ss.count+1)*2 > ss.slots {
+		if err := ss.grow(); err != nil {
+			logger.Errorf("Grow the seen set file failing: %s\n", err)
+		}
+	}
+	found, slot, err := findSlot(ss.file, ss.slots, hash)
+	if err != nil {
+		logger.Errorf("Look up the seen set file failing: %s\n", err)
+		return false
+	}
+	if found {
+		return false
+	}
+	if err := writeSlot(ss.file, slot, hash); err != nil {
+		logger.Errorf("Write the seen set file failing: %s\n", err)
+		return false
+	}
+	ss.count++
+	return true
+}
+
+func (ss *diskSeenSet) Contains(key string) bool {
+	hash := diskSetHash(key)
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.closed {
+		return false
+	}
+	found, _, err := findSlot(ss.file, ss.slots, hash)
+	if err != nil {
+		logger.Errorf("Look up the seen set file failing: %s\n", err)
+	}
+	return found
+}
+
+func (ss *diskSeenSet) Len() uint64 {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	return ss.count
+}
+
+func (ss *diskSeenSet) Close() error {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	if ss.closed {
+		return nil
+	}
+	ss.closed = true
+	if err := writeDiskSetHeader(ss.file, ss.slots, ss.count); err != nil {
+		ss.file.Close()
+		return err
+	}
+	return ss.file.Close()
+}
+
+func (ss *diskSeenSet) Summary() string {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+	return fmt.Sprintf("type: disk, length: %d, slots: %d, path: %s",
+		ss.count, ss.slots, ss.path)
+}
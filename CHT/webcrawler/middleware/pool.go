@@ -1,125 +1,354 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"reflect"
 	"sync"
+	"time"
 )
 
+// 等待中的取出者嘗試補足空出的位置的間隔時間。
+const POOL_REFILL_INTERVAL = 100 * time.Millisecond
+
 // 實體的接口型態。
 type Entity interface {
 	Id() uint32 // ID的取得方法。
 }
 
+// 實體健康檢查函數的型態。結果值為false時表示實體已損壞，需要被替換。
+type HealthCheck func(entity Entity) bool
+
 // 實體池的接口型態。
 type Pool interface {
-	Take() (Entity, error)      // 取出實體
+	Take() (Entity, error) // 取出實體。在沒有閒置實體時會一直等待。
+	// 取出實體。在沒有閒置實體時至多等待參數timeout所代表的時間。
+	TakeTimeout(timeout time.Duration) (Entity, error)
+	// 取出實體。在沒有閒置實體時會一直等待，直到參數ctx被取消。
+	TakeContext(ctx context.Context) (Entity, error)
 	Return(entity Entity) error // 歸還實體。
 	Total() uint32              // 實體池的容量。
 	Used() uint32               // 實體池中已被使用的實體的數量。
+	// 調整實體池的容量。
+	// 縮小容量時，多餘的閒置實體會被立即丟棄，正在被使用的實體則會在歸還時被丟棄。
+	Resize(total uint32) error
+	// 設定實體健康檢查函數。實體在被取出和歸還時都會接受檢查，未通過檢查的實體會被新產生的實體替換。
+	// 檢查會在持有池的鎖時進行，因此檢查函數應盡快傳回。
+	// 參數check為nil時表示不進行檢查。
+	SetHealthCheck(check HealthCheck)
+	Stats() PoolStats // 取得使用統計。
+}
+
+// 實體池的使用統計。
+type PoolStats struct {
+	Total        uint32        // 容量。
+	Used         uint32        // 正在被使用的實體的數量。
+	Waiting      uint32        // 正在等待實體的取出者的數量。
+	TakeCount    uint64        // 成功取出的次數。
+	ReturnCount  uint64        // 成功歸還的次數。
+	TimeoutCount uint64        // 因逾時或被取消而失敗的取出次數。
+	ReplaceCount uint64        // 因未通過健康檢查而被替換的實體的數量。
+	WaitTime     time.Duration // 取出實體時累計等待的時間。
+	MaxWaitTime  time.Duration // 取出實體時單次等待的最長時間。
+	HoldTime     time.Duration // 實體被取出後直至歸還的累計時間。
+}
+
+// 獲得平均等待時間。
+func (stats PoolStats) AvgWaitTime() time.Duration {
+	if stats.TakeCount == 0 {
+		return 0
+	}
+	return stats.WaitTime / time.Duration(stats.TakeCount)
+}
+
+// 獲得平均持有時間。
+func (stats PoolStats) AvgHoldTime() time.Duration {
+	if stats.ReturnCount == 0 {
+		return 0
+	}
+	return stats.HoldTime / time.Duration(stats.ReturnCount)
+}
+
+var poolStatsTemplate = "total: %d, used: %d, waiting: %d, takes: %d, timeouts: %d," +
+	" replaced: %d, avgWait: %s, maxWait: %s, avgHold: %s"
+
+func (stats PoolStats) String() string {
+	return fmt.Sprintf(poolStatsTemplate,
+		stats.Total, stats.Used, stats.Waiting, stats.TakeCount, stats.TimeoutCount,
+		stats.ReplaceCount, stats.AvgWaitTime(), stats.MaxWaitTime, stats.AvgHoldTime())
 }
 
 // 建立實體池。
 func NewPool(
 	total uint32,
-	entityType reflect.Type,
 	genEntity func() Entity) (Pool, error) {
 	if total == 0 {
 		errMsg :=
 			fmt.Sprintf("The pool can not be initialized! (total=%d)\n", total)
 		return nil, errors.New(errMsg)
 	}
-	size := int(total)
-	container := make(chan Entity, size)
-	idContainer := make(map[uint32]bool)
-	for i := 0; i < size; i++ {
-		newEntity := genEntity()
-		if entityType != reflect.TypeOf(newEntity) {
-			errMsg :=
-				fmt.Sprintf("The type of result of function genEntity() is NOT %s!\n", entityType)
-			return nil, errors.New(errMsg)
-		}
-		container <- newEntity
-		idContainer[newEntity.Id()] = true
+	if genEntity == nil {
+		return nil, errors.New("The entity generator can not be nil!\n")
 	}
 	pool := &myPool{
-		total:       total,
-		etype:       entityType,
-		genEntity:   genEntity,
-		container:   container,
-		idContainer: idContainer,
+		genEntity: genEntity,
+		states:    make(map[uint32]*entityState),
+	}
+	if err := pool.Resize(total); err != nil {
+		return nil, err
 	}
 	return pool, nil
 }
 
+// 實體的狀態。
+type entityState struct {
+	entity  Entity    // 實體。
+	inUse   bool      // 是否正在被使用。
+	takenAt time.Time // 被取出的時間。
+}
+
 // 實體池的實現型態。
 type myPool struct {
-	total       uint32          // 池的總容量。
-	etype       reflect.Type    // 池中實體的型態。
-	genEntity   func() Entity   // 池中實體的產生函數。
-	container   chan Entity     // 實體容器。
-	idContainer map[uint32]bool // 實體ID的容器。
-	mutex       sync.Mutex      // 針對實體ID容器動作的互斥鎖。
+	total       uint32                  // 池的總容量。
+	genEntity   func() Entity           // 池中實體的產生函數。
+	healthCheck HealthCheck             // 實體健康檢查函數。
+	idle        []Entity                // 閒置實體的容器。
+	states      map[uint32]*entityState // 池中所有實體的狀態，以實體ID為鍵。
+	waiters     []chan Entity           // 等待實體的取出者的佇列。
+	used        uint32                  // 正在被使用的實體的數量。
+	stats       PoolStats               // 使用統計。
+	mutex       sync.Mutex              // 互斥鎖。
+}
+
+// 產生新的實體並登記其狀態。呼叫方應持有互斥鎖。
+func (pool *myPool) newEntity() (Entity, error) {
+	entity := pool.genEntity()
+	if entity == nil {
+		return nil, errors.New("The entity generator returned nil!\n")
+	}
+	if _, ok := pool.states[entity.Id()]; ok {
+		errMsg := fmt.Sprintf("The entity (id=%d) is duplicate!\n", entity.Id())
+		return nil, errors.New(errMsg)
+	}
+	pool.states[entity.Id()] = &entityState{entity: entity}
+	return entity, nil
+}
+
+// 把閒置的實體交給等待中的取出者，或者放入閒置實體的容器。呼叫方應持有互斥鎖。
+func (pool *myPool) release(entity Entity) {
+	if len(pool.waiters) > 0 {
+		waiter := pool.waiters[0]
+		pool.waiters = pool.waiters[1:]
+		pool.markTaken(entity)
+		waiter <- entity
+		return
+	}
+	pool.idle = append(pool.idle, entity)
+}
+
+// 把實體標記為已取出。呼叫方應持有互斥鎖。
+func (pool *myPool) markTaken(entity Entity) {
+	state := pool.states[entity.Id()]
+	state.inUse = true
+	state.takenAt = time.Now()
+	pool.used++
+}
+
+// 為等待中的取出者補足因替換失敗而空出的位置。呼叫方應持有互斥鎖。
+func (pool *myPool) refill() {
+	for len(pool.waiters) > 0 && uint32(len(pool.states)) < pool.total {
+		entity, err := pool.newEntity()
+		if err != nil {
+			logger.Warnf("Refill the pool failing: %s", err)
+			return
+		}
+		pool.release(entity)
+	}
+}
+
+// 檢查實體的健康狀態，並在必要時替換它。呼叫方應持有互斥鎖。
+// 若果實體被丟棄且無法替換，那麼結果值為nil。此時空出的位置會在之後的取出動作中，
+// 或由等待中的取出者週期性的補足。
+func (pool *myPool) checkOrReplace(entity Entity) Entity {
+	if pool.healthCheck == nil || pool.healthCheck(entity) {
+		return entity
+	}
+	delete(pool.states, entity.Id())
+	pool.stats.ReplaceCount++
+	newEntity, err := pool.newEntity()
+	if err != nil {
+		logger.Warnf("Replace the unhealthy entity (id=%d) failing: %s", entity.Id(), err)
+		return nil
+	}
+	return newEntity
 }
 
 func (pool *myPool) Take() (Entity, error) {
-	entity, ok := <-pool.container
-	if !ok {
-		return nil, errors.New("The inner container is invalid!")
+	return pool.TakeContext(context.Background())
+}
+
+func (pool *myPool) TakeTimeout(timeout time.Duration) (Entity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return pool.TakeContext(ctx)
+}
+
+func (pool *myPool) TakeContext(ctx context.Context) (Entity, error) {
+	begin := time.Now()
+	pool.mutex.Lock()
+	for len(pool.idle) > 0 {
+		entity := pool.idle[len(pool.idle)-1]
+		pool.idle = pool.idle[:len(pool.idle)-1]
+		if entity = pool.checkOrReplace(entity); entity == nil {
+			continue
+		}
+		pool.markTaken(entity)
+		pool.recordTake(begin)
+		pool.mutex.Unlock()
+		return entity, nil
+	}
+	// 補足因替換失敗而空出的位置。
+	if uint32(len(pool.states)) < pool.total {
+		entity, err := pool.newEntity()
+		if err == nil {
+			pool.markTaken(entity)
+			pool.recordTake(begin)
+			pool.mutex.Unlock()
+			return entity, nil
+		}
+		logger.Warnf("Refill the pool failing: %s", err)
+	}
+	waiter := make(chan Entity, 1)
+	pool.waiters = append(pool.waiters, waiter)
+	pool.mutex.Unlock()
+
+	// 若果實體產生函數暫時失敗，那麼可能不會再有實體被歸還，因此需要週期性的嘗試補足空出的位置。
+	ticker := time.NewTicker(POOL_REFILL_INTERVAL)
+	defer ticker.Stop()
+	for waiting := true; waiting; {
+		select {
+		case entity := <-waiter:
+			pool.mutex.Lock()
+			pool.recordTake(begin)
+			pool.mutex.Unlock()
+			return entity, nil
+		case <-ticker.C:
+			pool.mutex.Lock()
+			pool.refill()
+			pool.mutex.Unlock()
+		case <-ctx.Done():
+			waiting = false
+		}
 	}
 	pool.mutex.Lock()
 	defer pool.mutex.Unlock()
-	pool.idContainer[entity.Id()] = false
-	return entity, nil
+	pool.stats.TimeoutCount++
+	for i, w := range pool.waiters {
+		if w == waiter {
+			pool.waiters = append(pool.waiters[:i], pool.waiters[i+1:]...)
+			return nil, ctx.Err()
+		}
+	}
+	// 實體已在取消之前被交給了該取出者，需要將其放回。
+	entity := <-waiter
+	pool.states[entity.Id()].inUse = false
+	pool.used--
+	pool.release(entity)
+	return nil, ctx.Err()
+}
+
+// 記錄一次成功的取出。呼叫方應持有互斥鎖。
+func (pool *myPool) recordTake(begin time.Time) {
+	wait := time.Since(begin)
+	pool.stats.TakeCount++
+	pool.stats.WaitTime += wait
+	if wait > pool.stats.MaxWaitTime {
+		pool.stats.MaxWaitTime = wait
+	}
 }
 
 func (pool *myPool) Return(entity Entity) error {
 	if entity == nil {
 		return errors.New("The returning entity is invalid!")
 	}
-	if pool.etype != reflect.TypeOf(entity) {
-		errMsg := fmt.Sprintf("The type of returning entity is NOT %s!\n", pool.etype)
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	entityId := entity.Id()
+	state, ok := pool.states[entityId]
+	if !ok || state.entity != entity {
+		errMsg := fmt.Sprintf("The entity (id=%d) is illegal!\n", entityId)
 		return errors.New(errMsg)
 	}
-	entityId := entity.Id()
-	casResult := pool.compareAndSetForIdContainer(entityId, false, true)
-	if casResult == 1 {
-		pool.container <- entity
-		return nil
-	} else if casResult == 0 {
+	if !state.inUse {
 		errMsg := fmt.Sprintf("The entity (id=%d) is already in the pool!\n", entityId)
 		return errors.New(errMsg)
-	} else {
-		errMsg := fmt.Sprintf("The entity (id=%d) is illegal!\n", entityId)
-		return errors.New(errMsg)
 	}
+	state.inUse = false
+	pool.used--
+	pool.stats.ReturnCount++
+	pool.stats.HoldTime += time.Since(state.takenAt)
+	// 容量已被縮小時，多餘的實體會被丟棄。
+	if uint32(len(pool.states)) > pool.total {
+		delete(pool.states, entityId)
+		return nil
+	}
+	if entity = pool.checkOrReplace(entity); entity == nil {
+		pool.refill()
+		return nil
+	}
+	pool.release(entity)
+	return nil
 }
 
-// 比較並設定實體ID容器中與指定實體ID對應的鍵值對的元素值。
-// 結果值：
-//       -1：表示鍵值對不存在。
-//        0：表示動作失敗。
-//        1：表示動作成功。
-func (pool *myPool) compareAndSetForIdContainer(
-	entityId uint32, oldValue bool, newValue bool) int8 {
+func (pool *myPool) Total() uint32 {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return pool.total
+}
+
+func (pool *myPool) Used() uint32 {
 	pool.mutex.Lock()
 	defer pool.mutex.Unlock()
-	v, ok := pool.idContainer[entityId]
-	if !ok {
-		return -1
+	return pool.used
+}
+
+func (pool *myPool) Resize(total uint32) error {
+	if total == 0 {
+		errMsg := fmt.Sprintf("The pool can not be resized! (total=%d)\n", total)
+		return errors.New(errMsg)
 	}
-	if v != oldValue {
-		return 0
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.total = total
+	// 丟棄多餘的閒置實體。
+	for uint32(len(pool.states)) > total && len(pool.idle) > 0 {
+		entity := pool.idle[len(pool.idle)-1]
+		pool.idle = pool.idle[:len(pool.idle)-1]
+		delete(pool.states, entity.Id())
+	}
+	// 補足實體。
+	for uint32(len(pool.states)) < total {
+		entity, err := pool.newEntity()
+		if err != nil {
+			return err
+		}
+		pool.release(entity)
 	}
-	pool.idContainer[entityId] = newValue
-	return 1
+	return nil
 }
 
-func (pool *myPool) Total() uint32 {
-	return pool.total
+func (pool *myPool) SetHealthCheck(check HealthCheck) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.healthCheck = check
 }
 
-func (pool *myPool) Used() uint32 {
-	return pool.total - uint32(len(pool.container))
+func (pool *myPool) Stats() PoolStats {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	stats := pool.stats
+	stats.Total = pool.total
+	stats.Used = pool.used
+	stats.Waiting = uint32(len(pool.waiters))
+	return stats
 }
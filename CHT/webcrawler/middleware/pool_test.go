@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 測試用的實體。
+type testEntity struct {
+	id     uint32
+	broken bool
+}
+
+func (entity *testEntity) Id() uint32 {
+	return entity.id
+}
+
+// 建立測試用的實體產生函數。
+func newTestEntityGenerator() func() Entity {
+	var sn uint32
+	return func() Entity {
+		return &testEntity{id: atomic.AddUint32(&sn, 1)}
+	}
+}
+
+func TestPoolTakeAndReturn(t *testing.T) {
+	if _, err := NewPool(0, newTestEntityGenerator()); err == nil {
+		t.Errorf("ERROR: No error with zero total!\n")
+	}
+	pool, err := NewPool(2, newTestEntityGenerator())
+	if err != nil {
+		t.Fatalf("ERROR: Pool initialization failing: %s\n", err)
+	}
+	e1, _ := pool.Take()
+	e2, _ := pool.Take()
+	if pool.Used() != 2 || pool.Total() != 2 {
+		t.Errorf("ERROR: The pool usage is %d/%d, but should be 2/2!\n", pool.Used(), pool.Total())
+	}
+	if _, err := pool.TakeTimeout(20 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("ERROR: Unexpected error when taking from exhausted pool: %v!\n", err)
+	}
+	if err := pool.Return(&testEntity{id: e1.Id()}); err == nil {
+		t.Errorf("ERROR: No error when returning a foreign entity!\n")
+	}
+	if err := pool.Return(e1); err != nil {
+		t.Errorf("ERROR: Returning entity failing: %s\n", err)
+	}
+	if err := pool.Return(e1); err == nil {
+		t.Errorf("ERROR: No error when returning an entity twice!\n")
+	}
+	// 等待中的取出者會在實體被歸還後立即得到它。
+	taken := make(chan Entity)
+	go func() {
+		pool.Take()
+		entity, _ := pool.Take()
+		taken <- entity
+	}()
+	time.Sleep(20 * time.Millisecond)
+	pool.Return(e2)
+	select {
+	case entity := <-taken:
+		if entity != e2 {
+			t.Errorf("ERROR: The waiting taker got entity %d, but should got %d!\n", entity.Id(), e2.Id())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("ERROR: The waiting taker is not woken up!\n")
+	}
+	stats := pool.Stats()
+	if stats.TakeCount != 4 || stats.ReturnCount != 2 || stats.TimeoutCount != 1 ||
+		stats.MaxWaitTime < 10*time.Millisecond {
+		t.Errorf("ERROR: Unexpected stats: %s!\n", stats)
+	}
+}
+
+func TestPoolResize(t *testing.T) {
+	pool, _ := NewPool(2, newTestEntityGenerator())
+	e1, _ := pool.Take()
+	e2, _ := pool.Take()
+	if err := pool.Resize(0); err == nil {
+		t.Errorf("ERROR: No error when resizing to zero!\n")
+	}
+	if err := pool.Resize(3); err != nil {
+		t.Fatalf("ERROR: Resizing pool failing: %s\n", err)
+	}
+	e3, err := pool.TakeTimeout(10 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("ERROR: No new entity after growing: %s\n", err)
+	}
+	// 縮小容量後，被歸還的實體會被丟棄，直到實體的數量符合容量。
+	pool.Resize(1)
+	pool.Return(e1)
+	pool.Return(e2)
+	if pool.Total() != 1 || pool.Used() != 1 {
+		t.Errorf("ERROR: The pool usage is %d/%d, but should be 1/1!\n", pool.Used(), pool.Total())
+	}
+	if _, err := pool.TakeTimeout(10 * time.Millisecond); err == nil {
+		t.Errorf("ERROR: The discarded entity is still in the pool!\n")
+	}
+	pool.Return(e3)
+	if entity, err := pool.TakeTimeout(10 * time.Millisecond); err != nil || entity != e3 {
+		t.Errorf("ERROR: The remaining entity can not be taken: %v!\n", err)
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	pool, _ := NewPool(1, newTestEntityGenerator())
+	pool.SetHealthCheck(func(entity Entity) bool {
+		return !entity.(*testEntity).broken
+	})
+	e1, _ := pool.Take()
+	e1.(*testEntity).broken = true
+	pool.Return(e1)
+	e2, _ := pool.Take()
+	if e2 == e1 || e2.(*testEntity).broken {
+		t.Errorf("ERROR: The broken entity is not replaced!\n")
+	}
+	if err := pool.Return(e1); err == nil {
+		t.Errorf("ERROR: No error when returning a replaced entity!\n")
+	}
+	if replaced := pool.Stats().ReplaceCount; replaced != 1 {
+		t.Errorf("ERROR: The replaced number is %d, but should be 1!\n", replaced)
+	}
+}
+
+func TestPoolFailedReplacement(t *testing.T) {
+	gen := newTestEntityGenerator()
+	failing := false
+	pool, _ := NewPool(1, func() Entity {
+		if failing {
+			return nil
+		}
+		return gen()
+	})
+	pool.SetHealthCheck(func(entity Entity) bool {
+		return !entity.(*testEntity).broken
+	})
+	e1, _ := pool.Take()
+	e1.(*testEntity).broken = true
+	failing = true
+	pool.Return(e1)
+	// 替換失敗時，空出的位置會在實體產生函數恢復後被補足。
+	if _, err := pool.TakeTimeout(20 * time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("ERROR: Unexpected error when the entity can not be generated: %v!\n", err)
+	}
+	failing = false
+	e2, err := pool.TakeTimeout(20 * time.Millisecond)
+	if err != nil || e2 == e1 {
+		t.Fatalf("ERROR: The lost slot is not refilled! (err=%v)\n", err)
+	}
+	if pool.Used() != 1 || pool.Total() != 1 {
+		t.Errorf("ERROR: The pool usage is %d/%d, but should be 1/1!\n", pool.Used(), pool.Total())
+	}
+}
+
+func TestPoolWaiterRefill(t *testing.T) {
+	gen := newTestEntityGenerator()
+	var failing int32
+	pool, _ := NewPool(1, func() Entity {
+		if atomic.LoadInt32(&failing) != 0 {
+			return nil
+		}
+		return gen()
+	})
+	pool.SetHealthCheck(func(entity Entity) bool {
+		return !entity.(*testEntity).broken
+	})
+	e1, _ := pool.Take()
+	taken := make(chan Entity)
+	go func() {
+		entity, _ := pool.Take()
+		taken <- entity
+	}()
+	for pool.Stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// 替換失敗之後不會再有實體被歸還，等待中的取出者應在實體產生函數恢復後自行補足空出的位置。
+	e1.(*testEntity).broken = true
+	atomic.StoreInt32(&failing, 1)
+	pool.Return(e1)
+	select {
+	case <-taken:
+		t.Fatalf("ERROR: The waiter got an entity when the entity can not be generated!\n")
+	case <-time.After(2 * POOL_REFILL_INTERVAL):
+	}
+	atomic.StoreInt32(&failing, 0)
+	select {
+	case e2 := <-taken:
+		if e2 == nil || e2 == e1 {
+			t.Errorf("ERROR: The waiter got a wrong entity %v!\n", e2)
+		}
+	case <-time.After(10 * POOL_REFILL_INTERVAL):
+		t.Fatalf("ERROR: The waiter is blocked after the entity generator recovers!\n")
+	}
+	if pool.Used() != 1 || pool.Stats().Waiting != 0 {
+		t.Errorf("ERROR: The pool stats %s are wrong!\n", pool.Stats())
+	}
+}
+
+func TestPoolConcurrency(t *testing.T) {
+	pool, _ := NewPool(3, newTestEntityGenerator())
+	var inUse, maxInUse int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				entity, err := pool.TakeTimeout(time.Duration(i%3) * time.Millisecond)
+				if err != nil {
+					continue
+				}
+				current := atomic.AddInt32(&inUse, 1)
+				for {
+					max := atomic.LoadInt32(&maxInUse)
+					if current <= max || atomic.CompareAndSwapInt32(&maxInUse, max, current) {
+						break
+					}
+				}
+				atomic.AddInt32(&inUse, -1)
+				if err := pool.Return(entity); err != nil {
+					t.Errorf("ERROR: Returning entity failing: %s\n", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if maxInUse > 3 {
+		t.Errorf("ERROR: %d entities are used at the same time!\n", maxInUse)
+	}
+	stats := pool.Stats()
+	if stats.Used != 0 || stats.Waiting != 0 || stats.TakeCount != stats.ReturnCount {
+		t.Errorf("ERROR: Unexpected stats after all returned: %s!\n", stats)
+	}
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"fmt"
+	"logging"
+	"sync"
+	base "webcrawler/base"
+)
+
+// 日志記錄器。
+var logger logging.Logger = base.NewLogger()
+
+// 已見集合的接口型態。它被用來判斷某個鍵（例如請求的URL）是否已經出現過。
+type SeenSet interface {
+	// 加入鍵。若果該鍵先前已經（或可能已經）存在，那麼該方法會傳回false。
+	// 判斷與加入是一個原子動作，因此多個呼叫方同時加入同一個鍵時只有一個會得到true。
+	Add(key string) bool
+	// 判斷鍵是否已經（或可能已經）存在。
+	Contains(key string) bool
+	// 獲得已加入的鍵的數量。對於機率性的實現，該值可能略小於實際值。
+	Len() uint64
+	// 關閉已見集合並釋放其持有的資源。
+	Close() error
+	// 取得摘要訊息。
+	Summary() string
+}
+
+// 建立基於記憶體的精確的已見集合。
+func NewMemorySeenSet() SeenSet {
+	return &memorySeenSet{m: make(map[string]struct{})}
+}
+
+// 基於記憶體的精確的已見集合。
+type memorySeenSet struct {
+	m       map[string]struct{} // 鍵的容器。
+	rwmutex sync.RWMutex        // 讀寫鎖。
+}
+
+func (ss *memorySeenSet) Add(key string) bool {
+	ss.rwmutex.Lock()
+	defer ss.rwmutex.Unlock()
+	if _, ok := ss.m[key]; ok {
+		return false
+	}
+	ss.m[key] = struct{}{}
+	return true
+}
+
+func (ss *memorySeenSet) Contains(key string) bool {
+	ss.rwmutex.RLock()
+	defer ss.rwmutex.RUnlock()
+	_, ok := ss.m[key]
+	return ok
+}
+
+func (ss *memorySeenSet) Len() uint64 {
+	ss.rwmutex.RLock()
+	defer ss.rwmutex.RUnlock()
+	return uint64(len(ss.m))
+}
+
+func (ss *memorySeenSet) Close() error {
+	return nil
+}
+
+func (ss *memorySeenSet) Summary() string {
+	return fmt.Sprintf("type: memory, length: %d", ss.Len())
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// 測試已見集合的基本行為。
+// 參數maxFpRate代表允許的誤判率，對於精確的實現應為0。
+func testSeenSet(t *testing.T, ss SeenSet, number int, maxFpRate float64) {
+	falsePositives := 0
+	for i := 0; i < number; i++ {
+		key := fmt.Sprintf("http://example.com/p%d", i)
+		if !ss.Add(key) {
+			falsePositives++
+			if maxFpRate == 0 {
+				t.Errorf("ERROR: The new key %q is regarded as seen!\n", key)
+			}
+		}
+		if ss.Add(key) {
+			t.Errorf("ERROR: The repeated key %q is added again!\n", key)
+		}
+		if !ss.Contains(key) {
+			t.Errorf("ERROR: The added key %q is not contained!\n", key)
+		}
+	}
+	if rate := float64(falsePositives) / float64(number); rate > maxFpRate {
+		t.Errorf("ERROR: The false positive rate %v is beyond %v!\n", rate, maxFpRate)
+	}
+	if l := ss.Len(); l != uint64(number-falsePositives) {
+		t.Errorf("ERROR: The length is %d, but should be %d!\n", l, number-falsePositives)
+	}
+	// 並發加入同一個鍵時只有一個呼叫方會成功。
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	added := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ss.Add("http://example.com/concurrent") {
+				mutex.Lock()
+				added++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if added != 1 {
+		t.Errorf("ERROR: The concurrent key is added %d times!\n", added)
+	}
+}
+
+func TestMemorySeenSet(t *testing.T) {
+	testSeenSet(t, NewMemorySeenSet(), 1000, 0)
+}
+
+func TestBloomSeenSet(t *testing.T) {
+	if _, err := NewBloomSeenSet(0, 0.01); err == nil {
+		t.Errorf("ERROR: No error with zero capacity!\n")
+	}
+	if _, err := NewBloomSeenSet(100, 1); err == nil {
+		t.Errorf("ERROR: No error with invalid false positive rate!\n")
+	}
+	fpRate := 0.01
+	ss, err := NewBloomSeenSet(100, fpRate)
+	if err != nil {
+		t.Fatalf("ERROR: Bloom seen set initialization failing: %s\n", err)
+	}
+	// 加入的鍵遠多於初始容量，以觸發擴展。
+	testSeenSet(t, ss, 5000, fpRate*2)
+	falsePositives := 0
+	probes := 10000
+	for i := 0; i < probes; i++ {
+		if ss.Contains(fmt.Sprintf("http://example.org/q%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / float64(probes); rate > fpRate*2 {
+		t.Errorf("ERROR: The false positive rate %v is far beyond %v!\n", rate, fpRate)
+	}
+}
+
+func TestDiskSeenSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seenset")
+	if err != nil {
+		t.Fatalf("ERROR: Temp dir creation failing: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "seen.db")
+	ss, err := NewDiskSeenSet(path, 0)
+	if err != nil {
+		t.Fatalf("ERROR: Disk seen set initialization failing: %s\n", err)
+	}
+	// 加入的鍵多於初始槽數的一半，以觸發擴容。
+	number := 3000
+	testSeenSet(t, ss, number, 0)
+	if err := ss.Close(); err != nil {
+		t.Fatalf("ERROR: Disk seen set closing failing: %s\n", err)
+	}
+
+	// 重新開啟後應能延續之前的內容。
+	ss, err = NewDiskSeenSet(path, 0)
+	if err != nil {
+		t.Fatalf("ERROR: Disk seen set reopening failing: %s\n", err)
+	}
+	defer ss.Close()
+	if l := ss.Len(); l != uint64(number+1) {
+		t.Errorf("ERROR: The reopened length is %d, but should be %d!\n", l, number+1)
+	}
+	for i := 0; i < number; i++ {
+		key := fmt.Sprintf("http://example.com/p%d", i)
+		if ss.Add(key) {
+			t.Errorf("ERROR: The persisted key %q is added again!\n", key)
+		}
+	}
+	if !ss.Add("http://example.com/new") {
+		t.Errorf("ERROR: The new key is regarded as seen after reopening!\n")
+	}
+
+	// 未被關閉的檔案在重新開啟時，其中的鍵數會被重新計算。
+	unclosedPath := filepath.Join(dir, "unclosed.db")
+	unclosed, err := NewDiskSeenSet(unclosedPath, 0)
+	if err != nil {
+		t.Fatalf("ERROR: Disk seen set initialization failing: %s\n", err)
+	}
+	for i := 0; i < 10; i++ {
+		unclosed.Add(fmt.Sprintf("http://example.com/u%d", i))
+	}
+	reopened, err := NewDiskSeenSet(unclosedPath, 0)
+	if err != nil {
+		t.Fatalf("ERROR: Disk seen set reopening failing: %s\n", err)
+	}
+	if l := reopened.Len(); l != 10 {
+		t.Errorf("ERROR: The length of the unclosed file is %d, but should be %d!\n", l, 10)
+	}
+	reopened.Close()
+	unclosed.Close()
+
+	// 擴容失敗時仍繼續使用原檔案。
+	failingPath := filepath.Join(dir, "failing.db")
+	os.Mkdir(failingPath+".tmp", 0755)
+	failing, err := NewDiskSeenSet(failingPath, 0)
+	if err != nil {
+		t.Fatalf("ERROR: Disk seen set initialization failing: %s\n", err)
+	}
+	defer failing.Close()
+	for i := 0; i < diskSetMinSlots/2+10; i++ {
+		key := fmt.Sprintf("http://example.com/f%d", i)
+		if !failing.Add(key) || !failing.Contains(key) {
+			t.Fatalf("ERROR: The key %q can not be added after failed growing!\n", key)
+		}
+	}
+
+	bad := filepath.Join(dir, "bad.db")
+	ioutil.WriteFile(bad, []byte("not a seen set file at all"), 0644)
+	if _, err := NewDiskSeenSet(bad, 0); err == nil {
+		t.Errorf("ERROR: No error with broken file!\n")
+	}
+}
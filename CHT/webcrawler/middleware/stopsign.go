@@ -51,6 +51,8 @@ func (ss *myStopSign) Sign() bool {
 }
 
 func (ss *myStopSign) Signed() bool {
+	ss.rwmutex.RLock()
+	defer ss.rwmutex.RUnlock()
 	return ss.signed
 }
 
@@ -76,13 +78,13 @@ func (ss *myStopSign) Deal(code string) {
 
 func (ss *myStopSign) DealCount(code string) uint32 {
 	ss.rwmutex.RLock()
-	defer ss.rwmutex.Unlock()
+	defer ss.rwmutex.RUnlock()
 	return ss.dealCountMap[code]
 }
 
 func (ss *myStopSign) DealTotal() uint32 {
 	ss.rwmutex.RLock()
-	defer ss.rwmutex.Unlock()
+	defer ss.rwmutex.RUnlock()
 	var total uint32
 	for _, v := range ss.dealCountMap {
 		total += v
@@ -91,6 +93,8 @@ func (ss *myStopSign) DealTotal() uint32 {
 }
 
 func (ss *myStopSign) Summary() string {
+	ss.rwmutex.RLock()
+	defer ss.rwmutex.RUnlock()
 	if ss.signed {
 		return fmt.Sprintf("signed: true, dealCount: %v", ss.dealCountMap)
 	} else {
@@ -0,0 +1,311 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 陷阱的種類的型態。
+type TrapKind uint8
+
+const (
+	TRAP_KIND_SEGMENT_REPETITION TrapKind = 0 // 路徑中的某個片段重復出現過多次，例如：/a/b/a/b/a/b。
+	TRAP_KIND_URL_LENGTH         TrapKind = 1 // URL過長。
+	TRAP_KIND_QUERY_COMBINATIONS TrapKind = 2 // 同一路徑範本下的查詢參數組合過多，例如：分面搜尋。
+	TRAP_KIND_PATTERN_URLS       TrapKind = 3 // 同一URL範本下的URL過多，例如：日曆和會話ID。
+)
+
+// 表示陷阱的種類與其名稱之間的映射關系的字典。
+var trapKindNameMap = map[TrapKind]string{
+	TRAP_KIND_SEGMENT_REPETITION: "segment-repetition",
+	TRAP_KIND_URL_LENGTH:         "url-length",
+	TRAP_KIND_QUERY_COMBINATIONS: "query-combinations",
+	TRAP_KIND_PATTERN_URLS:       "pattern-urls",
+}
+
+func (kind TrapKind) String() string {
+	if name, ok := trapKindNameMap[kind]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", kind)
+}
+
+// 摘要訊息中列出的陷阱的最大數量。
+const TRAP_SUMMARY_LIMIT = 5
+
+// 被視為ID的路徑片段的最小長度。
+const TRAP_ID_SEGMENT_MIN_LENGTH = 16
+
+// 陷阱檢測器參數容器的描述範本。
+var trapDetectorArgsTemplate string = "{ maxSegmentRepeats: %d, maxUrlLength: %d," +
+	" maxQueryCombinations: %d, maxUrlsPerPattern: %d }"
+
+// 陷阱檢測器參數的容器。各項限制為0時表示不限制。
+type TrapDetectorArgs struct {
+	maxSegmentRepeats    uint32 // 同一路徑片段的最大出現次數。
+	maxUrlLength         uint32 // URL的最大長度。
+	maxQueryCombinations uint32 // 每個路徑範本下不同的查詢參數組合的最大數量。
+	maxUrlsPerPattern    uint32 // 每個URL範本下的URL的最大數量。
+	description          string // 描述。
+}
+
+// 建立陷阱檢測器參數的容器。各項限制為0時表示不限制。
+// 路徑範本由主機和路徑組成，路徑中的數字會被替換為{n}，過長的並且包含數字的片段會被替換為{id}，
+// 片段中的路徑參數（例如;jsessionid=...）會被替換為{params}。
+// URL範本由路徑範本和排序後的查詢參數的名稱組成，查詢參數的值不在其中。
+func NewTrapDetectorArgs(
+	maxSegmentRepeats uint32,
+	maxUrlLength uint32,
+	maxQueryCombinations uint32,
+	maxUrlsPerPattern uint32) TrapDetectorArgs {
+	return TrapDetectorArgs{
+		maxSegmentRepeats:    maxSegmentRepeats,
+		maxUrlLength:         maxUrlLength,
+		maxQueryCombinations: maxQueryCombinations,
+		maxUrlsPerPattern:    maxUrlsPerPattern,
+	}
+}
+
+func (args *TrapDetectorArgs) Check() error {
+	if args.maxSegmentRepeats == 0 && args.maxUrlLength == 0 &&
+		args.maxQueryCombinations == 0 && args.maxUrlsPerPattern == 0 {
+		return errors.New("At least one limit of trap detector should be set!\n")
+	}
+	return nil
+}
+
+func (args *TrapDetectorArgs) String() string {
+	if args.description == "" {
+		args.description =
+			fmt.Sprintf(trapDetectorArgsTemplate,
+				args.maxSegmentRepeats,
+				args.maxUrlLength,
+				args.maxQueryCombinations,
+				args.maxUrlsPerPattern)
+	}
+	return args.description
+}
+
+// 獲得同一路徑片段的最大出現次數。
+func (args *TrapDetectorArgs) MaxSegmentRepeats() uint32 {
+	return args.maxSegmentRepeats
+}
+
+// 獲得URL的最大長度。
+func (args *TrapDetectorArgs) MaxUrlLength() uint32 {
+	return args.maxUrlLength
+}
+
+// 獲得每個路徑範本下不同的查詢參數組合的最大數量。
+func (args *TrapDetectorArgs) MaxQueryCombinations() uint32 {
+	return args.maxQueryCombinations
+}
+
+// 獲得每個URL範本下的URL的最大數量。
+func (args *TrapDetectorArgs) MaxUrlsPerPattern() uint32 {
+	return args.maxUrlsPerPattern
+}
+
+// 被觸發的陷阱。
+type Trap struct {
+	Kind    TrapKind // 種類。
+	Pattern string   // 觸發陷阱的範本。對於與範本無關的種類，則為路徑範本。
+	Example string   // 第一個觸發陷阱的URL。
+	Hits    uint64   // 被拒絕的URL的數量。
+}
+
+func (trap Trap) String() string {
+	return fmt.Sprintf("%s %s (%d)", trap.Kind, trap.Pattern, trap.Hits)
+}
+
+// 陷阱檢測器的接口型態。
+// 它以啟發式的方法判斷URL是否屬於無窮的URL空間（例如：日曆、會話ID和分面搜尋）。
+type TrapDetector interface {
+	// 檢查URL。若果URL觸發了陷阱，那麼第二個結果值為true。
+	// 該方法應只對尚未請求過的URL被呼叫，因為通過檢查的URL會被計入範本的URL數量。
+	Check(u *url.URL) (Trap, bool)
+	// 獲得已被觸發的陷阱，按被拒絕的URL的數量從多到少排序。
+	Traps() []Trap
+	// 取得摘要訊息。
+	Summary() string
+}
+
+// 建立陷阱檢測器。
+func NewTrapDetector(args TrapDetectorArgs) (TrapDetector, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	return &myTrapDetector{
+		args:         args,
+		combinations: make(map[string]map[string]struct{}),
+		patternUrls:  make(map[string]uint32),
+		traps:        make(map[string]*Trap),
+	}, nil
+}
+
+// 陷阱檢測器的實現型態。
+type myTrapDetector struct {
+	args         TrapDetectorArgs               // 參數。
+	combinations map[string]map[string]struct{} // 路徑範本與其下的查詢參數組合的集合的對應。
+	patternUrls  map[string]uint32              // URL範本與其下的URL的數量的對應。
+	traps        map[string]*Trap               // 種類和範本與被觸發的陷阱的對應。
+	checked      uint64                         // 已檢查的URL的數量。
+	trapped      uint64                         // 被拒絕的URL的數量。
+	mutex        sync.Mutex                     // 互斥鎖。
+}
+
+func (detector *myTrapDetector) Check(u *url.URL) (Trap, bool) {
+	pathPattern := strings.ToLower(u.Host) + templatePath(u.EscapedPath())
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	detector.checked++
+	args := detector.args
+	if args.maxUrlLength > 0 && uint32(len(u.String())) > args.maxUrlLength {
+		return detector.trap(TRAP_KIND_URL_LENGTH, pathPattern, u), true
+	}
+	if args.maxSegmentRepeats > 0 && maxSegmentRepeats(u.EscapedPath()) > args.maxSegmentRepeats {
+		return detector.trap(TRAP_KIND_SEGMENT_REPETITION, pathPattern, u), true
+	}
+	combination := queryCombination(u.Query())
+	if args.maxQueryCombinations > 0 && combination != "" {
+		combinations, ok := detector.combinations[pathPattern]
+		if !ok {
+			combinations = make(map[string]struct{})
+			detector.combinations[pathPattern] = combinations
+		}
+		if _, ok := combinations[combination]; !ok {
+			if uint32(len(combinations)) >= args.maxQueryCombinations {
+				return detector.trap(TRAP_KIND_QUERY_COMBINATIONS, pathPattern, u), true
+			}
+			combinations[combination] = struct{}{}
+		}
+	}
+	urlPattern := pathPattern
+	if combination != "" {
+		urlPattern += "?" + combination
+	}
+	if args.maxUrlsPerPattern > 0 {
+		if detector.patternUrls[urlPattern] >= args.maxUrlsPerPattern {
+			return detector.trap(TRAP_KIND_PATTERN_URLS, urlPattern, u), true
+		}
+		detector.patternUrls[urlPattern]++
+	}
+	return Trap{}, false
+}
+
+// 記錄被觸發的陷阱。該方法應在持有互斥鎖時被呼叫。
+func (detector *myTrapDetector) trap(kind TrapKind, pattern string, u *url.URL) Trap {
+	detector.trapped++
+	key := kind.String() + " " + pattern
+	trap, ok := detector.traps[key]
+	if !ok {
+		trap = &Trap{Kind: kind, Pattern: pattern, Example: u.String()}
+		detector.traps[key] = trap
+	}
+	trap.Hits++
+	return *trap
+}
+
+func (detector *myTrapDetector) Traps() []Trap {
+	detector.mutex.Lock()
+	traps := make([]Trap, 0, len(detector.traps))
+	for _, trap := range detector.traps {
+		traps = append(traps, *trap)
+	}
+	detector.mutex.Unlock()
+	sort.Slice(traps, func(i, j int) bool {
+		if traps[i].Hits != traps[j].Hits {
+			return traps[i].Hits > traps[j].Hits
+		}
+		return traps[i].String() < traps[j].String()
+	})
+	return traps
+}
+
+var trapSummaryTemplate = "checked: %d, trapped: %d, traps: [%s]"
+
+func (detector *myTrapDetector) Summary() string {
+	traps := detector.Traps()
+	parts := make([]string, 0, TRAP_SUMMARY_LIMIT+1)
+	for i, trap := range traps {
+		if i == TRAP_SUMMARY_LIMIT {
+			parts = append(parts, fmt.Sprintf("...(%d more)", len(traps)-i))
+			break
+		}
+		parts = append(parts, trap.String())
+	}
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	return fmt.Sprintf(trapSummaryTemplate, detector.checked, detector.trapped, strings.Join(parts, "; "))
+}
+
+// 產生路徑範本。
+func templatePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		var params string
+		if index := strings.Index(segment, ";"); index >= 0 {
+			segment, params = segment[:index], ";{params}"
+		}
+		segments[i] = templateSegment(segment) + params
+	}
+	return strings.Join(segments, "/")
+}
+
+// 產生路徑片段的範本。
+func templateSegment(segment string) string {
+	hasDigit := strings.IndexAny(segment, "0123456789") >= 0
+	if !hasDigit {
+		return segment
+	}
+	if len(segment) >= TRAP_ID_SEGMENT_MIN_LENGTH {
+		return "{id}"
+	}
+	var buf strings.Builder
+	inDigits := false
+	for _, r := range segment {
+		if r >= '0' && r <= '9' {
+			if !inDigits {
+				buf.WriteString("{n}")
+				inDigits = true
+			}
+			continue
+		}
+		inDigits = false
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// 獲得路徑中同一片段的最大出現次數。空片段不被計入。
+func maxSegmentRepeats(path string) uint32 {
+	counts := make(map[string]uint32)
+	var max uint32
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		counts[segment]++
+		if counts[segment] > max {
+			max = counts[segment]
+		}
+	}
+	return max
+}
+
+// 獲得查詢參數的組合，即：排序後的查詢參數的名稱。
+func queryCombination(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "&")
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 檢查URL，並傳回觸發的陷阱的種類。未觸發陷阱時傳回空字串。
+func checkTrap(detector TrapDetector, rawUrl string) string {
+	u, _ := url.Parse(rawUrl)
+	trap, trapped := detector.Check(u)
+	if !trapped {
+		return ""
+	}
+	return trap.Kind.String()
+}
+
+func TestTemplatePath(t *testing.T) {
+	cases := map[string]string{
+		"/calendar/2017/01/02":               "/calendar/{n}/{n}/{n}",
+		"/page-12.html":                      "/page-{n}.html",
+		"/item/5f3c9a2b7d1e4f60a8b9c0d1e2f3": "/item/{id}",
+		"/cart;jsessionid=A1B2":              "/cart;{params}",
+		"/about/team":                        "/about/team",
+	}
+	for path, expected := range cases {
+		if pattern := templatePath(path); pattern != expected {
+			t.Errorf("ERROR: The template of path %s is %s, but should be %s!\n", path, pattern, expected)
+		}
+	}
+}
+
+func TestTrapDetector(t *testing.T) {
+	detector, err := NewTrapDetector(NewTrapDetectorArgs(2, 60, 3, 4))
+	if err != nil {
+		t.Fatalf("ERROR: Trap detector initialization failing: %s\n", err)
+	}
+	cases := []struct {
+		url  string
+		kind string
+	}{
+		{"http://a.com/x/y/x/y", ""},
+		{"http://a.com/x/y/x/y/x", "segment-repetition"},
+		{"http://a.com/" + strings.Repeat("z", 60), "url-length"},
+		// 分面搜尋：每個路徑範本下最多3種查詢參數組合。
+		{"http://a.com/search?color=red", ""},
+		{"http://a.com/search?size=m&color=red", ""},
+		{"http://a.com/search?color=blue&size=l", ""},
+		{"http://a.com/search?brand=x", ""},
+		{"http://a.com/search?brand=x&color=red", "query-combinations"},
+		{"http://a.com/search?brand=y", ""},
+	}
+	for i, c := range cases {
+		if kind := checkTrap(detector, c.url); kind != c.kind {
+			t.Errorf("ERROR: The trap of case [%d] %s is %q, but should be %q!\n", i, c.url, kind, c.kind)
+		}
+	}
+	// 日曆：每個URL範本下最多4個URL，查詢參數的值和路徑中的數字不影響範本。
+	for month := 1; month <= 6; month++ {
+		kind := checkTrap(detector, fmt.Sprintf("http://a.com/cal/2017?month=%d", month))
+		if (month <= 4 && kind != "") || (month > 4 && kind != "pattern-urls") {
+			t.Errorf("ERROR: The trap of month %d is %q!\n", month, kind)
+		}
+	}
+	if kind := checkTrap(detector, "http://a.com/cal/2018?month=1&day=1"); kind != "" {
+		t.Errorf("ERROR: The url with different query combination should not be trapped, but got %q!\n", kind)
+	}
+	traps := detector.Traps()
+	if len(traps) != 4 || traps[0].Kind != TRAP_KIND_PATTERN_URLS ||
+		traps[0].Pattern != "a.com/cal/{n}?month" || traps[0].Hits != 2 ||
+		traps[0].Example != "http://a.com/cal/2017?month=5" {
+		t.Errorf("ERROR: The traps %v are wrong!\n", traps)
+	}
+	summary := detector.Summary()
+	if !strings.HasPrefix(summary, "checked: 16, trapped: 5, traps: [pattern-urls a.com/cal/{n}?month (2);") {
+		t.Errorf("ERROR: The summary %q is wrong!\n", summary)
+	}
+	if _, err := NewTrapDetector(NewTrapDetectorArgs(0, 0, 0, 0)); err == nil {
+		t.Errorf("ERROR: The trap detector args without any limit should be invalid!\n")
+	}
+}
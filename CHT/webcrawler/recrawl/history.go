@@ -0,0 +1,70 @@
+package recrawl
+
+import (
+	"math"
+	"time"
+)
+
+// 每個URL保存的變化間隔的最大數量。
+const MAX_CHANGE_INTERVALS = 16
+
+// 網頁的爬取歷史。
+type PageHistory struct {
+	Url         string          `json:"url"`          // 網頁的URL。
+	FirstFetch  time.Time       `json:"first_fetch"`  // 第一次成功下載的時間。
+	LastFetch   time.Time       `json:"last_fetch"`   // 最近一次成功下載的時間。
+	LastChange  time.Time       `json:"last_change"`  // 最近一次發現內容變化的時間。
+	ContentHash string          `json:"content_hash"` // 最近一次下載的內容的雜湊值。
+	StatusCode  int             `json:"status_code"`  // 最近一次下載的狀態碼。
+	Fetches     uint64          `json:"fetches"`      // 成功下載的次數。
+	Changes     uint64          `json:"changes"`      // 發現內容變化的次數，不包括第一次下載。
+	Intervals   []time.Duration `json:"intervals"`    // 最近觀察到的變化間隔。
+}
+
+// 依據一次成功的下載更新歷史。
+// 傳回內容是否發生了變化。第一次下載不被視為變化。
+func (history *PageHistory) observe(fetchTime time.Time, statusCode int, contentHash string) bool {
+	history.StatusCode = statusCode
+	if history.Fetches == 0 {
+		history.FirstFetch = fetchTime
+		history.LastFetch = fetchTime
+		history.LastChange = fetchTime
+		history.ContentHash = contentHash
+		history.Fetches = 1
+		return false
+	}
+	history.Fetches++
+	history.LastFetch = fetchTime
+	if contentHash == history.ContentHash {
+		return false
+	}
+	history.Changes++
+	history.Intervals = append(history.Intervals, fetchTime.Sub(history.LastChange))
+	if len(history.Intervals) > MAX_CHANGE_INTERVALS {
+		history.Intervals = history.Intervals[len(history.Intervals)-MAX_CHANGE_INTERVALS:]
+	}
+	history.LastChange = fetchTime
+	history.ContentHash = contentHash
+	return true
+}
+
+// 估算每秒的變化次數。
+// 網頁的變化被視為泊松過程。由於兩次下載之間的多次變化只能被觀察到一次，
+// 這裡使用 Cho 與 Garcia-Molina 提出的偏差修正的估算式：
+// λ = -ln((n - X + 0.5) / (n + 0.5)) / I，其中n為下載間隔的數量，X為發現變化的次數，I為平均下載間隔。
+// 只下載過一次的網頁無法估算，此時傳回參數prior。
+func (history *PageHistory) changeRate(prior float64) float64 {
+	if history.Fetches < 2 {
+		return prior
+	}
+	n := float64(history.Fetches - 1)
+	x := float64(history.Changes)
+	if x > n {
+		x = n
+	}
+	interval := history.LastFetch.Sub(history.FirstFetch).Seconds() / n
+	if interval <= 0 {
+		return prior
+	}
+	return -math.Log((n-x+0.5)/(n+0.5)) / interval
+}
@@ -0,0 +1,284 @@
+package recrawl
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 重新爬取規劃參數容器的描述範本。
+var plannerArgsTemplate string = "{ defaultInterval: %s, maxInterval: %s, minPriority: %v }"
+
+// 重新爬取規劃參數的容器。
+type PlannerArgs struct {
+	defaultInterval time.Duration // 無法估算時所假設的平均變化間隔。
+	maxInterval     time.Duration // 估算的平均變化間隔的上限。
+	minPriority     float64       // 被列入種子清單的最低優先級。
+	description     string        // 描述。
+}
+
+// 建立重新爬取規劃參數的容器。
+// 參數defaultInterval代表只下載過一次的網頁所假設的平均變化間隔。
+// 參數maxInterval代表估算的平均變化間隔的上限，以免從未變化過的網頁永遠不被重新爬取。
+// 參數minPriority代表被列入種子清單的最低優先級，即：網頁自上次下載以來已變化的最低機率。
+func NewPlannerArgs(
+	defaultInterval time.Duration,
+	maxInterval time.Duration,
+	minPriority float64) PlannerArgs {
+	return PlannerArgs{
+		defaultInterval: defaultInterval,
+		maxInterval:     maxInterval,
+		minPriority:     minPriority,
+	}
+}
+
+func (args *PlannerArgs) Check() error {
+	if args.defaultInterval <= 0 {
+		return errors.New("The default change interval must be positive!\n")
+	}
+	if args.maxInterval < args.defaultInterval {
+		errMsg := fmt.Sprintf("The max change interval %s is less than the default %s!\n",
+			args.maxInterval, args.defaultInterval)
+		return errors.New(errMsg)
+	}
+	if args.minPriority < 0 || args.minPriority >= 1 {
+		errMsg := fmt.Sprintf("Invalid min priority %v!\n", args.minPriority)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+func (args *PlannerArgs) String() string {
+	if args.description == "" {
+		args.description =
+			fmt.Sprintf(plannerArgsTemplate,
+				args.defaultInterval,
+				args.maxInterval,
+				args.minPriority)
+	}
+	return args.description
+}
+
+// 獲得無法估算時所假設的平均變化間隔。
+func (args *PlannerArgs) DefaultInterval() time.Duration {
+	return args.defaultInterval
+}
+
+// 獲得估算的平均變化間隔的上限。
+func (args *PlannerArgs) MaxInterval() time.Duration {
+	return args.maxInterval
+}
+
+// 獲得被列入種子清單的最低優先級。
+func (args *PlannerArgs) MinPriority() float64 {
+	return args.minPriority
+}
+
+// 重新爬取的種子。
+type Seed struct {
+	Url            string        // 網頁的URL。
+	Priority       float64       // 優先級，即：網頁自上次下載以來已變化的機率。
+	ChangeInterval time.Duration // 估算的平均變化間隔。
+	LastFetch      time.Time     // 最近一次成功下載的時間。
+}
+
+// 重新爬取規劃器的接口型態。
+// 它記錄每個URL的下載歷史，估算其變化頻率，並為下一次爬取產生按優先級排序的種子清單。
+type Planner interface {
+	// 記錄一次下載。只有2xx狀態碼的下載才會被用來估算變化頻率。
+	// 傳回內容是否發生了變化。
+	Observe(url string, fetchTime time.Time, statusCode int, content []byte) bool
+	// 取得URL的下載歷史。
+	History(url string) (PageHistory, bool)
+	// 估算URL的平均變化間隔。
+	ChangeInterval(url string) time.Duration
+	// 產生在參數now所代表的時間進行爬取時的種子清單。
+	// 種子按優先級從高到低排序，參數limit為0時表示不限制數量。
+	// 最近一次下載的狀態碼為4xx的網頁不會被列入。
+	Plan(now time.Time, limit uint32) []Seed
+	// 獲得已記錄的URL的數量。
+	Len() uint64
+	// 以JSON的形式保存下載歷史。
+	Save(w io.Writer) error
+	// 取得摘要訊息。
+	Summary() string
+}
+
+// 建立重新爬取規劃器。
+func NewPlanner(args PlannerArgs) (Planner, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	return &myPlanner{args: args, histories: make(map[string]*PageHistory)}, nil
+}
+
+// 載入以 Planner.Save 保存的下載歷史，並以之建立重新爬取規劃器。
+func LoadPlanner(r io.Reader, args PlannerArgs) (Planner, error) {
+	planner, err := NewPlanner(args)
+	if err != nil {
+		return nil, err
+	}
+	var histories []PageHistory
+	if err := json.NewDecoder(r).Decode(&histories); err != nil {
+		errMsg := fmt.Sprintf("Recrawl history loading failing: %s\n", err)
+		return nil, errors.New(errMsg)
+	}
+	mp := planner.(*myPlanner)
+	for i := range histories {
+		mp.histories[histories[i].Url] = &histories[i]
+	}
+	return planner, nil
+}
+
+// 重新爬取規劃器的實現型態。
+type myPlanner struct {
+	args      PlannerArgs             // 參數。
+	histories map[string]*PageHistory // URL與下載歷史的對應。
+	observed  uint64                  // 本次執行中記錄的下載次數。
+	changed   uint64                  // 本次執行中發現的內容變化次數。
+	rwmutex   sync.RWMutex            // 讀寫鎖。
+}
+
+func (planner *myPlanner) Observe(url string, fetchTime time.Time, statusCode int, content []byte) bool {
+	planner.rwmutex.Lock()
+	defer planner.rwmutex.Unlock()
+	history, ok := planner.histories[url]
+	if !ok {
+		history = &PageHistory{Url: url}
+		planner.histories[url] = history
+	}
+	if statusCode < 200 || statusCode >= 300 {
+		history.StatusCode = statusCode
+		return false
+	}
+	// 忽略比已記錄的更早的下載。
+	if fetchTime.Before(history.LastFetch) {
+		return false
+	}
+	sum := sha1.Sum(content)
+	planner.observed++
+	changed := history.observe(fetchTime, statusCode, hex.EncodeToString(sum[:]))
+	if changed {
+		planner.changed++
+	}
+	return changed
+}
+
+func (planner *myPlanner) History(url string) (PageHistory, bool) {
+	planner.rwmutex.RLock()
+	defer planner.rwmutex.RUnlock()
+	history, ok := planner.histories[url]
+	if !ok {
+		return PageHistory{}, false
+	}
+	copied := *history
+	copied.Intervals = append([]time.Duration(nil), history.Intervals...)
+	return copied, true
+}
+
+func (planner *myPlanner) ChangeInterval(url string) time.Duration {
+	planner.rwmutex.RLock()
+	defer planner.rwmutex.RUnlock()
+	history, ok := planner.histories[url]
+	if !ok {
+		return planner.args.defaultInterval
+	}
+	return rateToInterval(planner.rate(history))
+}
+
+// 估算每秒的變化次數。估算值不會小於平均變化間隔的上限所對應的值。
+func (planner *myPlanner) rate(history *PageHistory) float64 {
+	prior := 1 / planner.args.defaultInterval.Seconds()
+	floor := 1 / planner.args.maxInterval.Seconds()
+	return math.Max(history.changeRate(prior), floor)
+}
+
+// 把每秒的變化次數轉換為平均變化間隔。
+func rateToInterval(rate float64) time.Duration {
+	return time.Duration(float64(time.Second) / rate)
+}
+
+func (planner *myPlanner) Plan(now time.Time, limit uint32) []Seed {
+	planner.rwmutex.RLock()
+	seeds := make([]Seed, 0, len(planner.histories))
+	for _, history := range planner.histories {
+		if history.Fetches == 0 || (history.StatusCode >= 400 && history.StatusCode < 500) {
+			continue
+		}
+		rate := planner.rate(history)
+		age := now.Sub(history.LastFetch).Seconds()
+		if age < 0 {
+			age = 0
+		}
+		// 泊松過程在age秒內至少變化一次的機率。
+		priority := 1 - math.Exp(-rate*age)
+		if priority < planner.args.minPriority || priority == 0 {
+			continue
+		}
+		seeds = append(seeds, Seed{
+			Url:            history.Url,
+			Priority:       priority,
+			ChangeInterval: rateToInterval(rate),
+			LastFetch:      history.LastFetch,
+		})
+	}
+	planner.rwmutex.RUnlock()
+	sort.Slice(seeds, func(i, j int) bool {
+		if seeds[i].Priority != seeds[j].Priority {
+			return seeds[i].Priority > seeds[j].Priority
+		}
+		return seeds[i].Url < seeds[j].Url
+	})
+	if limit > 0 && uint32(len(seeds)) > limit {
+		seeds = seeds[:limit]
+	}
+	return seeds
+}
+
+func (planner *myPlanner) Len() uint64 {
+	planner.rwmutex.RLock()
+	defer planner.rwmutex.RUnlock()
+	return uint64(len(planner.histories))
+}
+
+func (planner *myPlanner) Save(w io.Writer) error {
+	planner.rwmutex.RLock()
+	defer planner.rwmutex.RUnlock()
+	histories := make([]*PageHistory, 0, len(planner.histories))
+	for _, history := range planner.histories {
+		histories = append(histories, history)
+	}
+	sort.Slice(histories, func(i, j int) bool {
+		return histories[i].Url < histories[j].Url
+	})
+	return json.NewEncoder(w).Encode(histories)
+}
+
+var summaryTemplate = "urls: %d, observed: %d, changed: %d"
+
+func (planner *myPlanner) Summary() string {
+	planner.rwmutex.RLock()
+	defer planner.rwmutex.RUnlock()
+	return fmt.Sprintf(summaryTemplate, len(planner.histories), planner.observed, planner.changed)
+}
+
+// 依據種子清單建立HTTP請求。種子的順序會被保留。
+func SeedRequests(seeds []Seed) ([]*http.Request, error) {
+	reqs := make([]*http.Request, 0, len(seeds))
+	for _, seed := range seeds {
+		req, err := http.NewRequest("GET", seed.Url, nil)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
@@ -0,0 +1,115 @@
+package recrawl
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestChangeRateEstimation(t *testing.T) {
+	planner, err := NewPlanner(NewPlannerArgs(24*time.Hour, 30*24*time.Hour, 0.1))
+	if err != nil {
+		t.Fatalf("ERROR: Planner initialization failing: %s\n", err)
+	}
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 每天下載一次，共11次：/news每次都有變化，/weekly每5天變化一次，/static從不變化。
+	for day := 0; day <= 10; day++ {
+		fetchTime := start.Add(time.Duration(day) * 24 * time.Hour)
+		planner.Observe("http://a/news", fetchTime, 200, []byte(fmt.Sprintf("news %d", day)))
+		planner.Observe("http://a/weekly", fetchTime, 200, []byte(fmt.Sprintf("weekly %d", day/5)))
+		planner.Observe("http://a/static", fetchTime, 200, []byte("static"))
+	}
+	history, ok := planner.History("http://a/weekly")
+	if !ok || history.Fetches != 11 || history.Changes != 2 || len(history.Intervals) != 2 ||
+		history.Intervals[0] != 5*24*time.Hour {
+		t.Errorf("ERROR: The history %+v is wrong!\n", history)
+	}
+	news := planner.ChangeInterval("http://a/news")
+	weekly := planner.ChangeInterval("http://a/weekly")
+	static := planner.ChangeInterval("http://a/static")
+	if !(news < 24*time.Hour && news < weekly && weekly < static) {
+		t.Errorf("ERROR: The change intervals (%s, %s, %s) are not ordered!\n", news, weekly, static)
+	}
+	// 從不變化的網頁的估算值為上限。
+	if static != 30*24*time.Hour {
+		t.Errorf("ERROR: The change interval of static page is %s, but should be %s!\n", static, 30*24*time.Hour)
+	}
+	// 只下載過一次的網頁使用預設值。
+	planner.Observe("http://a/new", start, 200, []byte("new"))
+	if interval := planner.ChangeInterval("http://a/new"); interval != 24*time.Hour {
+		t.Errorf("ERROR: The change interval of new page is %s, but should be %s!\n", interval, 24*time.Hour)
+	}
+	// 驗證偏差修正的估算式：n=10, X=2, I=1天。
+	expected := -math.Log((10-2+0.5)/(10+0.5)) / (24 * time.Hour).Seconds()
+	if rate := float64(time.Second) / float64(weekly); math.Abs(rate-expected)/expected > 1e-6 {
+		t.Errorf("ERROR: The change rate is %v, but should be %v!\n", rate, expected)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	planner, err := NewPlanner(NewPlannerArgs(24*time.Hour, 30*24*time.Hour, 0.1))
+	if err != nil {
+		t.Fatalf("ERROR: Planner initialization failing: %s\n", err)
+	}
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for day := 0; day <= 10; day++ {
+		fetchTime := start.Add(time.Duration(day) * 24 * time.Hour)
+		planner.Observe("http://a/news", fetchTime, 200, []byte(fmt.Sprintf("news %d", day)))
+		planner.Observe("http://a/static", fetchTime, 200, []byte("static"))
+	}
+	planner.Observe("http://a/gone", start, 200, []byte("gone"))
+	planner.Observe("http://a/gone", start.Add(time.Hour), 404, nil)
+	planner.Observe("http://a/flaky", start, 200, []byte("flaky"))
+	planner.Observe("http://a/flaky", start.Add(time.Hour), 503, nil)
+
+	now := start.Add(11 * 24 * time.Hour)
+	seeds := planner.Plan(now, 0)
+	// /static在一天內變化的機率約為3%，低於最低優先級。
+	if len(seeds) != 2 || seeds[0].Url != "http://a/flaky" || seeds[1].Url != "http://a/news" {
+		t.Fatalf("ERROR: The seeds %+v are wrong!\n", seeds)
+	}
+	if seeds[0].Priority < seeds[1].Priority || seeds[1].Priority < 0.9 {
+		t.Errorf("ERROR: The priorities of seeds %+v are wrong!\n", seeds)
+	}
+	if seeds := planner.Plan(now.Add(60*24*time.Hour), 1); len(seeds) != 1 {
+		t.Errorf("ERROR: The number of seeds is %d, but should be %d!\n", len(seeds), 1)
+	}
+	if seeds := planner.Plan(now.Add(60*24*time.Hour), 0); len(seeds) != 3 {
+		t.Errorf("ERROR: The static page should be planned after a long time, but the seeds are %+v!\n", seeds)
+	}
+	reqs, err := SeedRequests(seeds)
+	if err != nil || len(reqs) != 2 || reqs[0].URL.String() != "http://a/flaky" {
+		t.Errorf("ERROR: The seed requests are wrong! (err=%v)\n", err)
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	planner, err := NewPlanner(NewPlannerArgs(24*time.Hour, 30*24*time.Hour, 0.1))
+	if err != nil {
+		t.Fatalf("ERROR: Planner initialization failing: %s\n", err)
+	}
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	planner.Observe("http://a/1", start, 200, []byte("1"))
+	planner.Observe("http://a/1", start.Add(time.Hour), 200, []byte("2"))
+	var buf bytes.Buffer
+	if err := planner.Save(&buf); err != nil {
+		t.Fatalf("ERROR: Planner saving failing: %s\n", err)
+	}
+	loaded, err := LoadPlanner(&buf, NewPlannerArgs(24*time.Hour, 30*24*time.Hour, 0.1))
+	if err != nil {
+		t.Fatalf("ERROR: Planner loading failing: %s\n", err)
+	}
+	history, ok := loaded.History("http://a/1")
+	if !ok || history.Changes != 1 || !history.LastFetch.Equal(start.Add(time.Hour)) {
+		t.Errorf("ERROR: The loaded history %+v is wrong!\n", history)
+	}
+	// 比已記錄的更早的下載會被忽略。
+	if loaded.Observe("http://a/1", start, 200, []byte("3")) {
+		t.Errorf("ERROR: The earlier observation should be ignored!\n")
+	}
+	if _, err := NewPlanner(NewPlannerArgs(time.Hour, time.Minute, 0)); err == nil {
+		t.Errorf("ERROR: The planner args with max interval less than default should be invalid!\n")
+	}
+}
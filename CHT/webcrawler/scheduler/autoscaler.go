@@ -0,0 +1,278 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	dl "webcrawler/downloader"
+)
+
+// 自動伸縮在退避時使用的乘數。
+const AUTOSCALE_DECREASE_FACTOR = 0.5
+
+// 自動伸縮參數容器的描述範本。
+var autoscalerArgsTemplate string = "{ minSize: %d, maxSize: %d, interval: %s," +
+	" targetLatency: %s, maxErrorRate: %v }"
+
+// 網頁下載器池自動伸縮參數的容器。
+type AutoscalerArgs struct {
+	minSize       uint32        // 網頁下載器池的最小尺寸。
+	maxSize       uint32        // 網頁下載器池的最大尺寸。
+	interval      time.Duration // 調整的間隔時間。
+	targetLatency time.Duration // 目標下載延遲。為0時表示不考慮延遲。
+	maxErrorRate  float64       // 可容忍的最大下載錯誤率。
+	description   string        // 描述。
+}
+
+// 建立網頁下載器池自動伸縮參數的容器。
+func NewAutoscalerArgs(
+	minSize uint32,
+	maxSize uint32,
+	interval time.Duration,
+	targetLatency time.Duration,
+	maxErrorRate float64) AutoscalerArgs {
+	return AutoscalerArgs{
+		minSize:       minSize,
+		maxSize:       maxSize,
+		interval:      interval,
+		targetLatency: targetLatency,
+		maxErrorRate:  maxErrorRate,
+	}
+}
+
+func (args *AutoscalerArgs) Check() error {
+	if args.minSize == 0 {
+		return errors.New("The min size of autoscaler can not be 0!\n")
+	}
+	if args.maxSize < args.minSize {
+		errMsg := fmt.Sprintf("The max size %d is less than the min size %d!\n",
+			args.maxSize, args.minSize)
+		return errors.New(errMsg)
+	}
+	if args.interval <= 0 {
+		return errors.New("The interval of autoscaler must be positive!\n")
+	}
+	if args.maxErrorRate < 0 || args.maxErrorRate > 1 {
+		errMsg := fmt.Sprintf("Invalid max error rate %v!\n", args.maxErrorRate)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+func (args *AutoscalerArgs) String() string {
+	if args.description == "" {
+		args.description =
+			fmt.Sprintf(autoscalerArgsTemplate,
+				args.minSize,
+				args.maxSize,
+				args.interval,
+				args.targetLatency,
+				args.maxErrorRate)
+	}
+	return args.description
+}
+
+// 獲得網頁下載器池的最小尺寸。
+func (args *AutoscalerArgs) MinSize() uint32 {
+	return args.minSize
+}
+
+// 獲得網頁下載器池的最大尺寸。
+func (args *AutoscalerArgs) MaxSize() uint32 {
+	return args.maxSize
+}
+
+// 獲得調整的間隔時間。
+func (args *AutoscalerArgs) Interval() time.Duration {
+	return args.interval
+}
+
+// 獲得目標下載延遲。
+func (args *AutoscalerArgs) TargetLatency() time.Duration {
+	return args.targetLatency
+}
+
+// 獲得可容忍的最大下載錯誤率。
+func (args *AutoscalerArgs) MaxErrorRate() float64 {
+	return args.maxErrorRate
+}
+
+// 下載統計。它的所有字段都以原子動作存取。
+// 網頁下載器內部的每一次重試都算作一次下載嘗試，而一次下載只會有一個最終的結果。
+type downloadStats struct {
+	downloads     uint64 // 下載次數。
+	errors        uint64 // 最終失敗的下載次數，包括5xx狀態碼。
+	attempts      uint64 // 下載嘗試的次數。
+	attemptErrors uint64 // 失敗的下載嘗試的次數，包括5xx狀態碼。
+	throttled     uint64 // 被限流（429狀態碼）的下載嘗試的次數。
+	latencyTotal  int64  // 累計的下載嘗試的延遲，單位：納秒。
+}
+
+// 記錄一次下載的最終結果。
+func (stats *downloadStats) record(httpResp *http.Response, err error) {
+	atomic.AddUint64(&stats.downloads, 1)
+	if err != nil || (httpResp != nil && httpResp.StatusCode >= 500) {
+		atomic.AddUint64(&stats.errors, 1)
+	}
+}
+
+// 記錄一次下載嘗試。
+func (stats *downloadStats) recordAttempt(latency time.Duration, httpResp *http.Response, err error) {
+	atomic.AddUint64(&stats.attempts, 1)
+	atomic.AddInt64(&stats.latencyTotal, int64(latency))
+	switch {
+	case err != nil:
+		atomic.AddUint64(&stats.attemptErrors, 1)
+	case httpResp == nil:
+	case httpResp.StatusCode == http.StatusTooManyRequests:
+		atomic.AddUint64(&stats.throttled, 1)
+	case httpResp.StatusCode >= 500:
+		atomic.AddUint64(&stats.attemptErrors, 1)
+	}
+}
+
+// 取得目前的統計快照。
+func (stats *downloadStats) snapshot() downloadStats {
+	return downloadStats{
+		downloads:     atomic.LoadUint64(&stats.downloads),
+		errors:        atomic.LoadUint64(&stats.errors),
+		attempts:      atomic.LoadUint64(&stats.attempts),
+		attemptErrors: atomic.LoadUint64(&stats.attemptErrors),
+		throttled:     atomic.LoadUint64(&stats.throttled),
+		latencyTotal:  atomic.LoadInt64(&stats.latencyTotal),
+	}
+}
+
+// 自動伸縮的一次觀測。
+type autoscaleSample struct {
+	backlog    uint64        // 等待下載的請求的數量。
+	downloads  uint64        // 觀測期間的下載嘗試的次數。
+	errors     uint64        // 觀測期間失敗的下載嘗試的次數。
+	throttled  uint64        // 觀測期間被限流的下載嘗試的次數。
+	avgLatency time.Duration // 觀測期間的下載嘗試的平均延遲。
+}
+
+// 自動伸縮動作的型態。
+type scaleAction int
+
+// 自動伸縮動作的常數。
+const (
+	SCALE_ACTION_HOLD    scaleAction = iota // 保持不變。
+	SCALE_ACTION_GROW                       // 逐一增加。
+	SCALE_ACTION_SHRINK                     // 逐一減少。
+	SCALE_ACTION_BACKOFF                    // 按乘數退避。
+)
+
+// 根據觀測決定網頁下載器池的新尺寸以及相應的動作。
+// 出現限流或錯誤率超過上限時，尺寸會按乘數退避；
+// 平均延遲超過目標或長時間沒有下載時，尺寸會逐一減少；
+// 存在積壓且延遲在目標之內時，尺寸會逐一增加。
+// 新尺寸總是在參數args給定的上下限之內。
+func decideSize(args AutoscalerArgs, current uint32, sample autoscaleSample) (uint32, scaleAction) {
+	size := current
+	action := SCALE_ACTION_HOLD
+	switch {
+	case sample.downloads > 0 && (sample.throttled > 0 ||
+		float64(sample.errors)/float64(sample.downloads) > args.MaxErrorRate()):
+		size = uint32(float64(current) * AUTOSCALE_DECREASE_FACTOR)
+		action = SCALE_ACTION_BACKOFF
+	case args.TargetLatency() > 0 && sample.downloads > 0 &&
+		sample.avgLatency > args.TargetLatency():
+		size = current - 1
+		action = SCALE_ACTION_SHRINK
+	case sample.backlog > 0:
+		size = current + 1
+		action = SCALE_ACTION_GROW
+	case sample.downloads == 0:
+		size = current - 1
+		action = SCALE_ACTION_SHRINK
+	}
+	if size < args.MinSize() {
+		size = args.MinSize()
+	}
+	if size > args.MaxSize() {
+		size = args.MaxSize()
+	}
+	if size == current {
+		action = SCALE_ACTION_HOLD
+	}
+	return size, action
+}
+
+// 網頁下載器池的自動伸縮器。
+type autoscaler struct {
+	args     AutoscalerArgs        // 參數。
+	dlpool   dl.PageDownloaderPool // 網頁下載器池。
+	stats    *downloadStats        // 下載統計。
+	backlog  func() uint64         // 獲得等待下載的請求的數量的函數。
+	last     downloadStats         // 上一次觀測時的下載統計。
+	grows    uint64                // 增加尺寸的次數。
+	shrinks  uint64                // 減少尺寸的次數。
+	backoffs uint64                // 退避的次數。
+	mutex    sync.Mutex            // 互斥鎖。
+}
+
+// 觀測並調整一次網頁下載器池的尺寸。
+func (as *autoscaler) adjust() {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	current := as.stats.snapshot()
+	sample := autoscaleSample{
+		backlog:   as.backlog(),
+		downloads: current.attempts - as.last.attempts,
+		errors:    current.attemptErrors - as.last.attemptErrors,
+		throttled: current.throttled - as.last.throttled,
+	}
+	if sample.downloads > 0 {
+		sample.avgLatency = time.Duration(current.latencyTotal-as.last.latencyTotal) /
+			time.Duration(sample.downloads)
+	}
+	as.last = current
+	size := as.dlpool.Total()
+	newSize, action := decideSize(as.args, size, sample)
+	if action == SCALE_ACTION_HOLD {
+		return
+	}
+	if err := as.dlpool.Resize(newSize); err != nil {
+		logger.Warnf("Resize the page downloader pool failing: %s\n", err)
+		return
+	}
+	switch action {
+	case SCALE_ACTION_GROW:
+		as.grows++
+	case SCALE_ACTION_SHRINK:
+		as.shrinks++
+	case SCALE_ACTION_BACKOFF:
+		as.backoffs++
+	}
+	logger.Infof("Resize the page downloader pool from %d to %d (backlog=%d, downloads=%d,"+
+		" errors=%d, throttled=%d, avgLatency=%s).\n",
+		size, newSize, sample.backlog, sample.downloads, sample.errors,
+		sample.throttled, sample.avgLatency)
+}
+
+// 週期性的調整網頁下載器池的尺寸，直到參數stopCh被關閉。
+func (as *autoscaler) run(stopCh <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(as.args.Interval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				as.adjust()
+			}
+		}
+	}()
+}
+
+func (as *autoscaler) summary() string {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	return fmt.Sprintf("size: %d, grows: %d, shrinks: %d, backoffs: %d, args: %s",
+		as.dlpool.Total(), as.grows, as.shrinks, as.backoffs, as.args.String())
+}
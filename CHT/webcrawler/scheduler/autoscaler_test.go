@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+	anlz "webcrawler/analyzer"
+	base "webcrawler/base"
+	ipl "webcrawler/itempipeline"
+	"webcrawler/testhelper"
+)
+
+func TestAutoscalerArgsCheck(t *testing.T) {
+	invalidArgs := []AutoscalerArgs{
+		NewAutoscalerArgs(0, 4, time.Second, 0, 0.1),
+		NewAutoscalerArgs(4, 2, time.Second, 0, 0.1),
+		NewAutoscalerArgs(1, 4, 0, 0, 0.1),
+		NewAutoscalerArgs(1, 4, time.Second, 0, 1.5),
+	}
+	for _, args := range invalidArgs {
+		if err := args.Check(); err == nil {
+			t.Errorf("ERROR: The args %s should be invalid!\n", args.String())
+		}
+	}
+	args := NewAutoscalerArgs(1, 4, time.Second, 0, 0.1)
+	if err := args.Check(); err != nil {
+		t.Errorf("ERROR: The args %s should be valid: %s\n", args.String(), err)
+	}
+}
+
+func TestDecideSize(t *testing.T) {
+	args := NewAutoscalerArgs(2, 10, time.Second, 100*time.Millisecond, 0.2)
+	cases := []struct {
+		current uint32
+		sample  autoscaleSample
+		size    uint32
+		action  scaleAction
+	}{
+		// 有積壓且延遲在目標之內時逐一增加。
+		{4, autoscaleSample{backlog: 5, downloads: 10, avgLatency: 50 * time.Millisecond},
+			5, SCALE_ACTION_GROW},
+		// 不會超過上限。
+		{10, autoscaleSample{backlog: 5, downloads: 10}, 10, SCALE_ACTION_HOLD},
+		// 出現限流時按乘數退避。
+		{8, autoscaleSample{backlog: 5, downloads: 10, throttled: 1}, 4, SCALE_ACTION_BACKOFF},
+		// 錯誤率超過上限時按乘數退避，但不會低於下限。
+		{3, autoscaleSample{backlog: 5, downloads: 10, errors: 3}, 2, SCALE_ACTION_BACKOFF},
+		// 錯誤率在上限之內時不退避。
+		{4, autoscaleSample{backlog: 5, downloads: 10, errors: 2}, 5, SCALE_ACTION_GROW},
+		// 延遲超過目標時逐一減少。
+		{6, autoscaleSample{backlog: 5, downloads: 10, avgLatency: time.Second},
+			5, SCALE_ACTION_SHRINK},
+		// 沒有積壓也沒有下載時逐一減少。
+		{6, autoscaleSample{}, 5, SCALE_ACTION_SHRINK},
+		{2, autoscaleSample{}, 2, SCALE_ACTION_HOLD},
+		// 沒有積壓但仍在下載時保持不變。
+		{6, autoscaleSample{downloads: 3}, 6, SCALE_ACTION_HOLD},
+	}
+	for i, c := range cases {
+		size, action := decideSize(args, c.current, c.sample)
+		if size != c.size || action != c.action {
+			t.Errorf("ERROR: The %dth decision is (%d, %d), but should be (%d, %d)!\n",
+				i, size, action, c.size, c.action)
+		}
+	}
+}
+
+func TestAutoscalerBackoff(t *testing.T) {
+	dlpool, err := generatePageDownloaderPool(
+		8, func() *http.Client { return &http.Client{} }, nil)
+	if err != nil {
+		t.Fatalf("ERROR: Page downloader pool initialization failing: %s\n", err)
+	}
+	stats := &downloadStats{}
+	as := &autoscaler{
+		args:    NewAutoscalerArgs(1, 8, time.Second, 0, 0.5),
+		dlpool:  dlpool,
+		stats:   stats,
+		backlog: func() uint64 { return 10 },
+	}
+	for i := 0; i < 5; i++ {
+		stats.recordAttempt(time.Millisecond, &http.Response{StatusCode: http.StatusOK}, nil)
+	}
+	stats.recordAttempt(time.Millisecond, &http.Response{StatusCode: http.StatusTooManyRequests}, nil)
+	as.adjust()
+	if total := dlpool.Total(); total != 4 {
+		t.Errorf("ERROR: The pool size is %d after backoff, but should be %d!\n", total, 4)
+	}
+	// 之前的限流不應影響下一次觀測。
+	stats.recordAttempt(time.Millisecond, &http.Response{StatusCode: http.StatusOK}, nil)
+	as.adjust()
+	if total := dlpool.Total(); total != 5 {
+		t.Errorf("ERROR: The pool size is %d after growing, but should be %d!\n", total, 5)
+	}
+	if as.backoffs != 1 || as.grows != 1 || as.shrinks != 0 {
+		t.Errorf("ERROR: The adjustments (grows=%d, shrinks=%d, backoffs=%d) are wrong!\n",
+			as.grows, as.shrinks, as.backoffs)
+	}
+}
+
+func TestAutoscaledCrawl(t *testing.T) {
+	site, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(60, 4, 0, 20*time.Millisecond, 60))
+	if err != nil {
+		t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+	}
+	defer site.Close()
+	extractor, err := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Link extractor initialization failing: %s\n", err)
+	}
+	processItem := func(item base.Item) (base.Item, error) {
+		return item, nil
+	}
+	firstHttpReq, _ := http.NewRequest("GET", site.Start()+"/p0", nil)
+	sched := NewScheduler()
+	sched.SetAutoscalerArgs(NewAutoscalerArgs(1, 6, 10*time.Millisecond, 0, 0.5))
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(1, 2),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{processItem},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	waitForDone(t, sched, 20*time.Second)
+	sched.Stop()
+	as := sched.(*myScheduler).autoscaler
+	as.mutex.Lock()
+	grows := as.grows
+	as.mutex.Unlock()
+	if grows == 0 {
+		t.Errorf("ERROR: The page downloader pool has never grown!\n")
+	}
+	if requested, _ := siteResult(site); requested != len(site.Pages()) {
+		t.Errorf("ERROR: Only %d of %d pages are requested!\n", requested, len(site.Pages()))
+	}
+}
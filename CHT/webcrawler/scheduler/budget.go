@@ -0,0 +1,228 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	base "webcrawler/base"
+)
+
+// 爬取預算參數容器的描述範本。
+var budgetArgsTemplate string = "{ maxPages: %s, maxBytes: %s, maxDuration: %s, maxPagesPerHost: %s }"
+
+// 爬取預算參數的容器。各項限制為0時表示不限制。
+type BudgetArgs struct {
+	maxPages        uint64        // 下載的網頁的最大數量。
+	maxBytes        uint64        // 下載的內容的最大位元組數。
+	maxDuration     time.Duration // 爬取的最長時間。
+	maxPagesPerHost uint64        // 每個主機下載的網頁的最大數量。
+	description     string        // 描述。
+}
+
+// 建立爬取預算參數的容器。各項限制為0時表示不限制。
+// 參數maxPages、maxBytes和maxDuration是全域的預算，任何一項耗盡後爬取流程都會收尾：
+// 不再接受新的請求，已在快取和通道中等待的請求會被丟棄，而正在進行中的下載、分析和項目處理會照常完成。
+// 參數maxPagesPerHost耗盡後只有指向該主機的請求會被丟棄。
+func NewBudgetArgs(
+	maxPages uint64,
+	maxBytes uint64,
+	maxDuration time.Duration,
+	maxPagesPerHost uint64) BudgetArgs {
+	return BudgetArgs{
+		maxPages:        maxPages,
+		maxBytes:        maxBytes,
+		maxDuration:     maxDuration,
+		maxPagesPerHost: maxPagesPerHost,
+	}
+}
+
+func (args *BudgetArgs) Check() error {
+	if args.maxDuration < 0 {
+		errMsg := fmt.Sprintf("Invalid max duration %s!\n", args.maxDuration)
+		return errors.New(errMsg)
+	}
+	if args.maxPages > 0 && args.maxPagesPerHost > args.maxPages {
+		errMsg := fmt.Sprintf("The max pages per host %d is greater than the max pages %d!\n",
+			args.maxPagesPerHost, args.maxPages)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+func (args *BudgetArgs) String() string {
+	if args.description == "" {
+		maxDuration := "unlimited"
+		if args.maxDuration > 0 {
+			maxDuration = args.maxDuration.String()
+		}
+		args.description =
+			fmt.Sprintf(budgetArgsTemplate,
+				budgetLimit(args.maxPages),
+				budgetLimit(args.maxBytes),
+				maxDuration,
+				budgetLimit(args.maxPagesPerHost))
+	}
+	return args.description
+}
+
+// 獲得下載的網頁的最大數量。
+func (args *BudgetArgs) MaxPages() uint64 {
+	return args.maxPages
+}
+
+// 獲得下載的內容的最大位元組數。
+func (args *BudgetArgs) MaxBytes() uint64 {
+	return args.maxBytes
+}
+
+// 獲得爬取的最長時間。
+func (args *BudgetArgs) MaxDuration() time.Duration {
+	return args.maxDuration
+}
+
+// 獲得每個主機下載的網頁的最大數量。
+func (args *BudgetArgs) MaxPagesPerHost() uint64 {
+	return args.maxPagesPerHost
+}
+
+// 判斷是否設定了任何一項限制。
+func (args *BudgetArgs) limited() bool {
+	return args.maxPages > 0 || args.maxBytes > 0 || args.maxDuration > 0 || args.maxPagesPerHost > 0
+}
+
+// 獲得限制的表示。
+func budgetLimit(limit uint64) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d", limit)
+}
+
+// 爬取預算。它在分派器開啟時被建立，其字段由互斥鎖保護。
+type crawlBudget struct {
+	args      BudgetArgs        // 參數。
+	start     time.Time         // 爬取開始的時間。
+	pages     uint64            // 已開始下載的網頁的數量。
+	bytes     uint64            // 已下載的內容的位元組數。
+	hostPages map[string]uint64 // 主機與已開始下載的網頁的數量的對應。
+	dropped   uint64            // 因預算耗盡而被丟棄的請求的數量。
+	exhausted string            // 全域預算耗盡的原因。為空時表示尚未耗盡。
+	mutex     sync.Mutex        // 互斥鎖。
+}
+
+// 建立爬取預算。
+func newCrawlBudget(args BudgetArgs) *crawlBudget {
+	return &crawlBudget{
+		args:      args,
+		start:     time.Now(),
+		hostPages: make(map[string]uint64),
+	}
+}
+
+// 獲得請求所屬的主機。
+func requestHost(req *base.Request) string {
+	return strings.ToLower(req.HttpReq().URL.Host)
+}
+
+// 標記全域預算已耗盡。只有第一個原因會被保留。
+// 該方法應在持有互斥鎖時被呼叫。
+func (budget *crawlBudget) exhaust(reason string) {
+	if budget.exhausted != "" {
+		return
+	}
+	budget.exhausted = reason
+	logger.Warnf("The crawl budget is exhausted: %s. Draining...\n", reason)
+}
+
+// 檢查爬取時間是否已用盡。該方法應在持有互斥鎖時被呼叫。
+func (budget *crawlBudget) checkDuration() {
+	if budget.args.maxDuration > 0 && time.Since(budget.start) >= budget.args.maxDuration {
+		budget.exhaust(fmt.Sprintf("max duration %s reached", budget.args.maxDuration))
+	}
+}
+
+// 判斷請求是否可以被放入請求快取。
+// 該方法不會消耗預算，請求在被下載前還會被再次檢查。
+func (budget *crawlBudget) allows(req *base.Request) bool {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.checkDuration()
+	if budget.exhausted != "" {
+		budget.dropped++
+		return false
+	}
+	if budget.args.maxPagesPerHost > 0 &&
+		budget.hostPages[requestHost(req)] >= budget.args.maxPagesPerHost {
+		budget.dropped++
+		return false
+	}
+	return true
+}
+
+// 為請求的下載消耗預算。傳回false時表示預算已耗盡，請求應被丟棄。
+// 下載的網頁的數量達到上限時，全域預算會立即被標記為耗盡，以免其後的請求繼續等待。
+func (budget *crawlBudget) admit(req *base.Request) bool {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.checkDuration()
+	if budget.exhausted != "" {
+		budget.dropped++
+		return false
+	}
+	host := requestHost(req)
+	if budget.args.maxPagesPerHost > 0 && budget.hostPages[host] >= budget.args.maxPagesPerHost {
+		budget.dropped++
+		return false
+	}
+	budget.pages++
+	budget.hostPages[host]++
+	if budget.args.maxPages > 0 && budget.pages >= budget.args.maxPages {
+		budget.exhaust(fmt.Sprintf("max pages %d reached", budget.args.maxPages))
+	}
+	return true
+}
+
+// 記錄已下載的內容的位元組數。
+func (budget *crawlBudget) addBytes(n uint64) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.bytes += n
+	if budget.args.maxBytes > 0 && budget.bytes >= budget.args.maxBytes {
+		budget.exhaust(fmt.Sprintf("max bytes %d reached", budget.args.maxBytes))
+	}
+}
+
+// 獲得全域預算耗盡的原因。為空時表示尚未耗盡。
+func (budget *crawlBudget) reason() string {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.checkDuration()
+	return budget.exhausted
+}
+
+var budgetSummaryTemplate = "pages: %d/%s, bytes: %d/%s, hosts at limit: %d, dropped: %d, exhausted: %s"
+
+// 取得摘要訊息。
+func (budget *crawlBudget) summary() string {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.checkDuration()
+	hostsAtLimit := 0
+	if budget.args.maxPagesPerHost > 0 {
+		for _, pages := range budget.hostPages {
+			if pages >= budget.args.maxPagesPerHost {
+				hostsAtLimit++
+			}
+		}
+	}
+	exhausted := budget.exhausted
+	if exhausted == "" {
+		exhausted = "no"
+	}
+	return fmt.Sprintf(budgetSummaryTemplate,
+		budget.pages, budgetLimit(budget.args.maxPages),
+		budget.bytes, budgetLimit(budget.args.maxBytes),
+		hostsAtLimit, budget.dropped, exhausted)
+}
@@ -47,41 +47,45 @@ func (rcache *reqCacheBySlice) put(req *base.Request) bool {
 	if req == nil {
 		return false
 	}
+	rcache.mutex.Lock()
+	defer rcache.mutex.Unlock()
 	if rcache.status == 1 {
 		return false
 	}
-	rcache.mutex.Lock()
-	defer rcache.mutex.Unlock()
 	rcache.cache = append(rcache.cache, req)
 	return true
 }
 
 func (rcache *reqCacheBySlice) get() *base.Request {
-	if rcache.length() == 0 {
+	rcache.mutex.Lock()
+	defer rcache.mutex.Unlock()
+	if len(rcache.cache) == 0 {
 		return nil
 	}
 	if rcache.status == 1 {
 		return nil
 	}
-	rcache.mutex.Lock()
-	defer rcache.mutex.Unlock()
 	req := rcache.cache[0]
+	rcache.cache[0] = nil
 	rcache.cache = rcache.cache[1:]
 	return req
 }
 
 func (rcache *reqCacheBySlice) capacity() int {
+	rcache.mutex.Lock()
+	defer rcache.mutex.Unlock()
 	return cap(rcache.cache)
 }
 
 func (rcache *reqCacheBySlice) length() int {
+	rcache.mutex.Lock()
+	defer rcache.mutex.Unlock()
 	return len(rcache.cache)
 }
 
 func (rcache *reqCacheBySlice) close() {
-	if rcache.status == 1 {
-		return
-	}
+	rcache.mutex.Lock()
+	defer rcache.mutex.Unlock()
 	rcache.status = 1
 }
 
@@ -89,9 +93,11 @@ func (rcache *reqCacheBySlice) close() {
 var summaryTemplate = "status: %s, " + "length: %d, " + "capacity: %d"
 
 func (rcache *reqCacheBySlice) summary() string {
+	rcache.mutex.Lock()
+	defer rcache.mutex.Unlock()
 	summary := fmt.Sprintf(summaryTemplate,
 		statusMap[rcache.status],
-		rcache.length(),
-		rcache.capacity())
+		len(rcache.cache),
+		cap(rcache.cache))
 	return summary
 }
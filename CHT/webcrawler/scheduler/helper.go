@@ -18,11 +18,15 @@ func generateChannelManager(channelArgs base.ChannelArgs) mdw.ChannelManager {
 
 func generatePageDownloaderPool(
 	poolSize uint32,
-	httpClientGenerator GenHttpClient) (dl.PageDownloaderPool, error) {
+	httpClientGenerator GenHttpClient,
+	dlGenerator GenPageDownloader) (dl.PageDownloaderPool, error) {
+	if dlGenerator == nil {
+		dlGenerator = dl.NewPageDownloader
+	}
 	dlPool, err := dl.NewPageDownloaderPool(
 		poolSize,
 		func() dl.PageDownloader {
-			return dl.NewPageDownloader(httpClientGenerator())
+			return dlGenerator(httpClientGenerator())
 		},
 	)
 	if err != nil {
@@ -44,8 +48,62 @@ func generateAnalyzerPool(poolSize uint32) (anlz.AnalyzerPool, error) {
 	return analyzerPool, nil
 }
 
-func generateItemPipeline(itemProcessors []ipl.ProcessItem) ipl.ItemPipeline {
-	return ipl.NewItemPipeline(itemProcessors)
+func generateItemPipeline(
+	itemProcessors []ipl.ProcessItem,
+	stages []ipl.Stage,
+	branches []ipl.Branch) (ipl.ItemPipeline, error) {
+	if len(stages) == 0 && len(branches) == 0 {
+		return ipl.NewItemPipeline(itemProcessors), nil
+	}
+	allStages := make([]ipl.Stage, 0, len(itemProcessors)+len(stages))
+	for i, ip := range itemProcessors {
+		allStages = append(allStages, ipl.NewProcessorStage(fmt.Sprintf("processor-%d", i), ip))
+	}
+	allStages = append(allStages, stages...)
+	return ipl.NewStagedItemPipeline(allStages, branches...)
+}
+
+// 啟動固定數量的工作者。每個工作者都會在獨立的執行緒中執行參數work所代表的函數。
+func startWorkers(number uint32, work func()) {
+	for i := uint32(0); i < number; i++ {
+		go work()
+	}
+}
+
+// 根據請求產生錯誤的詳細訊息。
+func reqDetail(req *base.Request) base.ErrorDetail {
+	var detail base.ErrorDetail
+	if req == nil {
+		return detail
+	}
+	detail.Depth = req.Depth()
+	if httpReq := req.HttpReq(); httpReq != nil && httpReq.URL != nil {
+		detail.Url = httpReq.URL.String()
+	}
+	return detail
+}
+
+// 根據響應產生錯誤的詳細訊息。
+func respDetail(resp *base.Response) base.ErrorDetail {
+	var detail base.ErrorDetail
+	if resp == nil {
+		return detail
+	}
+	detail.Depth = resp.Depth()
+	if httpResp := resp.HttpResp(); httpResp != nil {
+		detail.StatusCode = httpResp.StatusCode
+		if httpResp.Request != nil && httpResp.Request.URL != nil {
+			detail.Url = httpResp.Request.URL.String()
+		}
+	}
+	return detail
+}
+
+// 根據項目產生錯誤的詳細訊息。項目所在網頁的URL來自項目的元資料。
+func itemDetail(item base.Item) base.ErrorDetail {
+	var detail base.ErrorDetail
+	detail.Url = item.Meta().GetString(base.META_KEY_PAGE_URL)
+	return detail
 }
 
 // 產生元件案例代號。
@@ -93,7 +151,8 @@ var regexpForDomains = []*regexp.Regexp{
 	regexp.MustCompile(`\.\w{2}$`),
 }
 
-func getPrimaryDomain(host string) (string, error) {
+// 獲得主機名所屬的主域名。若主機名為IP位址，則直接傳回主機名。
+func GetPrimaryDomain(host string) (string, error) {
 	host = strings.TrimSpace(host)
 	if host == "" {
 		return "", errors.New("The host is empty!")
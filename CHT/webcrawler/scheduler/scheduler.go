@@ -1,18 +1,24 @@
 package scheduler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"logging"
 	"net/http"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	anlz "webcrawler/analyzer"
 	base "webcrawler/base"
 	dl "webcrawler/downloader"
+	fp "webcrawler/fingerprint"
+	graph "webcrawler/graph"
 	ipl "webcrawler/itempipeline"
 	mdw "webcrawler/middleware"
+	"webcrawler/recrawl"
+	"webcrawler/tool/session"
 )
 
 // 元件的統一代號。
@@ -29,6 +35,9 @@ var logger logging.Logger = base.NewLogger()
 // 被用來產生HTTP用戶端的函數型態。
 type GenHttpClient func() *http.Client
 
+// 被用來根據HTTP用戶端產生網頁下載器的函數型態。
+type GenPageDownloader func(httpClient *http.Client) dl.PageDownloader
+
 // 分派器的接口型態。
 type Scheduler interface {
 	// 開啟分派器。
@@ -53,13 +62,98 @@ type Scheduler interface {
 	Running() bool
 	// 獲得錯誤通道。分派器以及各個處理模組執行過程中出現的所有錯誤都會被傳送到該通道。
 	// 若該方法的結果值為nil，則說明錯誤通道不可用或分派器已被停止。
+	// 該通道是錯誤匯集器的一個訂閱，無論錯誤匯集器的溢出策略為何，其緩沖區已滿時最早的錯誤都會被丟棄。
 	ErrorChan() <-chan error
-	// 判斷所有處理模組是否都處於閒置狀態。
+	// 獲得錯誤匯集器。若該方法的結果值為nil，則說明分派器尚未被開啟。
+	// 分派器停止後，錯誤匯集器會被關閉，但仍可從中取得最近的錯誤與計數。
+	ErrorAggregator() mdw.ErrorAggregator
+	// 判斷所有處理模組是否都處於閒置狀態，並且請求快取、各通道中都沒有待處理的資料。
 	Idle() bool
+	// 獲得完成通知通道。爬取流程中的所有工作都完成後，該通道會被關閉。
+	// 分派器被停止時，該通道也會被關閉。若該方法的結果值為nil，則說明分派器尚未被開啟。
+	Done() <-chan struct{}
+	// 獲得正在進行中的工作的數量。
+	// 請求快取和請求通道中的請求、正在被下載的請求、響應通道中的以及正在被分析的響應、
+	// 項目通道中的以及正在被處理的項目都各自算作一份工作。
+	InFlight() uint64
+	// 取得統計訊息。若分派器尚未被開啟，則結果值為零值。
+	Stats() SchedStats
 	// 取得摘要訊息。
 	Summary(prefix string) SchedSummary
+	// 設定內容去重器。該方法應在Start方法之前被呼叫。
+	// 參數deduper為nil時表示不進行內容去重。
+	// 參數suppressLinks指明是否忽略從內容重復的網頁中提取出的請求。
+	SetContentDeduper(deduper fp.ContentDeduper, suppressLinks bool)
+	// 設定網頁下載器的產生函數。該方法應在Start方法之前被呼叫。
+	// 參數gen為nil時會使用 downloader.NewPageDownloader。
+	SetPageDownloaderGenerator(gen GenPageDownloader)
+	// 設定已見集合。它被用來判斷請求是否重復。該方法應在Start方法之前被呼叫。
+	// 參數seenSet為nil時會使用基於記憶體的精確的已見集合。
+	// 已見集合不會被分派器關閉，以便在下一次爬取流程中繼續使用。
+	SetSeenSet(seenSet mdw.SeenSet)
+	// 設定錯誤匯集器參數。該方法應在Start方法之前被呼叫。
+	// 若不設定，則會使用容量為 DEFAULT_ERROR_CAPACITY 且溢出策略為丟棄最早的錯誤的參數。
+	// 在阻塞策略下，經由錯誤匯集器建立的訂閱必須被持續的接收，否則爬取流程會被阻塞。錯誤通道不受此限制。
+	SetErrorAggregatorArgs(args mdw.ErrorAggregatorArgs)
+	// 設定網頁下載器池的自動伸縮參數。該方法應在Start方法之前被呼叫。
+	// 設定後，網頁下載器池的初始尺寸會被限制在參數給定的上下限之內，
+	// 並且會依據請求的積壓、下載延遲以及錯誤率被週期性的調整。
+	// 若不設定，則網頁下載器池的尺寸保持不變。
+	SetAutoscalerArgs(args AutoscalerArgs)
+	// 設定爬取圖。已下載的網頁及其狀態碼、深度以及網頁之間的連結都會被記錄在其中。
+	// 該方法應在Start方法之前被呼叫。參數crawlGraph為nil時表示不進行記錄。
+	// 爬取圖不會被分派器清空，以便在爬取流程結束後輸出或產生報告。
+	SetCrawlGraph(crawlGraph graph.CrawlGraph)
+	// 設定項目結構註冊表和死信接收器。該方法應在Start方法之前被呼叫。
+	// 項目會在被項目處理器處理之前依據項目結構被驗證，未通過驗證的項目會被放入死信接收器，
+	// 並以項目處理管線錯誤的形式被報告。參數registry為nil時表示不進行驗證。
+	SetItemSchemas(registry ipl.SchemaRegistry, sink ipl.DeadLetterSink)
+	// 設定項目處理管線的階段和分支。該方法應在Start方法之前被呼叫。
+	// 傳給Start方法的項目處理器會成為主幹中最前面的階段，參數stages中的階段緊隨其後。
+	SetItemStages(stages []ipl.Stage, branches ...ipl.Branch)
+	// 設定附加的種子請求。該方法應在Start方法之前被呼叫。
+	// 種子會在首次請求之後按順序被放入請求快取，其深度為0。
+	// 與首次請求一樣，種子即使已在已見集合中也會被下載，以便在沿用已見集合的多次爬取流程中重新爬取它們。
+	// 首次請求和各種子的主域名都會被允許，因此種子可以來自不同的網站。協定不是http的種子會被忽略。
+	SetSeeds(seeds []*http.Request)
+	// 設定重新爬取規劃器。每次下載都會被記錄在其中，以便為下一次爬取產生種子清單。
+	// 該方法應在Start方法之前被呼叫。參數planner為nil時表示不進行記錄。
+	SetRecrawlPlanner(planner recrawl.Planner)
+	// 設定代理管理器。該方法應在Start方法之前被呼叫。
+	// 設定後，由HTTP用戶端產生函數產生的每個用戶端都會被以代理包裝，
+	// 參數assignment決定代理是按網頁下載器分配還是按請求分配。參數manager為nil時表示不使用代理。
+	// 代理管理器不會被分派器關閉，以便在下一次爬取流程中繼續使用。
+	SetProxyManager(manager dl.ProxyManager, assignment dl.ProxyAssignment)
+	// 設定爬取預算參數。該方法應在Start方法之前被呼叫。
+	// 全域預算耗盡後爬取流程會收尾，完成通知通道會在進行中的工作都完成後被關閉，
+	// 耗盡的原因會出現在統計訊息和摘要訊息中。若不設定，則只有爬取的最大深度會限制爬取流程。
+	SetBudgetArgs(args BudgetArgs)
+	// 設定陷阱檢測器。該方法應在Start方法之前被呼叫。
+	// 尚未請求過的URL在被放入請求快取之前會被檢查，觸發陷阱的URL會被忽略。
+	// 參數detector為nil時表示不進行檢測。
+	// 與已見集合一樣，陷阱檢測器的計數不會被Start方法重設，而會延續到下一次爬取流程中；
+	// 若需要重新計數，應設定新的陷阱檢測器。
+	SetTrapDetector(detector mdw.TrapDetector)
+	// 設定會話。該方法應在Start方法之前被呼叫。
+	// 設定後，分派器會在開啟時執行登入流程，並以會話的 HttpClient 方法取代傳給Start方法的HTTP用戶端產生函數，
+	// 以便所有網頁下載器共享登入狀態。首個請求及種子請求的主機會被加入會話，
+	// 只有發往這些主機的請求才會帶有驗證訊息。
+	// 設定了代理管理器時，代理會被置於會話的HTTP傳輸之下，而登入流程不經由代理。
+	// 參數sess為nil時表示不使用會話。
+	SetSession(sess session.Session)
 }
 
+// 錯誤匯集器的預設容量。
+const DEFAULT_ERROR_CAPACITY = 100
+
+// 執行標示的取值。
+const (
+	RUNNING_STATUS_IDLE     uint32 = 0 // 未執行。
+	RUNNING_STATUS_RUNNING  uint32 = 1 // 正在執行。
+	RUNNING_STATUS_STOPPED  uint32 = 2 // 已停止。
+	RUNNING_STATUS_STARTING uint32 = 3 // 正在開啟。
+)
+
 // 建立分派器。
 func NewScheduler() Scheduler {
 	return &myScheduler{}
@@ -67,18 +161,47 @@ func NewScheduler() Scheduler {
 
 // 分派器的實現型態。
 type myScheduler struct {
-	channelArgs   base.ChannelArgs      // 通道參數的容器。
-	poolBaseArgs  base.PoolBaseArgs     // 池基本參數的容器。
-	crawlDepth    uint32                // 爬取的最大深度。第一次請求的深度為0。
-	primaryDomain string                // 主域名。
-	chanman       mdw.ChannelManager    // 通道管理器。
-	stopSign      mdw.StopSign          // 停止訊號。
-	dlpool        dl.PageDownloaderPool // 網頁下載器池。
-	analyzerPool  anlz.AnalyzerPool     // 分析器池。
-	itemPipeline  ipl.ItemPipeline      // 項目處理管線。
-	reqCache      requestCache          // 請求快取。
-	urlMap        map[string]bool       // 已請求的URL的字典。
-	running       uint32                // 執行標示。0表示未執行，1表示已執行，2表示已停止。
+	channelArgs    base.ChannelArgs        // 通道參數的容器。
+	poolBaseArgs   base.PoolBaseArgs       // 池基本參數的容器。
+	crawlDepth     uint32                  // 爬取的最大深度。第一次請求的深度為0。
+	primaryDomains map[string]bool         // 允許的主域名。由首次請求和種子請求推算而得。
+	chanman        mdw.ChannelManager      // 通道管理器。
+	stopSign       mdw.StopSign            // 停止訊號。
+	dlpool         dl.PageDownloaderPool   // 網頁下載器池。
+	analyzerPool   anlz.AnalyzerPool       // 分析器池。
+	itemPipeline   ipl.ItemPipeline        // 項目處理管線。
+	reqCache       requestCache            // 請求快取。
+	seenSet        mdw.SeenSet             // 已請求的URL的已見集合。
+	running        uint32                  // 執行標示。取值為 RUNNING_STATUS_* 。
+	deduper        fp.ContentDeduper       // 內容去重器。
+	suppressLinks  bool                    // 是否忽略從內容重復的網頁中提取出的請求。
+	dlGenerator    GenPageDownloader       // 網頁下載器的產生函數。
+	stopCh         chan struct{}           // 停止通知通道。它會在分派器停止時被關閉。
+	stopCtx        context.Context         // 停止通知上下文。它會在分派器停止時被取消，進而中斷進行中的下載。
+	cancelStop     context.CancelFunc      // 停止通知上下文的取消函數。
+	errorArgs      mdw.ErrorAggregatorArgs // 錯誤匯集器參數。
+	errorAgg       mdw.ErrorAggregator     // 錯誤匯集器。
+	errorSub       mdw.ErrorSubscription   // 錯誤通道所對應的訂閱。
+	autoscaleArgs  AutoscalerArgs          // 網頁下載器池的自動伸縮參數。
+	autoscaler     *autoscaler             // 網頁下載器池的自動伸縮器。未啟用時為nil。
+	dlStats        *downloadStats          // 下載統計。
+	crawlGraph     graph.CrawlGraph        // 爬取圖。
+	schemas        ipl.SchemaRegistry      // 項目結構註冊表。
+	deadLetters    ipl.DeadLetterSink      // 死信接收器。
+	itemStages     []ipl.Stage             // 項目處理管線中的附加階段。
+	itemBranches   []ipl.Branch            // 項目處理管線中的分支。
+	seeds          []*http.Request         // 附加的種子請求。
+	planner        recrawl.Planner         // 重新爬取規劃器。
+	proxyManager   dl.ProxyManager         // 代理管理器。
+	proxyAssign    dl.ProxyAssignment      // 代理的分配方式。
+	budgetArgs     BudgetArgs              // 爬取預算參數。
+	budget         *crawlBudget            // 爬取預算。未設定任何限制時為nil。
+	trapDetector   mdw.TrapDetector        // 陷阱檢測器。
+	session        session.Session         // 會話。
+	inFlight       int64                   // 正在進行中的工作的數量。
+	doneCh         chan struct{}           // 完成通知通道。
+	doneOnce       *sync.Once              // 保證完成通知通道只被關閉一次。
+	rwmutex        sync.RWMutex            // 讀寫鎖。傳送資料時持有讀鎖，關閉通道管理器時持有寫鎖。
 }
 
 func (sched *myScheduler) Start(
@@ -89,17 +212,24 @@ func (sched *myScheduler) Start(
 	respParsers []anlz.ParseResponse,
 	itemProcessors []ipl.ProcessItem,
 	firstHttpReq *http.Request) (err error) {
+	// 各元件就緒之前，執行標示會處於正在開啟的狀態，以免監控者過早的存取它們。
+	if !atomic.CompareAndSwapUint32(&sched.running, RUNNING_STATUS_IDLE, RUNNING_STATUS_STARTING) &&
+		!atomic.CompareAndSwapUint32(&sched.running, RUNNING_STATUS_STOPPED, RUNNING_STATUS_STARTING) {
+		return errors.New("The scheduler has been started!\n")
+	}
+	// 恐慌需要先被轉換為錯誤，執行標示才能據此被恢復為閒置。
 	defer func() {
 		if p := recover(); p != nil {
 			errMsg := fmt.Sprintf("Fatal Scheduler Error: %s\n", p)
 			logger.Fatal(errMsg)
 			err = errors.New(errMsg)
 		}
+		if err != nil {
+			atomic.StoreUint32(&sched.running, RUNNING_STATUS_IDLE)
+		} else {
+			atomic.StoreUint32(&sched.running, RUNNING_STATUS_RUNNING)
+		}
 	}()
-	if atomic.LoadUint32(&sched.running) == 1 {
-		return errors.New("The scheduler has been started!\n")
-	}
-	atomic.StoreUint32(&sched.running, 1)
 
 	if err := channelArgs.Check(); err != nil {
 		return err
@@ -111,14 +241,79 @@ func (sched *myScheduler) Start(
 	sched.poolBaseArgs = poolBaseArgs
 	sched.crawlDepth = crawlDepth
 
+	if firstHttpReq == nil {
+		return errors.New("The first HTTP request is invalid!")
+	}
+	pd, err := GetPrimaryDomain(firstHttpReq.Host)
+	if err != nil {
+		return err
+	}
+	sched.primaryDomains = map[string]bool{pd: true}
+	for _, seed := range sched.seeds {
+		if seed == nil {
+			continue
+		}
+		if pd, err := GetPrimaryDomain(seed.Host); err == nil {
+			sched.primaryDomains[pd] = true
+		}
+	}
+
+	if sched.errorArgs.Capacity() == 0 {
+		sched.errorArgs = mdw.NewErrorAggregatorArgs(
+			DEFAULT_ERROR_CAPACITY, mdw.OVERFLOW_POLICY_DROP_OLDEST, 0)
+	}
+	errorAgg, err := mdw.NewErrorAggregator(sched.errorArgs)
+	if err != nil {
+		return err
+	}
+
+	autoscaling := sched.autoscaleArgs.MaxSize() != 0
+	dlPoolSize := sched.poolBaseArgs.PageDownloaderPoolSize()
+	if autoscaling {
+		if err := sched.autoscaleArgs.Check(); err != nil {
+			return err
+		}
+		if dlPoolSize < sched.autoscaleArgs.MinSize() {
+			dlPoolSize = sched.autoscaleArgs.MinSize()
+		}
+		if dlPoolSize > sched.autoscaleArgs.MaxSize() {
+			dlPoolSize = sched.autoscaleArgs.MaxSize()
+		}
+	}
+
+	budgeting := sched.budgetArgs.limited()
+	if budgeting {
+		if err := sched.budgetArgs.Check(); err != nil {
+			return err
+		}
+	}
+
+	if sched.session != nil {
+		// 只有發往首個請求及種子請求的主機的請求才會被加入驗證訊息。
+		if firstHttpReq.URL != nil {
+			sched.session.AddHosts(firstHttpReq.URL.Host)
+		}
+		for _, seed := range sched.seeds {
+			if seed != nil && seed.URL != nil {
+				sched.session.AddHosts(seed.URL.Host)
+			}
+		}
+		if err := sched.session.Login(); err != nil {
+			errMsg := fmt.Sprintf("Occur error when log in: %s\n", err)
+			return errors.New(errMsg)
+		}
+		httpClientGenerator = sched.session.HttpClient
+	}
+
 	sched.chanman = generateChannelManager(sched.channelArgs)
 	if httpClientGenerator == nil {
 		return errors.New("The HTTP client generator list is invalid!")
 	}
 	dlpool, err :=
 		generatePageDownloaderPool(
-			sched.poolBaseArgs.PageDownloaderPoolSize(),
-			httpClientGenerator)
+			dlPoolSize,
+			sched.wrapHttpClientGenerator(httpClientGenerator),
+			sched.dlGenerator)
 	if err != nil {
 		errMsg :=
 			fmt.Sprintf("Occur error when get page downloader pool: %s\n", err)
@@ -141,7 +336,16 @@ func (sched *myScheduler) Start(
 			return errors.New(fmt.Sprintf("The %dth item processor is invalid!", i))
 		}
 	}
-	sched.itemPipeline = generateItemPipeline(itemProcessors)
+	itemPipeline, err := generateItemPipeline(itemProcessors, sched.itemStages, sched.itemBranches)
+	if err != nil {
+		errMsg :=
+			fmt.Sprintf("Occur error when get item pipeline: %s\n", err)
+		return errors.New(errMsg)
+	}
+	sched.itemPipeline = itemPipeline
+	sched.itemPipeline.SetFailFast(true)
+	sched.itemPipeline.SetSchemaRegistry(sched.schemas)
+	sched.itemPipeline.SetDeadLetterSink(sched.deadLetters)
 
 	if sched.stopSign == nil {
 		sched.stopSign = mdw.NewStopSign()
@@ -149,76 +353,232 @@ func (sched *myScheduler) Start(
 		sched.stopSign.Reset()
 	}
 
+	sched.stopCh = make(chan struct{})
+	sched.stopCtx, sched.cancelStop = context.WithCancel(context.Background())
+	atomic.StoreInt64(&sched.inFlight, 0)
+	sched.doneCh = make(chan struct{})
+	sched.doneOnce = &sync.Once{}
+	sched.errorAgg = errorAgg
+	// 錯誤通道不一定會被接收（例如只使用監控者時），因此它的訂閱總是丟棄最早的錯誤，以免阻塞各處理模組。
+	sched.errorSub = errorAgg.SubscribeWithPolicy(
+		uint32(sched.channelArgs.ErrorChanLen()), mdw.OVERFLOW_POLICY_DROP_OLDEST)
 	sched.reqCache = newRequestCache()
-	sched.urlMap = make(map[string]bool)
+	if sched.seenSet == nil {
+		sched.seenSet = mdw.NewMemorySeenSet()
+	}
+	sched.dlStats = &downloadStats{}
+	sched.budget = nil
+	if budgeting {
+		sched.budget = newCrawlBudget(sched.budgetArgs)
+	}
+	sched.autoscaler = nil
+	if autoscaling {
+		reqChan := sched.getReqChan()
+		reqCache := sched.reqCache
+		sched.autoscaler = &autoscaler{
+			args:   sched.autoscaleArgs,
+			dlpool: sched.dlpool,
+			stats:  sched.dlStats,
+			backlog: func() uint64 {
+				return uint64(reqCache.length() + len(reqChan))
+			},
+		}
+		sched.autoscaler.run(sched.stopCh)
+	}
 
 	sched.startDownloading()
 	sched.activateAnalyzers(respParsers)
 	sched.openItemPipeline()
 	sched.schedule(10 * time.Millisecond)
 
-	if firstHttpReq == nil {
-		return errors.New("The first HTTP request is invalid!")
-	}
-	pd, err := getPrimaryDomain(firstHttpReq.Host)
-	if err != nil {
-		return err
-	}
-	sched.primaryDomain = pd
-
+	// 先持有一份工作，直到所有的種子請求都被放入請求快取，
+	// 以免第一個請求在其他種子請求被加入之前就已處理完畢並使計數歸零。
+	sched.addWork()
 	firstReq := base.NewRequest(firstHttpReq, 0)
+	sched.seenSet.Add(firstReq.Key())
+	sched.addWork()
 	sched.reqCache.put(firstReq)
+	sched.admitSeeds(firstReq)
+	sched.finishWork()
 
 	return nil
 }
 
 func (sched *myScheduler) Stop() bool {
-	if atomic.LoadUint32(&sched.running) != 1 {
+	if !atomic.CompareAndSwapUint32(&sched.running, RUNNING_STATUS_RUNNING, RUNNING_STATUS_STOPPED) {
 		return false
 	}
 	sched.stopSign.Sign()
+	// 先喚醒被阻塞在傳送動作上的執行緒，再等待它們釋放讀鎖後關閉通道。
+	close(sched.stopCh)
+	sched.cancelStop()
+	sched.errorAgg.Close()
+	sched.rwmutex.Lock()
 	sched.chanman.Close()
+	sched.rwmutex.Unlock()
 	sched.reqCache.close()
-	atomic.StoreUint32(&sched.running, 2)
+	sched.closeDone()
 	return true
 }
 
 func (sched *myScheduler) Running() bool {
-	return atomic.LoadUint32(&sched.running) == 1
+	return atomic.LoadUint32(&sched.running) == RUNNING_STATUS_RUNNING
 }
 
 func (sched *myScheduler) ErrorChan() <-chan error {
-	if sched.chanman.Status() != mdw.CHANNEL_MANAGER_STATUS_INITIALIZED {
+	if !sched.Running() {
+		return nil
+	}
+	return sched.errorSub.Errors()
+}
+
+func (sched *myScheduler) ErrorAggregator() mdw.ErrorAggregator {
+	status := atomic.LoadUint32(&sched.running)
+	if status != RUNNING_STATUS_RUNNING && status != RUNNING_STATUS_STOPPED {
 		return nil
 	}
-	return sched.getErrorChan()
+	return sched.errorAgg
 }
 
 func (sched *myScheduler) Idle() bool {
 	idleDlPool := sched.dlpool.Used() == 0
 	idleAnalyzerPool := sched.analyzerPool.Used() == 0
 	idleItemPipeline := sched.itemPipeline.ProcessingNumber() == 0
-	if idleDlPool && idleAnalyzerPool && idleItemPipeline {
+	noWork := atomic.LoadInt64(&sched.inFlight) == 0
+	if idleDlPool && idleAnalyzerPool && idleItemPipeline && noWork {
 		return true
 	}
 	return false
 }
 
+func (sched *myScheduler) Done() <-chan struct{} {
+	status := atomic.LoadUint32(&sched.running)
+	if status != RUNNING_STATUS_RUNNING && status != RUNNING_STATUS_STOPPED {
+		return nil
+	}
+	return sched.doneCh
+}
+
+func (sched *myScheduler) InFlight() uint64 {
+	if n := atomic.LoadInt64(&sched.inFlight); n > 0 {
+		return uint64(n)
+	}
+	return 0
+}
+
+// 增加一份進行中的工作。
+// 新的工作必須在產生它的工作結束之前被加入，以免計數過早的歸零。
+func (sched *myScheduler) addWork() {
+	atomic.AddInt64(&sched.inFlight, 1)
+}
+
+// 結束一份進行中的工作。計數歸零時完成通知通道會被關閉。
+func (sched *myScheduler) finishWork() {
+	if atomic.AddInt64(&sched.inFlight, -1) == 0 {
+		sched.closeDone()
+	}
+}
+
+// 關閉完成通知通道。
+func (sched *myScheduler) closeDone() {
+	sched.doneOnce.Do(func() {
+		close(sched.doneCh)
+	})
+}
+
 func (sched *myScheduler) Summary(prefix string) SchedSummary {
 	return NewSchedSummary(sched, prefix)
 }
 
+func (sched *myScheduler) SetContentDeduper(deduper fp.ContentDeduper, suppressLinks bool) {
+	sched.deduper = deduper
+	sched.suppressLinks = suppressLinks
+}
+
+func (sched *myScheduler) SetPageDownloaderGenerator(gen GenPageDownloader) {
+	sched.dlGenerator = gen
+}
+
+func (sched *myScheduler) SetSeenSet(seenSet mdw.SeenSet) {
+	sched.seenSet = seenSet
+}
+
+func (sched *myScheduler) SetErrorAggregatorArgs(args mdw.ErrorAggregatorArgs) {
+	sched.errorArgs = args
+}
+
+func (sched *myScheduler) SetAutoscalerArgs(args AutoscalerArgs) {
+	sched.autoscaleArgs = args
+}
+
+func (sched *myScheduler) SetCrawlGraph(crawlGraph graph.CrawlGraph) {
+	sched.crawlGraph = crawlGraph
+}
+
+func (sched *myScheduler) SetItemSchemas(registry ipl.SchemaRegistry, sink ipl.DeadLetterSink) {
+	sched.schemas = registry
+	sched.deadLetters = sink
+}
+
+func (sched *myScheduler) SetItemStages(stages []ipl.Stage, branches ...ipl.Branch) {
+	sched.itemStages = stages
+	sched.itemBranches = branches
+}
+
+func (sched *myScheduler) SetSeeds(seeds []*http.Request) {
+	sched.seeds = seeds
+}
+
+func (sched *myScheduler) SetRecrawlPlanner(planner recrawl.Planner) {
+	sched.planner = planner
+}
+
+func (sched *myScheduler) SetProxyManager(manager dl.ProxyManager, assignment dl.ProxyAssignment) {
+	sched.proxyManager = manager
+	sched.proxyAssign = assignment
+}
+
+func (sched *myScheduler) SetBudgetArgs(args BudgetArgs) {
+	sched.budgetArgs = args
+}
+
+func (sched *myScheduler) SetTrapDetector(detector mdw.TrapDetector) {
+	sched.trapDetector = detector
+}
+
+func (sched *myScheduler) SetSession(sess session.Session) {
+	sched.session = sess
+}
+
+// 以代理管理器包裝HTTP用戶端產生函數。未設定代理管理器時傳回原函數。
+func (sched *myScheduler) wrapHttpClientGenerator(gen GenHttpClient) GenHttpClient {
+	if sched.proxyManager == nil {
+		return gen
+	}
+	manager := sched.proxyManager
+	assignment := sched.proxyAssign
+	return func() *http.Client {
+		return manager.Wrap(gen(), assignment)
+	}
+}
+
 // 開始下載。
+// 下載工作者的數量與網頁下載器池的尺寸相同，因此取出網頁下載器的動作不會被阻塞。
+// 在所有工作者都忙碌時，請求會在請求通道中等待，進而使請求滯留在請求快取中。
+// 啟用自動伸縮時，下載工作者的數量與網頁下載器池的最大尺寸相同，
+// 此時下載的並發量由網頁下載器池的目前尺寸限制。
 func (sched *myScheduler) startDownloading() {
-	go func() {
-		for {
-			req, ok := <-sched.getReqChan()
-			if !ok {
-				break
-			}
-			go sched.download(req)
+	reqChan := sched.getReqChan()
+	number := sched.dlpool.Total()
+	if sched.autoscaler != nil {
+		number = sched.autoscaleArgs.MaxSize()
+	}
+	startWorkers(number, func() {
+		for req := range reqChan {
+			sched.download(req)
+			sched.finishWork()
 		}
-	}()
+	})
 }
 
 // 下載。
@@ -229,40 +589,126 @@ func (sched *myScheduler) download(req base.Request) {
 			logger.Fatal(errMsg)
 		}
 	}()
+	if sched.budget != nil && !sched.budget.admit(&req) {
+		return
+	}
 	downloader, err := sched.dlpool.Take()
 	if err != nil {
 		errMsg := fmt.Sprintf("Downloader pool error: %s", err)
-		sched.sendError(errors.New(errMsg), SCHEDULER_CODE)
+		sched.sendError(errors.New(errMsg), SCHEDULER_CODE, reqDetail(&req))
 		return
 	}
 	defer func() {
 		err := sched.dlpool.Return(downloader)
 		if err != nil {
 			errMsg := fmt.Sprintf("Downloader pool error: %s", err)
-			sched.sendError(errors.New(errMsg), SCHEDULER_CODE)
+			sched.sendError(errors.New(errMsg), SCHEDULER_CODE, reqDetail(&req))
 		}
 	}()
 	code := generateCode(DOWNLOADER_CODE, downloader.Id())
-	respp, err := downloader.Download(req)
+	// 每一次下載嘗試（包括被網頁下載器重試的嘗試）都會被計入下載統計，
+	// 以便自動伸縮器察覺到最終成功之前的限流和錯誤。
+	// 不支援下載嘗試的回呼的網頁下載器則以最終的結果作為唯一的一次嘗試。
+	var attempts int
+	hook := func(latency time.Duration, httpResp *http.Response, err error) {
+		attempts++
+		sched.dlStats.recordAttempt(latency, httpResp, err)
+	}
+	httpReq := req.HttpReq()
+	// 停止分派器時，進行中的下載及其重試等待都會被取消。
+	// 下載結束之後則不再取消，以免中斷之後對響應內容的讀取。
+	ctx, cancel := context.WithCancel(dl.WithAttemptHook(httpReq.Context(), hook))
+	stopWatching := context.AfterFunc(sched.stopCtx, cancel)
+	hookedReq := base.NewRequestWithMeta(httpReq.WithContext(ctx), req.Depth(), req.Meta())
+	begin := time.Now()
+	respp, err := downloader.Download(*hookedReq)
+	stopWatching()
+	var httpResp *http.Response
+	if respp != nil {
+		httpResp = respp.HttpResp()
+	}
+	if attempts == 0 {
+		sched.dlStats.recordAttempt(time.Since(begin), httpResp, err)
+	}
+	sched.dlStats.record(httpResp, err)
+	sched.recordPage(&req, httpResp)
 	if respp != nil {
+		sched.inspectResp(respp, begin, code)
 		sched.sendResp(*respp, code)
 	}
 	if err != nil {
-		sched.sendError(err, code)
+		sched.sendError(err, code, reqDetail(&req))
 	}
 }
 
+// 讀取響應的內容，並依次交給內容去重器、重新爬取規劃器和爬取預算。
+// 響應的內容只會被讀取一次，隨後以可重新讀取的形式放回。參數fetchTime代表開始下載的時間。
+func (sched *myScheduler) inspectResp(resp *base.Response, fetchTime time.Time, code string) {
+	if sched.deduper == nil && sched.planner == nil && sched.budget == nil {
+		return
+	}
+	httpResp := resp.HttpResp()
+	if httpResp == nil || httpResp.Body == nil {
+		return
+	}
+	content, err := base.BufferBody(httpResp)
+	sched.chargeBudget(content)
+	if err != nil {
+		sched.sendError(err, code, respDetail(resp))
+		return
+	}
+	sched.checkDuplicate(resp, content)
+	sched.observeRecrawl(resp, content, fetchTime)
+}
+
+// 檢查響應的內容是否與已下載過的網頁重復，並在響應上做出標記。
+func (sched *myScheduler) checkDuplicate(resp *base.Response, content []byte) {
+	if sched.deduper == nil {
+		return
+	}
+	httpResp := resp.HttpResp()
+	// 錯誤頁面的內容往往千篇一律，它們不應被視為重復的網頁。
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		return
+	}
+	dupType, _ := sched.deduper.Check(content)
+	if dupType != fp.DUP_TYPE_NONE {
+		logger.Infof("The content of response is duplicate (type=%s, url=%s).\n",
+			dupType, httpResp.Request.URL)
+		resp.SetDuplicate(true)
+	}
+}
+
+// 在重新爬取規劃器中記錄下載。參數fetchTime代表開始下載的時間。
+func (sched *myScheduler) observeRecrawl(resp *base.Response, content []byte, fetchTime time.Time) {
+	if sched.planner == nil {
+		return
+	}
+	httpResp := resp.HttpResp()
+	if httpResp.Request == nil {
+		return
+	}
+	sched.planner.Observe(httpResp.Request.URL.String(), fetchTime, httpResp.StatusCode, content)
+}
+
+// 把響應的內容的位元組數計入爬取預算。
+func (sched *myScheduler) chargeBudget(content []byte) {
+	if sched.budget == nil {
+		return
+	}
+	sched.budget.addBytes(uint64(len(content)))
+}
+
 // 啟動分析器。
+// 分析工作者的數量與分析器池的尺寸相同。在所有工作者都忙碌時，下載工作者會在傳送響應時被阻塞。
 func (sched *myScheduler) activateAnalyzers(respParsers []anlz.ParseResponse) {
-	go func() {
-		for {
-			resp, ok := <-sched.getRespChan()
-			if !ok {
-				break
-			}
-			go sched.analyze(respParsers, resp)
+	respChan := sched.getRespChan()
+	startWorkers(sched.analyzerPool.Total(), func() {
+		for resp := range respChan {
+			sched.analyze(respParsers, resp)
+			sched.finishWork()
 		}
-	}()
+	})
 }
 
 // 分析。
@@ -276,14 +722,14 @@ func (sched *myScheduler) analyze(respParsers []anlz.ParseResponse, resp base.Re
 	analyzer, err := sched.analyzerPool.Take()
 	if err != nil {
 		errMsg := fmt.Sprintf("Analyzer pool error: %s", err)
-		sched.sendError(errors.New(errMsg), SCHEDULER_CODE)
+		sched.sendError(errors.New(errMsg), SCHEDULER_CODE, respDetail(&resp))
 		return
 	}
 	defer func() {
 		err := sched.analyzerPool.Return(analyzer)
 		if err != nil {
 			errMsg := fmt.Sprintf("Analyzer pool error: %s", err)
-			sched.sendError(errors.New(errMsg), SCHEDULER_CODE)
+			sched.sendError(errors.New(errMsg), SCHEDULER_CODE, respDetail(&resp))
 		}
 	}()
 	code := generateCode(ANALYZER_CODE, analyzer.Id())
@@ -295,44 +741,124 @@ func (sched *myScheduler) analyze(respParsers []anlz.ParseResponse, resp base.Re
 			}
 			switch d := data.(type) {
 			case *base.Request:
+				sched.recordLink(d)
+				if resp.Duplicate() && sched.suppressLinks {
+					continue
+				}
 				sched.saveReqToCache(*d, code)
 			case *base.Item:
 				sched.sendItem(*d, code)
 			default:
 				errMsg := fmt.Sprintf("Unsupported data type '%T'! (value=%v)\n", d, d)
-				sched.sendError(errors.New(errMsg), code)
+				sched.sendError(errors.New(errMsg), code, respDetail(&resp))
 			}
 		}
 	}
 	if errs != nil {
 		for _, err := range errs {
-			sched.sendError(err, code)
+			sched.sendError(err, code, respDetail(&resp))
+		}
+	}
+}
+
+// 把種子請求放入請求快取。
+// 與首次請求一樣，種子不會因已在已見集合中而被忽略，但同一次爬取流程中重復的種子只會被放入一次。
+func (sched *myScheduler) admitSeeds(firstReq *base.Request) {
+	admitted := map[string]bool{firstReq.Key(): true}
+	for _, seed := range sched.seeds {
+		if seed == nil || seed.URL == nil {
+			continue
+		}
+		if strings.ToLower(seed.URL.Scheme) != "http" {
+			logger.Warnf("Ignore the seed! It's url scheme '%s', but should be 'http'!\n", seed.URL.Scheme)
+			sched.errorAgg.Count(base.FILTER_ERROR)
+			continue
+		}
+		req := base.NewRequest(seed, 0)
+		key := req.Key()
+		if admitted[key] {
+			continue
+		}
+		admitted[key] = true
+		sched.seenSet.Add(key)
+		sched.addWork()
+		if !sched.reqCache.put(req) {
+			sched.finishWork()
 		}
 	}
 }
 
+// 在爬取圖中記錄已下載的網頁。
+// 被重新導向的請求會以最終的URL記錄網頁，因為分析器以它作為子網頁的父網頁的URL。
+// 重新導向途經的每個URL則被記錄為以重新導向響應的網頁，並以重新導向的邊依次相連。
+func (sched *myScheduler) recordPage(req *base.Request, httpResp *http.Response) {
+	if sched.crawlGraph == nil {
+		return
+	}
+	pageUrl := req.HttpReq().URL.String()
+	statusCode := 0
+	if httpResp != nil {
+		statusCode = httpResp.StatusCode
+		if httpResp.Request != nil && httpResp.Request.URL != nil {
+			pageUrl = httpResp.Request.URL.String()
+		}
+	}
+	sched.crawlGraph.AddPage(pageUrl, req.Depth(), statusCode)
+	if httpResp == nil {
+		return
+	}
+	// 每個重新導向之後的請求都帶有導致它的重新導向響應。
+	for redirected := httpResp.Request; redirected != nil && redirected.Response != nil; {
+		prev := redirected.Response.Request
+		if prev == nil || prev.URL == nil || redirected.URL == nil {
+			break
+		}
+		sched.crawlGraph.AddRedirect(prev.URL.String(), redirected.URL.String(),
+			req.Depth(), redirected.Response.StatusCode)
+		redirected = prev
+	}
+}
+
+// 在爬取圖中記錄由父網頁指向請求的連結。
+// 連結在請求被過濾之前被記錄，因此指向已請求過的網頁的連結也會被記錄。
+func (sched *myScheduler) recordLink(req *base.Request) {
+	if sched.crawlGraph == nil || !req.Valid() {
+		return
+	}
+	parentUrl := req.Meta().GetString(base.META_KEY_PARENT_URL)
+	if parentUrl == "" {
+		return
+	}
+	sched.crawlGraph.AddLink(parentUrl, req.HttpReq().URL.String())
+}
+
 // 開啟項目處理管線。
+// 項目處理工作者的數量由池基本參數中的項目處理管線的並行處理數量決定。
+// 在所有工作者都忙碌時，分析工作者會在傳送項目時被阻塞。
 func (sched *myScheduler) openItemPipeline() {
-	go func() {
-		sched.itemPipeline.SetFailFast(true)
-		code := ITEMPIPELINE_CODE
-		for item := range sched.getItemChan() {
-			go func(item base.Item) {
-				defer func() {
-					if p := recover(); p != nil {
-						errMsg := fmt.Sprintf("Fatal Item Processing Error: %s\n", p)
-						logger.Fatal(errMsg)
-					}
-				}()
-				errs := sched.itemPipeline.Send(item)
-				if errs != nil {
-					for _, err := range errs {
-						sched.sendError(err, code)
-					}
-				}
-			}(item)
+	itemChan := sched.getItemChan()
+	startWorkers(sched.poolBaseArgs.ItemPipelineConcurrency(), func() {
+		for item := range itemChan {
+			sched.processItem(item)
+			sched.finishWork()
+		}
+	})
+}
+
+// 處理項目。
+func (sched *myScheduler) processItem(item base.Item) {
+	defer func() {
+		if p := recover(); p != nil {
+			errMsg := fmt.Sprintf("Fatal Item Processing Error: %s\n", p)
+			logger.Fatal(errMsg)
 		}
 	}()
+	errs := sched.itemPipeline.Send(item)
+	if errs != nil {
+		for _, err := range errs {
+			sched.sendError(err, ITEMPIPELINE_CODE, itemDetail(item))
+		}
+	}
 }
 
 // 把請求存放到請求快取。
@@ -347,106 +873,186 @@ func (sched *myScheduler) saveReqToCache(req base.Request, code string) bool {
 		logger.Warnln("Ignore the request! It's url is is invalid!")
 		return false
 	}
+	// 被過濾的請求只會被計入錯誤匯集器的計數，以免大量的錯誤淹沒錯誤通道。
 	if strings.ToLower(reqUrl.Scheme) != "http" {
 		logger.Warnf("Ignore the request! It's url scheme '%s', but should be 'http'!\n", reqUrl.Scheme)
+		sched.errorAgg.Count(base.FILTER_ERROR)
 		return false
 	}
-	if _, ok := sched.urlMap[reqUrl.String()]; ok {
-		logger.Warnf("Ignore the request! It's url is repeated. (requestUrl=%s)\n", reqUrl)
-		return false
-	}
-	if pd, _ := getPrimaryDomain(httpReq.Host); pd != sched.primaryDomain {
-		logger.Warnf("Ignore the request! It's host '%s' not in primary domain '%s'. (requestUrl=%s)\n",
-			httpReq.Host, sched.primaryDomain, reqUrl)
+	if pd, _ := GetPrimaryDomain(httpReq.Host); !sched.primaryDomains[pd] {
+		logger.Warnf("Ignore the request! It's host '%s' not in the primary domains. (requestUrl=%s)\n",
+			httpReq.Host, reqUrl)
+		sched.errorAgg.Count(base.FILTER_ERROR)
 		return false
 	}
 	if req.Depth() > sched.crawlDepth {
 		logger.Warnf("Ignore the request! It's depth %d greater than %d. (requestUrl=%s)\n",
 			req.Depth(), sched.crawlDepth, reqUrl)
+		sched.errorAgg.Count(base.FILTER_ERROR)
 		return false
 	}
 	if sched.stopSign.Signed() {
 		sched.stopSign.Deal(code)
 		return false
 	}
-	sched.reqCache.put(&req)
-	sched.urlMap[reqUrl.String()] = true
+	// 已見過的URL應先被排除，以免它們被計入爬取預算。
+	if sched.seenSet.Contains(req.Key()) {
+		logger.Warnf("Ignore the request! It's url is repeated. (requestUrl=%s)\n", reqUrl)
+		return false
+	}
+	if sched.budget != nil && !sched.budget.allows(&req) {
+		return false
+	}
+	if !sched.seenSet.Add(req.Key()) {
+		logger.Warnf("Ignore the request! It's url is repeated. (requestUrl=%s)\n", reqUrl)
+		return false
+	}
+	// 只有成功加入已見集合的URL才會被陷阱檢測器檢查，因此每個URL只會被計入一次。
+	if sched.trapDetector != nil {
+		if trap, trapped := sched.trapDetector.Check(reqUrl); trapped {
+			logger.Warnf("Ignore the request! It's url triggers the trap '%s %s'. (requestUrl=%s)\n",
+				trap.Kind, trap.Pattern, reqUrl)
+			sched.errorAgg.Count(base.FILTER_ERROR)
+			return false
+		}
+	}
+	sched.addWork()
+	if !sched.reqCache.put(&req) {
+		sched.finishWork()
+		return false
+	}
 	return true
 }
 
 // 傳送響應。
 func (sched *myScheduler) sendResp(resp base.Response, code string) bool {
+	sched.rwmutex.RLock()
+	defer sched.rwmutex.RUnlock()
 	if sched.stopSign.Signed() {
 		sched.stopSign.Deal(code)
 		return false
 	}
-	sched.getRespChan() <- resp
-	return true
+	sched.addWork()
+	select {
+	case sched.getRespChan() <- resp:
+		return true
+	case <-sched.stopCh:
+		sched.finishWork()
+		sched.stopSign.Deal(code)
+		return false
+	}
 }
 
 // 傳送項目。
 func (sched *myScheduler) sendItem(item base.Item, code string) bool {
+	sched.rwmutex.RLock()
+	defer sched.rwmutex.RUnlock()
 	if sched.stopSign.Signed() {
 		sched.stopSign.Deal(code)
 		return false
 	}
-	sched.getItemChan() <- item
-	return true
+	sched.addWork()
+	select {
+	case sched.getItemChan() <- item:
+		return true
+	case <-sched.stopCh:
+		sched.finishWork()
+		sched.stopSign.Deal(code)
+		return false
+	}
 }
 
 // 傳送錯誤。
-func (sched *myScheduler) sendError(err error, code string) bool {
+// 參數detail代表錯誤的詳細訊息，其中的元件案例代號和重試標志會由該方法填入。
+// 若果參數err本身就是爬蟲錯誤，那麼它會被原樣傳送。
+func (sched *myScheduler) sendError(err error, code string, detail base.ErrorDetail) bool {
 	if err == nil {
 		return false
 	}
-	codePrefix := parseCode(code)[0]
-	var errorType base.ErrorType
-	switch codePrefix {
-	case DOWNLOADER_CODE:
-		errorType = base.DOWNLOADER_ERROR
-	case ANALYZER_CODE:
-		errorType = base.ANALYZER_ERROR
-	case ITEMPIPELINE_CODE:
-		errorType = base.ITEM_PROCESSOR_ERROR
-	}
-	cError := base.NewCrawlerError(errorType, err.Error())
+	cError, ok := err.(base.CrawlerError)
+	var inner base.CrawlerError
+	if !ok && errors.As(err, &inner) {
+		// 被包裝的爬蟲錯誤沿用其錯誤型態與詳細訊息，但錯誤提示訊息以外層的錯誤為準，以免遺失附加的上下文。
+		detail = base.ErrorDetail{
+			Url:         inner.Url(),
+			Depth:       inner.Depth(),
+			ComponentId: inner.ComponentId(),
+			StatusCode:  inner.StatusCode(),
+			Retryable:   inner.Retryable(),
+		}
+		cError = base.NewCrawlerErrorWithDetail(inner.Type(), err, detail)
+	} else if !ok {
+		codePrefix := parseCode(code)[0]
+		var errorType base.ErrorType
+		switch codePrefix {
+		case DOWNLOADER_CODE:
+			errorType = base.DOWNLOADER_ERROR
+			// 沒有響應的下載錯誤通常源於網路故障。
+			detail.Retryable = detail.StatusCode == 0
+		case ANALYZER_CODE:
+			errorType = base.ANALYZER_ERROR
+		case ITEMPIPELINE_CODE:
+			errorType = base.ITEM_PROCESSOR_ERROR
+		default:
+			errorType = base.SCHEDULER_ERROR
+		}
+		detail.ComponentId = code
+		if dl.IsRetryableStatus(detail.StatusCode) {
+			detail.Retryable = true
+		}
+		cError = base.NewCrawlerErrorWithDetail(errorType, err, detail)
+	}
 	if sched.stopSign.Signed() {
 		sched.stopSign.Deal(code)
 		return false
 	}
-	go func() {
-		sched.getErrorChan() <- cError
-	}()
-	return true
+	return sched.errorAgg.Put(cError)
 }
 
 // 分派。適當的搬運請求快取中的請求到請求通道。
 func (sched *myScheduler) schedule(interval time.Duration) {
 	go func() {
 		for {
-			if sched.stopSign.Signed() {
-				sched.stopSign.Deal(SCHEDULER_CODE)
+			if !sched.moveRequests() {
 				return
 			}
-			remainder := cap(sched.getReqChan()) - len(sched.getReqChan())
-			var temp *base.Request
-			for remainder > 0 {
-				temp = sched.reqCache.get()
-				if temp == nil {
-					break
-				}
-				if sched.stopSign.Signed() {
-					sched.stopSign.Deal(SCHEDULER_CODE)
-					return
-				}
-				sched.getReqChan() <- *temp
-				remainder--
+			select {
+			case <-time.After(interval):
+			case <-sched.stopCh:
+				sched.stopSign.Deal(SCHEDULER_CODE)
+				return
 			}
-			time.Sleep(interval)
 		}
 	}()
 }
 
+// 把請求快取中的請求搬運到請求通道，直到請求通道已滿或請求快取已空。
+// 若果分派器已被停止，那麼該方法會傳回false。
+func (sched *myScheduler) moveRequests() bool {
+	sched.rwmutex.RLock()
+	defer sched.rwmutex.RUnlock()
+	if sched.stopSign.Signed() {
+		sched.stopSign.Deal(SCHEDULER_CODE)
+		return false
+	}
+	reqChan := sched.getReqChan()
+	remainder := cap(reqChan) - len(reqChan)
+	for remainder > 0 {
+		temp := sched.reqCache.get()
+		if temp == nil {
+			break
+		}
+		select {
+		case reqChan <- *temp:
+		case <-sched.stopCh:
+			sched.stopSign.Deal(SCHEDULER_CODE)
+			return false
+		}
+		remainder--
+	}
+	return true
+}
+
 // 取得通道管理器持有的請求通道。
 func (sched *myScheduler) getReqChan() chan base.Request {
 	reqChan, err := sched.chanman.ReqChan()
@@ -473,12 +1079,3 @@ func (sched *myScheduler) getItemChan() chan base.Item {
 	}
 	return itemChan
 }
-
-// 取得通道管理器持有的錯誤通道。
-func (sched *myScheduler) getErrorChan() chan error {
-	errorChan, err := sched.chanman.ErrorChan()
-	if err != nil {
-		panic(err)
-	}
-	return errorChan
-}
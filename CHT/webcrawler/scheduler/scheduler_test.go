@@ -0,0 +1,926 @@
+package scheduler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	anlz "webcrawler/analyzer"
+	base "webcrawler/base"
+	dl "webcrawler/downloader"
+	graph "webcrawler/graph"
+	ipl "webcrawler/itempipeline"
+	mdw "webcrawler/middleware"
+	"webcrawler/recrawl"
+	"webcrawler/testhelper"
+	"webcrawler/tool/session"
+)
+
+// 傳回合成網站中被請求過的路徑的數量，以及被重復請求的路徑的清單。
+func siteResult(site testhelper.SyntheticSite) (int, []string) {
+	hits := site.Hits()
+	repeated := make([]string, 0)
+	for path, count := range hits {
+		if count > 1 {
+			repeated = append(repeated, fmt.Sprintf("%s(%d)", path, count))
+		}
+	}
+	return len(hits), repeated
+}
+
+// 啟動針對指定網站的爬取流程。
+func startCrawl(t *testing.T, url string, poolSize uint32, itemCount *uint64) Scheduler {
+	extractor, err := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Link extractor initialization failing: %s\n", err)
+	}
+	processItem := func(item base.Item) (base.Item, error) {
+		atomic.AddUint64(itemCount, 1)
+		return item, nil
+	}
+	firstHttpReq, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("ERROR: Request creation failing: %s\n", err)
+	}
+	sched := NewScheduler()
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(poolSize, poolSize),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{processItem},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	return sched
+}
+
+// 等待分派器完成所有的工作，並且不允許出現任何錯誤。
+func waitForDone(t *testing.T, sched Scheduler, timeout time.Duration) {
+	for _, err := range waitForCrawl(t, sched, timeout) {
+		t.Errorf("ERROR: Unexpected crawling error: %s\n", err)
+	}
+}
+
+// 等待分派器完成所有的工作。期間會不斷的取得摘要訊息和錯誤，以模擬監控者。
+// 結果值為期間收到的錯誤。
+func waitForCrawl(t *testing.T, sched Scheduler, timeout time.Duration) []error {
+	deadline := time.After(timeout)
+	errs := make([]error, 0)
+	errorChan := sched.ErrorChan()
+	done := false
+	for !done {
+		select {
+		case <-deadline:
+			t.Fatalf("ERROR: The crawling is not done in time!\n%s", sched.Summary("  ").Detail())
+		case err := <-errorChan:
+			errs = append(errs, err)
+		case <-sched.Done():
+			done = true
+		case <-time.After(10 * time.Millisecond):
+			_ = sched.Summary("").String()
+		}
+	}
+	// 所有的錯誤都在工作結束之前被傳送，因此只需取出錯誤通道中剩餘的錯誤。
+	for len(errorChan) > 0 {
+		errs = append(errs, <-errorChan)
+	}
+	if !sched.Idle() || sched.InFlight() != 0 || sched.(*myScheduler).reqCache.length() != 0 {
+		t.Errorf("ERROR: The scheduler is not idle after done!\n%s", sched.Summary("  ").Detail())
+	}
+	return errs
+}
+
+func TestConcurrentCrawls(t *testing.T) {
+	number := 4
+	sites := make([]testhelper.SyntheticSite, number)
+	for i := range sites {
+		site, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(60, 4, 0, 0, int64(i)))
+		if err != nil {
+			t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+		}
+		site.Start()
+		defer site.Close()
+		sites[i] = site
+	}
+	var wg sync.WaitGroup
+	itemCounts := make([]uint64, number)
+	for i := 0; i < number; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sched := startCrawl(t, sites[i].URL()+"/p0", 8, &itemCounts[i])
+			waitForDone(t, sched, 20*time.Second)
+			if !sched.Stop() {
+				t.Errorf("ERROR: The scheduler can not be stopped!\n")
+			}
+			if sched.Stop() {
+				t.Errorf("ERROR: The scheduler is stopped twice!\n")
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i, site := range sites {
+		requested, repeated := siteResult(site)
+		if requested != len(site.Pages()) {
+			t.Errorf("ERROR: Only %d of %d pages are requested! (site=%d)\n",
+				requested, len(site.Pages()), i)
+		}
+		if len(repeated) > 0 {
+			t.Errorf("ERROR: Some pages are requested repeatedly: %v (site=%d)\n", repeated, i)
+		}
+	}
+}
+
+func TestStopDuringCrawl(t *testing.T) {
+	for round := 0; round < 5; round++ {
+		site, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(200, 6, 0, 2*time.Millisecond, int64(round)))
+		if err != nil {
+			t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+		}
+		var itemCount uint64
+		sched := startCrawl(t, site.Start()+"/p0", 4, &itemCount)
+		// 在爬取流程進行中同時停止分派器和取得摘要訊息。
+		time.Sleep(time.Duration(round*10) * time.Millisecond)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = sched.Summary("").Detail()
+				sched.Stop()
+				_ = sched.Idle()
+			}()
+		}
+		wg.Wait()
+		if sched.Running() {
+			t.Errorf("ERROR: The scheduler is still running after stopping!\n")
+		}
+		if sched.ErrorChan() != nil {
+			t.Errorf("ERROR: The error channel is still available after stopping!\n")
+		}
+		site.Close()
+	}
+}
+
+func TestBoundedConcurrency(t *testing.T) {
+	site, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(40, 4, 0, time.Millisecond, 40))
+	if err != nil {
+		t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+	}
+	defer site.Close()
+	extractor, err := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Link extractor initialization failing: %s\n", err)
+	}
+	// 每個網頁都會產生一個項目，項目處理器會記錄同時處理的最大數量。
+	var itemProcessing, itemProcessingMax int32
+	processItem := func(item base.Item) (base.Item, error) {
+		current := atomic.AddInt32(&itemProcessing, 1)
+		defer atomic.AddInt32(&itemProcessing, -1)
+		for {
+			max := atomic.LoadInt32(&itemProcessingMax)
+			if current <= max || atomic.CompareAndSwapInt32(&itemProcessingMax, max, current) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return item, nil
+	}
+	makeItem := func(httpResp *http.Response, respDepth uint32) ([]base.Data, []error) {
+		item := base.Item(map[string]interface{}{"url": httpResp.Request.URL.String()})
+		return []base.Data{&item}, nil
+	}
+	firstHttpReq, _ := http.NewRequest("GET", site.Start()+"/p0", nil)
+	sched := NewScheduler()
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgsWithPipeline(4, 3, 2),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor, makeItem},
+		[]ipl.ProcessItem{processItem},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	waitForDone(t, sched, 20*time.Second)
+	sched.Stop()
+	if max := atomic.LoadInt32(&itemProcessingMax); max > 2 || max == 0 {
+		t.Errorf("ERROR: The max number of concurrent item processing is %d, but should be in [1, 2]!\n", max)
+	}
+	if requested, _ := siteResult(site); requested != len(site.Pages()) {
+		t.Errorf("ERROR: Only %d of %d pages are requested!\n", requested, len(site.Pages()))
+	}
+}
+
+func TestErrorAggregation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/bad1">1</a><a href="/bad2">2</a><a href="/bad3">3</a>`)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	var itemCount uint64
+	sched := startCrawl(t, server.URL+"/", 2, &itemCount)
+	received := 0
+	deadline := time.After(10 * time.Second)
+	for received < 3 {
+		select {
+		case err := <-sched.ErrorChan():
+			ce, ok := err.(base.CrawlerError)
+			if !ok || ce.Type() != base.ANALYZER_ERROR {
+				t.Fatalf("ERROR: Unexpected error: %v!\n", err)
+			}
+			if !strings.HasPrefix(ce.Url(), server.URL+"/bad") || ce.Depth() != 1 ||
+				ce.StatusCode() != http.StatusInternalServerError ||
+				!strings.HasPrefix(ce.ComponentId(), ANALYZER_CODE+"-") ||
+				!ce.Retryable() || ce.Cause() == nil || ce.Timestamp().IsZero() {
+				t.Errorf("ERROR: Incomplete error detail: %s", ce)
+			}
+			received++
+		case <-deadline:
+			t.Fatalf("ERROR: Only %d errors are received!\n", received)
+		}
+	}
+	sched.Stop()
+	errorAgg := sched.ErrorAggregator()
+	if errorAgg == nil || !errorAgg.Closed() {
+		t.Fatalf("ERROR: The error aggregator is not closed after stopping!\n")
+	}
+	if count := errorAgg.Counts()[base.ANALYZER_ERROR]; count != 3 {
+		t.Errorf("ERROR: The analyzer error count is %d, but should be 3!\n", count)
+	}
+	if recent := errorAgg.Recent(10); len(recent) != 3 {
+		t.Errorf("ERROR: The number of recent errors is %d, but should be 3!\n", len(recent))
+	}
+}
+
+func TestBlockPolicyWithoutErrorChan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			for i := 0; i < 10; i++ {
+				fmt.Fprintf(w, `<a href="/bad%d">%d</a>`, i, i)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	extractor, _ := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	firstHttpReq, _ := http.NewRequest("GET", server.URL+"/", nil)
+	sched := NewScheduler()
+	sched.SetErrorAggregatorArgs(mdw.NewErrorAggregatorArgs(100, mdw.OVERFLOW_POLICY_BLOCK, 0))
+	err := sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	defer sched.Stop()
+	// 錯誤通道從未被接收，但在阻塞策略下爬取流程也不應被阻塞。
+	select {
+	case <-sched.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatalf("ERROR: The crawling is blocked by the unread error channel!\n%s", sched.Summary("  ").Detail())
+	}
+	if total := sched.ErrorAggregator().Total(); total != 10 {
+		t.Errorf("ERROR: The number of errors is %d, but should be %d!\n", total, 10)
+	}
+}
+
+func TestSendWrappedError(t *testing.T) {
+	errorAgg, err := mdw.NewErrorAggregator(mdw.NewErrorAggregatorArgs(10, mdw.OVERFLOW_POLICY_DROP_OLDEST, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Error aggregator initialization failing: %s\n", err)
+	}
+	sched := &myScheduler{errorAgg: errorAgg, stopSign: mdw.NewStopSign()}
+	inner := base.NewCrawlerErrorWithDetail(base.DOWNLOADER_ERROR, fmt.Errorf("Timeout!"),
+		base.ErrorDetail{Url: "http://a.com/", StatusCode: 503, Retryable: true})
+	sched.sendError(inner, ITEMPIPELINE_CODE, base.ErrorDetail{})
+	sched.sendError(fmt.Errorf("Archiving failing: %w", inner), ITEMPIPELINE_CODE, base.ErrorDetail{})
+	errs := errorAgg.Recent(2)
+	if len(errs) != 2 || errs[0] != inner {
+		t.Fatalf("ERROR: The crawler error should be sent as it is, but the errors are %v!\n", errs)
+	}
+	// 被包裝的爬蟲錯誤保留外層的錯誤提示訊息，並沿用原有的錯誤型態與詳細訊息。
+	ce := errs[1].(base.CrawlerError)
+	if !strings.Contains(ce.Error(), "Archiving failing") || ce.Type() != base.DOWNLOADER_ERROR ||
+		ce.Url() != "http://a.com/" || ce.StatusCode() != 503 || !ce.Retryable() {
+		t.Errorf("ERROR: The wrapped crawler error is sent as %q (type=%s)!\n", ce.Error(), ce.Type())
+	}
+}
+
+func TestStartWithInvalidArgs(t *testing.T) {
+	sched := NewScheduler()
+	err := sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		1,
+		func() *http.Client { return &http.Client{} },
+		nil,
+		[]ipl.ProcessItem{},
+		nil)
+	if err == nil {
+		t.Fatalf("ERROR: No error with nil first request!\n")
+	}
+	if sched.Running() {
+		t.Errorf("ERROR: The scheduler is running after failed startup!\n")
+	}
+	// 開啟時的恐慌會被轉換為錯誤，並且分派器可以被再次開啟。
+	firstHttpReq, _ := http.NewRequest("GET", "http://127.0.0.1:1/", nil)
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		0,
+		func() *http.Client { panic("no client") },
+		nil,
+		[]ipl.ProcessItem{},
+		firstHttpReq)
+	if err == nil || !strings.Contains(err.Error(), "no client") {
+		t.Fatalf("ERROR: The panic in startup is turned into %v!\n", err)
+	}
+	if sched.Running() {
+		t.Errorf("ERROR: The scheduler is running after panicking startup!\n")
+	}
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		0,
+		func() *http.Client { return &http.Client{} },
+		nil,
+		[]ipl.ProcessItem{},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Startup after panicking startup failing: %s\n", err)
+	}
+	if !sched.Stop() {
+		t.Errorf("ERROR: The scheduler can not be stopped after restarting!\n")
+	}
+}
+
+func TestCrawlGraph(t *testing.T) {
+	pages := map[string]string{
+		"/":  `<a href="/a">a</a><a href="/b">b</a><a href="/missing">missing</a><a href="/old">old</a>`,
+		"/a": `<a href="/b">b</a>`,
+		"/b": `<a href="/">home</a>`,
+		"/c": `<a href="/a">a</a>`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+			return
+		}
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "<html><body>%s</body></html>", body)
+	}))
+	defer server.Close()
+	extractor, err := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Link extractor initialization failing: %s\n", err)
+	}
+	processItem := func(item base.Item) (base.Item, error) {
+		return item, nil
+	}
+	firstHttpReq, _ := http.NewRequest("GET", server.URL+"/", nil)
+	crawlGraph := graph.NewCrawlGraph()
+	sched := NewScheduler()
+	sched.SetCrawlGraph(crawlGraph)
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{processItem},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	// 只有針對失效網頁的分析會產生錯誤。
+	if errs := waitForCrawl(t, sched, 20*time.Second); len(errs) != 1 {
+		t.Errorf("ERROR: The crawling errors are %v, but there should be only one!\n", errs)
+	}
+	sched.Stop()
+	if count := crawlGraph.PageCount(); count != 6 {
+		t.Errorf("ERROR: The page count is %d, but should be %d!\n", count, 6)
+	}
+	if count := crawlGraph.LinkCount(); count != 8 {
+		t.Errorf("ERROR: The link count is %d, but should be %d!\n", count, 8)
+	}
+	report := crawlGraph.Report()
+	if report.FetchedCount != 6 {
+		t.Errorf("ERROR: The fetched count is %d, but should be %d!\n", report.FetchedCount, 6)
+	}
+	// 被重新導向的網頁以重新導向的邊指向最終的網頁，而子網頁的連結屬於最終的網頁。
+	oldPage, _ := crawlGraph.Page(server.URL + "/old")
+	newPage, _ := crawlGraph.Page(server.URL + "/c")
+	if oldPage.StatusCode != http.StatusMovedPermanently || !newPage.Fetched || newPage.Depth != 1 {
+		t.Errorf("ERROR: The redirected pages are %v and %v!\n", oldPage, newPage)
+	}
+	if report.OutDegree[server.URL+"/old"] != 1 || report.OutDegree[server.URL+"/c"] != 1 ||
+		len(report.Orphans) != 0 {
+		t.Errorf("ERROR: The redirected pages are not connected! (report=%s)\n", report)
+	}
+	var redirects int
+	for _, link := range crawlGraph.Links() {
+		if link.Redirect {
+			redirects++
+			if link.Source != server.URL+"/old" || link.Target != server.URL+"/c" {
+				t.Errorf("ERROR: The redirect edge %v is wrong!\n", link)
+			}
+		}
+	}
+	if redirects != 1 {
+		t.Errorf("ERROR: The number of redirect edges is %d, but should be 1!\n", redirects)
+	}
+	expectedBroken := graph.BrokenLink{
+		Source:     server.URL + "/",
+		Target:     server.URL + "/missing",
+		StatusCode: http.StatusNotFound,
+	}
+	if len(report.BrokenLinks) != 1 || report.BrokenLinks[0] != expectedBroken {
+		t.Errorf("ERROR: The broken links are %v, but should be [%v]!\n",
+			report.BrokenLinks, expectedBroken)
+	}
+	if in := report.InDegree[server.URL+"/b"]; in != 2 {
+		t.Errorf("ERROR: The in-degree of /b is %d, but should be %d!\n", in, 2)
+	}
+}
+
+// 以指定的網頁下載器產生函數爬取網站，並傳回爬取圖以及爬取期間的錯誤。
+func crawlWith(t *testing.T, startUrl string, gen GenPageDownloader) (graph.CrawlGraph, []error) {
+	extractor, err := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Link extractor initialization failing: %s\n", err)
+	}
+	processItem := func(item base.Item) (base.Item, error) {
+		return item, nil
+	}
+	firstHttpReq, _ := http.NewRequest("GET", startUrl, nil)
+	crawlGraph := graph.NewCrawlGraph()
+	sched := NewScheduler()
+	sched.SetCrawlGraph(crawlGraph)
+	sched.SetPageDownloaderGenerator(gen)
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(3, 3),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{processItem},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	errs := waitForCrawl(t, sched, 20*time.Second)
+	sched.Stop()
+	return crawlGraph, errs
+}
+
+// 獲得爬取圖中各網頁的狀態碼，以URL為鍵。
+func pageStatuses(crawlGraph graph.CrawlGraph) map[string]int {
+	statuses := make(map[string]int)
+	for _, page := range crawlGraph.Pages() {
+		statuses[page.Url] = page.StatusCode
+	}
+	return statuses
+}
+
+func TestRecordReplayCrawl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixture")
+	if err != nil {
+		t.Fatalf("ERROR: Temp dir creation failing: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+	site, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(30, 3, 2, 0, 2017))
+	if err != nil {
+		t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+	}
+	startUrl := site.Start() + "/p0"
+	recorded, recordErrs := crawlWith(t, startUrl, func(client *http.Client) dl.PageDownloader {
+		recorder, err := dl.NewRecordingDownloader(dl.NewPageDownloader(client), dir)
+		if err != nil {
+			t.Fatalf("ERROR: Recording downloader initialization failing: %s\n", err)
+		}
+		return recorder
+	})
+	site.Close()
+	expected := len(site.Reachable(100))
+	if count := recorded.Report().FetchedCount; count != uint64(expected) {
+		t.Errorf("ERROR: The number of recorded pages is %d, but should be %d!\n", count, expected)
+	}
+	for path, hits := range site.Hits() {
+		if hits != 1 {
+			t.Errorf("ERROR: The path %s is requested %d times!\n", path, hits)
+		}
+	}
+
+	// 網站已被關閉，重播只能依賴夾具。
+	replayed, replayErrs := crawlWith(t, startUrl, func(client *http.Client) dl.PageDownloader {
+		replayer, err := dl.NewReplayDownloader(dir)
+		if err != nil {
+			t.Fatalf("ERROR: Replay downloader initialization failing: %s\n", err)
+		}
+		return replayer
+	})
+	// 網頁的深度取決於並發的爬取順序，因此只比對URL和狀態碼。
+	if !reflect.DeepEqual(pageStatuses(recorded), pageStatuses(replayed)) {
+		t.Errorf("ERROR: The replayed pages are different from the recorded pages!\n")
+	}
+	if !reflect.DeepEqual(recorded.Links(), replayed.Links()) {
+		t.Errorf("ERROR: The replayed links are different from the recorded links!\n")
+	}
+	// 兩個失效連結在兩次爬取中都會產生分析錯誤。
+	if len(recordErrs) != 2 || len(replayErrs) != 2 {
+		t.Errorf("ERROR: The numbers of errors are %d and %d, but should be both %d!\n",
+			len(recordErrs), len(replayErrs), 2)
+	}
+}
+
+func TestDone(t *testing.T) {
+	sched := NewScheduler()
+	if sched.Done() != nil {
+		t.Errorf("ERROR: The done channel should be nil before starting!\n")
+	}
+	site, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(50, 3, 0, 20*time.Millisecond, 50))
+	if err != nil {
+		t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+	}
+	defer site.Close()
+	var itemCount uint64
+	sched = startCrawl(t, site.Start()+"/p0", 2, &itemCount)
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-sched.Done():
+		t.Fatalf("ERROR: The done channel is closed before the crawling completes!\n")
+	default:
+	}
+	if sched.InFlight() == 0 {
+		t.Errorf("ERROR: There should be some work in flight!\n")
+	}
+	// 停止分派器也會關閉完成通知通道。
+	sched.Stop()
+	select {
+	case <-sched.Done():
+	case <-time.After(time.Second):
+		t.Errorf("ERROR: The done channel is not closed after stopping!\n")
+	}
+}
+
+func TestRecrawlSeeds(t *testing.T) {
+	site, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(10, 2, 0, 0, 3))
+	if err != nil {
+		t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+	}
+	serverUrl := site.Start()
+	defer site.Close()
+	other, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(10, 2, 0, 0, 5))
+	if err != nil {
+		t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+	}
+	otherUrl := other.Start()
+	defer other.Close()
+	planner, err := recrawl.NewPlanner(recrawl.NewPlannerArgs(time.Hour, 24*time.Hour, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Planner initialization failing: %s\n", err)
+	}
+	var seeds []*http.Request
+	for _, path := range []string{"/p7", "/p0", "/p9"} {
+		seed, _ := http.NewRequest("GET", serverUrl+path, nil)
+		seeds = append(seeds, seed)
+	}
+	offsite, _ := http.NewRequest("GET", otherUrl+"/p1", nil)
+	secure, _ := http.NewRequest("GET", "https://example.com/p1", nil)
+	seeds = append(seeds, offsite, secure)
+	seenSet := mdw.NewMemorySeenSet()
+	firstHttpReq, _ := http.NewRequest("GET", serverUrl+"/p0", nil)
+	sched := NewScheduler()
+	sched.SetSeeds(seeds)
+	sched.SetSeenSet(seenSet)
+	sched.SetRecrawlPlanner(planner)
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		0,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{},
+		[]ipl.ProcessItem{},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	waitForCrawl(t, sched, 10*time.Second)
+	sched.Stop()
+	// 深度為0，因此只有首次請求和協定為http的種子會被下載，並且每個網頁只會被下載一次。
+	hits := site.Hits()
+	if !reflect.DeepEqual(hits, map[string]int{"/p0": 1, "/p7": 1, "/p9": 1}) {
+		t.Errorf("ERROR: The hits %v are wrong!\n", hits)
+	}
+	if hits := other.Hits(); !reflect.DeepEqual(hits, map[string]int{"/p1": 1}) {
+		t.Errorf("ERROR: The hits %v of the other site are wrong!\n", hits)
+	}
+	if planner.Len() != 4 {
+		t.Errorf("ERROR: The planner has %d urls, but should have %d!\n", planner.Len(), 4)
+	}
+	planned := planner.Plan(time.Now().Add(time.Hour), 0)
+	if len(planned) != 4 {
+		t.Errorf("ERROR: The number of planned seeds is %d, but should be %d!\n", len(planned), 4)
+	}
+	if !strings.Contains(sched.Summary("").String(), "Recrawl planner: urls: 4, observed: 4") {
+		t.Errorf("ERROR: The summary does not contain the planner summary!\n")
+	}
+
+	// 沿用同一個已見集合的第二次爬取流程仍然會下載所有規劃的種子。
+	var plannedSeeds []*http.Request
+	for _, seed := range planned {
+		seedReq, _ := http.NewRequest("GET", seed.Url, nil)
+		plannedSeeds = append(plannedSeeds, seedReq)
+	}
+	sched = NewScheduler()
+	sched.SetSeeds(plannedSeeds)
+	sched.SetSeenSet(seenSet)
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		0,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{},
+		[]ipl.ProcessItem{},
+		plannedSeeds[0])
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler restartup failing: %s\n", err)
+	}
+	waitForCrawl(t, sched, 10*time.Second)
+	sched.Stop()
+	if hits := site.Hits(); !reflect.DeepEqual(hits, map[string]int{"/p0": 2, "/p7": 2, "/p9": 2}) {
+		t.Errorf("ERROR: The hits %v after the recrawl are wrong!\n", hits)
+	}
+	if hits := other.Hits(); !reflect.DeepEqual(hits, map[string]int{"/p1": 2}) {
+		t.Errorf("ERROR: The hits %v of the other site after the recrawl are wrong!\n", hits)
+	}
+}
+
+// 啟動設定了爬取預算的爬取流程。
+func startBudgetCrawl(t *testing.T, url string, poolSize uint32, args BudgetArgs) Scheduler {
+	extractor, err := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Link extractor initialization failing: %s\n", err)
+	}
+	firstHttpReq, _ := http.NewRequest("GET", url, nil)
+	sched := NewScheduler()
+	sched.SetBudgetArgs(args)
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(poolSize, poolSize),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	return sched
+}
+
+func TestBudgets(t *testing.T) {
+	cases := []struct {
+		args   BudgetArgs
+		delay  time.Duration
+		reason string
+		check  func(pages int) bool
+	}{
+		{NewBudgetArgs(5, 0, 0, 0), 0, "max pages 5 reached", func(pages int) bool { return pages == 5 }},
+		// 位元組數的預算在下載之後才被計入，因此正在進行中的下載仍會完成。
+		{NewBudgetArgs(0, 300, 0, 0), 0, "max bytes 300 reached", func(pages int) bool { return pages >= 3 && pages < 10 }},
+		{NewBudgetArgs(0, 0, 100*time.Millisecond, 0), 20 * time.Millisecond, "max duration 100ms reached",
+			func(pages int) bool { return pages > 0 && pages < 50 }},
+		// 每個主機的預算耗盡時，爬取流程會正常結束，而不會被視為全域預算耗盡。
+		{NewBudgetArgs(0, 0, 0, 4), 0, "", func(pages int) bool { return pages == 4 }},
+	}
+	for i, c := range cases {
+		site, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(100, 3, 0, c.delay, int64(i)))
+		if err != nil {
+			t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+		}
+		sched := startBudgetCrawl(t, site.Start()+"/p0", 2, c.args)
+		waitForDone(t, sched, 10*time.Second)
+		stats := sched.Stats()
+		summary := sched.Summary("").String()
+		sched.Stop()
+		site.Close()
+		pages, repeated := siteResult(site)
+		if !c.check(pages) || len(repeated) > 0 {
+			t.Errorf("ERROR: The number of pages of case [%d] is %d (repeated: %v)!\n", i, pages, repeated)
+		}
+		if stats.BudgetExhausted != c.reason {
+			t.Errorf("ERROR: The exhausted reason of case [%d] is %q, but should be %q!\n",
+				i, stats.BudgetExhausted, c.reason)
+		}
+		if !strings.Contains(summary, "Crawl budget: pages: ") {
+			t.Errorf("ERROR: The summary of case [%d] does not contain the budget!\n%s", i, summary)
+		}
+	}
+	args := NewBudgetArgs(2, 0, 0, 3)
+	if err := args.Check(); err == nil {
+		t.Errorf("ERROR: The budget args with max pages per host greater than max pages should be invalid!\n")
+	}
+}
+
+func TestTrapDetection(t *testing.T) {
+	// 日曆網頁：每個月份都連結到上一個和下一個月份，形成無窮的URL空間。
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		var month int
+		fmt.Sscanf(r.URL.Query().Get("month"), "%d", &month)
+		fmt.Fprintf(w, `<html><body><a href="/cal?month=%d">prev</a><a href="/cal?month=%d">next</a></body></html>`,
+			month-1, month+1)
+	}))
+	defer server.Close()
+	detector, err := mdw.NewTrapDetector(mdw.NewTrapDetectorArgs(0, 0, 0, 5))
+	if err != nil {
+		t.Fatalf("ERROR: Trap detector initialization failing: %s\n", err)
+	}
+	extractor, _ := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	firstHttpReq, _ := http.NewRequest("GET", server.URL+"/cal?month=0", nil)
+	sched := NewScheduler()
+	sched.SetTrapDetector(detector)
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		1000,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	waitForDone(t, sched, 10*time.Second)
+	summary := sched.Summary("").String()
+	sched.Stop()
+	// 首次請求不經過檢測，其後的5個月份通過檢測。
+	if n := atomic.LoadInt64(&hits); n != 6 {
+		t.Errorf("ERROR: The number of requested pages is %d, but should be %d!\n", n, 6)
+	}
+	if !strings.Contains(summary, "Traps: checked: ") || !strings.Contains(summary, "pattern-urls") {
+		t.Errorf("ERROR: The summary does not contain the triggered trap!\n%s", summary)
+	}
+	// 被陷阱檢測器拒絕的請求只被計數，而不會被放入錯誤通道。
+	errorAgg := sched.ErrorAggregator()
+	if count := errorAgg.Counts()[base.FILTER_ERROR]; count == 0 || errorAgg.Total() != 0 {
+		t.Errorf("ERROR: The filter error count is %d and the error total is %d!\n", count, errorAgg.Total())
+	}
+}
+
+func TestSessionCrawl(t *testing.T) {
+	// 只有帶有權杖的請求才能取得網頁，否則會被拒絕。
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<html><body><a href="/a">a</a><a href="/b">b</a></body></html>`)
+			return
+		}
+		fmt.Fprint(w, `<html><body>ok</body></html>`)
+	}))
+	defer server.Close()
+	sess, err := session.NewSession(session.NewBearerAuthenticator("secret"), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("ERROR: Session initialization failing: %s\n", err)
+	}
+	extractor, _ := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	firstHttpReq, _ := http.NewRequest("GET", server.URL+"/", nil)
+	crawlGraph := graph.NewCrawlGraph()
+	sched := NewScheduler()
+	sched.SetCrawlGraph(crawlGraph)
+	sched.SetSession(sess)
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	if errs := waitForCrawl(t, sched, 10*time.Second); len(errs) != 0 {
+		t.Errorf("ERROR: The crawling errors are %v!\n", errs)
+	}
+	summary := sched.Summary("").String()
+	sched.Stop()
+	for url, status := range pageStatuses(crawlGraph) {
+		if status != http.StatusOK {
+			t.Errorf("ERROR: The status of %s is %d, but should be %d!\n", url, status, http.StatusOK)
+		}
+	}
+	if count := crawlGraph.PageCount(); count != 3 || sess.LoginCount() != 1 {
+		t.Errorf("ERROR: The page count is %d and the login count is %d!\n", count, sess.LoginCount())
+	}
+	if !strings.Contains(summary, "Session: auth: bearer") {
+		t.Errorf("ERROR: The summary does not contain the session!\n%s", summary)
+	}
+
+	// 測試伺服器本身也可以充當代理，經由它的請求仍然需要帶有權杖。
+	manager, err := dl.NewProxyManager(dl.NewProxyManagerArgs([]string{server.URL}, 1, time.Minute, "", 0))
+	if err != nil {
+		t.Fatalf("ERROR: Proxy manager initialization failing: %s\n", err)
+	}
+	defer manager.Close()
+	crawlGraph = graph.NewCrawlGraph()
+	sched = NewScheduler()
+	sched.SetCrawlGraph(crawlGraph)
+	sched.SetSession(sess)
+	sched.SetProxyManager(manager, dl.PROXY_PER_DOWNLOADER)
+	err = sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup with the proxy manager failing: %s\n", err)
+	}
+	if errs := waitForCrawl(t, sched, 10*time.Second); len(errs) != 0 {
+		t.Errorf("ERROR: The crawling errors with the proxy manager are %v!\n", errs)
+	}
+	sched.Stop()
+	for url, status := range pageStatuses(crawlGraph) {
+		if status != http.StatusOK {
+			t.Errorf("ERROR: The status of %s via the proxy is %d, but should be %d!\n", url, status, http.StatusOK)
+		}
+	}
+	if stats := manager.Stats(); stats[0].Successes != 3 {
+		t.Errorf("ERROR: The proxy stats %s are wrong!\n", stats[0])
+	}
+}
+
+func TestRetriedAttemptStats(t *testing.T) {
+	// 首次請求先被限流一次，重試之後才成功。
+	var hits int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "<html><body>ok</body></html>")
+	}))
+	defer server.Close()
+	dlArgs := dl.NewDownloaderArgs(nil, 2, 0)
+	if err := dlArgs.Check(); err != nil {
+		t.Fatalf("ERROR: Invalid downloader args: %s\n", err)
+	}
+	extractor, _ := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	firstHttpReq, _ := http.NewRequest("GET", server.URL+"/", nil)
+	sched := NewScheduler()
+	sched.SetPageDownloaderGenerator(func(httpClient *http.Client) dl.PageDownloader {
+		pageDownloader, _ := dl.NewPageDownloaderWithArgs(httpClient, dlArgs)
+		return pageDownloader
+	})
+	err := sched.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	waitForDone(t, sched, 10*time.Second)
+	stats := sched.Stats()
+	sched.Stop()
+	if stats.Downloads != 1 || stats.DownloadErrors != 0 || stats.Throttled != 1 {
+		t.Errorf("ERROR: The download stats (downloads=%d, errors=%d, throttled=%d) are wrong!\n",
+			stats.Downloads, stats.DownloadErrors, stats.Throttled)
+	}
+}
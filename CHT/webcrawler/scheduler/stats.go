@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"sync/atomic"
+	mdw "webcrawler/middleware"
+)
+
+// 分派器的統計訊息。它是某一時刻的快照，適合被程式處理。
+type SchedStats struct {
+	Running         bool   `json:"running"`                    // 分派器是否正在執行。
+	Urls            uint64 `json:"urls"`                       // 已請求的URL的數量。
+	Downloads       uint64 `json:"downloads"`                  // 下載次數。
+	DownloadErrors  uint64 `json:"download_errors"`            // 失敗的下載次數，包括5xx狀態碼。
+	Throttled       uint64 `json:"throttled"`                  // 被限流（429狀態碼）的下載嘗試的次數，包括之後被重試的嘗試。
+	Errors          uint64 `json:"errors"`                     // 錯誤匯集器收到的錯誤的總數。
+	ItemsProcessed  uint64 `json:"items_processed"`            // 已被項目處理管線處理的項目的數量。
+	ReqCacheLen     uint64 `json:"req_cache_len"`              // 請求快取的長度。
+	ReqChanLen      uint64 `json:"req_chan_len"`               // 請求通道中的請求的數量。
+	RespChanLen     uint64 `json:"resp_chan_len"`              // 響應通道中的響應的數量。
+	ItemChanLen     uint64 `json:"item_chan_len"`              // 項目通道中的項目的數量。
+	InFlight        uint64 `json:"in_flight"`                  // 正在進行中的工作的數量。
+	DlPoolTotal     uint32 `json:"dl_pool_total"`              // 網頁下載器池的容量。
+	DlPoolUsed      uint32 `json:"dl_pool_used"`               // 正在被使用的網頁下載器的數量。
+	BudgetExhausted string `json:"budget_exhausted,omitempty"` // 全域爬取預算耗盡的原因。為空時表示尚未耗盡。
+}
+
+// 獲得前沿的長度，即：等待下載的請求的數量。
+func (stats SchedStats) Frontier() uint64 {
+	return stats.ReqCacheLen + stats.ReqChanLen
+}
+
+func (sched *myScheduler) Stats() SchedStats {
+	status := atomic.LoadUint32(&sched.running)
+	if status != RUNNING_STATUS_RUNNING && status != RUNNING_STATUS_STOPPED {
+		return SchedStats{}
+	}
+	dlStats := sched.dlStats.snapshot()
+	stats := SchedStats{
+		Running:        status == RUNNING_STATUS_RUNNING,
+		Urls:           sched.seenSet.Len(),
+		Downloads:      dlStats.downloads,
+		DownloadErrors: dlStats.errors,
+		Throttled:      dlStats.throttled,
+		Errors:         sched.errorAgg.Total(),
+		ItemsProcessed: sched.itemPipeline.Count()[2],
+		ReqCacheLen:    uint64(sched.reqCache.length()),
+		InFlight:       sched.InFlight(),
+		DlPoolTotal:    sched.dlpool.Total(),
+		DlPoolUsed:     sched.dlpool.Used(),
+	}
+	if sched.budget != nil {
+		stats.BudgetExhausted = sched.budget.reason()
+	}
+	// 持有讀鎖以免通道管理器在取得通道時被關閉。
+	sched.rwmutex.RLock()
+	defer sched.rwmutex.RUnlock()
+	if sched.chanman.Status() == mdw.CHANNEL_MANAGER_STATUS_INITIALIZED {
+		stats.ReqChanLen = uint64(len(sched.getReqChan()))
+		stats.RespChanLen = uint64(len(sched.getRespChan()))
+		stats.ItemChanLen = uint64(len(sched.getItemChan()))
+	}
+	return stats
+}
@@ -1,8 +1,8 @@
 package scheduler
 
 import (
-	"bytes"
 	"fmt"
+	"sync/atomic"
 	base "webcrawler/base"
 )
 
@@ -18,37 +18,74 @@ func NewSchedSummary(sched *myScheduler, prefix string) SchedSummary {
 	if sched == nil {
 		return nil
 	}
-	urlCount := len(sched.urlMap)
-	var urlDetail string
-	if urlCount > 0 {
-		var buffer bytes.Buffer
-		buffer.WriteByte('\n')
-		for k, _ := range sched.urlMap {
-			buffer.WriteString(prefix)
-			buffer.WriteString(prefix)
-			buffer.WriteString(k)
-			buffer.WriteByte('\n')
-		}
-		urlDetail = buffer.String()
-	} else {
-		urlDetail = "\n"
-	}
 	return &mySchedSummary{
 		prefix:              prefix,
-		running:             sched.running,
+		running:             atomic.LoadUint32(&sched.running),
 		channelArgs:         sched.channelArgs,
 		poolBaseArgs:        sched.poolBaseArgs,
 		crawlDepth:          sched.crawlDepth,
 		chanmanSummary:      sched.chanman.Summary(),
 		reqCacheSummary:     sched.reqCache.summary(),
+		inFlight:            sched.InFlight(),
 		dlPoolLen:           sched.dlpool.Used(),
 		dlPoolCap:           sched.dlpool.Total(),
 		analyzerPoolLen:     sched.analyzerPool.Used(),
 		analyzerPoolCap:     sched.analyzerPool.Total(),
+		dlPoolStats:         sched.dlpool.Stats().String(),
+		analyzerPoolStats:   sched.analyzerPool.Stats().String(),
 		itemPipelineSummary: sched.itemPipeline.Summary(),
-		urlCount:            urlCount,
-		urlDetail:           urlDetail,
+		urlCount:            sched.seenSet.Len(),
+		seenSetSummary:      sched.seenSet.Summary(),
 		stopSignSummary:     sched.stopSign.Summary(),
+		errorSummary:        sched.errorAgg.Summary(),
+		autoscalerSummary: func() string {
+			if sched.autoscaler == nil {
+				return "<none>"
+			}
+			return sched.autoscaler.summary()
+		}(),
+		crawlGraphSummary: func() string {
+			if sched.crawlGraph == nil {
+				return "<none>"
+			}
+			return sched.crawlGraph.Summary()
+		}(),
+		plannerSummary: func() string {
+			if sched.planner == nil {
+				return "<none>"
+			}
+			return sched.planner.Summary()
+		}(),
+		trapSummary: func() string {
+			if sched.trapDetector == nil {
+				return "<none>"
+			}
+			return sched.trapDetector.Summary()
+		}(),
+		budgetSummary: func() string {
+			if sched.budget == nil {
+				return "<none>"
+			}
+			return sched.budget.summary()
+		}(),
+		proxySummary: func() string {
+			if sched.proxyManager == nil {
+				return "<none>"
+			}
+			return sched.proxyManager.Summary()
+		}(),
+		sessionSummary: func() string {
+			if sched.session == nil {
+				return "<none>"
+			}
+			return sched.session.Summary()
+		}(),
+		deduperSummary: func() string {
+			if sched.deduper == nil {
+				return "<none>"
+			}
+			return sched.deduper.Summary()
+		}(),
 	}
 }
 
@@ -61,14 +98,26 @@ type mySchedSummary struct {
 	crawlDepth          uint32            // 爬取的最大深度。
 	chanmanSummary      string            // 通道管理器的摘要訊息。
 	reqCacheSummary     string            // 請求快取的摘要訊息。
+	inFlight            uint64            // 正在進行中的工作的數量。
 	dlPoolLen           uint32            // 網頁下載器池的長度。
 	dlPoolCap           uint32            // 網頁下載器池的容量。
 	analyzerPoolLen     uint32            // 分析器池的長度。
 	analyzerPoolCap     uint32            // 分析器池的容量。
+	dlPoolStats         string            // 網頁下載器池的使用統計。
+	analyzerPoolStats   string            // 分析器池的使用統計。
 	itemPipelineSummary string            // 項目處理管線的摘要訊息。
-	urlCount            int               // 已請求的URL的計數。
-	urlDetail           string            // 已請求的URL的詳細訊息。
+	urlCount            uint64            // 已請求的URL的計數。
+	seenSetSummary      string            // 已見集合的摘要訊息。
 	stopSignSummary     string            // 停止訊號的摘要訊息。
+	deduperSummary      string            // 內容去重器的摘要訊息。
+	errorSummary        string            // 錯誤匯集器的摘要訊息。
+	autoscalerSummary   string            // 網頁下載器池的自動伸縮器的摘要訊息。
+	crawlGraphSummary   string            // 爬取圖的摘要訊息。
+	plannerSummary      string            // 重新爬取規劃器的摘要訊息。
+	proxySummary        string            // 代理管理器的摘要訊息。
+	sessionSummary      string            // 會話的摘要訊息。
+	budgetSummary       string            // 爬取預算的摘要訊息。
+	trapSummary         string            // 陷阱檢測器的摘要訊息。
 }
 
 func (ss *mySchedSummary) String() string {
@@ -86,36 +135,64 @@ func (ss *mySchedSummary) getSummary(detail bool) string {
 		prefix + "Channel args: %s \n" +
 		prefix + "Pool base args: %s \n" +
 		prefix + "Crawl depth: %d \n" +
+		prefix + "Crawl budget: %s\n" +
 		prefix + "Channels manager: %s \n" +
 		prefix + "Request cache: %s\n" +
-		prefix + "Downloader pool: %d/%d\n" +
-		prefix + "Analyzer pool: %d/%d\n" +
+		prefix + "In flight: %d\n" +
+		prefix + "Downloader pool: %d/%d%s\n" +
+		prefix + "Downloader autoscaler: %s\n" +
+		prefix + "Proxies: %s\n" +
+		prefix + "Session: %s\n" +
+		prefix + "Analyzer pool: %d/%d%s\n" +
 		prefix + "Item pipeline: %s\n" +
-		prefix + "Urls(%d): %s" +
+		prefix + "Urls(%d): %s\n" +
+		prefix + "Traps: %s\n" +
+		prefix + "Crawl graph: %s\n" +
+		prefix + "Recrawl planner: %s\n" +
+		prefix + "Content deduper: %s\n" +
+		prefix + "Errors: %s\n" +
 		prefix + "Stop sign: %s\n"
 	return fmt.Sprintf(template,
 		func() bool {
-			return ss.running == 1
+			return ss.running == RUNNING_STATUS_RUNNING
 		}(),
 		ss.channelArgs.String(),
 		ss.poolBaseArgs.String(),
 		ss.crawlDepth,
+		ss.budgetSummary,
 		ss.chanmanSummary,
 		ss.reqCacheSummary,
-		ss.dlPoolLen, ss.dlPoolCap,
-		ss.analyzerPoolLen, ss.analyzerPoolCap,
+		ss.inFlight,
+		ss.dlPoolLen, ss.dlPoolCap, poolStatsDetail(ss.dlPoolStats, detail),
+		ss.autoscalerSummary,
+		ss.proxySummary,
+		ss.sessionSummary,
+		ss.analyzerPoolLen, ss.analyzerPoolCap, poolStatsDetail(ss.analyzerPoolStats, detail),
 		ss.itemPipelineSummary,
 		ss.urlCount,
 		func() string {
 			if detail {
-				return ss.urlDetail
+				return ss.seenSetSummary
 			} else {
-				return "<concealed>\n"
+				return "<concealed>"
 			}
 		}(),
+		ss.trapSummary,
+		ss.crawlGraphSummary,
+		ss.plannerSummary,
+		ss.deduperSummary,
+		ss.errorSummary,
 		ss.stopSignSummary)
 }
 
+// 獲得池的使用統計的表示。使用統計只會出現在詳細表示中。
+func poolStatsDetail(stats string, detail bool) string {
+	if !detail {
+		return ""
+	}
+	return " (" + stats + ")"
+}
+
 func (ss *mySchedSummary) Same(other SchedSummary) bool {
 	if other == nil {
 		return false
@@ -132,7 +209,17 @@ func (ss *mySchedSummary) Same(other SchedSummary) bool {
 		ss.analyzerPoolCap != otherSs.analyzerPoolCap ||
 		ss.urlCount != otherSs.urlCount ||
 		ss.stopSignSummary != otherSs.stopSignSummary ||
+		ss.deduperSummary != otherSs.deduperSummary ||
+		ss.errorSummary != otherSs.errorSummary ||
+		ss.autoscalerSummary != otherSs.autoscalerSummary ||
+		ss.crawlGraphSummary != otherSs.crawlGraphSummary ||
+		ss.plannerSummary != otherSs.plannerSummary ||
+		ss.proxySummary != otherSs.proxySummary ||
+		ss.sessionSummary != otherSs.sessionSummary ||
+		ss.budgetSummary != otherSs.budgetSummary ||
+		ss.trapSummary != otherSs.trapSummary ||
 		ss.reqCacheSummary != otherSs.reqCacheSummary ||
+		ss.inFlight != otherSs.inFlight ||
 		ss.poolBaseArgs.String() != otherSs.poolBaseArgs.String() ||
 		ss.channelArgs.String() != otherSs.channelArgs.String() ||
 		ss.itemPipelineSummary != otherSs.itemPipelineSummary ||
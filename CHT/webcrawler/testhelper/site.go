@@ -0,0 +1,254 @@
+package testhelper
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 合成網站參數容器的描述範本。
+var siteArgsTemplate string = "{ pages: %d, fanout: %d, brokenLinks: %d, delay: %s, seed: %d }"
+
+// 合成網站參數的容器。
+type SiteArgs struct {
+	pages       uint32        // 網頁的數量。
+	fanout      uint32        // 每個網頁指向其他網頁的連結的數量。
+	brokenLinks uint32        // 指向不存在的網頁的連結的數量。
+	delay       time.Duration // 每次響應之前的延遲時間。
+	seed        int64         // 產生連結時使用的隨機種子。相同的參數總是產生相同的網站。
+	description string        // 描述。
+}
+
+// 建立合成網站參數的容器。
+func NewSiteArgs(
+	pages uint32,
+	fanout uint32,
+	brokenLinks uint32,
+	delay time.Duration,
+	seed int64) SiteArgs {
+	return SiteArgs{
+		pages:       pages,
+		fanout:      fanout,
+		brokenLinks: brokenLinks,
+		delay:       delay,
+		seed:        seed,
+	}
+}
+
+func (args *SiteArgs) Check() error {
+	if args.pages == 0 {
+		return errors.New("The number of pages can not be 0!\n")
+	}
+	if args.fanout == 0 {
+		return errors.New("The fanout can not be 0!\n")
+	}
+	if args.delay < 0 {
+		return errors.New("The delay can not be negative!\n")
+	}
+	return nil
+}
+
+func (args *SiteArgs) String() string {
+	if args.description == "" {
+		args.description =
+			fmt.Sprintf(siteArgsTemplate,
+				args.pages,
+				args.fanout,
+				args.brokenLinks,
+				args.delay,
+				args.seed)
+	}
+	return args.description
+}
+
+// 獲得網頁的數量。
+func (args *SiteArgs) Pages() uint32 {
+	return args.pages
+}
+
+// 獲得每個網頁指向其他網頁的連結的數量。
+func (args *SiteArgs) Fanout() uint32 {
+	return args.fanout
+}
+
+// 獲得指向不存在的網頁的連結的數量。
+func (args *SiteArgs) BrokenLinks() uint32 {
+	return args.brokenLinks
+}
+
+// 獲得每次響應之前的延遲時間。
+func (args *SiteArgs) Delay() time.Duration {
+	return args.delay
+}
+
+// 獲得隨機種子。
+func (args *SiteArgs) Seed() int64 {
+	return args.seed
+}
+
+// 合成網站的接口型態。
+// 網頁的路徑為「/p序號」，首頁為「/p0」。每個網頁都連結到下一個網頁，因此所有網頁都可以從首頁到達。
+// 其餘連結的目標由隨機種子決定。指向不存在的網頁的連結的路徑為「/missing/序號」，它們會得到404響應。
+type SyntheticSite interface {
+	http.Handler
+	// 啟動測試伺服器並傳回其URL。重復呼叫會傳回同一個URL。
+	Start() string
+	// 獲得測試伺服器的URL。尚未啟動時為空字串。
+	URL() string
+	// 關閉測試伺服器。
+	Close()
+	// 獲得所有存在的網頁的路徑。
+	Pages() []string
+	// 獲得網頁中的連結的路徑。
+	Links(path string) []string
+	// 獲得從首頁出發、在指定深度之內可以到達的所有路徑，包括不存在的網頁的路徑。
+	Reachable(depth uint32) []string
+	// 獲得各路徑被請求的次數。
+	Hits() map[string]int
+}
+
+// 建立合成網站。
+func NewSyntheticSite(args SiteArgs) (SyntheticSite, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	random := rand.New(rand.NewSource(args.Seed()))
+	pages := args.Pages()
+	links := make(map[string][]string, pages)
+	for i := uint32(0); i < pages; i++ {
+		targets := make([]string, 0, args.Fanout())
+		if i+1 < pages {
+			targets = append(targets, pagePath(i+1))
+		}
+		for uint32(len(targets)) < args.Fanout() {
+			targets = append(targets, pagePath(uint32(random.Intn(int(pages)))))
+		}
+		links[pagePath(i)] = targets
+	}
+	for i := uint32(0); i < args.BrokenLinks(); i++ {
+		source := pagePath(uint32(random.Intn(int(pages))))
+		links[source] = append(links[source], fmt.Sprintf("/missing/%d", i))
+	}
+	return &mySyntheticSite{
+		args:  args,
+		links: links,
+		hits:  make(map[string]int),
+	}, nil
+}
+
+// 獲得網頁的路徑。
+func pagePath(index uint32) string {
+	return fmt.Sprintf("/p%d", index)
+}
+
+// 合成網站的實現型態。
+type mySyntheticSite struct {
+	args   SiteArgs            // 參數。
+	links  map[string][]string // 各網頁中的連結，以網頁的路徑為鍵。
+	server *httptest.Server    // 測試伺服器。
+	hits   map[string]int      // 各路徑被請求的次數。
+	mutex  sync.Mutex          // 互斥鎖。
+}
+
+func (site *mySyntheticSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	site.mutex.Lock()
+	site.hits[r.URL.Path]++
+	site.mutex.Unlock()
+	if delay := site.args.Delay(); delay > 0 {
+		time.Sleep(delay)
+	}
+	targets, ok := site.links[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("<html><head><title>%s</title></head><body>", r.URL.Path))
+	for _, target := range targets {
+		builder.WriteString(fmt.Sprintf(`<a href="%s">%s</a>`, target, target))
+	}
+	builder.WriteString("</body></html>")
+	w.Write([]byte(builder.String()))
+}
+
+func (site *mySyntheticSite) Start() string {
+	site.mutex.Lock()
+	defer site.mutex.Unlock()
+	if site.server == nil {
+		site.server = httptest.NewServer(site)
+	}
+	return site.server.URL
+}
+
+func (site *mySyntheticSite) URL() string {
+	site.mutex.Lock()
+	defer site.mutex.Unlock()
+	if site.server == nil {
+		return ""
+	}
+	return site.server.URL
+}
+
+func (site *mySyntheticSite) Close() {
+	site.mutex.Lock()
+	server := site.server
+	site.mutex.Unlock()
+	if server != nil {
+		server.Close()
+	}
+}
+
+func (site *mySyntheticSite) Pages() []string {
+	pages := make([]string, 0, len(site.links))
+	for i := uint32(0); i < site.args.Pages(); i++ {
+		pages = append(pages, pagePath(i))
+	}
+	return pages
+}
+
+func (site *mySyntheticSite) Links(path string) []string {
+	targets := site.links[path]
+	result := make([]string, len(targets))
+	copy(result, targets)
+	return result
+}
+
+func (site *mySyntheticSite) Reachable(depth uint32) []string {
+	seen := map[string]bool{pagePath(0): true}
+	current := []string{pagePath(0)}
+	for d := uint32(0); d < depth && len(current) > 0; d++ {
+		next := make([]string, 0)
+		for _, path := range current {
+			for _, target := range site.links[path] {
+				if !seen[target] {
+					seen[target] = true
+					next = append(next, target)
+				}
+			}
+		}
+		current = next
+	}
+	result := make([]string, 0, len(seen))
+	for path := range seen {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (site *mySyntheticSite) Hits() map[string]int {
+	site.mutex.Lock()
+	defer site.mutex.Unlock()
+	hits := make(map[string]int, len(site.hits))
+	for path, count := range site.hits {
+		hits[path] = count
+	}
+	return hits
+}
@@ -0,0 +1,70 @@
+package testhelper
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSyntheticSiteDeterministic(t *testing.T) {
+	args := NewSiteArgs(20, 3, 2, 0, 42)
+	site1, err := NewSyntheticSite(args)
+	if err != nil {
+		t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+	}
+	site2, _ := NewSyntheticSite(args)
+	for _, page := range site1.Pages() {
+		if !reflect.DeepEqual(site1.Links(page), site2.Links(page)) {
+			t.Errorf("ERROR: The links of %s are different with the same seed!\n", page)
+		}
+	}
+	// 所有網頁都應該可以從首頁到達，並且還應包括兩個不存在的網頁。
+	reachable := site1.Reachable(100)
+	if len(reachable) != 22 {
+		t.Errorf("ERROR: The number of reachable paths is %d, but should be %d!\n", len(reachable), 22)
+	}
+	if reachable := site1.Reachable(0); len(reachable) != 1 || reachable[0] != "/p0" {
+		t.Errorf("ERROR: The reachable paths at depth 0 are %v, but should be [/p0]!\n", reachable)
+	}
+	if _, err := NewSyntheticSite(NewSiteArgs(0, 3, 0, 0, 1)); err == nil {
+		t.Errorf("ERROR: The site args with 0 pages should be invalid!\n")
+	}
+}
+
+func TestSyntheticSiteServe(t *testing.T) {
+	site, err := NewSyntheticSite(NewSiteArgs(5, 2, 1, 0, 7))
+	if err != nil {
+		t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+	}
+	serverUrl := site.Start()
+	defer site.Close()
+	if site.Start() != serverUrl || site.URL() != serverUrl {
+		t.Errorf("ERROR: The site URL should not change after restarting!\n")
+	}
+	resp, err := http.Get(serverUrl + "/p0")
+	if err != nil {
+		t.Fatalf("ERROR: Page requesting failing: %s\n", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, link := range site.Links("/p0") {
+		if !strings.Contains(string(body), `href="`+link+`"`) {
+			t.Errorf("ERROR: The page /p0 does not contain the link %s!\n", link)
+		}
+	}
+	resp, err = http.Get(serverUrl + "/missing/0")
+	if err != nil {
+		t.Fatalf("ERROR: Page requesting failing: %s\n", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("ERROR: The status code of missing page is %d, but should be %d!\n",
+			resp.StatusCode, http.StatusNotFound)
+	}
+	hits := site.Hits()
+	if hits["/p0"] != 1 || hits["/missing/0"] != 1 {
+		t.Errorf("ERROR: The hits %v are wrong!\n", hits)
+	}
+}
@@ -0,0 +1,157 @@
+package tool
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	sched "webcrawler/scheduler"
+)
+
+// 警報。
+type Alert struct {
+	Rule     string    // 觸發警報的規則的名稱。
+	Message  string    // 警報的內容。
+	Time     time.Time // 警報觸發或解除的時間。
+	Resolved bool      // 是否為解除警報。
+}
+
+func (alert Alert) String() string {
+	if alert.Resolved {
+		return fmt.Sprintf("Alert resolved [%s]: %s", alert.Rule, alert.Message)
+	}
+	return fmt.Sprintf("Alert [%s]: %s", alert.Rule, alert.Message)
+}
+
+// 警報規則的接口型態。
+// 監控者會在每一份快照產生後檢查所有的規則。
+// 規則由不滿足變為滿足時會觸發警報，由滿足變為不滿足時會解除警報。
+// 同一個規則的方法只會在同一個Goroutine中被呼叫。
+type AlertRule interface {
+	// 獲得規則的名稱。
+	Name() string
+	// 檢查規則是否被滿足。參數prev代表上一份快照，第一次檢查時它為零值。
+	// 第二個結果值代表對當前狀況的描述。
+	Check(prev, curr Snapshot) (bool, string)
+}
+
+// 建立錯誤率規則。
+// 當失敗的下載次數與下載次數之比大於參數maxRate時規則被滿足。
+// 分析器和項目處理管線的錯誤不被計入，因此錯誤率不會大於1。
+// 下載次數小於參數minSamples時規則不會被滿足，以免樣本過少時的誤報。
+func NewErrorRateRule(maxRate float64, minSamples uint64) (AlertRule, error) {
+	if maxRate < 0 {
+		errMsg := fmt.Sprintf("The max error rate %f is invalid!\n", maxRate)
+		return nil, errors.New(errMsg)
+	}
+	return &errorRateRule{maxRate: maxRate, minSamples: minSamples}, nil
+}
+
+// 錯誤率規則的實現型態。
+type errorRateRule struct {
+	maxRate    float64 // 最大錯誤率。
+	minSamples uint64  // 最少的下載次數。
+}
+
+func (rule *errorRateRule) Name() string {
+	return "error-rate"
+}
+
+func (rule *errorRateRule) Check(prev, curr Snapshot) (bool, string) {
+	stats := curr.Stats
+	if stats.Downloads == 0 || stats.Downloads < rule.minSamples {
+		return false, fmt.Sprintf("too few downloads (%d)", stats.Downloads)
+	}
+	rate := float64(stats.DownloadErrors) / float64(stats.Downloads)
+	return rate > rule.maxRate,
+		fmt.Sprintf("error rate %.3f (%d download errors / %d downloads, max %.3f)",
+			rate, stats.DownloadErrors, stats.Downloads, rule.maxRate)
+}
+
+// 建立空前沿規則。
+// 當分派器仍在執行，但前沿（等待下載的請求）持續為空的時間達到參數minDuration時規則被滿足。
+// 這通常意味著爬取即將結束，或者網頁下載器正在等待過慢的響應。
+func NewEmptyFrontierRule(minDuration time.Duration) AlertRule {
+	return &emptyFrontierRule{minDuration: minDuration}
+}
+
+// 空前沿規則的實現型態。
+type emptyFrontierRule struct {
+	minDuration time.Duration // 前沿持續為空的最短時間。
+	emptySince  time.Time     // 前沿開始為空的時間。
+}
+
+func (rule *emptyFrontierRule) Name() string {
+	return "empty-frontier"
+}
+
+func (rule *emptyFrontierRule) Check(prev, curr Snapshot) (bool, string) {
+	stats := curr.Stats
+	if !stats.Running || stats.Frontier() > 0 {
+		rule.emptySince = time.Time{}
+		return false, fmt.Sprintf("frontier size %d", stats.Frontier())
+	}
+	if rule.emptySince.IsZero() {
+		rule.emptySince = curr.Time
+	}
+	emptyFor := curr.Time.Sub(rule.emptySince)
+	return emptyFor >= rule.minDuration,
+		fmt.Sprintf("frontier empty for %s (%d in flight)", emptyFor, stats.InFlight)
+}
+
+// 閾值檢查函數的型態。
+type ThresholdFunc func(stats sched.SchedStats) (bool, string)
+
+// 建立以自定義函數檢查統計訊息的規則。
+func NewThresholdRule(name string, check ThresholdFunc) (AlertRule, error) {
+	if name == "" {
+		return nil, errors.New("The name of threshold rule is empty!\n")
+	}
+	if check == nil {
+		return nil, errors.New("The threshold function is invalid!\n")
+	}
+	return &thresholdRule{name: name, check: check}, nil
+}
+
+// 以自定義函數檢查統計訊息的規則的實現型態。
+type thresholdRule struct {
+	name  string        // 規則的名稱。
+	check ThresholdFunc // 閾值檢查函數。
+}
+
+func (rule *thresholdRule) Name() string {
+	return rule.name
+}
+
+func (rule *thresholdRule) Check(prev, curr Snapshot) (bool, string) {
+	return rule.check(curr.Stats)
+}
+
+// 警報評估器。它記錄每個規則的狀態，並只在狀態變化時產生警報。
+type alertEvaluator struct {
+	rules  []AlertRule // 警報規則的列表。
+	firing []bool      // 各規則是否正在觸發中。
+}
+
+// 建立警報評估器。
+func newAlertEvaluator(rules []AlertRule) *alertEvaluator {
+	return &alertEvaluator{rules: rules, firing: make([]bool, len(rules))}
+}
+
+// 依據快照檢查所有的規則，並傳回觸發或解除的警報。
+func (eval *alertEvaluator) evaluate(prev, curr Snapshot) []Alert {
+	var alerts []Alert
+	for i, rule := range eval.rules {
+		matched, message := rule.Check(prev, curr)
+		if matched == eval.firing[i] {
+			continue
+		}
+		eval.firing[i] = matched
+		alerts = append(alerts, Alert{
+			Rule:     rule.Name(),
+			Message:  message,
+			Time:     curr.Time,
+			Resolved: !matched,
+		})
+	}
+	return alerts
+}
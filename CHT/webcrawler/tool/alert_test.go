@@ -0,0 +1,52 @@
+package tool
+
+import (
+	"testing"
+	"time"
+	sched "webcrawler/scheduler"
+)
+
+func TestAlertEvaluator(t *testing.T) {
+	errorRateRule, err := NewErrorRateRule(0.2, 10)
+	if err != nil {
+		t.Fatalf("ERROR: Error rate rule initialization failing: %s\n", err)
+	}
+	frontierRule := NewEmptyFrontierRule(time.Second)
+	evaluator := newAlertEvaluator([]AlertRule{errorRateRule, frontierRule})
+	start := time.Now()
+	steps := []struct {
+		offset   time.Duration
+		stats    sched.SchedStats
+		expected []Alert
+	}{
+		// 樣本過少，前沿剛開始為空。
+		{0, sched.SchedStats{Running: true, Downloads: 5, DownloadErrors: 5}, nil},
+		{2 * time.Second, sched.SchedStats{Running: true, Downloads: 10, DownloadErrors: 3},
+			[]Alert{{Rule: "error-rate"}, {Rule: "empty-frontier"}}},
+		// 狀態未變化時不會重覆觸發。
+		{3 * time.Second, sched.SchedStats{Running: true, Downloads: 12, DownloadErrors: 3}, nil},
+		// 分析器和項目處理管線的錯誤不被計入錯誤率。
+		{4 * time.Second, sched.SchedStats{Running: true, Downloads: 20, DownloadErrors: 3, Errors: 30, ReqCacheLen: 1},
+			[]Alert{{Rule: "error-rate", Resolved: true}, {Rule: "empty-frontier", Resolved: true}}},
+	}
+	var prev Snapshot
+	for i, step := range steps {
+		curr := Snapshot{Time: start.Add(step.offset), Stats: step.stats}
+		alerts := evaluator.evaluate(prev, curr)
+		if len(alerts) != len(step.expected) {
+			t.Fatalf("ERROR: The alerts of step %d are %v, but should be %v!\n", i, alerts, step.expected)
+		}
+		for j, alert := range alerts {
+			if alert.Rule != step.expected[j].Rule || alert.Resolved != step.expected[j].Resolved {
+				t.Errorf("ERROR: The alert %v of step %d is wrong!\n", alert, i)
+			}
+		}
+		prev = curr
+	}
+	if _, err := NewErrorRateRule(-1, 0); err == nil {
+		t.Errorf("ERROR: The negative max error rate should be invalid!\n")
+	}
+	if _, err := NewThresholdRule("t", nil); err == nil {
+		t.Errorf("ERROR: The threshold rule without function should be invalid!\n")
+	}
+}
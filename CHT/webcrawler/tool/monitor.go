@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"runtime"
+	"sync"
 	"time"
 	sched "webcrawler/scheduler"
 )
@@ -14,207 +15,191 @@ var summaryForMonitoring = "Monitor - Collected information[%d]:\n" +
 	"  Scheduler:\n%s" +
 	"  Escaped time: %s\n"
 
-// 已達到最大閒置計數的訊息範本。
-var msgReachMaxIdleCount = "The scheduler has been idle for a period of time" +
-	" (about %s)." +
-	" Now consider what stop it."
+// 分派器已完成所有工作的訊息範本。
+var msgSchedulerDone = "The scheduler has done all work" +
+	" (about %s after monitoring)."
 
 // 停止分派器的訊息範本。
 var msgStopScheduler = "Stop scheduler...%s."
 
+// 爬取預算已耗盡的訊息範本。
+var msgBudgetExhausted = "The crawl budget is exhausted (%s), draining in-flight work..."
+
+// 監控者訂閱錯誤時所使用的緩沖區的尺寸。
+const ERROR_SUBSCRIPTION_BUFFER_SIZE = 100
+
 // 日志記錄函數的型態。
 // 參數level代表日志等級。等級設定：0：普通；1：警示；2：錯誤。
 type Record func(level byte, content string)
 
 // 分派器監控函數。
 // 參數scheduler代表作為監控目的的分派器。
-// 參數intervalNs代表檢查間隔時間，單位：毫微秒。
-// 參數maxIdleCount代表最大閒置計數。
-// 參數autoStop被用來指示該方法是否在分派器閒置一段時間（即持續閒置時間，由intervalNs * maxIdleCount得出）之後自行停止分派器。
-// 參數detailSummary被用來表示是否需要詳細的摘要訊息。
-// 參數record代表日志記錄函數。
-// 當監控結束之後，該方法會會向作為唯一傳回值的通道傳送一個代表了閒置狀態檢查次數的數值。
+// 參數intervalNs代表產生快照的間隔時間，單位：毫微秒。
+// 參數autoStop被用來指示該方法是否在分派器完成所有工作（即完成通知通道被關閉）之後自行停止分派器。
+// 參數reporter代表報告者。
+// 參數rules代表警報規則，它們會在每一份快照產生後被檢查。
+// 當監控結束之後，該方法會結束報告，並向作為唯一傳回值的通道傳送一個代表了快照數量的數值。
 func Monitoring(
 	scheduler sched.Scheduler,
 	intervalNs time.Duration,
-	maxIdleCount uint,
 	autoStop bool,
-	detailSummary bool,
-	record Record) <-chan uint64 {
+	reporter Reporter,
+	rules ...AlertRule) <-chan uint64 {
 	if scheduler == nil { // 分派器不能不可用！
 		panic(errors.New("The scheduler is invalid!"))
 	}
+	if reporter == nil { // 報告者不能不可用！
+		panic(errors.New("The reporter is invalid!"))
+	}
 	// 防止過小的參數值對爬取流程的影響
 	if intervalNs < time.Millisecond {
 		intervalNs = time.Millisecond
 	}
-	if maxIdleCount < 1000 {
-		maxIdleCount = 1000
-	}
 	// 監控停止知會器
 	stopNotifier := make(chan byte, 1)
+	var wg sync.WaitGroup
+	wg.Add(2)
 	// 接收和報告錯誤
-	reportError(scheduler, record, stopNotifier)
-	// 記錄摘要訊息
-	recordSummary(scheduler, detailSummary, record, stopNotifier)
+	reportError(scheduler, reporter, stopNotifier, &wg)
 	// 檢查計數通道
-	checkCountChan := make(chan uint64, 2)
-	// 檢查閒置狀態
-	checkStatus(scheduler,
-		intervalNs,
-		maxIdleCount,
-		autoStop,
-		checkCountChan,
-		record,
-		stopNotifier)
+	checkCountChan := make(chan uint64, 1)
+	// 報告快照
+	var checkCount uint64
+	reportSnapshot(scheduler, intervalNs, reporter, rules, &checkCount, stopNotifier, &wg)
+	// 等待分派器完成所有工作
+	waitForDone(scheduler, autoStop, reporter, stopNotifier)
+	go func() {
+		wg.Wait()
+		reporter.Finish()
+		checkCountChan <- checkCount
+	}()
 	return checkCountChan
 }
 
-// 檢查狀態，並在滿足持續閒置時間的條件時采取必要措施。
-func checkStatus(
+// 等待分派器完成所有工作，並在之後采取必要措施。
+func waitForDone(
 	scheduler sched.Scheduler,
-	intervalNs time.Duration,
-	maxIdleCount uint,
 	autoStop bool,
-	checkCountChan chan<- uint64,
-	record Record,
+	reporter Reporter,
 	stopNotifier chan<- byte) {
-	var checkCount uint64
 	go func() {
 		defer func() {
 			stopNotifier <- 1
 			stopNotifier <- 2
-			checkCountChan <- checkCount
 		}()
+		startTime := time.Now()
 		// 等待分派器開啟
 		waitForSchedulerStart(scheduler)
-		// 準備
-		var idleCount uint
-		var firstIdleTime time.Time
-		for {
-			// 檢查分派器的閒置狀態
-			if scheduler.Idle() {
-				idleCount++
-				if idleCount == 1 {
-					firstIdleTime = time.Now()
-				}
-				if idleCount >= maxIdleCount {
-					msg :=
-						fmt.Sprintf(msgReachMaxIdleCount, time.Since(firstIdleTime).String())
-					record(0, msg)
-					// 再次檢查分派器的閒置狀態，確保它已經可以被停止
-					if scheduler.Idle() {
-						if autoStop {
-							var result string
-							if scheduler.Stop() {
-								result = "success"
-							} else {
-								result = "failing"
-							}
-							msg = fmt.Sprintf(msgStopScheduler, result)
-							record(0, msg)
-						}
-						break
-					} else {
-						if idleCount > 0 {
-							idleCount = 0
-						}
-					}
-				}
+		<-scheduler.Done()
+		reporter.Message(LEVEL_INFO, fmt.Sprintf(msgSchedulerDone, time.Since(startTime).String()))
+		if autoStop {
+			var result string
+			if scheduler.Stop() {
+				result = "success"
 			} else {
-				if idleCount > 0 {
-					idleCount = 0
-				}
+				result = "failing"
 			}
-			checkCount++
-			time.Sleep(intervalNs)
+			reporter.Message(LEVEL_INFO, fmt.Sprintf(msgStopScheduler, result))
 		}
 	}()
 }
 
-// 記錄摘要訊息。
-func recordSummary(
+// 報告快照。
+// 快照會每隔一段時間被產生一次，並在產生後被用來檢查警報規則。
+// 分派器的爬取預算耗盡時，會另外報告一條警示等級的訊息。
+// 監控停止時會再產生最後一份快照，快照的數量會被存放到參數checkCount所指向的值中。
+func reportSnapshot(
 	scheduler sched.Scheduler,
-	detailSummary bool,
-	record Record,
-	stopNotifier <-chan byte) {
+	intervalNs time.Duration,
+	reporter Reporter,
+	rules []AlertRule,
+	checkCount *uint64,
+	stopNotifier <-chan byte,
+	wg *sync.WaitGroup) {
 	go func() {
+		defer wg.Done()
 		// 等待分派器開啟
 		waitForSchedulerStart(scheduler)
 		// 準備
-		var prevSchedSummary sched.SchedSummary
-		var prevNumGoroutine int
-		var recordCount uint64 = 1
+		evaluator := newAlertEvaluator(rules)
+		var prev Snapshot
 		startTime := time.Now()
+		report := func() {
+			curr := takeSnapshot(scheduler, startTime)
+			*checkCount++
+			reporter.Snapshot(curr)
+			if prev.Stats.BudgetExhausted == "" && curr.Stats.BudgetExhausted != "" {
+				reporter.Message(LEVEL_WARN, fmt.Sprintf(msgBudgetExhausted, curr.Stats.BudgetExhausted))
+			}
+			for _, alert := range evaluator.evaluate(prev, curr) {
+				reporter.Alert(alert)
+			}
+			prev = curr
+		}
 		for {
+			report()
 			// 檢視監控停止知會器
 			select {
 			case <-stopNotifier:
+				// 報告分派器的最終狀態
+				report()
 				return
-			default:
+			case <-time.After(intervalNs):
 			}
-			// 取得摘要訊息的各群組成部分
-			currNumGoroutine := runtime.NumGoroutine()
-			currSchedSummary := scheduler.Summary("    ")
-			// 比對前後兩份摘要訊息的一致性。只有不一致時才會予以記錄。
-			if currNumGoroutine != prevNumGoroutine ||
-				!currSchedSummary.Same(prevSchedSummary) {
-				schedSummaryStr := func() string {
-					if detailSummary {
-						return currSchedSummary.Detail()
-					} else {
-						return currSchedSummary.String()
-					}
-				}()
-				// 記錄摘要訊息
-				info := fmt.Sprintf(summaryForMonitoring,
-					recordCount,
-					currNumGoroutine,
-					schedSummaryStr,
-					time.Since(startTime).String(),
-				)
-				record(0, info)
-				prevNumGoroutine = currNumGoroutine
-				prevSchedSummary = currSchedSummary
-				recordCount++
-			}
-			time.Sleep(time.Microsecond)
 		}
 	}()
 }
 
+// 產生快照。
+func takeSnapshot(scheduler sched.Scheduler, startTime time.Time) Snapshot {
+	now := time.Now()
+	return Snapshot{
+		Time:       now,
+		Elapsed:    now.Sub(startTime),
+		Goroutines: runtime.NumGoroutine(),
+		Stats:      scheduler.Stats(),
+		Summary:    scheduler.Summary("    "),
+	}
+}
+
 // 接收和報告錯誤。
+// 錯誤會透過對錯誤匯集器的訂閱被接收。錯誤匯集器被關閉（即分派器停止）後，
+// 該函數仍會等待監控停止知會器，以保證等待分派器完成工作的函數能夠順利的結束。
 func reportError(
 	scheduler sched.Scheduler,
-	record Record,
-	stopNotifier <-chan byte) {
+	reporter Reporter,
+	stopNotifier <-chan byte,
+	wg *sync.WaitGroup) {
 	go func() {
+		defer wg.Done()
 		// 等待分派器開啟
 		waitForSchedulerStart(scheduler)
+		errorAgg := scheduler.ErrorAggregator()
+		if errorAgg == nil {
+			<-stopNotifier
+			return
+		}
+		subscription := errorAgg.Subscribe(ERROR_SUBSCRIPTION_BUFFER_SIZE)
+		defer subscription.Cancel()
+		errorChan := subscription.Errors()
 		for {
-			// 檢視監控停止知會器
 			select {
 			case <-stopNotifier:
 				return
-			default:
-			}
-			errorChan := scheduler.ErrorChan()
-			if errorChan == nil {
-				return
-			}
-			err := <-errorChan
-			if err != nil {
-				errMsg := fmt.Sprintf("Error (received from error channel): %s", err)
-				record(2, errMsg)
+			case err, ok := <-errorChan:
+				if !ok {
+					errorChan = nil
+					continue
+				}
+				reporter.Error(err)
 			}
-			time.Sleep(time.Microsecond)
 		}
 	}()
 }
 
-// 等待分派器開啟。
+// 等待分派器開啟。在此之前就已完成工作並被停止的分派器也被視為已開啟。
 func waitForSchedulerStart(scheduler sched.Scheduler) {
-	for !scheduler.Running() {
-		time.Sleep(time.Microsecond)
+	for scheduler.Done() == nil {
+		time.Sleep(time.Millisecond)
 	}
 }
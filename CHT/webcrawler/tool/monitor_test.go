@@ -0,0 +1,110 @@
+package tool
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	anlz "webcrawler/analyzer"
+	base "webcrawler/base"
+	ipl "webcrawler/itempipeline"
+	sched "webcrawler/scheduler"
+	"webcrawler/testhelper"
+)
+
+func TestMonitoringAutoStop(t *testing.T) {
+	site, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(20, 3, 0, 0, 1))
+	if err != nil {
+		t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+	}
+	serverUrl := site.Start()
+	defer site.Close()
+	scheduler := sched.NewScheduler()
+	reporter := NewRecordReporter(func(level byte, content string) {}, 0, false)
+	checkCountChan := Monitoring(scheduler, time.Millisecond, true, reporter)
+	extractor, err := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Link extractor initialization failing: %s\n", err)
+	}
+	firstHttpReq, _ := http.NewRequest("GET", serverUrl+"/p0", nil)
+	err = scheduler.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{func(item base.Item) (base.Item, error) { return item, nil }},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	select {
+	case checkCount := <-checkCountChan:
+		if checkCount == 0 {
+			t.Errorf("ERROR: The summary has never been checked!\n")
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatalf("ERROR: The monitoring is not finished in time!\n")
+	}
+	if scheduler.Running() {
+		t.Errorf("ERROR: The scheduler should be stopped by the monitor!\n")
+	}
+	if hits := len(site.Hits()); hits != len(site.Reachable(100)) {
+		t.Errorf("ERROR: Only %d of %d paths are requested!\n", hits, len(site.Reachable(100)))
+	}
+}
+
+func TestMonitoringBudget(t *testing.T) {
+	site, err := testhelper.NewSyntheticSite(testhelper.NewSiteArgs(20, 3, 0, 0, 1))
+	if err != nil {
+		t.Fatalf("ERROR: Synthetic site initialization failing: %s\n", err)
+	}
+	serverUrl := site.Start()
+	defer site.Close()
+	var mutex sync.Mutex
+	var warnings []string
+	var snapshots []string
+	reporter := NewRecordReporter(func(level byte, content string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if level == byte(LEVEL_WARN) {
+			warnings = append(warnings, content)
+		} else if strings.HasPrefix(content, "Monitor - ") {
+			snapshots = append(snapshots, content)
+		}
+	}, 0, false)
+	scheduler := sched.NewScheduler()
+	scheduler.SetBudgetArgs(sched.NewBudgetArgs(3, 0, 0, 0))
+	checkCountChan := Monitoring(scheduler, time.Millisecond, true, reporter)
+	extractor, _ := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	firstHttpReq, _ := http.NewRequest("GET", serverUrl+"/p0", nil)
+	err = scheduler.Start(
+		base.NewChannelArgs(2, 2, 2, 2),
+		base.NewPoolBaseArgs(2, 2),
+		100,
+		func() *http.Client { return &http.Client{} },
+		[]anlz.ParseResponse{extractor},
+		[]ipl.ProcessItem{},
+		firstHttpReq)
+	if err != nil {
+		t.Fatalf("ERROR: Scheduler startup failing: %s\n", err)
+	}
+	select {
+	case <-checkCountChan:
+	case <-time.After(20 * time.Second):
+		t.Fatalf("ERROR: The monitoring is not finished in time!\n")
+	}
+	if hits := len(site.Hits()); hits != 3 {
+		t.Errorf("ERROR: %d paths are requested, but the budget is %d!\n", hits, 3)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "max pages 3 reached") {
+		t.Errorf("ERROR: The warnings %v should report the exhausted budget!\n", warnings)
+	}
+	// 預算耗盡的訊息可能在最後一份快照之後才被報告，但最後一份快照的摘要一定包含耗盡的原因。
+	if len(snapshots) == 0 || !strings.Contains(snapshots[len(snapshots)-1], "exhausted: max pages 3 reached") {
+		t.Errorf("ERROR: The final summary does not contain the exhausted reason!\n%v", snapshots)
+	}
+}
@@ -0,0 +1,365 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	sched "webcrawler/scheduler"
+)
+
+// 報告的等級。
+type Level byte
+
+// 報告等級的常數。它們的值與日志記錄函數的參數level的取值相同。
+const (
+	LEVEL_INFO  Level = 0 // 普通。
+	LEVEL_WARN  Level = 1 // 警示。
+	LEVEL_ERROR Level = 2 // 錯誤。
+)
+
+func (level Level) String() string {
+	switch level {
+	case LEVEL_INFO:
+		return "INFO"
+	case LEVEL_WARN:
+		return "WARN"
+	case LEVEL_ERROR:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", byte(level))
+}
+
+// 監控快照。它在每一個檢查間隔被產生一次。
+type Snapshot struct {
+	Time       time.Time          // 產生的時間。
+	Elapsed    time.Duration      // 自監控開始以來經過的時間。
+	Goroutines int                // Goroutine的數量。
+	Stats      sched.SchedStats   // 分派器的統計訊息。
+	Summary    sched.SchedSummary // 分派器的摘要訊息。
+}
+
+// 報告者的接口型態。監控者會把快照、訊息、錯誤和警報交給報告者。
+// 報告者的方法可能會被並發的呼叫。
+type Reporter interface {
+	// 報告快照。
+	Snapshot(snapshot Snapshot)
+	// 報告訊息。
+	Message(level Level, content string)
+	// 報告從錯誤匯集器收到的錯誤。
+	Error(err error)
+	// 報告警報的觸發或解除。
+	Alert(alert Alert)
+	// 結束報告。它在監控結束時被呼叫一次，不會關閉報告者所使用的寫入器。
+	Finish()
+}
+
+// 建立把報告分派給多個報告者的報告者。
+func NewMultiReporter(reporters ...Reporter) Reporter {
+	return multiReporter(reporters)
+}
+
+// 把報告分派給多個報告者的報告者的實現型態。
+type multiReporter []Reporter
+
+func (mr multiReporter) Snapshot(snapshot Snapshot) {
+	for _, reporter := range mr {
+		reporter.Snapshot(snapshot)
+	}
+}
+
+func (mr multiReporter) Message(level Level, content string) {
+	for _, reporter := range mr {
+		reporter.Message(level, content)
+	}
+}
+
+func (mr multiReporter) Error(err error) {
+	for _, reporter := range mr {
+		reporter.Error(err)
+	}
+}
+
+func (mr multiReporter) Alert(alert Alert) {
+	for _, reporter := range mr {
+		reporter.Alert(alert)
+	}
+}
+
+func (mr multiReporter) Finish() {
+	for _, reporter := range mr {
+		reporter.Finish()
+	}
+}
+
+// 建立以文字形式寫入報告的報告者。
+// 快照只有在摘要訊息發生變化，並且距離上一次寫入快照已超過參數minInterval所代表的時間時才會被寫入。
+// 參數detail被用來表示是否需要詳細的摘要訊息。
+func NewTextReporter(w io.Writer, minInterval time.Duration, detail bool) Reporter {
+	return NewRecordReporter(func(level byte, content string) {
+		fmt.Fprintf(w, "[%s] %s\n", Level(level), strings.TrimRight(content, "\n"))
+	}, minInterval, detail)
+}
+
+// 建立以日志記錄函數記錄報告的報告者。快照的節流規則與 NewTextReporter 相同。
+func NewRecordReporter(record Record, minInterval time.Duration, detail bool) Reporter {
+	return &textReporter{record: record, minInterval: minInterval, detail: detail}
+}
+
+// 以文字形式記錄報告的報告者的實現型態。
+type textReporter struct {
+	record      Record             // 日志記錄函數。
+	minInterval time.Duration      // 記錄快照的最小間隔時間。
+	detail      bool               // 是否需要詳細的摘要訊息。
+	prevSummary sched.SchedSummary // 上一次記錄的摘要訊息。
+	prevNumGo   int                // 上一次記錄的Goroutine的數量。
+	prevTime    time.Time          // 上一次記錄快照的時間。
+	recordCount uint64             // 已記錄的快照的數量。
+	pending     *Snapshot          // 因節流而未被記錄的最新快照。
+	mutex       sync.Mutex         // 互斥鎖。
+}
+
+func (tr *textReporter) Snapshot(snapshot Snapshot) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	if snapshot.Summary == nil {
+		return
+	}
+	// 比對前後兩份摘要訊息的一致性。只有不一致時才會予以記錄。
+	if snapshot.Goroutines == tr.prevNumGo && snapshot.Summary.Same(tr.prevSummary) {
+		return
+	}
+	if !tr.prevTime.IsZero() && snapshot.Time.Sub(tr.prevTime) < tr.minInterval {
+		tr.pending = &snapshot
+		return
+	}
+	tr.write(snapshot)
+}
+
+// 記錄快照。
+func (tr *textReporter) write(snapshot Snapshot) {
+	var schedSummaryStr string
+	if tr.detail {
+		schedSummaryStr = snapshot.Summary.Detail()
+	} else {
+		schedSummaryStr = snapshot.Summary.String()
+	}
+	tr.recordCount++
+	info := fmt.Sprintf(summaryForMonitoring,
+		tr.recordCount,
+		snapshot.Goroutines,
+		schedSummaryStr,
+		snapshot.Elapsed.String(),
+	)
+	tr.record(byte(LEVEL_INFO), info)
+	tr.prevSummary = snapshot.Summary
+	tr.prevNumGo = snapshot.Goroutines
+	tr.prevTime = snapshot.Time
+	tr.pending = nil
+}
+
+func (tr *textReporter) Message(level Level, content string) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.record(byte(level), content)
+}
+
+func (tr *textReporter) Error(err error) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	errMsg := fmt.Sprintf("Error (received from error aggregator): %s", err)
+	tr.record(byte(LEVEL_ERROR), errMsg)
+}
+
+func (tr *textReporter) Alert(alert Alert) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.record(byte(LEVEL_WARN), alert.String())
+}
+
+// 記錄因節流而未被記錄的最新快照，以免遺漏最終的狀態。
+func (tr *textReporter) Finish() {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	if tr.pending != nil {
+		tr.write(*tr.pending)
+	}
+}
+
+// 建立以JSON行的形式寫入報告的報告者。每一份報告都是一個獨立的JSON物件，並佔據一行。
+// 物件的type字段的值為snapshot、message、error或alert。
+func NewJSONReporter(w io.Writer) Reporter {
+	return &jsonReporter{encoder: json.NewEncoder(w)}
+}
+
+// 以JSON行的形式寫入報告的報告者的實現型態。
+type jsonReporter struct {
+	encoder *json.Encoder // JSON編碼器。
+	mutex   sync.Mutex    // 互斥鎖。
+}
+
+// JSON格式的報告。
+type jsonReport struct {
+	Type       string            `json:"type"`
+	Time       time.Time         `json:"time"`
+	ElapsedMs  int64             `json:"elapsed_ms,omitempty"`
+	Goroutines int               `json:"goroutines,omitempty"`
+	Stats      *sched.SchedStats `json:"stats,omitempty"`
+	Level      string            `json:"level,omitempty"`
+	Content    string            `json:"content,omitempty"`
+	Rule       string            `json:"rule,omitempty"`
+	Resolved   bool              `json:"resolved,omitempty"`
+}
+
+// 寫入一份報告。
+func (jr *jsonReporter) write(report jsonReport) {
+	jr.mutex.Lock()
+	defer jr.mutex.Unlock()
+	jr.encoder.Encode(report)
+}
+
+func (jr *jsonReporter) Snapshot(snapshot Snapshot) {
+	stats := snapshot.Stats
+	jr.write(jsonReport{
+		Type:       "snapshot",
+		Time:       snapshot.Time,
+		ElapsedMs:  int64(snapshot.Elapsed / time.Millisecond),
+		Goroutines: snapshot.Goroutines,
+		Stats:      &stats,
+	})
+}
+
+func (jr *jsonReporter) Message(level Level, content string) {
+	jr.write(jsonReport{
+		Type:    "message",
+		Time:    time.Now(),
+		Level:   strings.ToLower(level.String()),
+		Content: content,
+	})
+}
+
+func (jr *jsonReporter) Error(err error) {
+	jr.write(jsonReport{
+		Type:    "error",
+		Time:    time.Now(),
+		Level:   strings.ToLower(LEVEL_ERROR.String()),
+		Content: strings.TrimRight(err.Error(), "\n"),
+	})
+}
+
+func (jr *jsonReporter) Alert(alert Alert) {
+	jr.write(jsonReport{
+		Type:     "alert",
+		Time:     alert.Time,
+		Level:    strings.ToLower(LEVEL_WARN.String()),
+		Content:  alert.Message,
+		Rule:     alert.Rule,
+		Resolved: alert.Resolved,
+	})
+}
+
+func (jr *jsonReporter) Finish() {}
+
+// 建立在終端上顯示進度的報告者。
+// 進度行會顯示已下載的網頁數量、每秒下載的網頁數量、各佇列的長度以及預計剩餘時間，並在原地刷新。
+// 參數expectedPages代表預計的網頁總數，為0時預計剩餘時間會依據進行中的工作的數量估算。
+func NewProgressReporter(w io.Writer, expectedPages uint64) Reporter {
+	return &progressReporter{w: w, expectedPages: expectedPages}
+}
+
+// 在終端上顯示進度的報告者的實現型態。
+type progressReporter struct {
+	w             io.Writer  // 寫入器。
+	expectedPages uint64     // 預計的網頁總數。
+	prev          *Snapshot  // 上一份快照。
+	rate          float64    // 平滑後的每秒下載的網頁數量。
+	lineWritten   bool       // 進度行是否已被寫入。
+	mutex         sync.Mutex // 互斥鎖。
+}
+
+// 速率平滑時新樣本所佔的比重。
+const PROGRESS_RATE_SMOOTHING = 0.3
+
+func (pr *progressReporter) Snapshot(snapshot Snapshot) {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	if pr.prev != nil {
+		interval := snapshot.Time.Sub(pr.prev.Time).Seconds()
+		if interval > 0 {
+			current := float64(snapshot.Stats.Downloads-pr.prev.Stats.Downloads) / interval
+			if pr.rate == 0 {
+				pr.rate = current
+			} else {
+				pr.rate = PROGRESS_RATE_SMOOTHING*current + (1-PROGRESS_RATE_SMOOTHING)*pr.rate
+			}
+		}
+	}
+	pr.prev = &snapshot
+	fmt.Fprintf(pr.w, "\r%s\x1b[K", progressLine(snapshot.Stats, pr.rate, pr.expectedPages))
+	pr.lineWritten = true
+}
+
+// 產生進度行。
+func progressLine(stats sched.SchedStats, rate float64, expectedPages uint64) string {
+	pages := fmt.Sprintf("%d", stats.Downloads)
+	if expectedPages > 0 {
+		pages = fmt.Sprintf("%d/%d", stats.Downloads, expectedPages)
+	}
+	line := fmt.Sprintf("pages: %s | %.1f pages/s | queue: cache %d, req %d, resp %d, item %d"+
+		" | in flight: %d | errors: %d | ETA: %s",
+		pages, rate, stats.ReqCacheLen, stats.ReqChanLen, stats.RespChanLen, stats.ItemChanLen,
+		stats.InFlight, stats.Errors, estimate(stats, rate, expectedPages))
+	if stats.BudgetExhausted != "" {
+		line += " | budget exhausted: " + stats.BudgetExhausted
+	}
+	return line
+}
+
+// 估算剩餘時間。無法估算時傳回「--」。
+func estimate(stats sched.SchedStats, rate float64, expectedPages uint64) string {
+	if rate <= 0 {
+		return "--"
+	}
+	remaining := float64(stats.InFlight)
+	if expectedPages > 0 {
+		remaining = 0
+		if expectedPages > stats.Downloads {
+			remaining = float64(expectedPages - stats.Downloads)
+		}
+	}
+	eta := time.Duration(remaining / rate * float64(time.Second))
+	return eta.Round(time.Second).String()
+}
+
+// 在新的一行寫入內容。進度行會在下一份快照到來時重新出現。
+func (pr *progressReporter) writeLine(content string) {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	if pr.lineWritten {
+		fmt.Fprint(pr.w, "\r\x1b[K")
+		pr.lineWritten = false
+	}
+	fmt.Fprintln(pr.w, strings.TrimRight(content, "\n"))
+}
+
+func (pr *progressReporter) Message(level Level, content string) {
+	pr.writeLine(fmt.Sprintf("[%s] %s", level, content))
+}
+
+// 進度顯示不會逐一列出錯誤，錯誤的總數已顯示在進度行中。
+func (pr *progressReporter) Error(err error) {}
+
+func (pr *progressReporter) Alert(alert Alert) {
+	pr.writeLine(fmt.Sprintf("[%s] %s", LEVEL_WARN, alert))
+}
+
+func (pr *progressReporter) Finish() {
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+	if pr.lineWritten {
+		fmt.Fprintln(pr.w)
+		pr.lineWritten = false
+	}
+}
@@ -0,0 +1,103 @@
+package tool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	sched "webcrawler/scheduler"
+)
+
+// 用於測試的摘要訊息。
+type testSummary string
+
+func (ts testSummary) String() string { return string(ts) }
+func (ts testSummary) Detail() string { return string(ts) + " (detail)" }
+func (ts testSummary) Same(other sched.SchedSummary) bool {
+	return other != nil && other.String() == ts.String()
+}
+
+func TestTextReporterThrottle(t *testing.T) {
+	var buf bytes.Buffer
+	reporter := NewTextReporter(&buf, time.Second, false)
+	start := time.Now()
+	snapshot := func(offset time.Duration, summary string) Snapshot {
+		return Snapshot{Time: start.Add(offset), Elapsed: offset, Goroutines: 1, Summary: testSummary(summary)}
+	}
+	reporter.Snapshot(snapshot(0, "a\n"))
+	reporter.Snapshot(snapshot(10*time.Millisecond, "a\n")) // 未變化
+	reporter.Snapshot(snapshot(20*time.Millisecond, "b\n")) // 被節流
+	reporter.Snapshot(snapshot(2*time.Second, "c\n"))
+	reporter.Snapshot(snapshot(2100*time.Millisecond, "d\n")) // 被節流，但在結束時被記錄
+	reporter.Error(errors.New("boom"))
+	reporter.Finish()
+	output := buf.String()
+	if count := strings.Count(output, "Monitor - Collected information"); count != 3 {
+		t.Errorf("ERROR: The number of recorded snapshots is %d, but should be %d!\n%s", count, 3, output)
+	}
+	if strings.Contains(output, "b\n") || !strings.Contains(output, "d\n") {
+		t.Errorf("ERROR: The throttled snapshots are not handled correctly!\n%s", output)
+	}
+	if !strings.Contains(output, "[ERROR] Error (received from error aggregator): boom") {
+		t.Errorf("ERROR: The error is not recorded!\n%s", output)
+	}
+}
+
+func TestJSONReporter(t *testing.T) {
+	var buf bytes.Buffer
+	reporter := NewJSONReporter(&buf)
+	reporter.Snapshot(Snapshot{Time: time.Now(), Stats: sched.SchedStats{Downloads: 5, ReqCacheLen: 2}})
+	reporter.Message(LEVEL_WARN, "hello")
+	reporter.Error(errors.New("boom\n"))
+	reporter.Alert(Alert{Rule: "r", Message: "m", Time: time.Now(), Resolved: true})
+	reporter.Finish()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("ERROR: The number of JSON lines is %d, but should be %d!\n", len(lines), 4)
+	}
+	expectedTypes := []string{"snapshot", "message", "error", "alert"}
+	for i, line := range lines {
+		var report map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &report); err != nil {
+			t.Fatalf("ERROR: The line %q is not valid JSON: %s\n", line, err)
+		}
+		if report["type"] != expectedTypes[i] {
+			t.Errorf("ERROR: The type of line %d is %v, but should be %s!\n", i, report["type"], expectedTypes[i])
+		}
+	}
+	if !strings.Contains(lines[0], `"downloads":5`) || !strings.Contains(lines[0], `"req_cache_len":2`) {
+		t.Errorf("ERROR: The snapshot line %s does not contain the stats!\n", lines[0])
+	}
+	if !strings.Contains(lines[2], `"content":"boom"`) || !strings.Contains(lines[3], `"resolved":true`) {
+		t.Errorf("ERROR: The error or alert line is wrong!\n")
+	}
+}
+
+func TestProgressReporter(t *testing.T) {
+	var buf bytes.Buffer
+	reporter := NewProgressReporter(&buf, 100)
+	start := time.Now()
+	reporter.Snapshot(Snapshot{Time: start, Stats: sched.SchedStats{Downloads: 10}})
+	reporter.Snapshot(Snapshot{Time: start.Add(time.Second),
+		Stats: sched.SchedStats{Downloads: 30, ReqCacheLen: 7, InFlight: 9, Errors: 1}})
+	output := buf.String()
+	for _, expected := range []string{"pages: 30/100", "20.0 pages/s", "cache 7", "in flight: 9", "errors: 1", "ETA: 4s"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("ERROR: The progress output %q does not contain %q!\n", output, expected)
+		}
+	}
+	reporter.Alert(Alert{Rule: "r", Message: "m"})
+	reporter.Finish()
+	if !strings.HasSuffix(buf.String(), "[WARN] Alert [r]: m\n") {
+		t.Errorf("ERROR: The alert should be written on its own line!\n")
+	}
+	line := progressLine(sched.SchedStats{BudgetExhausted: "max pages 3 reached"}, 0, 0)
+	if !strings.HasSuffix(line, "| budget exhausted: max pages 3 reached") {
+		t.Errorf("ERROR: The progress line %q does not contain the exhausted reason!\n", line)
+	}
+	if estimate(sched.SchedStats{}, 0, 0) != "--" {
+		t.Errorf("ERROR: The ETA without rate should be unknown!\n")
+	}
+}
@@ -0,0 +1,202 @@
+package session
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 驗證器的接口型態。
+type Authenticator interface {
+	// 執行登入流程。參數client所持有的cookie容器會被用來存放登入後的狀態。
+	Login(client *http.Client) error
+	// 在請求被傳送之前為其加入驗證訊息。
+	Decorate(req *http.Request)
+	// 獲得驗證器的字串表現形式。
+	String() string
+}
+
+// 建立以表單提交的方式登入的驗證器。
+// 參數loginUrl代表表單的提交位址。
+// 參數userField和passField分別代表用戶名和密碼所對應的表單字段的名稱。
+// 參數extraFields代表需要一並提交的其他字段，可以為nil。
+func NewFormAuthenticator(
+	loginUrl string,
+	userField string,
+	passField string,
+	creds Credentials,
+	extraFields url.Values) (Authenticator, error) {
+	if _, err := url.Parse(loginUrl); err != nil || loginUrl == "" {
+		return nil, errors.New(fmt.Sprintf("Invalid login url '%s'!\n", loginUrl))
+	}
+	if userField == "" || passField == "" {
+		return nil, errors.New("The user field and password field can not be empty!\n")
+	}
+	form := url.Values{}
+	for k, vs := range extraFields {
+		for _, v := range vs {
+			form.Add(k, v)
+		}
+	}
+	form.Set(userField, creds.Username)
+	form.Set(passField, creds.Password)
+	return &formAuthenticator{loginUrl: loginUrl, form: form}, nil
+}
+
+// 以表單提交的方式登入的驗證器。
+type formAuthenticator struct {
+	loginUrl string     // 表單的提交位址。
+	form     url.Values // 需要被提交的表單字段。
+}
+
+func (auth *formAuthenticator) Login(client *http.Client) error {
+	resp, err := client.PostForm(auth.loginUrl, auth.form)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		errMsg := fmt.Sprintf("Login failing! (url=%s, statusCode=%d)\n",
+			auth.loginUrl, resp.StatusCode)
+		return errors.New(errMsg)
+	}
+	return nil
+}
+
+func (auth *formAuthenticator) Decorate(req *http.Request) {}
+
+func (auth *formAuthenticator) String() string {
+	return fmt.Sprintf("form(%s)", auth.loginUrl)
+}
+
+// 建立以Bearer權杖驗證的驗證器。
+func NewBearerAuthenticator(token string) Authenticator {
+	return &headerAuthenticator{
+		kind:  "bearer",
+		value: "Bearer " + token,
+	}
+}
+
+// 建立以基本驗證（Basic Authentication）方式驗證的驗證器。
+func NewBasicAuthenticator(creds Credentials) Authenticator {
+	req := &http.Request{Header: make(http.Header)}
+	req.SetBasicAuth(creds.Username, creds.Password)
+	return &headerAuthenticator{
+		kind:  "basic",
+		value: req.Header.Get("Authorization"),
+	}
+}
+
+// 以Authorization標頭驗證的驗證器。
+type headerAuthenticator struct {
+	kind  string // 驗證方式。
+	value string // Authorization標頭的值。
+}
+
+func (auth *headerAuthenticator) Login(client *http.Client) error {
+	return nil
+}
+
+func (auth *headerAuthenticator) Decorate(req *http.Request) {
+	if req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", auth.value)
+	}
+}
+
+func (auth *headerAuthenticator) String() string {
+	return auth.kind
+}
+
+// 建立從Netscape格式的cookie檔案中匯入登入狀態的驗證器。
+func NewCookieFileAuthenticator(path string) Authenticator {
+	return &cookieFileAuthenticator{path: path}
+}
+
+// 從Netscape格式的cookie檔案中匯入登入狀態的驗證器。
+type cookieFileAuthenticator struct {
+	path string // cookie檔案的路徑。
+}
+
+func (auth *cookieFileAuthenticator) Login(client *http.Client) error {
+	if client.Jar == nil {
+		return errors.New("The cookie jar of http client is invalid!")
+	}
+	file, err := os.Open(auth.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return ImportNetscapeCookies(client.Jar, file)
+}
+
+func (auth *cookieFileAuthenticator) Decorate(req *http.Request) {}
+
+func (auth *cookieFileAuthenticator) String() string {
+	return fmt.Sprintf("cookie_file(%s)", auth.path)
+}
+
+// 由HttpOnly的cookie所在行所使用的前綴。
+const httpOnlyPrefix = "#HttpOnly_"
+
+// 從Netscape格式的內容中匯入cookie到指定的cookie容器。
+// 每一行應包含以定位字元分隔的七個字段：
+// 域名、是否包含子域名、路徑、是否僅限安全連接、到期時間、名稱和值。
+func ImportNetscapeCookies(jar http.CookieJar, reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := false
+		if strings.HasPrefix(line, httpOnlyPrefix) {
+			httpOnly = true
+			line = line[len(httpOnlyPrefix):]
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			errMsg := fmt.Sprintf("Invalid cookie line %d: expect 7 fields but got %d!\n",
+				lineNumber, len(fields))
+			return errors.New(errMsg)
+		}
+		domain := fields[0]
+		host := strings.TrimPrefix(domain, ".")
+		secure := strings.EqualFold(fields[3], "TRUE")
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   secure,
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = host
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			errMsg := fmt.Sprintf("Invalid expiry '%s' in cookie line %d!\n", fields[4], lineNumber)
+			return errors.New(errMsg)
+		}
+		if expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+		u := &url.URL{Scheme: scheme, Host: host, Path: fields[2]}
+		jar.SetCookies(u, []*http.Cookie{cookie})
+	}
+	return scanner.Err()
+}
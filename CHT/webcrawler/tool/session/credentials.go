@@ -0,0 +1,73 @@
+package session
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 登入憑證。
+type Credentials struct {
+	Username string // 用戶名。
+	Password string // 密碼。
+}
+
+// 從環境變數中讀取登入憑證。
+// 參數userKey和passKey分別代表存放用戶名和密碼的環境變數的名稱。
+func CredentialsFromEnv(userKey string, passKey string) (Credentials, error) {
+	username := os.Getenv(userKey)
+	if username == "" {
+		errMsg := fmt.Sprintf("The environment variable '%s' is empty!\n", userKey)
+		return Credentials{}, errors.New(errMsg)
+	}
+	password := os.Getenv(passKey)
+	if password == "" {
+		errMsg := fmt.Sprintf("The environment variable '%s' is empty!\n", passKey)
+		return Credentials{}, errors.New(errMsg)
+	}
+	return Credentials{Username: username, Password: password}, nil
+}
+
+// 從檔案中讀取登入憑證。
+// 檔案中的每一行都應為“鍵=值”的形式，並且應包含username和password兩個鍵。
+// 以“#”開頭的行和空行會被忽略。
+func CredentialsFromFile(path string) (Credentials, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer file.Close()
+	var creds Credentials
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		index := strings.Index(line, "=")
+		if index < 0 {
+			errMsg := fmt.Sprintf("Invalid credentials line %d in file '%s'!\n", lineNumber, path)
+			return Credentials{}, errors.New(errMsg)
+		}
+		key := strings.TrimSpace(line[:index])
+		value := strings.TrimSpace(line[index+1:])
+		switch strings.ToLower(key) {
+		case "username":
+			creds.Username = value
+		case "password":
+			creds.Password = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Credentials{}, err
+	}
+	if creds.Username == "" || creds.Password == "" {
+		errMsg := fmt.Sprintf("The username or password is missing in file '%s'!\n", path)
+		return Credentials{}, errors.New(errMsg)
+	}
+	return creds, nil
+}
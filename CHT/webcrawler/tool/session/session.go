@@ -0,0 +1,258 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"logging"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	base "webcrawler/base"
+	"webcrawler/tool/cookie"
+)
+
+// 日志記錄器。
+var logger logging.Logger = base.NewLogger()
+
+// 登出狀態檢測函數的型態。
+// 若果響應表明目前已處於登出狀態，那麼該函數應傳回true。
+type LoggedOutDetector func(resp *http.Response) bool
+
+// 建立依據響應狀態碼判斷登出狀態的檢測函數。
+func StatusDetector(statusCodes ...int) LoggedOutDetector {
+	codeSet := make(map[int]bool)
+	for _, code := range statusCodes {
+		codeSet[code] = true
+	}
+	return func(resp *http.Response) bool {
+		return codeSet[resp.StatusCode]
+	}
+}
+
+// 建立依據重新導向的目的路徑判斷登出狀態的檢測函數。
+// 當響應把用戶端重新導向到以pathPrefix開頭的路徑時，即被視為處於登出狀態。
+func RedirectDetector(pathPrefix string) LoggedOutDetector {
+	return func(resp *http.Response) bool {
+		if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+			return false
+		}
+		location, err := resp.Location()
+		if err != nil {
+			return false
+		}
+		return strings.HasPrefix(location.Path, pathPrefix)
+	}
+}
+
+// 會話的接口型態。
+type Session interface {
+	// 執行登入流程。應在爬取流程開始之前呼叫。
+	Login() error
+	// 產生共享此會話的HTTP用戶端。
+	// 該方法與分派器所需的HTTP用戶端產生函數相容。
+	HttpClient() *http.Client
+	// 獲得在所有用戶端之間共享的cookie容器。
+	Jar() http.CookieJar
+	// 獲得已成功登入的次數。
+	LoginCount() uint32
+	// 加入需要驗證訊息的主機。
+	// 只有發往這些主機（主機名稱或帶有埠號的主機）的請求才會被加入驗證訊息，
+	// 以免驗證訊息被洩漏給其他主機，例如跨網域的重新導向的目的地。
+	AddHosts(hosts ...string)
+	// 取得摘要訊息。
+	Summary() string
+}
+
+// 建立會話。
+// 參數auth代表驗證器。
+// 參數detector代表登出狀態檢測函數，為nil時不會重新登入。
+// 參數jar代表共享的cookie容器，為nil時會以 cookie.NewCookiejar 建立一個新的容器，
+// 它依據公共後綴清單拒絕針對公共後綴（例如.com.tw）設定的cookie。
+// 參數transport代表底層的HTTP傳輸，為nil時使用http.DefaultTransport。
+// 參數hosts代表需要驗證訊息的主機，之後還可以經由 AddHosts 方法加入。
+func NewSession(
+	auth Authenticator,
+	detector LoggedOutDetector,
+	jar http.CookieJar,
+	transport http.RoundTripper,
+	hosts ...string) (Session, error) {
+	if auth == nil {
+		return nil, errors.New("The authenticator is invalid!")
+	}
+	if jar == nil {
+		jar = cookie.NewCookiejar()
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	sess := &mySession{
+		auth:      auth,
+		detector:  detector,
+		jar:       jar,
+		transport: transport,
+		hosts:     make(map[string]bool),
+	}
+	sess.AddHosts(hosts...)
+	return sess, nil
+}
+
+// 會話的實現型態。
+type mySession struct {
+	auth       Authenticator     // 驗證器。
+	detector   LoggedOutDetector // 登出狀態檢測函數。
+	jar        http.CookieJar    // 共享的cookie容器。
+	transport  http.RoundTripper // 底層的HTTP傳輸。
+	generation uint32            // 登入的世代。每次成功登入之後都會遞增。
+	failures   uint32            // 登入失敗的次數。
+	mutex      sync.Mutex        // 針對登入流程的互斥鎖。
+	hosts      map[string]bool   // 需要驗證訊息的主機。
+	hostsMutex sync.RWMutex      // 針對主機集合的讀寫鎖。
+}
+
+func (sess *mySession) Login() error {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	return sess.login()
+}
+
+// 執行登入流程。呼叫方應持有互斥鎖。
+func (sess *mySession) login() error {
+	client := &http.Client{Jar: sess.jar, Transport: sess.transport}
+	if err := sess.auth.Login(client); err != nil {
+		sess.failures++
+		return err
+	}
+	sess.generation++
+	return nil
+}
+
+// 在檢測到登出狀態之後重新登入。
+// 參數seenGeneration代表檢測時的登入世代。若果在此之後已經有其他請求完成了重新登入，
+// 那麼就不會再次登入。
+func (sess *mySession) relogin(seenGeneration uint32) error {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	if sess.generation != seenGeneration {
+		return nil
+	}
+	logger.Infof("Logged out detected, re-authenticate (auth=%s)...\n", sess.auth)
+	return sess.login()
+}
+
+// 獲得目前的登入世代。
+func (sess *mySession) currentGeneration() uint32 {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	return sess.generation
+}
+
+func (sess *mySession) HttpClient() *http.Client {
+	return &http.Client{
+		Jar:       sess.jar,
+		Transport: &sessionTransport{sess: sess, transport: sess.transport},
+	}
+}
+
+func (sess *mySession) Jar() http.CookieJar {
+	return sess.jar
+}
+
+func (sess *mySession) LoginCount() uint32 {
+	return sess.currentGeneration()
+}
+
+func (sess *mySession) AddHosts(hosts ...string) {
+	sess.hostsMutex.Lock()
+	defer sess.hostsMutex.Unlock()
+	for _, host := range hosts {
+		if host != "" {
+			sess.hosts[strings.ToLower(host)] = true
+		}
+	}
+}
+
+// 判斷發往指定URL的請求是否需要驗證訊息。
+func (sess *mySession) authorized(u *url.URL) bool {
+	if u == nil {
+		return false
+	}
+	sess.hostsMutex.RLock()
+	defer sess.hostsMutex.RUnlock()
+	return sess.hosts[strings.ToLower(u.Host)] ||
+		sess.hosts[strings.ToLower(u.Hostname())]
+}
+
+var summaryTemplate = "auth: %s, logins: %d, loginFailures: %d"
+
+func (sess *mySession) Summary() string {
+	sess.mutex.Lock()
+	defer sess.mutex.Unlock()
+	return fmt.Sprintf(summaryTemplate, sess.auth, sess.generation, sess.failures)
+}
+
+// 會話所使用的HTTP傳輸。
+// 它會為發往需要驗證訊息的主機的請求加入驗證訊息，並在檢測到登出狀態時重新登入並重試一次。
+type sessionTransport struct {
+	sess      *mySession        // 所屬的會話。
+	transport http.RoundTripper // 底層的HTTP傳輸。
+}
+
+// 取得底層的HTTP傳輸。
+func (st *sessionTransport) BaseTransport() http.RoundTripper {
+	return st.transport
+}
+
+// 產生以base作為底層HTTP傳輸的新的傳輸器，例如經由代理的傳輸器。
+// 登入流程仍然使用建立會話時指定的傳輸。
+func (st *sessionTransport) WithBaseTransport(base http.RoundTripper) http.RoundTripper {
+	return &sessionTransport{sess: st.sess, transport: base}
+}
+
+func (st *sessionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sess := st.sess
+	generation := sess.currentGeneration()
+	resp, err := st.transport.RoundTrip(st.decorate(req, req.Body))
+	if err != nil || sess.detector == nil || !sess.detector(resp) {
+		return resp, err
+	}
+	// 只有請求體可以被重新讀取時才能重試。
+	var body io.ReadCloser
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		body, err = req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+	}
+	if err := sess.relogin(generation); err != nil {
+		if body != nil {
+			body.Close()
+		}
+		return resp, nil
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	retryReq := st.decorate(req, body)
+	// 重新登入之後的cookie需要被重新附加。
+	retryReq.Header.Del("Cookie")
+	for _, cookie := range sess.jar.Cookies(retryReq.URL) {
+		retryReq.AddCookie(cookie)
+	}
+	return st.transport.RoundTrip(retryReq)
+}
+
+// 複製請求並加入驗證訊息。按照http.RoundTripper的約定，原請求不會被修改。
+// 只有發往需要驗證訊息的主機的請求才會被加入驗證訊息。
+func (st *sessionTransport) decorate(req *http.Request, body io.ReadCloser) *http.Request {
+	newReq := req.Clone(req.Context())
+	newReq.Body = body
+	if st.sess.authorized(newReq.URL) {
+		st.sess.auth.Decorate(newReq)
+	}
+	return newReq
+}
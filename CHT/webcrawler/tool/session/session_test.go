@@ -0,0 +1,170 @@
+package session
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 需要登入才能存取的測試網站。
+type loginSite struct {
+	validSid string
+	logins   int
+	mutex    sync.Mutex
+}
+
+func (site *loginSite) expire() {
+	site.mutex.Lock()
+	defer site.mutex.Unlock()
+	site.validSid = ""
+}
+
+func (site *loginSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	site.mutex.Lock()
+	defer site.mutex.Unlock()
+	switch r.URL.Path {
+	case "/login":
+		if r.Method != "POST" || r.FormValue("user") != "gopher" || r.FormValue("pass") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		site.logins++
+		site.validSid = fmt.Sprintf("sid-%d", site.logins)
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: site.validSid, Path: "/"})
+		fmt.Fprint(w, "welcome")
+	case "/bearer":
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "bearer page")
+	case "/away":
+		http.Redirect(w, r, r.FormValue("to"), http.StatusFound)
+	default:
+		cookie, err := r.Cookie("sid")
+		if err != nil || site.validSid == "" || cookie.Value != site.validSid {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		fmt.Fprint(w, "private page")
+	}
+}
+
+func fetch(t *testing.T, client *http.Client, url string) string {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("ERROR: Fetch %s failing: %s\n", url, err)
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+	return string(content)
+}
+
+func TestFormSession(t *testing.T) {
+	site := &loginSite{}
+	server := httptest.NewServer(site)
+	defer server.Close()
+
+	creds := Credentials{Username: "gopher", Password: "secret"}
+	auth, err := NewFormAuthenticator(server.URL+"/login", "user", "pass", creds, nil)
+	if err != nil {
+		t.Fatalf("ERROR: Authenticator initialization failing: %s\n", err)
+	}
+	sess, err := NewSession(auth, RedirectDetector("/login"), nil, nil)
+	if err != nil {
+		t.Fatalf("ERROR: Session initialization failing: %s\n", err)
+	}
+	if err := sess.Login(); err != nil {
+		t.Fatalf("ERROR: Login failing: %s\n", err)
+	}
+	clients := []*http.Client{sess.HttpClient(), sess.HttpClient()}
+	for i, client := range clients {
+		if content := fetch(t, client, server.URL+"/page"); content != "private page" {
+			t.Errorf("ERROR: Client [%d] got %q before expiration!\n", i, content)
+		}
+	}
+	site.expire()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(client *http.Client) {
+			defer wg.Done()
+			if content := fetch(t, client, server.URL+"/page"); content != "private page" {
+				t.Errorf("ERROR: Got %q after expiration!\n", content)
+			}
+		}(clients[i%len(clients)])
+	}
+	wg.Wait()
+	if count := sess.LoginCount(); count != 2 {
+		t.Errorf("ERROR: The login count is %d, but should be 2!\n", count)
+	}
+}
+
+func TestHeaderSession(t *testing.T) {
+	server := httptest.NewServer(&loginSite{})
+	defer server.Close()
+	var leaked string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = r.Header.Get("Authorization")
+		fmt.Fprint(w, "other page")
+	}))
+	defer other.Close()
+	sess, err := NewSession(NewBearerAuthenticator("token"), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("ERROR: Session initialization failing: %s\n", err)
+	}
+	// 未被加入的主機不會得到驗證訊息。
+	if content := fetch(t, sess.HttpClient(), server.URL+"/bearer"); content != "" {
+		t.Errorf("ERROR: Got %q from the host which is not added!\n", content)
+	}
+	serverUrl, _ := url.Parse(server.URL)
+	sess.AddHosts(serverUrl.Host)
+	if content := fetch(t, sess.HttpClient(), server.URL+"/bearer"); content != "bearer page" {
+		t.Errorf("ERROR: Got %q with bearer authenticator!\n", content)
+	}
+	// 重新導向到其他主機時，驗證訊息不應被一同傳送。
+	awayUrl := server.URL + "/away?to=" + url.QueryEscape(other.URL+"/page")
+	if content := fetch(t, sess.HttpClient(), awayUrl); content != "other page" {
+		t.Errorf("ERROR: Got %q after the cross-host redirect!\n", content)
+	}
+	if leaked != "" {
+		t.Errorf("ERROR: The authorization %q is leaked to the other host!\n", leaked)
+	}
+	// 預設的cookie容器不接受針對公共後綴設定的cookie。
+	pageUrl, _ := url.Parse("http://a.com.tw/")
+	sess.Jar().SetCookies(pageUrl, []*http.Cookie{{Name: "sid", Value: "x", Domain: ".com.tw"}})
+	otherUrl, _ := url.Parse("http://b.com.tw/")
+	if cookies := sess.Jar().Cookies(otherUrl); len(cookies) != 0 {
+		t.Errorf("ERROR: The cookies %v set for the public suffix are accepted!\n", cookies)
+	}
+}
+
+func TestImportNetscapeCookies(t *testing.T) {
+	content := "# Netscape HTTP Cookie File\n" +
+		"\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tsid\tabc\n" +
+		"#HttpOnly_www.example.com\tFALSE\t/app\tFALSE\t4102444800\ttoken\txyz\n"
+	jar, _ := cookiejar.New(nil)
+	if err := ImportNetscapeCookies(jar, strings.NewReader(content)); err != nil {
+		t.Fatalf("ERROR: Import cookies failing: %s\n", err)
+	}
+	u, _ := url.Parse("http://www.example.com/app/index")
+	cookies := jar.Cookies(u)
+	if len(cookies) != 2 {
+		t.Fatalf("ERROR: The cookie number is %d, but should be 2!\n", len(cookies))
+	}
+	u, _ = url.Parse("http://img.example.com/")
+	if cookies := jar.Cookies(u); len(cookies) != 1 || cookies[0].Value != "abc" {
+		t.Errorf("ERROR: Unexpected cookies for sub domain: %v\n", cookies)
+	}
+	err := ImportNetscapeCookies(jar, strings.NewReader("bad line\n"))
+	if err == nil {
+		t.Errorf("ERROR: Invalid cookie line should be rejected!\n")
+	}
+}
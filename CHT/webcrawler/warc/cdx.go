@@ -0,0 +1,175 @@
+package warc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// CDX索引的標頭行。各字段依次為：
+// 規範化的URL、時間戳記、原始URL、MIME型態、狀態碼、摘要、重定向、元標記、記錄長度、偏移量和檔案名稱。
+const CDX_HEADER = " CDX N b a m s k r M S V g"
+
+// CDX索引的條目。它指向WARC檔案中的一筆響應記錄。
+type CdxEntry struct {
+	Key        string // 規範化的URL（SURT形式）。
+	Timestamp  string // 14位數字的時間戳記。
+	Url        string // 原始URL。
+	MimeType   string // 響應的MIME型態。
+	StatusCode int    // 響應的HTTP狀態碼。
+	Digest     string // 響應體的摘要。
+	Length     int64  // 經過壓縮的記錄的長度。
+	Offset     int64  // 記錄在WARC檔案中的偏移量。
+	Filename   string // WARC檔案的名稱。
+}
+
+func (entry CdxEntry) String() string {
+	return strings.Join([]string{
+		entry.Key,
+		entry.Timestamp,
+		entry.Url,
+		entry.MimeType,
+		strconv.Itoa(entry.StatusCode),
+		strings.TrimPrefix(entry.Digest, "sha1:"),
+		"-",
+		"-",
+		strconv.FormatInt(entry.Length, 10),
+		strconv.FormatInt(entry.Offset, 10),
+		entry.Filename,
+	}, " ")
+}
+
+// 解析CDX索引的條目。
+func parseCdxEntry(line string) (CdxEntry, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 11 {
+		return CdxEntry{}, errors.New(fmt.Sprintf("Invalid CDX line '%s'!\n", line))
+	}
+	statusCode, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return CdxEntry{}, errors.New(fmt.Sprintf("Invalid CDX status code '%s'!\n", fields[4]))
+	}
+	length, err := strconv.ParseInt(fields[8], 10, 64)
+	if err != nil {
+		return CdxEntry{}, errors.New(fmt.Sprintf("Invalid CDX record length '%s'!\n", fields[8]))
+	}
+	offset, err := strconv.ParseInt(fields[9], 10, 64)
+	if err != nil {
+		return CdxEntry{}, errors.New(fmt.Sprintf("Invalid CDX offset '%s'!\n", fields[9]))
+	}
+	return CdxEntry{
+		Key:        fields[0],
+		Timestamp:  fields[1],
+		Url:        fields[2],
+		MimeType:   fields[3],
+		StatusCode: statusCode,
+		Digest:     "sha1:" + fields[5],
+		Length:     length,
+		Offset:     offset,
+		Filename:   fields[10],
+	}, nil
+}
+
+// 讀取CDX索引。標頭行會被略過。
+func ReadIndex(r io.Reader) ([]CdxEntry, error) {
+	entries := make([]CdxEntry, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, " CDX") {
+			continue
+		}
+		entry, err := parseCdxEntry(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// CDX索引寫入器。
+type cdxWriter struct {
+	file   *os.File      // 索引檔案。
+	writer *bufio.Writer // 緩衝寫入器。
+}
+
+// 建立CDX索引寫入器。索引檔案已存在時新的條目會被附加在其後，否則會先寫入標頭行。
+func newCdxWriter(path string) (*cdxWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	if info.Size() == 0 {
+		if _, err := writer.WriteString(CDX_HEADER + "\n"); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &cdxWriter{file: file, writer: writer}, nil
+}
+
+// 寫入一個條目。條目會被立即刷新到檔案中，以便在爬取期間查詢。
+func (cw *cdxWriter) write(entry CdxEntry) error {
+	if _, err := cw.writer.WriteString(entry.String() + "\n"); err != nil {
+		return err
+	}
+	return cw.writer.Flush()
+}
+
+// 關閉索引檔案。
+func (cw *cdxWriter) close() error {
+	if err := cw.writer.Flush(); err != nil {
+		cw.file.Close()
+		return err
+	}
+	return cw.file.Close()
+}
+
+// 獲得URL的SURT形式，例如：「http://www.example.com/a?b」會被轉換為「com,example,www)/a?b」。
+func surtKey(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	parts := strings.Split(host, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	key := strings.Join(parts, ",")
+	if port := u.Port(); port != "" && port != "80" && port != "443" {
+		key += ":" + port
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	key += ")" + strings.ToLower(path)
+	if u.RawQuery != "" {
+		key += "?" + strings.ToLower(u.RawQuery)
+	}
+	return key
+}
+
+// 從Content-Type標頭中取得MIME型態。未知時為「-」。
+func mimeType(contentType string) string {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	contentType = strings.TrimSpace(contentType)
+	if contentType == "" || strings.ContainsAny(contentType, " \t") {
+		return "-"
+	}
+	return strings.ToLower(contentType)
+}
@@ -0,0 +1,45 @@
+package warc
+
+import (
+	"errors"
+	"fmt"
+	base "webcrawler/base"
+	dl "webcrawler/downloader"
+)
+
+// 建立把下載結果寫入WARC檔案的網頁下載器。
+// 它包裝了參數downloader，並把每一個響應及其請求寫入參數writer。
+// 寫入失敗時響應仍會被傳回；若下載本身沒有出錯，傳回的錯誤會說明寫入失敗的原因。
+// 它通常被用在分派器的 SetPageDownloaderGenerator 方法中。
+func NewArchivingDownloader(downloader dl.PageDownloader, writer Writer) dl.PageDownloader {
+	return &archivingDownloader{downloader: downloader, writer: writer}
+}
+
+// 把下載結果寫入WARC檔案的網頁下載器的實現型態。
+type archivingDownloader struct {
+	downloader dl.PageDownloader // 被包裝的網頁下載器。
+	writer     Writer            // WARC寫入器。
+}
+
+func (ad *archivingDownloader) Id() uint32 {
+	return ad.downloader.Id()
+}
+
+func (ad *archivingDownloader) Download(req base.Request) (*base.Response, error) {
+	resp, err := ad.downloader.Download(req)
+	if resp == nil || resp.HttpResp() == nil {
+		return resp, err
+	}
+	if archiveErr := ad.writer.WriteExchange(resp.HttpResp(), req.Depth()); archiveErr != nil {
+		detail := base.ErrorDetail{
+			Url:        req.HttpReq().URL.String(),
+			Depth:      req.Depth(),
+			StatusCode: resp.HttpResp().StatusCode,
+		}
+		cause := errors.New(fmt.Sprintf("WARC archiving failing: %s", archiveErr))
+		if err == nil {
+			err = base.NewCrawlerErrorWithDetail(base.DOWNLOADER_ERROR, cause, detail)
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,207 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WARC的版本。
+const WARC_VERSION = "WARC/1.1"
+
+// WARC記錄的型態。
+const (
+	RECORD_TYPE_WARCINFO = "warcinfo"
+	RECORD_TYPE_REQUEST  = "request"
+	RECORD_TYPE_RESPONSE = "response"
+)
+
+// WARC記錄的標頭名稱。
+const (
+	HEADER_TYPE           = "WARC-Type"
+	HEADER_RECORD_ID      = "WARC-Record-ID"
+	HEADER_DATE           = "WARC-Date"
+	HEADER_TARGET_URI     = "WARC-Target-URI"
+	HEADER_CONCURRENT_TO  = "WARC-Concurrent-To"
+	HEADER_BLOCK_DIGEST   = "WARC-Block-Digest"
+	HEADER_PAYLOAD_DIGEST = "WARC-Payload-Digest"
+	HEADER_FILENAME       = "WARC-Filename"
+	HEADER_CONTENT_TYPE   = "Content-Type"
+	HEADER_CONTENT_LENGTH = "Content-Length"
+	// 擴充標頭：請求的爬取深度。它使離線重播可以還原響應的深度。
+	HEADER_CRAWL_DEPTH = "X-Crawl-Depth"
+)
+
+// HTTP請求和響應記錄的內容型態。
+const (
+	CONTENT_TYPE_HTTP_REQUEST  = "application/http;msgtype=request"
+	CONTENT_TYPE_HTTP_RESPONSE = "application/http;msgtype=response"
+	CONTENT_TYPE_WARC_FIELDS   = "application/warc-fields"
+)
+
+// WARC記錄。
+type Record struct {
+	Header  textproto.MIMEHeader // 記錄的標頭。鍵已被規範化，應以 Get 方法存取。
+	Content []byte               // 記錄的內容塊。
+}
+
+// 獲得記錄的型態。
+func (record *Record) Type() string {
+	return record.Header.Get(HEADER_TYPE)
+}
+
+// 獲得記錄的ID。
+func (record *Record) RecordId() string {
+	return record.Header.Get(HEADER_RECORD_ID)
+}
+
+// 獲得記錄的目標URI。
+func (record *Record) TargetUri() string {
+	return record.Header.Get(HEADER_TARGET_URI)
+}
+
+// 獲得記錄的日期。
+func (record *Record) Date() (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, record.Header.Get(HEADER_DATE))
+}
+
+// 獲得記錄的爬取深度。不存在時為0。
+func (record *Record) Depth() uint32 {
+	depth, _ := strconv.ParseUint(record.Header.Get(HEADER_CRAWL_DEPTH), 10, 32)
+	return uint32(depth)
+}
+
+// 產生新的記錄ID。
+func newRecordId() string {
+	var uuid [16]byte
+	if _, err := io.ReadFull(rand.Reader, uuid[:]); err != nil {
+		panic(err)
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>",
+		uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+// 計算資料的SHA-1摘要，並以WARC慣用的Base32形式表示。
+func digest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// 格式化WARC日期。
+func formatDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// 把記錄編碼為經過gzip壓縮的位元組序列。每一筆記錄都是一個獨立的gzip成員。
+// 參數fields中的標頭會按順序被寫入，WARC-Block-Digest和Content-Length會被自動加入。
+func encodeRecord(fields [][2]string, content []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	gw := gzip.NewWriter(&buffer)
+	bw := bufio.NewWriter(gw)
+	bw.WriteString(WARC_VERSION + "\r\n")
+	for _, field := range fields {
+		bw.WriteString(field[0] + ": " + field[1] + "\r\n")
+	}
+	bw.WriteString(HEADER_BLOCK_DIGEST + ": " + digest(content) + "\r\n")
+	bw.WriteString(HEADER_CONTENT_LENGTH + ": " + strconv.Itoa(len(content)) + "\r\n")
+	bw.WriteString("\r\n")
+	bw.Write(content)
+	bw.WriteString("\r\n\r\n")
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// WARC讀取器。它可以讀取未壓縮的或者經過gzip壓縮的WARC檔案。
+type Reader struct {
+	reader *textproto.Reader // 文字協定讀取器。
+	closer io.Closer         // 解壓縮讀取器。未壓縮時為nil。
+}
+
+// 建立WARC讀取器。是否經過gzip壓縮會被自動判斷。
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return &Reader{reader: textproto.NewReader(bufio.NewReader(gr)), closer: gr}, nil
+	}
+	return &Reader{reader: textproto.NewReader(br)}, nil
+}
+
+// 讀取下一筆記錄。沒有更多記錄時傳回 io.EOF。
+func (reader *Reader) Next() (*Record, error) {
+	line, err := reader.reader.ReadLine()
+	for err == nil && line == "" {
+		line, err = reader.reader.ReadLine()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, errors.New(fmt.Sprintf("Invalid WARC version line '%s'!\n", line))
+	}
+	header, err := reader.reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseInt(header.Get(HEADER_CONTENT_LENGTH), 10, 64)
+	if err != nil || length < 0 {
+		errMsg := fmt.Sprintf("Invalid content length '%s'!\n", header.Get(HEADER_CONTENT_LENGTH))
+		return nil, errors.New(errMsg)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader.reader.R, content); err != nil {
+		return nil, err
+	}
+	return &Record{Header: header, Content: content}, nil
+}
+
+// 關閉讀取器。它不會關閉底層的讀取器。
+func (reader *Reader) Close() error {
+	if reader.closer != nil {
+		return reader.closer.Close()
+	}
+	return nil
+}
+
+// 讀取WARC檔案中位於指定偏移量的記錄。偏移量通常來自於CDX索引。
+func ReadRecordAt(path string, offset int64) (*Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader, err := NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return reader.Next()
+}
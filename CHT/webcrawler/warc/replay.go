@@ -0,0 +1,92 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	anlz "webcrawler/analyzer"
+	base "webcrawler/base"
+)
+
+// 處理重播結果的函數型態。
+// 參數resp是從WARC記錄還原的響應，參數dataList和errs是分析器針對它的分析結果。
+type ReplayHandler func(resp base.Response, dataList []base.Data, errs []error)
+
+// 把響應記錄還原為響應。響應的請求會以記錄的目標URI重新建立。
+func RecordToResponse(record *Record) (*base.Response, error) {
+	if record.Type() != RECORD_TYPE_RESPONSE {
+		errMsg := fmt.Sprintf("The record (id=%s) is not a response record!\n", record.RecordId())
+		return nil, errors.New(errMsg)
+	}
+	httpReq, err := http.NewRequest("GET", record.TargetUri(), nil)
+	if err != nil {
+		return nil, err
+	}
+	httpResp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(record.Content)), httpReq)
+	if err != nil {
+		return nil, err
+	}
+	return base.NewResponse(httpResp, record.Depth()), nil
+}
+
+// 把WARC資料中的所有響應記錄交給分析器離線分析，不需要存取網路。
+// 每一個響應的分析結果都會被交給參數handle。結果值為被重播的響應的數量。
+func Replay(
+	r io.Reader,
+	analyzer anlz.Analyzer,
+	respParsers []anlz.ParseResponse,
+	handle ReplayHandler) (uint64, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	var count uint64
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if record.Type() != RECORD_TYPE_RESPONSE {
+			continue
+		}
+		resp, err := RecordToResponse(record)
+		if err != nil {
+			return count, err
+		}
+		dataList, errs := analyzer.Analyze(respParsers, *resp)
+		count++
+		if handle != nil {
+			handle(*resp, dataList, errs)
+		}
+	}
+}
+
+// 依次重播多個WARC檔案。結果值為被重播的響應的總數。
+func ReplayFiles(
+	paths []string,
+	analyzer anlz.Analyzer,
+	respParsers []anlz.ParseResponse,
+	handle ReplayHandler) (uint64, error) {
+	var total uint64
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return total, err
+		}
+		count, err := Replay(file, analyzer, respParsers, handle)
+		file.Close()
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
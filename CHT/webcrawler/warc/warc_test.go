@@ -0,0 +1,255 @@
+package warc
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	anlz "webcrawler/analyzer"
+	base "webcrawler/base"
+	dl "webcrawler/downloader"
+)
+
+// 下載測試網站中的網頁，並把結果寫入WARC檔案。結果值為WARC寫入器和測試網站的URL。
+func archivePages(t *testing.T, args WriterArgs, paths []string) (Writer, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<html><body><a href="%s/next">next</a></body></html>`, r.URL.Path)
+	}))
+	defer server.Close()
+	writer, err := NewWriter(args)
+	if err != nil {
+		t.Fatalf("ERROR: WARC writer initialization failing: %s\n", err)
+	}
+	downloader := NewArchivingDownloader(dl.NewPageDownloader(&http.Client{}), writer)
+	for i, path := range paths {
+		httpReq, _ := http.NewRequest("GET", server.URL+path, nil)
+		resp, err := downloader.Download(*base.NewRequest(httpReq, uint32(i)))
+		if err != nil {
+			t.Fatalf("ERROR: Download failing: %s\n", err)
+		}
+		// 響應體應該仍然可以被讀取。
+		body, _ := ioutil.ReadAll(resp.HttpResp().Body)
+		if resp.HttpResp().StatusCode == http.StatusOK && !strings.Contains(string(body), "next") {
+			t.Errorf("ERROR: The response body of %s is lost after archiving!\n", path)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("ERROR: WARC writer closing failing: %s\n", err)
+	}
+	return writer, server.URL
+}
+
+func TestWriteAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatalf("ERROR: Temp dir creation failing: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+	paths := []string{"/a", "/b", "/missing"}
+	// 檔案尺寸上限很小，因此每一對記錄都會被寫入新的檔案。
+	writer, serverUrl := archivePages(t, NewWriterArgs(dir, "test", 1), paths)
+	files := writer.Files()
+	if len(files) != len(paths) {
+		t.Fatalf("ERROR: The number of WARC files is %d, but should be %d!\n", len(files), len(paths))
+	}
+	expectedTypes := []string{RECORD_TYPE_WARCINFO, RECORD_TYPE_RESPONSE, RECORD_TYPE_REQUEST}
+	for i, path := range files {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("ERROR: WARC file opening failing: %s\n", err)
+		}
+		reader, err := NewReader(file)
+		if err != nil {
+			t.Fatalf("ERROR: WARC reader initialization failing: %s\n", err)
+		}
+		records := make([]*Record, 0)
+		for {
+			record, err := reader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("ERROR: WARC record reading failing: %s\n", err)
+			}
+			records = append(records, record)
+		}
+		reader.Close()
+		file.Close()
+		if len(records) != len(expectedTypes) {
+			t.Fatalf("ERROR: The number of records in %s is %d, but should be %d!\n",
+				path, len(records), len(expectedTypes))
+		}
+		for j, record := range records {
+			if record.Type() != expectedTypes[j] {
+				t.Errorf("ERROR: The type of record %d is %s, but should be %s!\n",
+					j, record.Type(), expectedTypes[j])
+			}
+		}
+		resp, req := records[1], records[2]
+		if resp.TargetUri() != serverUrl+paths[i] || resp.Depth() != uint32(i) {
+			t.Errorf("ERROR: The response record (uri=%s, depth=%d) is wrong!\n",
+				resp.TargetUri(), resp.Depth())
+		}
+		if req.Header.Get(HEADER_CONCURRENT_TO) != resp.RecordId() {
+			t.Errorf("ERROR: The request record is not concurrent to the response record!\n")
+		}
+		if !strings.HasPrefix(string(req.Content), "GET "+paths[i]+" HTTP/1.1\r\n") {
+			t.Errorf("ERROR: The request record content is wrong: %q\n", req.Content)
+		}
+	}
+
+	indexFile, err := os.Open(writer.IndexFile())
+	if err != nil {
+		t.Fatalf("ERROR: CDX index opening failing: %s\n", err)
+	}
+	defer indexFile.Close()
+	entries, err := ReadIndex(indexFile)
+	if err != nil {
+		t.Fatalf("ERROR: CDX index reading failing: %s\n", err)
+	}
+	if len(entries) != len(paths) {
+		t.Fatalf("ERROR: The number of CDX entries is %d, but should be %d!\n", len(entries), len(paths))
+	}
+	for i, entry := range entries {
+		if entry.Url != serverUrl+paths[i] || entry.MimeType != "text/html" && i < 2 {
+			t.Errorf("ERROR: The CDX entry %s is wrong!\n", entry)
+		}
+		record, err := ReadRecordAt(filepath.Join(dir, entry.Filename), entry.Offset)
+		if err != nil {
+			t.Fatalf("ERROR: Record reading at offset %d failing: %s\n", entry.Offset, err)
+		}
+		if record.Type() != RECORD_TYPE_RESPONSE || record.TargetUri() != entry.Url {
+			t.Errorf("ERROR: The record at offset %d is %s %s, but should be the response of %s!\n",
+				entry.Offset, record.Type(), record.TargetUri(), entry.Url)
+		}
+		if record.Header.Get(HEADER_PAYLOAD_DIGEST) != entry.Digest {
+			t.Errorf("ERROR: The payload digest of %s is not consistent with the CDX entry!\n", entry.Url)
+		}
+	}
+	if entries[2].StatusCode != http.StatusNotFound {
+		t.Errorf("ERROR: The status code in CDX entry is %d, but should be %d!\n",
+			entries[2].StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestWriterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatalf("ERROR: Temp dir creation failing: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+	args := NewWriterArgs(dir, "test", 1)
+	archivePages(t, args, []string{"/a", "/b"})
+	// 重新開啟後，序號從已有的最大序號之後繼續，CDX索引中已有的條目被保留。
+	writer, _ := archivePages(t, args, []string{"/c"})
+	files := writer.Files()
+	if len(files) != 1 || filepath.Base(files[0]) != "test-00002.warc.gz" {
+		t.Fatalf("ERROR: The WARC files after restart are %v!\n", files)
+	}
+	content, err := ioutil.ReadFile(writer.IndexFile())
+	if err != nil {
+		t.Fatalf("ERROR: CDX index reading failing: %s\n", err)
+	}
+	if n := strings.Count(string(content), CDX_HEADER); n != 1 {
+		t.Errorf("ERROR: The number of CDX headers is %d, but should be %d!\n", n, 1)
+	}
+	entries, err := ReadIndex(strings.NewReader(string(content)))
+	if err != nil {
+		t.Fatalf("ERROR: CDX index parsing failing: %s\n", err)
+	}
+	if len(entries) != 3 || entries[0].Filename != "test-00000.warc.gz" ||
+		entries[2].Filename != "test-00002.warc.gz" {
+		t.Errorf("ERROR: The CDX entries after restart are %v!\n", entries)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatalf("ERROR: Temp dir creation failing: %s\n", err)
+	}
+	defer os.RemoveAll(dir)
+	paths := []string{"/a", "/b"}
+	// 測試網站在重播之前就已被關閉，因此重播不會存取網路。
+	writer, serverUrl := archivePages(t, NewWriterArgs(dir, "test", 0), paths)
+	if len(writer.Files()) != 1 {
+		t.Errorf("ERROR: The WARC file should not be rotated!\n")
+	}
+	extractor, err := anlz.NewLinkExtractor(anlz.NewLinkExtractorArgs(anlz.LINK_SOURCE_A, 0))
+	if err != nil {
+		t.Fatalf("ERROR: Link extractor initialization failing: %s\n", err)
+	}
+	links := make(map[string]uint32)
+	count, err := ReplayFiles(writer.Files(), anlz.NewAnalyzer(), []anlz.ParseResponse{extractor},
+		func(resp base.Response, dataList []base.Data, errs []error) {
+			for _, err := range errs {
+				t.Errorf("ERROR: Replay analysis error: %s\n", err)
+			}
+			for _, data := range dataList {
+				if req, ok := data.(*base.Request); ok {
+					links[req.HttpReq().URL.String()] = req.Depth()
+				}
+			}
+		})
+	if err != nil {
+		t.Fatalf("ERROR: Replay failing: %s\n", err)
+	}
+	if count != uint64(len(paths)) {
+		t.Errorf("ERROR: The number of replayed responses is %d, but should be %d!\n", count, len(paths))
+	}
+	for i, path := range paths {
+		depth, ok := links[serverUrl+path+"/next"]
+		if !ok || depth != uint32(i)+1 {
+			t.Errorf("ERROR: The link from %s is not replayed correctly! (found=%v, depth=%d)\n",
+				path, ok, depth)
+		}
+	}
+}
+
+func TestReadPlainRecord(t *testing.T) {
+	content := "WARC/1.1\r\n" +
+		"WARC-Type: resource\r\n" +
+		"WARC-Target-URI: http://example.com/\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello\r\n\r\n"
+	reader, err := NewReader(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ERROR: WARC reader initialization failing: %s\n", err)
+	}
+	record, err := reader.Next()
+	if err != nil {
+		t.Fatalf("ERROR: WARC record reading failing: %s\n", err)
+	}
+	if record.Type() != "resource" || string(record.Content) != "hello" {
+		t.Errorf("ERROR: The record (type=%s, content=%q) is wrong!\n", record.Type(), record.Content)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("ERROR: The reader should reach EOF, but got %v!\n", err)
+	}
+}
+
+func TestSurtKey(t *testing.T) {
+	cases := map[string]string{
+		"http://www.Example.com/A?b=C": "com,example,www)/a?b=c",
+		"http://example.com":           "com,example)/",
+		"http://127.0.0.1:8080/x":      "1,0,0,127:8080)/x",
+	}
+	for rawUrl, expected := range cases {
+		u, _ := url.Parse(rawUrl)
+		if key := surtKey(u); key != expected {
+			t.Errorf("ERROR: The SURT key of %s is %s, but should be %s!\n", rawUrl, key, expected)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package warc
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	base "webcrawler/base"
+)
+
+// 寫入warcinfo記錄時使用的軟體名稱。
+const SOFTWARE_NAME = "webcrawler"
+
+// WARC寫入器參數容器的描述範本。
+var writerArgsTemplate string = "{ dir: %s, prefix: %s, maxFileSize: %d }"
+
+// WARC寫入器參數的容器。
+type WriterArgs struct {
+	dir         string // 存放WARC檔案和CDX索引的目錄。
+	prefix      string // WARC檔案名稱的前綴。
+	maxFileSize int64  // 單個WARC檔案的最大尺寸。為0時表示不進行輪替。
+	description string // 描述。
+}
+
+// 建立WARC寫入器參數的容器。
+func NewWriterArgs(dir string, prefix string, maxFileSize int64) WriterArgs {
+	return WriterArgs{
+		dir:         dir,
+		prefix:      prefix,
+		maxFileSize: maxFileSize,
+	}
+}
+
+func (args *WriterArgs) Check() error {
+	if args.dir == "" {
+		return errors.New("The WARC directory can not be empty!\n")
+	}
+	if args.prefix == "" {
+		return errors.New("The WARC file prefix can not be empty!\n")
+	}
+	if args.maxFileSize < 0 {
+		return errors.New("The max WARC file size can not be negative!\n")
+	}
+	return nil
+}
+
+func (args *WriterArgs) String() string {
+	if args.description == "" {
+		args.description =
+			fmt.Sprintf(writerArgsTemplate,
+				args.dir,
+				args.prefix,
+				args.maxFileSize)
+	}
+	return args.description
+}
+
+// 獲得存放WARC檔案和CDX索引的目錄。
+func (args *WriterArgs) Dir() string {
+	return args.dir
+}
+
+// 獲得WARC檔案名稱的前綴。
+func (args *WriterArgs) Prefix() string {
+	return args.prefix
+}
+
+// 獲得單個WARC檔案的最大尺寸。
+func (args *WriterArgs) MaxFileSize() int64 {
+	return args.maxFileSize
+}
+
+// WARC寫入器的接口型態。
+type Writer interface {
+	// 把HTTP響應及其請求寫為一對響應記錄和請求記錄，並在CDX索引中加入響應記錄。
+	// 響應體會被讀取，隨後以可重新讀取的形式放回。參數depth代表請求的爬取深度。
+	WriteExchange(httpResp *http.Response, depth uint32) error
+	// 獲得已經產生的WARC檔案的路徑。
+	Files() []string
+	// 獲得CDX索引檔案的路徑。
+	IndexFile() string
+	// 關閉寫入器。
+	Close() error
+	// 獲得摘要訊息。
+	Summary() string
+}
+
+// 建立WARC寫入器。
+// WARC檔案會以「前綴-序號.warc.gz」的形式命名，CDX索引則會被寫入「前綴.cdx」。
+// 目錄中已存在同一前綴的WARC檔案時（例如爬取流程被重新開啟），序號會從其中最大的序號之後繼續，
+// 新的條目則會被附加到已存在的CDX索引之後，因此已有的檔案和條目都不會被覆寫。
+func NewWriter(args WriterArgs) (Writer, error) {
+	if err := args.Check(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(args.Dir(), 0755); err != nil {
+		return nil, err
+	}
+	nextSeq, err := nextFileSeq(args.Dir(), args.Prefix())
+	if err != nil {
+		return nil, err
+	}
+	indexPath := filepath.Join(args.Dir(), args.Prefix()+".cdx")
+	index, err := newCdxWriter(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	return &myWriter{args: args, index: index, indexPath: indexPath, nextSeq: nextSeq}, nil
+}
+
+// 獲得目錄中下一個WARC檔案的序號，即：同一前綴的WARC檔案的最大序號加1。不存在這樣的檔案時為0。
+func nextFileSeq(dir string, prefix string) (int, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	nextSeq := 0
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, prefix+"-") || !strings.HasSuffix(name, ".warc.gz") {
+			continue
+		}
+		seqStr := strings.TrimSuffix(strings.TrimPrefix(name, prefix+"-"), ".warc.gz")
+		seq, err := strconv.Atoi(seqStr)
+		if err != nil || seq < 0 {
+			continue
+		}
+		if seq >= nextSeq {
+			nextSeq = seq + 1
+		}
+	}
+	return nextSeq, nil
+}
+
+// WARC寫入器的實現型態。
+type myWriter struct {
+	args      WriterArgs // 參數。
+	file      *os.File   // 目前的WARC檔案。
+	fileSize  int64      // 目前的WARC檔案的尺寸。
+	files     []string   // 已經產生的WARC檔案的路徑。
+	index     *cdxWriter // CDX索引寫入器。
+	indexPath string     // CDX索引檔案的路徑。
+	nextSeq   int        // 下一個WARC檔案的序號。
+	records   uint64     // 已寫入的記錄的數量。
+	closed    bool       // 是否已關閉。
+	mutex     sync.Mutex // 互斥鎖。
+}
+
+func (writer *myWriter) WriteExchange(httpResp *http.Response, depth uint32) error {
+	if httpResp == nil || httpResp.Request == nil || httpResp.Request.URL == nil {
+		return errors.New("The HTTP response or its request is invalid!\n")
+	}
+	respBlock, payload, err := dumpResponse(httpResp)
+	if err != nil {
+		return err
+	}
+	reqBlock, err := dumpRequest(httpResp.Request)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	targetUri := httpResp.Request.URL.String()
+	depthStr := strconv.FormatUint(uint64(depth), 10)
+	respId := newRecordId()
+	respRecord, err := encodeRecord([][2]string{
+		{HEADER_TYPE, RECORD_TYPE_RESPONSE},
+		{HEADER_RECORD_ID, respId},
+		{HEADER_DATE, formatDate(now)},
+		{HEADER_TARGET_URI, targetUri},
+		{HEADER_PAYLOAD_DIGEST, digest(payload)},
+		{HEADER_CRAWL_DEPTH, depthStr},
+		{HEADER_CONTENT_TYPE, CONTENT_TYPE_HTTP_RESPONSE},
+	}, respBlock)
+	if err != nil {
+		return err
+	}
+	reqRecord, err := encodeRecord([][2]string{
+		{HEADER_TYPE, RECORD_TYPE_REQUEST},
+		{HEADER_RECORD_ID, newRecordId()},
+		{HEADER_DATE, formatDate(now)},
+		{HEADER_TARGET_URI, targetUri},
+		{HEADER_CONCURRENT_TO, respId},
+		{HEADER_CRAWL_DEPTH, depthStr},
+		{HEADER_CONTENT_TYPE, CONTENT_TYPE_HTTP_REQUEST},
+	}, reqBlock)
+	if err != nil {
+		return err
+	}
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.closed {
+		return errors.New("The WARC writer has been closed!\n")
+	}
+	if err := writer.rotate(); err != nil {
+		return err
+	}
+	offset := writer.fileSize
+	if err := writer.write(respRecord); err != nil {
+		return err
+	}
+	if err := writer.write(reqRecord); err != nil {
+		return err
+	}
+	entry := CdxEntry{
+		Key:        surtKey(httpResp.Request.URL),
+		Timestamp:  now.UTC().Format("20060102150405"),
+		Url:        targetUri,
+		MimeType:   mimeType(httpResp.Header.Get("Content-Type")),
+		StatusCode: httpResp.StatusCode,
+		Digest:     digest(payload),
+		Length:     int64(len(respRecord)),
+		Offset:     offset,
+		Filename:   filepath.Base(writer.file.Name()),
+	}
+	return writer.index.write(entry)
+}
+
+// 在需要時開啟新的WARC檔案。呼叫方應持有互斥鎖。
+// 目前的檔案的尺寸達到上限時，它會被關閉並由新的檔案取代。
+func (writer *myWriter) rotate() error {
+	if writer.file != nil {
+		maxSize := writer.args.MaxFileSize()
+		if maxSize == 0 || writer.fileSize < maxSize {
+			return nil
+		}
+		if err := writer.file.Close(); err != nil {
+			return err
+		}
+		writer.file = nil
+	}
+	name := fmt.Sprintf("%s-%05d.warc.gz", writer.args.Prefix(), writer.nextSeq)
+	path := filepath.Join(writer.args.Dir(), name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer.nextSeq++
+	writer.file = file
+	writer.fileSize = 0
+	writer.files = append(writer.files, path)
+	info := fmt.Sprintf("software: %s\r\nformat: WARC File Format 1.1\r\n", SOFTWARE_NAME)
+	infoRecord, err := encodeRecord([][2]string{
+		{HEADER_TYPE, RECORD_TYPE_WARCINFO},
+		{HEADER_RECORD_ID, newRecordId()},
+		{HEADER_DATE, formatDate(time.Now())},
+		{HEADER_FILENAME, name},
+		{HEADER_CONTENT_TYPE, CONTENT_TYPE_WARC_FIELDS},
+	}, []byte(info))
+	if err != nil {
+		return err
+	}
+	return writer.write(infoRecord)
+}
+
+// 把編碼後的記錄寫入目前的WARC檔案。呼叫方應持有互斥鎖。
+func (writer *myWriter) write(record []byte) error {
+	n, err := writer.file.Write(record)
+	writer.fileSize += int64(n)
+	if err != nil {
+		return err
+	}
+	writer.records++
+	return nil
+}
+
+func (writer *myWriter) Files() []string {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	files := make([]string, len(writer.files))
+	copy(files, writer.files)
+	return files
+}
+
+func (writer *myWriter) IndexFile() string {
+	return writer.indexPath
+}
+
+func (writer *myWriter) Close() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	if writer.closed {
+		return nil
+	}
+	writer.closed = true
+	var fileErr error
+	if writer.file != nil {
+		fileErr = writer.file.Close()
+	}
+	if err := writer.index.close(); err != nil {
+		return err
+	}
+	return fileErr
+}
+
+func (writer *myWriter) Summary() string {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	return fmt.Sprintf("files: %d, records: %d, currentSize: %d, args: %s",
+		len(writer.files), writer.records, writer.fileSize, writer.args.String())
+}
+
+// 把HTTP響應轉儲為WARC響應記錄的內容塊，同時傳回響應體。
+// 響應體會被讀取，隨後以可重新讀取的形式放回。
+func dumpResponse(httpResp *http.Response) ([]byte, []byte, error) {
+	payload, err := base.BufferBody(httpResp)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 轉儲會以自己的緩衝取代響應體，因此之後需要放回已被緩衝的響應體。
+	body := httpResp.Body
+	block, err := httputil.DumpResponse(httpResp, true)
+	httpResp.Body = body
+	base.BufferBody(httpResp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, payload, nil
+}
+
+// 把HTTP請求轉儲為WARC請求記錄的內容塊。
+// 已被傳送的請求的請求體只有在可以被重新讀取時才會被包含在內。
+func dumpRequest(httpReq *http.Request) ([]byte, error) {
+	block, err := httputil.DumpRequest(withoutBody(httpReq), false)
+	if err != nil {
+		return nil, err
+	}
+	if httpReq.GetBody == nil {
+		return block, nil
+	}
+	body, err := httpReq.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return append(block, content...), nil
+}
+
+// 傳回不帶請求體的HTTP請求的淺層副本。
+func withoutBody(httpReq *http.Request) *http.Request {
+	copied := *httpReq
+	copied.Body = nil
+	return &copied
+}